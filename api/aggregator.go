@@ -0,0 +1,121 @@
+package api
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/casell/signing-service-challenge/domain"
+	"github.com/casell/signing-service-challenge/persistence"
+	"github.com/google/uuid"
+)
+
+const (
+	DEFAULT_AGGREGATION_WINDOW     = 10 * time.Millisecond
+	DEFAULT_AGGREGATION_MAX_LEAVES = 1024
+)
+
+// AggregatedSignature is the outcome of an aggregated signing: the chained signature of the Merkle root
+// and the inclusion proof of the leaf.
+type AggregatedSignature struct {
+	Record    domain.SignatureRecord
+	Root      []byte
+	LeafIndex int
+	Proof     []domain.MerkleProofStep
+}
+
+// Aggregator collects the data to be signed by a device over a window, then signs only the Merkle root
+// of the collected leaves, so that the device lock is taken once per window instead of once per transaction.
+type Aggregator struct {
+	store     persistence.Storage
+	window    time.Duration
+	maxLeaves int
+
+	lock    sync.Mutex
+	pending map[uuid.UUID]*aggregate
+}
+
+// aggregate is the set of leaves collected for a device, done is closed once root and record are set.
+type aggregate struct {
+	leaves []string
+	full   chan struct{}
+	done   chan struct{}
+
+	tree   *domain.MerkleTree
+	record domain.SignatureRecord
+	err    error
+}
+
+// NewAggregator returns an Aggregator signing with store. A window is flushed after window,
+// or as soon as it holds maxLeaves leaves.
+func NewAggregator(store persistence.Storage, window time.Duration, maxLeaves int) *Aggregator {
+	return &Aggregator{
+		store:     store,
+		window:    window,
+		maxLeaves: maxLeaves,
+		pending:   make(map[uuid.UUID]*aggregate),
+	}
+}
+
+// Sign adds data to the current window of device id and waits for the root to be signed.
+// Once added the leaf is signed even if ctx is done meanwhile, the caller just stops waiting for it.
+func (a *Aggregator) Sign(ctx context.Context, id uuid.UUID, data string) (*AggregatedSignature, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	a.lock.Lock()
+	agg, ok := a.pending[id]
+	if !ok {
+		agg = &aggregate{full: make(chan struct{}), done: make(chan struct{})}
+		a.pending[id] = agg
+		go a.flush(id, agg)
+	}
+	index := len(agg.leaves)
+	agg.leaves = append(agg.leaves, data)
+	if len(agg.leaves) >= a.maxLeaves {
+		delete(a.pending, id)
+		close(agg.full)
+	}
+	a.lock.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-agg.done:
+	}
+	if agg.err != nil {
+		return nil, agg.err
+	}
+	return &AggregatedSignature{
+		Record:    agg.record,
+		Root:      agg.tree.Root(),
+		LeafIndex: index,
+		Proof:     agg.tree.Proof(index),
+	}, nil
+}
+
+// flush waits for the window of agg to close, then signs the root of its leaves.
+// The signature is shared by several requests, so it is not bound to any of their contexts.
+func (a *Aggregator) flush(id uuid.UUID, agg *aggregate) {
+	timer := time.NewTimer(a.window)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		a.lock.Lock()
+		if a.pending[id] == agg {
+			delete(a.pending, id)
+		}
+		a.lock.Unlock()
+	case <-agg.full:
+	}
+
+	agg.tree = domain.NewMerkleTree(agg.leaves)
+	record, err := a.store.SignAndCommit(context.Background(), id, domain.MerkleRootData(agg.tree.Root()), "")
+	if err != nil {
+		agg.err = err
+	} else {
+		agg.record = *record
+	}
+	close(agg.done)
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/casell/signing-service-challenge/domain"
+	mockPersistence "github.com/casell/signing-service-challenge/mocks/github.com/casell/signing-service-challenge/persistence"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// signConcurrently has n requests sign with the aggregator at once.
+func signConcurrently(a *Aggregator, id uuid.UUID, n int) ([]*AggregatedSignature, []error) {
+	signatures := make([]*AggregatedSignature, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			signatures[i], errs[i] = a.Sign(context.TODO(), id, "data"+strconv.Itoa(i))
+		}(i)
+	}
+	wg.Wait()
+	return signatures, errs
+}
+
+func TestAggregatorSign(t *testing.T) {
+	id := uuid.New()
+
+	mockStorage := mockPersistence.NewMockStorage(t)
+	mockStorage.EXPECT().SignAndCommit(mock.Anything, id, mock.Anything, "").
+		RunAndReturn(func(_ context.Context, _ uuid.UUID, data string, _ string) (*domain.SignatureRecord, error) {
+			return &domain.SignatureRecord{Counter: 7, Data: data, SignedData: "7_" + data + "_last", Signature: "sig"}, nil
+		}).Once()
+
+	signatures, errs := signConcurrently(NewAggregator(mockStorage, 50*time.Millisecond, 100), id, 5)
+
+	leaves := map[int]bool{}
+	for i, signature := range signatures {
+		if !assert.NoError(t, errs[i]) {
+			continue
+		}
+		assert.Equal(t, uint(7), signature.Record.Counter)
+		assert.Equal(t, domain.MerkleRootData(signature.Root), signature.Record.Data)
+		assert.True(t, domain.VerifyMerkleProof("data"+strconv.Itoa(i), signature.Proof, signature.Root))
+		leaves[signature.LeafIndex] = true
+	}
+	assert.Len(t, leaves, 5)
+}
+
+func TestAggregatorMaxLeaves(t *testing.T) {
+	id := uuid.New()
+
+	mockStorage := mockPersistence.NewMockStorage(t)
+	mockStorage.EXPECT().SignAndCommit(mock.Anything, id, mock.Anything, "").
+		Return(&domain.SignatureRecord{Counter: 0}, nil).Times(2)
+
+	// The window would outlast the test, full windows are flushed right away.
+	signatures, errs := signConcurrently(NewAggregator(mockStorage, time.Hour, 2), id, 4)
+
+	for i := range signatures {
+		assert.NoError(t, errs[i])
+		assert.Len(t, signatures[i].Proof, 1)
+	}
+}
+
+func TestAggregatorSignError(t *testing.T) {
+	id := uuid.New()
+
+	mockStorage := mockPersistence.NewMockStorage(t)
+	signErr := errors.New("Sign error")
+	mockStorage.EXPECT().SignAndCommit(mock.Anything, id, mock.Anything, "").Return(nil, signErr).Once()
+
+	signatures, errs := signConcurrently(NewAggregator(mockStorage, 50*time.Millisecond, 100), id, 3)
+
+	for i := range signatures {
+		assert.Nil(t, signatures[i])
+		assert.Equal(t, signErr, errs[i])
+	}
+}
+
+func TestAggregatorContextDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+	cancel()
+
+	signature, err := NewAggregator(mockPersistence.NewMockStorage(t), time.Millisecond, 100).Sign(ctx, uuid.New(), "data")
+
+	assert.Nil(t, signature)
+	assert.ErrorIs(t, err, context.Canceled)
+}
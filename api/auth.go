@@ -0,0 +1,190 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/casell/signing-service-challenge/generated/signingapi"
+	"github.com/casell/signing-service-challenge/persistence"
+	"github.com/google/uuid"
+)
+
+const (
+	// SCOPE_ADMIN grants device administration, webhooks and API keys.
+	SCOPE_ADMIN = "admin"
+	// SCOPE_SIGN grants signing and signing jobs.
+	SCOPE_SIGN = "sign"
+	// SCOPE_READ grants reading devices, signatures and events, and verifying signatures.
+	SCOPE_READ = "read"
+
+	// API_KEY_HEADER is the header requests carry their API key in, the gRPC metadata key is its lowercase.
+	API_KEY_HEADER = "X-API-Key"
+
+	// BOOTSTRAP_API_KEY_NAME is the name of the key issued when none is stored yet.
+	BOOTSTRAP_API_KEY_NAME = "bootstrap"
+
+	apiKeySize = 32
+)
+
+// API_KEY_SCOPES are the scopes an API key can be granted.
+var API_KEY_SCOPES = []string{SCOPE_ADMIN, SCOPE_SIGN, SCOPE_READ}
+
+// operationScopes is the scope each operation requires, by operation name. The gRPC methods share the
+// name of their REST counterpart. An operation missing here is refused to every key.
+var operationScopes = map[string]string{
+	"CreateDevice":       SCOPE_ADMIN,
+	"UpdateDevice":       SCOPE_ADMIN,
+	"ActivateDevice":     SCOPE_ADMIN,
+	"DisableDevice":      SCOPE_ADMIN,
+	"DecommissionDevice": SCOPE_ADMIN,
+	"CreateWebhook":      SCOPE_ADMIN,
+	"ListWebhooks":       SCOPE_ADMIN,
+	"GetWebhook":         SCOPE_ADMIN,
+	"UpdateWebhook":      SCOPE_ADMIN,
+	"DeleteWebhook":      SCOPE_ADMIN,
+	"CreateAPIKey":       SCOPE_ADMIN,
+	"ListAPIKeys":        SCOPE_ADMIN,
+	"RevokeAPIKey":       SCOPE_ADMIN,
+
+	"SignTransaction":           SCOPE_SIGN,
+	"SignTransactionBatch":      SCOPE_SIGN,
+	"SignTransactionAggregated": SCOPE_SIGN,
+	"GetJob":                    SCOPE_SIGN,
+
+	"GetDevice":         SCOPE_READ,
+	"ListDevices":       SCOPE_READ,
+	"GetSignature":      SCOPE_READ,
+	"ListSignatures":    SCOPE_READ,
+	"VerifySignature":   SCOPE_READ,
+	"VerifyInclusion":   SCOPE_READ,
+	"VerifyStoredChain": SCOPE_READ,
+	// Served by EventHandler, which requires the same scope.
+	"StreamEvents":       SCOPE_READ,
+	"StreamDeviceEvents": SCOPE_READ,
+}
+
+// Authenticator checks the API keys requests are made with against the keys issued in an APIKeyStorage.
+// It is the SecurityHandler of the generated server.
+type Authenticator struct {
+	keys persistence.APIKeyStorage
+}
+
+// NewAuthenticator returns an Authenticator accepting the keys issued in keys.
+func NewAuthenticator(keys persistence.APIKeyStorage) *Authenticator {
+	return &Authenticator{keys: keys}
+}
+
+// HashAPIKey returns the hash an API key is stored as: keys are random, so a plain SHA-256 is enough.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Authorize returns the stored API key key stands for, provided it is granted scope.
+func (a *Authenticator) Authorize(ctx context.Context, key string, scope string) (*persistence.APIKey, error) {
+	if key == "" {
+		return nil, errMissingAPIKey{}
+	}
+	stored, err := a.keys.GetAPIKeyByHash(ctx, HashAPIKey(key))
+	if err != nil {
+		return nil, err
+	}
+	if stored == nil {
+		return nil, errInvalidAPIKey{}
+	}
+	if scope == "" || !slices.Contains(stored.Scopes, scope) {
+		return nil, errScopeNotGranted{scope: scope}
+	}
+	return stored, nil
+}
+
+// AuthorizeOperation is Authorize for the scope operation requires.
+func (a *Authenticator) AuthorizeOperation(ctx context.Context, key string, operation string) (*persistence.APIKey, error) {
+	return a.Authorize(ctx, key, operationScopes[operation])
+}
+
+// HandleApiKeyAuth handles the ApiKeyAuth security of the generated server.
+func (a *Authenticator) HandleApiKeyAuth(ctx context.Context, operationName string, t signingapi.ApiKeyAuth) (context.Context, error) {
+	_, err := a.AuthorizeOperation(ctx, t.APIKey, operationName)
+	return ctx, err
+}
+
+// RequireScope serves next only to the requests made with an API key granted scope,
+// for the routes served outside of the generated server.
+func (a *Authenticator) RequireScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, err := a.Authorize(r.Context(), r.Header.Get(API_KEY_HEADER), scope)
+		switch err.(type) {
+		case nil:
+			next(w, r)
+		case errMissingAPIKey, errInvalidAPIKey:
+			WriteErrorResponse(w, http.StatusUnauthorized, []string{err.Error()})
+		case errScopeNotGranted:
+			WriteErrorResponse(w, http.StatusForbidden, []string{err.Error()})
+		default:
+			WriteErrorResponse(w, http.StatusInternalServerError, []string{err.Error()})
+		}
+	}
+}
+
+// IssueAPIKey generates an API key granted scopes and stores its hash in keys.
+// The key is returned alongside its stored record, it cannot be recovered afterwards.
+func IssueAPIKey(ctx context.Context, keys persistence.APIKeyStorage, name string, scopes []string) (string, *persistence.APIKey, error) {
+	secret := make([]byte, apiKeySize)
+	if _, err := rand.Read(secret); err != nil {
+		return "", nil, err
+	}
+	key := hex.EncodeToString(secret)
+	stored, err := addAPIKey(ctx, keys, key, name, scopes)
+	if err != nil {
+		return "", nil, err
+	}
+	return key, stored, nil
+}
+
+// BootstrapAPIKey makes sure keys is not left without a key: if it is empty, key is issued with every scope,
+// or a generated one if key is empty. It returns the key issued, an empty string if keys were already issued.
+func BootstrapAPIKey(ctx context.Context, keys persistence.APIKeyStorage, key string) (string, error) {
+	issued, err := keys.ListAPIKeys(ctx)
+	if err != nil || len(issued) > 0 {
+		return "", err
+	}
+	if key == "" {
+		key, _, err = IssueAPIKey(ctx, keys, BOOTSTRAP_API_KEY_NAME, API_KEY_SCOPES)
+		return key, err
+	}
+	_, err = addAPIKey(ctx, keys, key, BOOTSTRAP_API_KEY_NAME, API_KEY_SCOPES)
+	return key, err
+}
+
+// addAPIKey stores the hash of key, granted the distinct scopes.
+func addAPIKey(ctx context.Context, keys persistence.APIKeyStorage, key string, name string, scopes []string) (*persistence.APIKey, error) {
+	if len(scopes) == 0 {
+		return nil, errNoScope{}
+	}
+	granted := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		if !slices.Contains(API_KEY_SCOPES, scope) {
+			return nil, errInvalidScope{scope: scope}
+		}
+		if !slices.Contains(granted, scope) {
+			granted = append(granted, scope)
+		}
+	}
+	stored := persistence.APIKey{
+		ID:        uuid.New(),
+		Name:      name,
+		Scopes:    granted,
+		Hash:      HashAPIKey(key),
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := keys.AddAPIKey(ctx, stored); err != nil {
+		return nil, err
+	}
+	return &stored, nil
+}
@@ -0,0 +1,157 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/casell/signing-service-challenge/domain"
+	"github.com/casell/signing-service-challenge/generated/signingapi"
+	"github.com/casell/signing-service-challenge/persistence"
+	"github.com/stretchr/testify/assert"
+)
+
+func issueTestAPIKey(t *testing.T, keys persistence.APIKeyStorage, scopes ...string) string {
+	key, _, err := IssueAPIKey(context.Background(), keys, "test", scopes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestOperationScopes(t *testing.T) {
+	handler := reflect.TypeOf((*signingapi.Handler)(nil)).Elem()
+	for i := 0; i < handler.NumMethod(); i++ {
+		operation := handler.Method(i).Name
+		if operation == "NewError" {
+			continue
+		}
+		assert.Contains(t, API_KEY_SCOPES, operationScopes[operation], "operation %s has no scope", operation)
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	keys := persistence.NewMemoryAPIKeyStore()
+	auth := NewAuthenticator(keys)
+	signer := issueTestAPIKey(t, keys, SCOPE_SIGN, SCOPE_READ)
+	ctx := context.Background()
+
+	stored, err := auth.Authorize(ctx, signer, SCOPE_SIGN)
+	assert.NoError(t, err)
+	if assert.NotNil(t, stored) {
+		assert.Equal(t, "test", stored.Name)
+	}
+	_, err = auth.Authorize(ctx, signer, SCOPE_ADMIN)
+	assert.Equal(t, errScopeNotGranted{SCOPE_ADMIN}, err)
+	_, err = auth.Authorize(ctx, "", SCOPE_READ)
+	assert.Equal(t, errMissingAPIKey{}, err)
+	_, err = auth.Authorize(ctx, "unknown", SCOPE_READ)
+	assert.Equal(t, errInvalidAPIKey{}, err)
+
+	_, err = auth.HandleApiKeyAuth(ctx, "SignTransaction", signingapi.ApiKeyAuth{APIKey: signer})
+	assert.NoError(t, err)
+	_, err = auth.HandleApiKeyAuth(ctx, "DecommissionDevice", signingapi.ApiKeyAuth{APIKey: signer})
+	assert.Equal(t, errScopeNotGranted{SCOPE_ADMIN}, err)
+	// An operation without a scope is refused.
+	_, err = auth.HandleApiKeyAuth(ctx, "Unknown", signingapi.ApiKeyAuth{APIKey: signer})
+	assert.ErrorAs(t, err, &errScopeNotGranted{})
+}
+
+func TestIssueAPIKey(t *testing.T) {
+	keys := persistence.NewMemoryAPIKeyStore()
+	ctx := context.Background()
+
+	_, _, err := IssueAPIKey(ctx, keys, "test", nil)
+	assert.Equal(t, errNoScope{}, err)
+	_, _, err = IssueAPIKey(ctx, keys, "test", []string{SCOPE_READ, "root"})
+	assert.Equal(t, errInvalidScope{"root"}, err)
+
+	first, stored, err := IssueAPIKey(ctx, keys, "test", []string{SCOPE_READ, SCOPE_READ})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{SCOPE_READ}, stored.Scopes)
+	assert.Equal(t, HashAPIKey(first), stored.Hash)
+	second, _, err := IssueAPIKey(ctx, keys, "test", []string{SCOPE_READ})
+	assert.NoError(t, err)
+	assert.NotEqual(t, first, second)
+}
+
+func TestBootstrapAPIKey(t *testing.T) {
+	ctx := context.Background()
+
+	keys := persistence.NewMemoryAPIKeyStore()
+	key, err := BootstrapAPIKey(ctx, keys, "")
+	assert.NoError(t, err)
+	assert.Len(t, key, 2*apiKeySize)
+	stored, _ := keys.GetAPIKeyByHash(ctx, HashAPIKey(key))
+	if assert.NotNil(t, stored) {
+		assert.Equal(t, BOOTSTRAP_API_KEY_NAME, stored.Name)
+		assert.Equal(t, API_KEY_SCOPES, stored.Scopes)
+	}
+	// Nothing is issued once a key exists.
+	key, err = BootstrapAPIKey(ctx, keys, "")
+	assert.NoError(t, err)
+	assert.Empty(t, key)
+
+	keys = persistence.NewMemoryAPIKeyStore()
+	key, err = BootstrapAPIKey(ctx, keys, "configured")
+	assert.NoError(t, err)
+	assert.Equal(t, "configured", key)
+	_, err = NewAuthenticator(keys).Authorize(ctx, "configured", SCOPE_ADMIN)
+	assert.NoError(t, err)
+}
+
+func TestRequireScope(t *testing.T) {
+	keys := persistence.NewMemoryAPIKeyStore()
+	auth := NewAuthenticator(keys)
+	reader := issueTestAPIKey(t, keys, SCOPE_READ)
+	signer := issueTestAPIKey(t, keys, SCOPE_SIGN)
+	handler := auth.RequireScope(SCOPE_READ, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	for key, status := range map[string]int{reader: http.StatusNoContent, signer: http.StatusForbidden, "": http.StatusUnauthorized, "unknown": http.StatusUnauthorized} {
+		req := httptest.NewRequest(http.MethodGet, "/events", nil)
+		if key != "" {
+			req.Header.Set(API_KEY_HEADER, key)
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		assert.Equal(t, status, rec.Code, "key %q", key)
+	}
+}
+
+func TestGeneratedServerSecurity(t *testing.T) {
+	store := persistence.NewMemoryStore()
+	dh := NewDeviceHandler(store, store.Journal(), domain.NewDefaultDeviceFactory())
+	auth := NewAuthenticator(dh.apikeys)
+	srv, err := signingapi.NewServer(dh, auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader := issueTestAPIKey(t, dh.apikeys, SCOPE_READ)
+	admin := issueTestAPIKey(t, dh.apikeys, SCOPE_ADMIN)
+
+	for _, tc := range []struct {
+		method string
+		path   string
+		key    string
+		status int
+	}{
+		{http.MethodGet, "/device", "", http.StatusUnauthorized},
+		{http.MethodGet, "/device", "unknown", http.StatusUnauthorized},
+		{http.MethodGet, "/device", reader, http.StatusOK},
+		{http.MethodGet, "/api-keys", reader, http.StatusForbidden},
+		{http.MethodGet, "/api-keys", admin, http.StatusOK},
+		{http.MethodGet, "/device", admin, http.StatusForbidden},
+	} {
+		req := httptest.NewRequest(tc.method, tc.path, nil)
+		if tc.key != "" {
+			req.Header.Set(API_KEY_HEADER, tc.key)
+		}
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		assert.Equal(t, tc.status, rec.Code, "%s %s with key %q: %s", tc.method, tc.path, tc.key, rec.Body.String())
+	}
+}
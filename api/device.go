@@ -2,27 +2,89 @@ package api
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
+	mycrypto "github.com/casell/signing-service-challenge/crypto"
 	"github.com/casell/signing-service-challenge/domain"
 	"github.com/casell/signing-service-challenge/generated/signingapi"
 	"github.com/casell/signing-service-challenge/persistence"
+	"github.com/google/uuid"
+	"github.com/ogen-go/ogen/ogenerrors"
+)
+
+const (
+	defaultSignaturesLimit = 100
+	defaultDevicesLimit    = 100
+	chainVerificationPage  = 1000
+	webhookSecretSize      = 32
 )
 
 // DeviceHandler represents the HTTP Handler to reply to signing api requests.
 type DeviceHandler struct {
 	store         persistence.Storage
+	journal       persistence.SignatureJournal
 	devicefactory domain.SigningDeviceFactory
+	aggregator    *Aggregator
+	jobs          *JobQueue
+	webhooks      persistence.WebhookStorage
+	apikeys       persistence.APIKeyStorage
 }
 
-// NewDeviceHandler creates a device handler backed by the Storage store.
-func NewDeviceHandler(store persistence.Storage, devicefactory domain.SigningDeviceFactory) *DeviceHandler {
+// DeviceHandlerOptions are the dependencies of a DeviceHandler. The handler is built once from them
+// and shared by the REST and gRPC servers, so that both serve the same jobs, aggregation windows and webhooks.
+type DeviceHandlerOptions struct {
+	Store persistence.Storage
+	// Journal is the SignatureJournal Store commits to.
+	Journal       persistence.SignatureJournal
+	DeviceFactory domain.SigningDeviceFactory
+	// AggregationWindow is how long aggregated signatures are collected, DEFAULT_AGGREGATION_WINDOW when zero.
+	AggregationWindow time.Duration
+	// Webhooks and APIKeys are kept in memory when nil.
+	Webhooks persistence.WebhookStorage
+	APIKeys  persistence.APIKeyStorage
+}
+
+// NewDeviceHandlerWithOptions creates a device handler from opts, async signatures are run by a JobQueue.
+func NewDeviceHandlerWithOptions(opts DeviceHandlerOptions) *DeviceHandler {
+	if opts.AggregationWindow == 0 {
+		opts.AggregationWindow = DEFAULT_AGGREGATION_WINDOW
+	}
+	if opts.Webhooks == nil {
+		opts.Webhooks = persistence.NewMemoryWebhookStore()
+	}
+	if opts.APIKeys == nil {
+		opts.APIKeys = persistence.NewMemoryAPIKeyStore()
+	}
 	return &DeviceHandler{
-		store:         store,
-		devicefactory: devicefactory,
+		store:         opts.Store,
+		journal:       opts.Journal,
+		devicefactory: opts.DeviceFactory,
+		aggregator:    NewAggregator(opts.Store, opts.AggregationWindow, DEFAULT_AGGREGATION_MAX_LEAVES),
+		jobs:          NewJobQueue(opts.Store, NewCallbackClient(JOB_CALLBACK_TIMEOUT)),
+		webhooks:      opts.Webhooks,
+		apikeys:       opts.APIKeys,
 	}
 }
 
+// NewDeviceHandler creates a device handler backed by the Storage store,
+// reading produced signatures from journal, the SignatureJournal store commits to.
+// The other DeviceHandlerOptions are left to their defaults.
+func NewDeviceHandler(store persistence.Storage, journal persistence.SignatureJournal, devicefactory domain.SigningDeviceFactory) *DeviceHandler {
+	return NewDeviceHandlerWithOptions(DeviceHandlerOptions{
+		Store:         store,
+		Journal:       journal,
+		DeviceFactory: devicefactory,
+	})
+}
+
 // CreateDevice handles device creation requests.
 func (h *DeviceHandler) CreateDevice(ctx context.Context, req *signingapi.DeviceRequest) (*signingapi.DeviceResponse, error) {
 
@@ -35,11 +97,30 @@ func (h *DeviceHandler) CreateDevice(ctx context.Context, req *signingapi.Device
 		label = &origlabel
 	}
 
-	device, err := h.devicefactory.New(string(req.GetSignatureAlgorithm()), label)
+	parameters := mycrypto.Parameters{}
+
+	if keySize, ok := req.GetKeySize().Get(); ok {
+		parameters.KeySize = keySize
+	}
+	if curve, ok := req.GetCurve().Get(); ok {
+		parameters.Curve = string(curve)
+	}
+	if hash, ok := req.GetHashAlgorithm().Get(); ok {
+		parameters.Hash = string(hash)
+	}
+
+	id, ok := req.GetID().Get()
+	if ok && id == uuid.Nil {
+		return nil, errInvalidDeviceID{id.String()}
+	}
+
+	status := domain.DeviceStatus(req.GetStatus().Or(signingapi.DeviceRequestStatusACTIVE))
+
+	device, err := h.devicefactory.New(id, status, string(req.GetSignatureAlgorithm()), label, parameters)
 	if err != nil {
 		return nil, err
 	}
-	if err := h.store.Add(device); err != nil {
+	if err := h.store.Add(ctx, device); err != nil {
 		return nil, err
 	}
 
@@ -47,68 +128,645 @@ func (h *DeviceHandler) CreateDevice(ctx context.Context, req *signingapi.Device
 }
 
 // SignTransaction handles signing requests.
-func (h *DeviceHandler) SignTransaction(ctx context.Context, req *signingapi.SignatureRequest, params signingapi.SignTransactionParams) (*signingapi.SignatureResponse, error) {
+func (h *DeviceHandler) SignTransaction(ctx context.Context, req *signingapi.SignatureRequest, params signingapi.SignTransactionParams) (signingapi.SignTransactionRes, error) {
 
-	device, err := h.store.Get(params.Deviceid)
+	callbackURL, err := parseCallbackURL(req, params.Async.Or(false))
 	if err != nil {
 		return nil, err
 	}
+	if params.Async.Or(false) {
+		// Fail fast on unknown devices, the status is only checked when the job runs.
+		if _, err := h.store.Get(ctx, params.Deviceid); err != nil {
+			return nil, err
+		}
+		submitted, err := h.jobs.Submit(params.Deviceid, req.DataToBeSigned, params.IdempotencyKey.Or(""), callbackURL)
+		if err != nil {
+			return nil, err
+		}
+		job := convertToApiJob(submitted)
+		return &job, nil
+	}
 
-	if device == nil {
-		return nil, errDeviceNotFound{params.Deviceid.String()}
+	record, err := h.store.SignAndCommit(ctx, params.Deviceid, req.DataToBeSigned, params.IdempotencyKey.Or(""))
+	if err != nil {
+		return nil, err
 	}
 
-	signature, extendedDataToBeSigned, err := device.Sign(req.DataToBeSigned)
+	return &signingapi.SignatureResponse{
+		Signature:  record.Signature,
+		SignedData: record.SignedData,
+		Counter:    int(record.Counter),
+	}, nil
+}
+
+// parseCallbackURL returns the callback URL of req, empty if not set. Callbacks are only allowed for async requests.
+func parseCallbackURL(req *signingapi.SignatureRequest, async bool) (string, error) {
+	callbackURL, ok := req.CallbackUrl.Get()
+	if !ok {
+		return "", nil
+	}
+	if !async {
+		return "", errInvalidCallbackURL{url: callbackURL.String(), reason: "only async requests can have a callback"}
+	}
+	if (callbackURL.Scheme != "http" && callbackURL.Scheme != "https") || callbackURL.Host == "" {
+		return "", errInvalidCallbackURL{url: callbackURL.String(), reason: "not an absolute http(s) URL"}
+	}
+	if reason := checkCallbackHost(callbackURL.Hostname()); reason != "" {
+		return "", errInvalidCallbackURL{url: callbackURL.String(), reason: reason}
+	}
+	return callbackURL.String(), nil
+}
+
+// GetJob handles signing job requests.
+func (h *DeviceHandler) GetJob(ctx context.Context, params signingapi.GetJobParams) (*signingapi.Job, error) {
+	job, ok := h.jobs.Get(params.Jobid)
+	if !ok {
+		return nil, errJobNotFound{jobID: params.Jobid.String()}
+	}
+	res := convertToApiJob(job)
+	return &res, nil
+}
+
+func convertToApiJob(job Job) signingapi.Job {
+	res := signingapi.Job{
+		ID:        job.ID,
+		DeviceId:  job.DeviceID,
+		Status:    signingapi.JobStatus(job.Status),
+		CreatedAt: job.CreatedAt,
+	}
+	if !job.CompletedAt.IsZero() {
+		res.CompletedAt = signingapi.NewOptDateTime(job.CompletedAt)
+	}
+	if job.Record != nil {
+		res.Signature = signingapi.NewOptSignatureResponse(signingapi.SignatureResponse{
+			Signature:  job.Record.Signature,
+			SignedData: job.Record.SignedData,
+			Counter:    int(job.Record.Counter),
+		})
+	}
+	if job.Err != nil {
+		res.Error = signingapi.NewOptString(job.Err.Error())
+	}
+	return res
+}
+
+// CreateWebhook handles webhook registration requests, the generated secret is only returned here.
+func (h *DeviceHandler) CreateWebhook(ctx context.Context, req *signingapi.WebhookRequest) (*signingapi.Webhook, error) {
+	if err := checkWebhookURL(req.URL); err != nil {
+		return nil, err
+	}
+	secret := make([]byte, webhookSecretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	webhook := persistence.Webhook{
+		ID:         uuid.New(),
+		URL:        req.URL.String(),
+		EventTypes: convertFromApiEventTypes(req.EventTypes),
+		Secret:     hex.EncodeToString(secret),
+		CreatedAt:  time.Now().UTC(),
+	}
+	if err := h.webhooks.AddWebhook(ctx, webhook); err != nil {
+		return nil, err
+	}
+	res, err := convertToApiWebhook(webhook)
 	if err != nil {
 		return nil, err
 	}
+	res.Secret = signingapi.NewOptString(webhook.Secret)
+	return res, nil
+}
 
-	if err := h.store.Put(device); err != nil {
+// ListWebhooks handles webhook list requests.
+func (h *DeviceHandler) ListWebhooks(ctx context.Context) (*signingapi.WebhookList, error) {
+	webhooks, err := h.webhooks.ListWebhooks(ctx)
+	if err != nil {
 		return nil, err
 	}
+	items := make([]signingapi.Webhook, 0, len(webhooks))
+	for _, webhook := range webhooks {
+		item, err := convertToApiWebhook(webhook)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, *item)
+	}
+	return &signingapi.WebhookList{Items: items}, nil
+}
 
-	return &signingapi.SignatureResponse{
-		Signature:  signature,
-		SignedData: extendedDataToBeSigned,
+// GetWebhook handles webhook requests.
+func (h *DeviceHandler) GetWebhook(ctx context.Context, params signingapi.GetWebhookParams) (*signingapi.Webhook, error) {
+	webhook, err := h.webhooks.GetWebhook(ctx, params.Webhookid)
+	if err != nil {
+		return nil, err
+	}
+	return convertToApiWebhook(*webhook)
+}
+
+// UpdateWebhook handles webhook update requests, fields left out are unchanged.
+func (h *DeviceHandler) UpdateWebhook(ctx context.Context, req *signingapi.WebhookUpdateRequest, params signingapi.UpdateWebhookParams) (*signingapi.Webhook, error) {
+	webhook, err := h.webhooks.GetWebhook(ctx, params.Webhookid)
+	if err != nil {
+		return nil, err
+	}
+	if webhookURL, ok := req.URL.Get(); ok {
+		if err := checkWebhookURL(webhookURL); err != nil {
+			return nil, err
+		}
+		webhook.URL = webhookURL.String()
+	}
+	if req.EventTypes != nil {
+		webhook.EventTypes = convertFromApiEventTypes(req.EventTypes)
+	}
+	if err := h.webhooks.PutWebhook(ctx, *webhook); err != nil {
+		return nil, err
+	}
+	return convertToApiWebhook(*webhook)
+}
+
+// DeleteWebhook handles webhook deletion requests.
+func (h *DeviceHandler) DeleteWebhook(ctx context.Context, params signingapi.DeleteWebhookParams) error {
+	return h.webhooks.DeleteWebhook(ctx, params.Webhookid)
+}
+
+// checkWebhookURL accepts absolute http(s) URLs of public hosts only, like callback URLs.
+func checkWebhookURL(webhookURL url.URL) error {
+	if (webhookURL.Scheme != "http" && webhookURL.Scheme != "https") || webhookURL.Host == "" {
+		return errInvalidWebhookURL{url: webhookURL.String(), reason: "not an absolute http(s) URL"}
+	}
+	if reason := checkCallbackHost(webhookURL.Hostname()); reason != "" {
+		return errInvalidWebhookURL{url: webhookURL.String(), reason: reason}
+	}
+	return nil
+}
+
+// convertFromApiEventTypes returns the distinct event types, in request order.
+func convertFromApiEventTypes(eventTypes []signingapi.WebhookEventType) []string {
+	res := make([]string, 0, len(eventTypes))
+	for _, eventType := range eventTypes {
+		if !slices.Contains(res, string(eventType)) {
+			res = append(res, string(eventType))
+		}
+	}
+	return res
+}
+
+// convertToApiWebhook converts webhook, leaving its secret out.
+func convertToApiWebhook(webhook persistence.Webhook) (*signingapi.Webhook, error) {
+	webhookURL, err := url.Parse(webhook.URL)
+	if err != nil {
+		return nil, err
+	}
+	eventTypes := make([]signingapi.WebhookEventType, 0, len(webhook.EventTypes))
+	for _, eventType := range webhook.EventTypes {
+		eventTypes = append(eventTypes, signingapi.WebhookEventType(eventType))
+	}
+	return &signingapi.Webhook{
+		ID:         webhook.ID,
+		URL:        *webhookURL,
+		EventTypes: eventTypes,
+		CreatedAt:  webhook.CreatedAt,
+	}, nil
+}
+
+// CreateAPIKey handles API key issuing requests, the key is only returned here.
+func (h *DeviceHandler) CreateAPIKey(ctx context.Context, req *signingapi.APIKeyRequest) (*signingapi.APIKey, error) {
+	scopes := make([]string, len(req.Scopes))
+	for i, scope := range req.Scopes {
+		scopes[i] = string(scope)
+	}
+	key, stored, err := IssueAPIKey(ctx, h.apikeys, req.Name, scopes)
+	if err != nil {
+		return nil, err
+	}
+	res := convertToApiAPIKey(*stored)
+	res.Key = signingapi.NewOptString(key)
+	return res, nil
+}
+
+// ListAPIKeys handles API key list requests.
+func (h *DeviceHandler) ListAPIKeys(ctx context.Context) (*signingapi.APIKeyList, error) {
+	keys, err := h.apikeys.ListAPIKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]signingapi.APIKey, 0, len(keys))
+	for _, key := range keys {
+		items = append(items, *convertToApiAPIKey(key))
+	}
+	return &signingapi.APIKeyList{Items: items}, nil
+}
+
+// RevokeAPIKey handles API key revocation requests.
+func (h *DeviceHandler) RevokeAPIKey(ctx context.Context, params signingapi.RevokeAPIKeyParams) error {
+	return h.apikeys.DeleteAPIKey(ctx, params.Keyid)
+}
+
+// convertToApiAPIKey converts key, which carries no more than the hash of the key.
+func convertToApiAPIKey(key persistence.APIKey) *signingapi.APIKey {
+	scopes := make([]signingapi.APIKeyScope, len(key.Scopes))
+	for i, scope := range key.Scopes {
+		scopes[i] = signingapi.APIKeyScope(scope)
+	}
+	return &signingapi.APIKey{
+		ID:        key.ID,
+		Name:      key.Name,
+		Scopes:    scopes,
+		CreatedAt: key.CreatedAt,
+	}
+}
+
+// SignTransactionBatch handles batch signing requests, the signatures are returned in request order.
+func (h *DeviceHandler) SignTransactionBatch(ctx context.Context, req *signingapi.BatchSignatureRequest, params signingapi.SignTransactionBatchParams) (*signingapi.BatchSignatureResponse, error) {
+	records, err := h.store.SignBatchAndCommit(ctx, params.Deviceid, req.DataToBeSigned)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]signingapi.SignatureResponse, len(records))
+	for i, v := range records {
+		items[i] = signingapi.SignatureResponse{
+			Signature:  v.Signature,
+			SignedData: v.SignedData,
+			Counter:    int(v.Counter),
+		}
+	}
+	return &signingapi.BatchSignatureResponse{Items: items}, nil
+}
+
+// SignTransactionAggregated handles aggregated signing requests, see Aggregator.
+func (h *DeviceHandler) SignTransactionAggregated(ctx context.Context, req *signingapi.SignatureRequest, params signingapi.SignTransactionAggregatedParams) (*signingapi.AggregatedSignatureResponse, error) {
+	if _, err := h.store.Get(ctx, params.Deviceid); err != nil {
+		return nil, err
+	}
+
+	signature, err := h.aggregator.Sign(ctx, params.Deviceid, req.DataToBeSigned)
+	if err != nil {
+		return nil, err
+	}
+
+	proof := make([]signingapi.MerkleProofStep, len(signature.Proof))
+	for i, v := range signature.Proof {
+		proof[i] = signingapi.MerkleProofStep{
+			Hash:     v.Hash,
+			Position: signingapi.MerkleProofStepPosition(v.Position),
+		}
+	}
+	return &signingapi.AggregatedSignatureResponse{
+		Signature:  signature.Record.Signature,
+		SignedData: signature.Record.SignedData,
+		Counter:    int(signature.Record.Counter),
+		Root:       signature.Root,
+		LeafIndex:  signature.LeafIndex,
+		Proof:      proof,
+	}, nil
+}
+
+// ListSignatures handles signature journal list requests.
+func (h *DeviceHandler) ListSignatures(ctx context.Context, params signingapi.ListSignaturesParams) (*signingapi.SignatureList, error) {
+	if _, err := h.store.Get(ctx, params.Deviceid); err != nil {
+		return nil, err
+	}
+
+	records, total, err := h.journal.List(ctx, params.Deviceid, params.Offset.Or(0), params.Limit.Or(defaultSignaturesLimit))
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]signingapi.SignatureRecord, len(records))
+	for i, v := range records {
+		items[i] = convertToApiSignatureRecord(v)
+	}
+
+	return &signingapi.SignatureList{
+		Items: items,
+		Total: total,
+	}, nil
+}
+
+// GetSignature handles signature journal retrieval requests.
+func (h *DeviceHandler) GetSignature(ctx context.Context, params signingapi.GetSignatureParams) (*signingapi.SignatureRecord, error) {
+	record, err := h.journal.Get(ctx, params.Deviceid, uint(params.Counter))
+	if err != nil {
+		return nil, err
+	}
+
+	if record == nil {
+		return nil, errSignatureNotFound{params.Deviceid.String(), params.Counter}
+	}
+
+	res := convertToApiSignatureRecord(*record)
+	return &res, nil
+}
+
+func convertToApiSignatureRecord(record domain.SignatureRecord) signingapi.SignatureRecord {
+	return signingapi.SignatureRecord{
+		Counter:    int(record.Counter),
+		Data:       record.Data,
+		SignedData: record.SignedData,
+		Signature:  record.Signature,
+		Timestamp:  record.Timestamp,
+	}
+}
+
+// VerifySignature handles signature verification requests.
+func (h *DeviceHandler) VerifySignature(ctx context.Context, req *signingapi.VerificationRequest, params signingapi.VerifySignatureParams) (*signingapi.VerificationResponse, error) {
+
+	device, err := h.store.Get(ctx, params.Deviceid)
+	if err != nil {
+		return nil, err
+	}
+
+	valid, err := device.Verify(req.SignedData, req.Signature)
+	if err != nil {
+		return nil, err
+	}
+
+	return &signingapi.VerificationResponse{
+		Valid: valid,
+	}, nil
+}
+
+// VerifyInclusion handles aggregated signature verification requests.
+func (h *DeviceHandler) VerifyInclusion(ctx context.Context, req *signingapi.InclusionVerificationRequest, params signingapi.VerifyInclusionParams) (*signingapi.VerificationResponse, error) {
+
+	device, err := h.store.Get(ctx, params.Deviceid)
+	if err != nil {
+		return nil, err
+	}
+
+	proof := make([]domain.MerkleProofStep, len(req.Proof))
+	for i, v := range req.Proof {
+		proof[i] = domain.MerkleProofStep{
+			Hash:     v.Hash,
+			Position: string(v.Position),
+		}
+	}
+
+	valid, err := domain.VerifyInclusion(device, req.DataToBeSigned, proof, domain.ChainLink{
+		Signature:  req.Signature,
+		SignedData: req.SignedData,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &signingapi.VerificationResponse{
+		Valid: valid,
+	}, nil
+}
+
+// VerifyStoredChain handles verification requests of the signature chain kept in the journal:
+// the stored history is walked from counter 0, a page at a time.
+func (h *DeviceHandler) VerifyStoredChain(ctx context.Context, params signingapi.VerifyStoredChainParams) (*signingapi.ChainVerificationResponse, error) {
+
+	device, err := h.store.Get(ctx, params.Deviceid)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read the counter first: signatures produced afterwards are not part of the verification.
+	counter, _ := device.CounterAndLastSignature()
+
+	verifier := domain.NewChainVerifier(device)
+	for verifier.Length() < counter {
+		records, _, err := h.journal.List(ctx, params.Deviceid, int(verifier.Length()), chainVerificationPage)
+		if err != nil {
+			return nil, err
+		}
+		if len(records) == 0 {
+			// A valid but incomplete chain means that signatures are missing from the journal.
+			return convertToApiChainBreak(&domain.ChainBreak{Counter: verifier.Length(), Reason: domain.CHAIN_BREAK_COUNTER_GAP})
+		}
+		for _, v := range records {
+			if verifier.Length() == counter {
+				break
+			}
+			chainBreak, err := verifier.Next(domain.ChainLink{Signature: v.Signature, SignedData: v.SignedData})
+			if err != nil {
+				return nil, err
+			}
+			if chainBreak != nil {
+				return convertToApiChainBreak(chainBreak)
+			}
+		}
+	}
+
+	return &signingapi.ChainVerificationResponse{
+		Valid:  true,
+		Length: int(verifier.Length()),
+	}, nil
+}
+
+func convertToApiChainBreak(chainBreak *domain.ChainBreak) (*signingapi.ChainVerificationResponse, error) {
+	var reason signingapi.ChainBreakReason
+	if err := reason.UnmarshalText([]byte(chainBreak.Reason)); err != nil {
+		return nil, err
+	}
+
+	return &signingapi.ChainVerificationResponse{
+		Valid: false,
+		// The links before the broken one are the ones verified.
+		Length: int(chainBreak.Counter),
+		BrokenLink: signingapi.NewOptChainBreak(signingapi.ChainBreak{
+			Counter: int(chainBreak.Counter),
+			Reason:  reason,
+		}),
 	}, nil
 }
 
 // ListDevices handles device list requests.
-func (h *DeviceHandler) ListDevices(ctx context.Context) ([]signingapi.DeviceSummary, error) {
-	devices, err := h.store.List()
+func (h *DeviceHandler) ListDevices(ctx context.Context, params signingapi.ListDevicesParams) (*signingapi.DeviceList, error) {
+	query := persistence.DeviceQuery{
+		Algorithm:   string(params.SignatureAlgorithm.Or("")),
+		Status:      domain.DeviceStatus(params.Status.Or("")),
+		LabelPrefix: params.LabelPrefix.Or(""),
+		Limit:       params.Limit.Or(defaultDevicesLimit),
+		Cursor:      params.Cursor.Or(""),
+	}
+	if len(params.Metadata) > 0 {
+		query.Metadata = make(map[string]string, len(params.Metadata))
+		for _, v := range params.Metadata {
+			key, value, ok := strings.Cut(v, ":")
+			if !ok || key == "" {
+				return nil, errInvalidMetadataFilter{filter: v}
+			}
+			query.Metadata[key] = value
+		}
+	}
+	sort := string(params.Sort.Or(signingapi.ListDevicesSortCreatedAt))
+	sort, query.Descending = strings.CutPrefix(sort, "-")
+	query.SortBy = persistence.DeviceSortField(sort)
+
+	page, err := h.store.Query(ctx, query)
 	if err != nil {
 		return nil, err
 	}
 
-	devicesummaries := make([]signingapi.DeviceSummary, len(devices))
-	for i, v := range devices {
-		optlabel := signingapi.OptString{}
-		if label := v.Label(); label != nil {
-			optlabel.SetTo(*label)
+	devicesummaries := make([]signingapi.DeviceSummary, len(page.Devices))
+	for i, v := range page.Devices {
+		devicesummaries[i], err = convertToApiSummary(v, params.Fields)
+		if err != nil {
+			return nil, err
 		}
-		devicesummaries[i] = signingapi.DeviceSummary{
-			ID:    v.ID(),
-			Label: optlabel,
+	}
+	list := &signingapi.DeviceList{Items: devicesummaries}
+	if page.NextCursor != "" {
+		list.NextCursor.SetTo(page.NextCursor)
+	}
+	return list, nil
+}
+
+// convertToApiSummary fills in only the requested fields, or all of them when fields is empty,
+// so that a projection does not pay for the fields it leaves out.
+func convertToApiSummary(device domain.SigningDevice, fields []signingapi.ListDevicesFieldsItem) (signingapi.DeviceSummary, error) {
+	wanted := func(field signingapi.ListDevicesFieldsItem) bool {
+		return len(fields) == 0 || slices.Contains(fields, field)
+	}
+
+	summary := signingapi.DeviceSummary{ID: device.ID()}
+	if wanted(signingapi.ListDevicesFieldsItemLabel) {
+		if label := device.Label(); label != nil {
+			summary.Label.SetTo(*label)
 		}
 	}
-	return devicesummaries, nil
+	if wanted(signingapi.ListDevicesFieldsItemSignatureAlgorithm) {
+		var sigalg signingapi.DeviceSummarySignatureAlgorithm
+		if err := sigalg.UnmarshalText([]byte(device.SignatureAlgorithm())); err != nil {
+			return signingapi.DeviceSummary{}, err
+		}
+		summary.SignatureAlgorithm.SetTo(sigalg)
+	}
+	if wanted(signingapi.ListDevicesFieldsItemStatus) {
+		summary.Status.SetTo(signingapi.DeviceStatus(device.Status()))
+	}
+	if wanted(signingapi.ListDevicesFieldsItemCounter) {
+		counter, _ := device.CounterAndLastSignature()
+		summary.Counter.SetTo(int(counter))
+	}
+	if wanted(signingapi.ListDevicesFieldsItemCreatedAt) {
+		summary.CreatedAt = optDateTime(device.CreatedAt())
+	}
+	if wanted(signingapi.ListDevicesFieldsItemLastSignedAt) {
+		summary.LastSignedAt = optDateTime(device.LastSignedAt())
+	}
+	return summary, nil
+}
+
+// optDateTime leaves the zero time unset.
+func optDateTime(t time.Time) signingapi.OptDateTime {
+	if t.IsZero() {
+		return signingapi.OptDateTime{}
+	}
+	return signingapi.NewOptDateTime(t)
 }
 
 // GetDevice handles device retrieval requests
-func (h *DeviceHandler) GetDevice(ctx context.Context, params signingapi.GetDeviceParams) (*signingapi.DeviceResponse, error) {
-	device, err := h.store.Get(params.Deviceid)
+func (h *DeviceHandler) GetDevice(ctx context.Context, params signingapi.GetDeviceParams) (*signingapi.DeviceResponseHeaders, error) {
+	device, err := h.store.Get(ctx, params.Deviceid)
+	if err != nil {
+		return nil, err
+	}
+
+	return convertToApiResponseHeaders(device)
+}
+
+// UpdateDevice handles label and metadata updates, conditional on the If-Match ETag.
+func (h *DeviceHandler) UpdateDevice(ctx context.Context, req *signingapi.DeviceUpdateRequest, params signingapi.UpdateDeviceParams) (*signingapi.DeviceResponseHeaders, error) {
+	device, err := h.store.Get(ctx, params.Deviceid)
 	if err != nil {
 		return nil, err
 	}
 
-	if device == nil {
-		return nil, errDeviceNotFound{params.Deviceid.String()}
+	// Fields left out keep their value: the revision check makes sure it is the one the client saw.
+	profile := device.Profile()
+	if params.IfMatch != "*" {
+		revision, ok := parseETag(params.IfMatch)
+		if !ok {
+			return nil, errPreconditionFailed{deviceID: params.Deviceid.String(), etag: params.IfMatch}
+		}
+		profile.Revision = revision
+	}
+	if label, ok := req.GetLabel().Get(); ok {
+		profile.Label = &label
+	} else if req.GetLabel().IsNull() {
+		profile.Label = nil
+	}
+	if metadata, ok := req.GetMetadata().Get(); ok {
+		profile.Metadata = metadata
+	}
+
+	device, err = h.store.UpdateProfile(ctx, params.Deviceid, profile.Revision, profile.Label, profile.Metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	return convertToApiResponseHeaders(device)
+}
+
+// formatETag renders a profile revision as a strong ETag.
+func formatETag(revision uint) string {
+	return `"` + strconv.FormatUint(uint64(revision), 10) + `"`
+}
+
+func parseETag(etag string) (uint, bool) {
+	unquoted, ok := strings.CutPrefix(etag, `"`)
+	if !ok {
+		return 0, false
+	}
+	unquoted, ok = strings.CutSuffix(unquoted, `"`)
+	if !ok {
+		return 0, false
+	}
+	revision, err := strconv.ParseUint(unquoted, 10, 0)
+	if err != nil {
+		return 0, false
+	}
+	return uint(revision), true
+}
+
+// ActivateDevice handles requests to move a device to ACTIVE.
+func (h *DeviceHandler) ActivateDevice(ctx context.Context, params signingapi.ActivateDeviceParams) (*signingapi.DeviceResponse, error) {
+	return h.setStatus(ctx, params.Deviceid, domain.STATUS_ACTIVE)
+}
+
+// DisableDevice handles requests to move a device to DISABLED.
+func (h *DeviceHandler) DisableDevice(ctx context.Context, params signingapi.DisableDeviceParams) (*signingapi.DeviceResponse, error) {
+	return h.setStatus(ctx, params.Deviceid, domain.STATUS_DISABLED)
+}
+
+// DecommissionDevice handles requests to move a device to DECOMMISSIONED.
+func (h *DeviceHandler) DecommissionDevice(ctx context.Context, params signingapi.DecommissionDeviceParams) (*signingapi.DeviceResponse, error) {
+	return h.setStatus(ctx, params.Deviceid, domain.STATUS_DECOMMISSIONED)
+}
+
+func (h *DeviceHandler) setStatus(ctx context.Context, id uuid.UUID, status domain.DeviceStatus) (*signingapi.DeviceResponse, error) {
+	device, err := h.store.SetStatus(ctx, id, status)
+	if err != nil {
+		return nil, err
 	}
 
 	return convertToApiResponse(device)
 }
 
+// convertToApiResponseHeaders renders the device along with the ETag of the profile it shows.
+func convertToApiResponseHeaders(device domain.SigningDevice) (*signingapi.DeviceResponseHeaders, error) {
+	profile := device.Profile()
+	response, err := convertProfileToApiResponse(device, profile)
+	if err != nil {
+		return nil, err
+	}
+	return &signingapi.DeviceResponseHeaders{
+		ETag:     formatETag(profile.Revision),
+		Response: *response,
+	}, nil
+}
+
 func convertToApiResponse(device domain.SigningDevice) (*signingapi.DeviceResponse, error) {
+	return convertProfileToApiResponse(device, device.Profile())
+}
+
+func convertProfileToApiResponse(device domain.SigningDevice, profile domain.DeviceProfile) (*signingapi.DeviceResponse, error) {
 
 	counter, lastSignature := device.CounterAndLastSignature()
 	pub, _, err := device.KeyPair().Marshal()
@@ -123,37 +781,145 @@ func convertToApiResponse(device domain.SigningDevice) (*signingapi.DeviceRespon
 
 	optlabel := signingapi.OptString{}
 
-	if label := device.Label(); label != nil {
-		optlabel.SetTo(*label)
+	if profile.Label != nil {
+		optlabel.SetTo(*profile.Label)
+	}
+
+	optmetadata := signingapi.OptDeviceMetadata{}
+	if len(profile.Metadata) > 0 {
+		optmetadata.SetTo(profile.Metadata)
+	}
+
+	parameters := device.Parameters()
+	optkeysize := signingapi.OptInt{}
+	optcurve := signingapi.OptDeviceResponseCurve{}
+	opthash := signingapi.OptDeviceResponseHashAlgorithm{}
+
+	if parameters.KeySize != 0 {
+		optkeysize.SetTo(parameters.KeySize)
+	}
+	if parameters.Curve != "" {
+		var c signingapi.DeviceResponseCurve
+		if err := c.UnmarshalText([]byte(parameters.Curve)); err != nil {
+			return nil, err
+		}
+		optcurve.SetTo(c)
+	}
+	if parameters.Hash != "" {
+		var h signingapi.DeviceResponseHashAlgorithm
+		if err := h.UnmarshalText([]byte(parameters.Hash)); err != nil {
+			return nil, err
+		}
+		opthash.SetTo(h)
+	}
+
+	optpadding := signingapi.OptDeviceResponsePadding{}
+	optsaltlength := signingapi.OptInt{}
+
+	if padding, saltLength := device.Padding(); padding != "" {
+		var p signingapi.DeviceResponsePadding
+		if err := p.UnmarshalText([]byte(padding)); err != nil {
+			return nil, err
+		}
+		optpadding.SetTo(p)
+		if padding == mycrypto.PSS_PADDING {
+			optsaltlength.SetTo(saltLength)
+		}
 	}
 
 	return &signingapi.DeviceResponse{
 		ID:                 device.ID(),
 		SignatureAlgorithm: sigalg,
 		Label:              optlabel,
+		Metadata:           optmetadata,
 		Counter:            int(counter),
 		LastSignature:      lastSignature,
+		CreatedAt:          optDateTime(device.CreatedAt()),
+		LastSignedAt:       optDateTime(device.LastSignedAt()),
 		PublicKey:          string(pub),
+		Status:             signingapi.DeviceStatus(device.Status()),
+		KeySize:            optkeysize,
+		Curve:              optcurve,
+		HashAlgorithm:      opthash,
+		Padding:            optpadding,
+		SaltLength:         optsaltlength,
 	}, nil
 }
 
 // NewError converts errors to an http structure response
 func (h *DeviceHandler) NewError(ctx context.Context, err error) *signingapi.ErrorResponseStatusCode {
-	switch err.(type) {
-	case domain.ErrInvalidAlgorithm:
+	switch e := err.(type) {
+	case *ogenerrors.SecurityError:
+		// A request without an API key satisfies no security requirement.
+		if errors.Is(e.Err, ogenerrors.ErrSecurityRequirementIsNotSatisfied) {
+			return h.NewError(ctx, errMissingAPIKey{})
+		}
+		return h.NewError(ctx, e.Err)
+	case domain.ErrInvalidAlgorithm, mycrypto.ErrInvalidParameters, domain.ErrInvalidStatus, domain.ErrInvalidMetadata, errInvalidDeviceID,
+		errInvalidMetadataFilter, errInvalidCallbackURL, errInvalidWebhookURL, errInvalidScope, errNoScope,
+		persistence.ErrInvalidCursor, persistence.ErrInvalidQuery:
 		return &signingapi.ErrorResponseStatusCode{
 			StatusCode: http.StatusBadRequest,
 			Response: signingapi.ErrorResponse{
 				Errors: []string{err.Error()},
 			},
 		}
-	case errDeviceNotFound:
+	case persistence.ErrNotFound, errSignatureNotFound, errJobNotFound, persistence.ErrWebhookNotFound, persistence.ErrAPIKeyNotFound:
 		return &signingapi.ErrorResponseStatusCode{
 			StatusCode: http.StatusNotFound,
 			Response: signingapi.ErrorResponse{
 				Errors: []string{err.Error()},
 			},
 		}
+	case errMissingAPIKey, errInvalidAPIKey:
+		return &signingapi.ErrorResponseStatusCode{
+			StatusCode: http.StatusUnauthorized,
+			Response: signingapi.ErrorResponse{
+				Errors: []string{err.Error()},
+			},
+		}
+	case errScopeNotGranted:
+		return &signingapi.ErrorResponseStatusCode{
+			StatusCode: http.StatusForbidden,
+			Response: signingapi.ErrorResponse{
+				Errors: []string{err.Error()},
+			},
+		}
+	case persistence.ErrAlreadyExists, persistence.ErrConflict, domain.ErrDeviceNotActive, domain.ErrInvalidTransition:
+		return &signingapi.ErrorResponseStatusCode{
+			StatusCode: http.StatusConflict,
+			Response: signingapi.ErrorResponse{
+				Errors: []string{err.Error()},
+			},
+		}
+	case domain.ErrRevisionMismatch, errPreconditionFailed:
+		return &signingapi.ErrorResponseStatusCode{
+			StatusCode: http.StatusPreconditionFailed,
+			Response: signingapi.ErrorResponse{
+				Errors: []string{err.Error()},
+			},
+		}
+	case persistence.ErrIdempotencyKeyReused:
+		return &signingapi.ErrorResponseStatusCode{
+			StatusCode: http.StatusUnprocessableEntity,
+			Response: signingapi.ErrorResponse{
+				Errors: []string{err.Error()},
+			},
+		}
+	case errTooManyJobs:
+		return &signingapi.ErrorResponseStatusCode{
+			StatusCode: http.StatusTooManyRequests,
+			Response: signingapi.ErrorResponse{
+				Errors: []string{err.Error()},
+			},
+		}
+	case errJobQueueFull:
+		return &signingapi.ErrorResponseStatusCode{
+			StatusCode: http.StatusServiceUnavailable,
+			Response: signingapi.ErrorResponse{
+				Errors: []string{err.Error()},
+			},
+		}
 	default:
 		return &signingapi.ErrorResponseStatusCode{
 			StatusCode: http.StatusInternalServerError,
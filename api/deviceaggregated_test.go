@@ -0,0 +1,79 @@
+package api
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	mycrypto "github.com/casell/signing-service-challenge/crypto"
+	"github.com/casell/signing-service-challenge/domain"
+	"github.com/casell/signing-service-challenge/generated/signingapi"
+	mockDomain "github.com/casell/signing-service-challenge/mocks/github.com/casell/signing-service-challenge/domain"
+	mockPersistence "github.com/casell/signing-service-challenge/mocks/github.com/casell/signing-service-challenge/persistence"
+	"github.com/casell/signing-service-challenge/persistence"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestSignTransactionAggregatedNoDevice(t *testing.T) {
+	id := uuid.New()
+
+	mockStorage := mockPersistence.NewMockStorage(t)
+	mockStorage.EXPECT().Get(mock.Anything, id).Return(nil, persistence.ErrNotFound{})
+
+	dh := NewDeviceHandler(mockStorage, mockPersistence.NewMockSignatureJournal(t), mockDomain.NewMockSigningDeviceFactory(t))
+
+	res, err := dh.SignTransactionAggregated(context.TODO(), &signingapi.SignatureRequest{DataToBeSigned: "data"}, signingapi.SignTransactionAggregatedParams{Deviceid: id})
+
+	assert.Nil(t, res)
+	if assert.Error(t, err) {
+		assert.IsType(t, persistence.ErrNotFound{}, err)
+	}
+}
+
+// TestSignTransactionAggregated signs through a real device, so that the response can be verified back.
+func TestSignTransactionAggregated(t *testing.T) {
+	device, err := domain.NewDefaultDeviceFactory().New(uuid.Nil, domain.STATUS_ACTIVE, "ED25519", nil, mycrypto.Parameters{})
+	if !assert.NoError(t, err) {
+		return
+	}
+	id := device.ID()
+
+	mockStorage := mockPersistence.NewMockStorage(t)
+	mockStorage.EXPECT().Get(mock.Anything, id).Return(device, nil)
+	mockStorage.EXPECT().SignAndCommit(mock.Anything, id, mock.Anything, "").
+		RunAndReturn(func(ctx context.Context, _ uuid.UUID, data string, _ string) (*domain.SignatureRecord, error) {
+			record, err := device.Sign(ctx, data)
+			return &record, err
+		}).Once()
+
+	dh := NewDeviceHandler(mockStorage, mockPersistence.NewMockSignatureJournal(t), mockDomain.NewMockSigningDeviceFactory(t))
+	dh.aggregator = NewAggregator(mockStorage, time.Millisecond, 100)
+
+	res, err := dh.SignTransactionAggregated(context.TODO(), &signingapi.SignatureRequest{DataToBeSigned: "data"}, signingapi.SignTransactionAggregatedParams{Deviceid: id})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, 0, res.Counter)
+	assert.Equal(t, 0, res.LeafIndex)
+	assert.Empty(t, res.Proof)
+
+	verification, err := dh.VerifyInclusion(context.TODO(), &signingapi.InclusionVerificationRequest{
+		DataToBeSigned: "data",
+		Proof:          res.Proof,
+		SignedData:     res.SignedData,
+		Signature:      res.Signature,
+	}, signingapi.VerifyInclusionParams{Deviceid: id})
+	assert.NoError(t, err)
+	assert.True(t, verification.Valid)
+
+	verification, err = dh.VerifyInclusion(context.TODO(), &signingapi.InclusionVerificationRequest{
+		DataToBeSigned: "other",
+		Proof:          res.Proof,
+		SignedData:     res.SignedData,
+		Signature:      res.Signature,
+	}, signingapi.VerifyInclusionParams{Deviceid: id})
+	assert.NoError(t, err)
+	assert.False(t, verification.Valid)
+}
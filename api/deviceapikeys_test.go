@@ -0,0 +1,57 @@
+package api
+
+import (
+	"context"
+	"testing"
+
+	"github.com/casell/signing-service-challenge/generated/signingapi"
+	"github.com/casell/signing-service-challenge/persistence"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateAPIKey(t *testing.T) {
+	dh := newWebhookHandler(t)
+
+	res, err := dh.CreateAPIKey(context.TODO(), &signingapi.APIKeyRequest{
+		Name:   "ci",
+		Scopes: []signingapi.APIKeyScope{signingapi.APIKeyScopeSign, signingapi.APIKeyScopeRead, signingapi.APIKeyScopeSign},
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "ci", res.Name)
+	assert.Equal(t, []signingapi.APIKeyScope{signingapi.APIKeyScopeSign, signingapi.APIKeyScopeRead}, res.Scopes)
+	key, ok := res.Key.Get()
+	assert.True(t, ok)
+	assert.Len(t, key, 2*apiKeySize)
+
+	// Only the hash of the key is stored.
+	stored, err := dh.apikeys.GetAPIKeyByHash(context.TODO(), HashAPIKey(key))
+	assert.NoError(t, err)
+	if assert.NotNil(t, stored) {
+		assert.Equal(t, res.ID, stored.ID)
+		assert.NotEqual(t, key, stored.Hash)
+	}
+
+	// The key is only returned on creation.
+	list, err := dh.ListAPIKeys(context.TODO())
+	assert.NoError(t, err)
+	if assert.Len(t, list.Items, 1) {
+		assert.Equal(t, res.ID, list.Items[0].ID)
+		assert.Equal(t, res.Scopes, list.Items[0].Scopes)
+		assert.False(t, list.Items[0].Key.IsSet())
+	}
+}
+
+func TestRevokeAPIKey(t *testing.T) {
+	dh := newWebhookHandler(t)
+	res, err := dh.CreateAPIKey(context.TODO(), &signingapi.APIKeyRequest{Name: "ci", Scopes: []signingapi.APIKeyScope{signingapi.APIKeyScopeRead}})
+	assert.NoError(t, err)
+
+	assert.NoError(t, dh.RevokeAPIKey(context.TODO(), signingapi.RevokeAPIKeyParams{Keyid: res.ID}))
+	_, err = NewAuthenticator(dh.apikeys).Authorize(context.TODO(), res.Key.Value, SCOPE_READ)
+	assert.ErrorAs(t, err, &errInvalidAPIKey{})
+
+	err = dh.RevokeAPIKey(context.TODO(), signingapi.RevokeAPIKeyParams{Keyid: uuid.New()})
+	assert.ErrorAs(t, err, &persistence.ErrAPIKeyNotFound{})
+}
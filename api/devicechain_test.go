@@ -0,0 +1,140 @@
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+
+	"github.com/casell/signing-service-challenge/domain"
+	"github.com/casell/signing-service-challenge/generated/signingapi"
+	mockDomain "github.com/casell/signing-service-challenge/mocks/github.com/casell/signing-service-challenge/domain"
+	mockPersistence "github.com/casell/signing-service-challenge/mocks/github.com/casell/signing-service-challenge/persistence"
+	"github.com/casell/signing-service-challenge/persistence"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestVerifyStoredChainNoDevice(t *testing.T) {
+	id := uuid.New()
+
+	mockFactory := mockDomain.NewMockSigningDeviceFactory(t)
+
+	mockStorage := mockPersistence.NewMockStorage(t)
+	mockStorage.EXPECT().Get(mock.Anything, id).Return(nil, persistence.ErrNotFound{})
+
+	dh := NewDeviceHandler(mockStorage, mockPersistence.NewMockSignatureJournal(t), mockFactory)
+
+	res, err := dh.VerifyStoredChain(context.TODO(), signingapi.VerifyStoredChainParams{Deviceid: id})
+
+	assert.Nil(t, res)
+	if assert.Error(t, err) {
+		assert.IsType(t, persistence.ErrNotFound{}, err)
+	}
+}
+
+func TestVerifyStoredChainPages(t *testing.T) {
+	id := uuid.New()
+	initialSignature := base64.StdEncoding.EncodeToString([]byte(id.String()))
+	records := []domain.SignatureRecord{
+		{Counter: 0, Signature: "c2lnMA==", SignedData: "0_data_" + initialSignature},
+		{Counter: 1, Signature: "c2lnMQ==", SignedData: "1_data_c2lnMA=="},
+		{Counter: 2, Signature: "c2lnMg==", SignedData: "2_data_c2lnMQ=="},
+	}
+
+	mockDevice := mockDomain.NewMockSigningDevice(t)
+	mockDevice.EXPECT().ID().Return(id)
+	mockDevice.EXPECT().CounterAndLastSignature().Return(2, "c2lnMQ==")
+	for _, v := range records[:2] {
+		mockDevice.EXPECT().Verify(v.SignedData, v.Signature).Return(true, nil)
+	}
+
+	mockFactory := mockDomain.NewMockSigningDeviceFactory(t)
+
+	mockStorage := mockPersistence.NewMockStorage(t)
+	mockStorage.EXPECT().Get(mock.Anything, id).Return(mockDevice, nil)
+
+	// The journal is read a page at a time, the signature made after the counter was read is left out.
+	mockJournal := mockPersistence.NewMockSignatureJournal(t)
+	mockJournal.EXPECT().List(mock.Anything, id, 0, chainVerificationPage).Return(records[:1], 3, nil)
+	mockJournal.EXPECT().List(mock.Anything, id, 1, chainVerificationPage).Return(records[1:], 3, nil)
+
+	dh := NewDeviceHandler(mockStorage, mockJournal, mockFactory)
+
+	res, err := dh.VerifyStoredChain(context.TODO(), signingapi.VerifyStoredChainParams{Deviceid: id})
+
+	assert.Nil(t, err)
+	assert.True(t, res.GetValid())
+	assert.Equal(t, 2, res.GetLength())
+	assert.False(t, res.GetBrokenLink().IsSet())
+}
+
+func TestVerifyStoredChainBroken(t *testing.T) {
+	id := uuid.New()
+	initialSignature := base64.StdEncoding.EncodeToString([]byte(id.String()))
+	records := []domain.SignatureRecord{
+		{Counter: 0, Signature: "c2lnMA==", SignedData: "0_data_" + initialSignature},
+		{Counter: 1, Signature: "c2lnMQ==", SignedData: "1_data_Zm9yZ2Vk"},
+		{Counter: 2, Signature: "c2lnMg==", SignedData: "2_data_c2lnMQ=="},
+	}
+
+	mockDevice := mockDomain.NewMockSigningDevice(t)
+	mockDevice.EXPECT().ID().Return(id)
+	mockDevice.EXPECT().CounterAndLastSignature().Return(3, "c2lnMg==")
+	mockDevice.EXPECT().Verify(records[0].SignedData, records[0].Signature).Return(true, nil)
+
+	mockFactory := mockDomain.NewMockSigningDeviceFactory(t)
+
+	mockStorage := mockPersistence.NewMockStorage(t)
+	mockStorage.EXPECT().Get(mock.Anything, id).Return(mockDevice, nil)
+
+	mockJournal := mockPersistence.NewMockSignatureJournal(t)
+	mockJournal.EXPECT().List(mock.Anything, id, 0, chainVerificationPage).Return(records, 3, nil)
+
+	dh := NewDeviceHandler(mockStorage, mockJournal, mockFactory)
+
+	res, err := dh.VerifyStoredChain(context.TODO(), signingapi.VerifyStoredChainParams{Deviceid: id})
+
+	assert.Nil(t, err)
+	assert.False(t, res.GetValid())
+	// Only the link before the broken one was verified.
+	assert.Equal(t, 1, res.GetLength())
+	brokenLink, ok := res.GetBrokenLink().Get()
+	if assert.True(t, ok) {
+		assert.Equal(t, 1, brokenLink.GetCounter())
+		assert.Equal(t, signingapi.ChainBreakReasonPREVIOUSSIGNATUREMISMATCH, brokenLink.GetReason())
+	}
+}
+
+func TestVerifyStoredChainBrokenFirstLink(t *testing.T) {
+	id := uuid.New()
+	records := []domain.SignatureRecord{
+		{Counter: 0, Signature: "c2lnMA==", SignedData: "0_data_Zm9yZ2Vk"},
+	}
+
+	mockDevice := mockDomain.NewMockSigningDevice(t)
+	mockDevice.EXPECT().ID().Return(id)
+	mockDevice.EXPECT().CounterAndLastSignature().Return(1, "c2lnMA==")
+
+	mockFactory := mockDomain.NewMockSigningDeviceFactory(t)
+
+	mockStorage := mockPersistence.NewMockStorage(t)
+	mockStorage.EXPECT().Get(mock.Anything, id).Return(mockDevice, nil)
+
+	mockJournal := mockPersistence.NewMockSignatureJournal(t)
+	mockJournal.EXPECT().List(mock.Anything, id, 0, chainVerificationPage).Return(records, 1, nil)
+
+	dh := NewDeviceHandler(mockStorage, mockJournal, mockFactory)
+
+	res, err := dh.VerifyStoredChain(context.TODO(), signingapi.VerifyStoredChainParams{Deviceid: id})
+
+	assert.Nil(t, err)
+	assert.False(t, res.GetValid())
+	// No link was verified before the broken one.
+	assert.Equal(t, 0, res.GetLength())
+	brokenLink, ok := res.GetBrokenLink().Get()
+	if assert.True(t, ok) {
+		assert.Equal(t, 0, brokenLink.GetCounter())
+		assert.Equal(t, signingapi.ChainBreakReasonPREVIOUSSIGNATUREMISMATCH, brokenLink.GetReason())
+	}
+}
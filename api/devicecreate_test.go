@@ -4,14 +4,18 @@ import (
 	"context"
 	"errors"
 	"testing"
+	"time"
 
+	mycrypto "github.com/casell/signing-service-challenge/crypto"
 	"github.com/casell/signing-service-challenge/domain"
 	"github.com/casell/signing-service-challenge/generated/signingapi"
 	mockCrypto "github.com/casell/signing-service-challenge/mocks/github.com/casell/signing-service-challenge/crypto"
 	mockDomain "github.com/casell/signing-service-challenge/mocks/github.com/casell/signing-service-challenge/domain"
 	mockPersistence "github.com/casell/signing-service-challenge/mocks/github.com/casell/signing-service-challenge/persistence"
+	"github.com/casell/signing-service-challenge/persistence"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 )
 
 func TestCreateDeviceUnableToAdd(t *testing.T) {
@@ -22,11 +26,11 @@ func TestCreateDeviceUnableToAdd(t *testing.T) {
 	addErr := errors.New("Add error")
 
 	mockDevice := mockDomain.NewMockSigningDevice(t)
-	mockFactory := setupMockFactory(t, algo, label, mockDevice)
+	mockFactory := setupMockFactory(t, algo, label, mycrypto.Parameters{}, mockDevice)
 	mockStorage := mockPersistence.NewMockStorage(t)
-	mockStorage.EXPECT().Add(mockDevice).Return(addErr)
+	mockStorage.EXPECT().Add(mock.Anything, mockDevice).Return(addErr)
 
-	dh := NewDeviceHandler(mockStorage, mockFactory)
+	dh := NewDeviceHandler(mockStorage, mockPersistence.NewMockSignatureJournal(t), mockFactory)
 
 	reqLabel := signingapi.OptNilString{}
 
@@ -42,6 +46,14 @@ func TestCreateDeviceUnableToAdd(t *testing.T) {
 }
 
 func setupMockDevice(t *testing.T, id uuid.UUID, algo, pub, priv, signature string, counter int, label *string) domain.SigningDevice {
+	return setupMockDeviceWithPadding(t, id, algo, pub, priv, signature, counter, label, mycrypto.PKCS1V15_PADDING, 0)
+}
+
+func setupMockDeviceWithPadding(t *testing.T, id uuid.UUID, algo, pub, priv, signature string, counter int, label *string, padding string, saltLength int) domain.SigningDevice {
+	return setupMockDeviceWithParameters(t, id, algo, pub, priv, signature, counter, label, mycrypto.Parameters{KeySize: 2048, Hash: "SHA-256"}, padding, saltLength)
+}
+
+func setupMockDeviceWithParameters(t *testing.T, id uuid.UUID, algo, pub, priv, signature string, counter int, label *string, parameters mycrypto.Parameters, padding string, saltLength int) domain.SigningDevice {
 	mockDevice := mockDomain.NewMockSigningDevice(t)
 	mockDevice.EXPECT().ID().Return(id)
 	mockKP := mockCrypto.NewMockKeyPair(t)
@@ -49,13 +61,18 @@ func setupMockDevice(t *testing.T, id uuid.UUID, algo, pub, priv, signature stri
 	mockDevice.EXPECT().KeyPair().Return(mockKP)
 	mockDevice.EXPECT().CounterAndLastSignature().Return(uint(counter), signature)
 	mockDevice.EXPECT().SignatureAlgorithm().Return(algo)
-	mockDevice.EXPECT().Label().Return(label)
+	mockDevice.EXPECT().Profile().Return(domain.DeviceProfile{Label: label})
+	mockDevice.EXPECT().Parameters().Return(parameters)
+	mockDevice.EXPECT().Padding().Return(padding, saltLength)
+	mockDevice.EXPECT().Status().Return(domain.STATUS_ACTIVE)
+	mockDevice.EXPECT().CreatedAt().Return(time.Time{})
+	mockDevice.EXPECT().LastSignedAt().Return(time.Time{})
 	return mockDevice
 }
 
-func setupMockFactory(t *testing.T, algo string, label *string, mockDevice domain.SigningDevice) domain.SigningDeviceFactory {
+func setupMockFactory(t *testing.T, algo string, label *string, parameters mycrypto.Parameters, mockDevice domain.SigningDevice) domain.SigningDeviceFactory {
 	mockFactory := mockDomain.NewMockSigningDeviceFactory(t)
-	mockFactory.EXPECT().New(algo, label).Return(mockDevice, nil)
+	mockFactory.EXPECT().New(uuid.Nil, domain.STATUS_ACTIVE, algo, label, parameters).Return(mockDevice, nil)
 	return mockFactory
 }
 
@@ -79,11 +96,11 @@ func testCreateDevice(t *testing.T, label *string) {
 	priv := "priv"
 
 	mockDevice := setupMockDevice(t, id, algo, pub, priv, signature, counter, label)
-	mockFactory := setupMockFactory(t, algo, label, mockDevice)
+	mockFactory := setupMockFactory(t, algo, label, mycrypto.Parameters{}, mockDevice)
 	mockStorage := mockPersistence.NewMockStorage(t)
-	mockStorage.EXPECT().Add(mockDevice).Return(nil)
+	mockStorage.EXPECT().Add(mock.Anything, mockDevice).Return(nil)
 
-	dh := NewDeviceHandler(mockStorage, mockFactory)
+	dh := NewDeviceHandler(mockStorage, mockPersistence.NewMockSignatureJournal(t), mockFactory)
 
 	var reqLabel signingapi.OptNilString
 	if label == nil {
@@ -104,6 +121,8 @@ func testCreateDevice(t *testing.T, label *string) {
 	assert.Equal(t, algo, string(res.SignatureAlgorithm))
 	assert.Equal(t, counter, res.Counter)
 	assert.Equal(t, signature, res.LastSignature)
+	assert.Equal(t, signingapi.NewOptDeviceResponsePadding(signingapi.DeviceResponsePaddingPKCS1V15), res.Padding)
+	assert.False(t, res.SaltLength.IsSet())
 
 	resLabel, labelOK := res.Label.Get()
 
@@ -112,20 +131,123 @@ func testCreateDevice(t *testing.T, label *string) {
 	} else {
 		assert.Equal(t, *label, resLabel)
 	}
+}
+
+func TestCreateDeviceParameters(t *testing.T) {
+	id := uuid.New()
+	algo := string(signingapi.DeviceRequestSignatureAlgorithmECC)
+	parameters := mycrypto.Parameters{Curve: "P-521", Hash: "SHA-512"}
+
+	mockDevice := setupMockDeviceWithParameters(t, id, algo, "pub", "priv", "signature", 0, nil, parameters, "", 0)
+	mockFactory := setupMockFactory(t, algo, nil, parameters, mockDevice)
+	mockStorage := mockPersistence.NewMockStorage(t)
+	mockStorage.EXPECT().Add(mock.Anything, mockDevice).Return(nil)
+
+	dh := NewDeviceHandler(mockStorage, mockPersistence.NewMockSignatureJournal(t), mockFactory)
+
+	res, err := dh.CreateDevice(context.TODO(), &signingapi.DeviceRequest{
+		SignatureAlgorithm: signingapi.DeviceRequestSignatureAlgorithmECC,
+		Curve:              signingapi.NewOptDeviceRequestCurve(signingapi.DeviceRequestCurveP521),
+		HashAlgorithm:      signingapi.NewOptDeviceRequestHashAlgorithm(signingapi.DeviceRequestHashAlgorithmSHA512),
+	})
+
+	assert.Nil(t, err)
+
+	assert.False(t, res.KeySize.IsSet())
+	assert.Equal(t, signingapi.NewOptDeviceResponseCurve(signingapi.DeviceResponseCurveP521), res.Curve)
+	assert.Equal(t, signingapi.NewOptDeviceResponseHashAlgorithm(signingapi.DeviceResponseHashAlgorithmSHA512), res.HashAlgorithm)
+	assert.False(t, res.Padding.IsSet())
+}
+
+func TestCreateDeviceWithID(t *testing.T) {
+	id := uuid.New()
+	algo := string(signingapi.DeviceRequestSignatureAlgorithmECC)
+
+	mockDevice := setupMockDeviceWithParameters(t, id, algo, "pub", "priv", "signature", 0, nil, mycrypto.Parameters{}, "", 0)
+	mockFactory := mockDomain.NewMockSigningDeviceFactory(t)
+	mockFactory.EXPECT().New(id, domain.STATUS_ACTIVE, algo, (*string)(nil), mycrypto.Parameters{}).Return(mockDevice, nil)
+	mockStorage := mockPersistence.NewMockStorage(t)
+	mockStorage.EXPECT().Add(mock.Anything, mockDevice).Return(nil)
+
+	dh := NewDeviceHandler(mockStorage, mockPersistence.NewMockSignatureJournal(t), mockFactory)
+
+	res, err := dh.CreateDevice(context.TODO(), &signingapi.DeviceRequest{
+		ID:                 signingapi.NewOptUUID(id),
+		SignatureAlgorithm: signingapi.DeviceRequestSignatureAlgorithmECC,
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, id, res.ID)
+}
+
+func TestCreateDeviceInitialized(t *testing.T) {
+	algo := string(signingapi.DeviceRequestSignatureAlgorithmED25519)
+
+	mockDevice := mockDomain.NewMockSigningDevice(t)
+	mockFactory := mockDomain.NewMockSigningDeviceFactory(t)
+	mockFactory.EXPECT().New(uuid.Nil, domain.STATUS_INITIALIZED, algo, (*string)(nil), mycrypto.Parameters{}).Return(mockDevice, nil)
+	mockStorage := mockPersistence.NewMockStorage(t)
+	addErr := errors.New("Add error")
+	mockStorage.EXPECT().Add(mock.Anything, mockDevice).Return(addErr)
+
+	dh := NewDeviceHandler(mockStorage, mockPersistence.NewMockSignatureJournal(t), mockFactory)
+
+	_, err := dh.CreateDevice(context.TODO(), &signingapi.DeviceRequest{
+		SignatureAlgorithm: signingapi.DeviceRequestSignatureAlgorithmED25519,
+		Status:             signingapi.NewOptDeviceRequestStatus(signingapi.DeviceRequestStatusINITIALIZED),
+	})
+
+	assert.Equal(t, addErr, err)
+}
+
+func TestCreateDeviceIDInUse(t *testing.T) {
+	id := uuid.New()
+	algo := string(signingapi.DeviceRequestSignatureAlgorithmECC)
+
+	mockDevice := mockDomain.NewMockSigningDevice(t)
+	mockFactory := mockDomain.NewMockSigningDeviceFactory(t)
+	mockFactory.EXPECT().New(id, domain.STATUS_ACTIVE, algo, (*string)(nil), mycrypto.Parameters{}).Return(mockDevice, nil)
+	mockStorage := mockPersistence.NewMockStorage(t)
+	mockStorage.EXPECT().Add(mock.Anything, mockDevice).Return(persistence.ErrAlreadyExists{})
+
+	dh := NewDeviceHandler(mockStorage, mockPersistence.NewMockSignatureJournal(t), mockFactory)
+
+	res, err := dh.CreateDevice(context.TODO(), &signingapi.DeviceRequest{
+		ID:                 signingapi.NewOptUUID(id),
+		SignatureAlgorithm: signingapi.DeviceRequestSignatureAlgorithmECC,
+	})
+
+	assert.Nil(t, res)
+	if assert.Error(t, err) {
+		assert.IsType(t, persistence.ErrAlreadyExists{}, err)
+	}
+}
+
+func TestCreateDeviceNilID(t *testing.T) {
+	dh := NewDeviceHandler(mockPersistence.NewMockStorage(t), mockPersistence.NewMockSignatureJournal(t), mockDomain.NewMockSigningDeviceFactory(t))
+
+	res, err := dh.CreateDevice(context.TODO(), &signingapi.DeviceRequest{
+		ID:                 signingapi.NewOptUUID(uuid.Nil),
+		SignatureAlgorithm: signingapi.DeviceRequestSignatureAlgorithmECC,
+	})
 
+	assert.Nil(t, res)
+	if assert.Error(t, err) {
+		assert.IsType(t, errInvalidDeviceID{}, err)
+	}
 }
 
 func TestWrongAlgo(t *testing.T) {
 	mockFactory := mockDomain.NewMockSigningDeviceFactory(t)
 	mockStorage := mockPersistence.NewMockStorage(t)
 
-	dh := NewDeviceHandler(mockStorage, mockFactory)
+	dh := NewDeviceHandler(mockStorage, mockPersistence.NewMockSignatureJournal(t), mockFactory)
 
 	sigalg := signingapi.DeviceRequestSignatureAlgorithm("FAKE")
 
 	var label *string = nil
 
-	mockFactory.EXPECT().New(string(sigalg), label).Return(nil, errors.New("Invalid algorithm"))
+	mockFactory.EXPECT().New(uuid.Nil, domain.STATUS_ACTIVE, string(sigalg), label, mycrypto.Parameters{}).Return(nil, errors.New("Invalid algorithm"))
 
 	_, err := dh.CreateDevice(context.TODO(), &signingapi.DeviceRequest{
 		SignatureAlgorithm: sigalg,
@@ -5,11 +5,14 @@ import (
 	"errors"
 	"testing"
 
+	mycrypto "github.com/casell/signing-service-challenge/crypto"
 	"github.com/casell/signing-service-challenge/generated/signingapi"
 	mockDomain "github.com/casell/signing-service-challenge/mocks/github.com/casell/signing-service-challenge/domain"
 	mockPersistence "github.com/casell/signing-service-challenge/mocks/github.com/casell/signing-service-challenge/persistence"
+	"github.com/casell/signing-service-challenge/persistence"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 )
 
 func TestGetDevice(t *testing.T) {
@@ -29,9 +32,9 @@ func TestGetDevice(t *testing.T) {
 
 	mockStorage := mockPersistence.NewMockStorage(t)
 
-	mockStorage.EXPECT().Get(id).Return(mockDevice, nil)
+	mockStorage.EXPECT().Get(mock.Anything, id).Return(mockDevice, nil)
 
-	dh := NewDeviceHandler(mockStorage, mockFactory)
+	dh := NewDeviceHandler(mockStorage, mockPersistence.NewMockSignatureJournal(t), mockFactory)
 
 	params := signingapi.GetDeviceParams{
 		Deviceid: id,
@@ -41,18 +44,46 @@ func TestGetDevice(t *testing.T) {
 
 	assert.Nil(t, err)
 
-	assert.Equal(t, id, res.ID)
-	assert.Equal(t, pub, res.PublicKey)
-	assert.Equal(t, algo, string(res.SignatureAlgorithm))
-	assert.Equal(t, counter, res.Counter)
-	assert.Equal(t, signature, res.LastSignature)
+	assert.Equal(t, `"0"`, res.ETag)
+	assert.Equal(t, id, res.Response.ID)
+	assert.Equal(t, pub, res.Response.PublicKey)
+	assert.Equal(t, algo, string(res.Response.SignatureAlgorithm))
+	assert.Equal(t, counter, res.Response.Counter)
+	assert.Equal(t, signature, res.Response.LastSignature)
 
-	resLabel, labelOK := res.Label.Get()
+	resLabel, labelOK := res.Response.Label.Get()
 
 	assert.True(t, labelOK)
 	assert.Equal(t, label, resLabel)
 }
 
+func TestGetDevicePSS(t *testing.T) {
+	id := uuid.New()
+	algo := string(signingapi.DeviceRequestSignatureAlgorithmRSAPSS)
+
+	mockDevice := setupMockDeviceWithPadding(t, id, algo, "pub", "priv", "signature", 0, nil, mycrypto.PSS_PADDING, 32)
+
+	mockFactory := mockDomain.NewMockSigningDeviceFactory(t)
+
+	mockStorage := mockPersistence.NewMockStorage(t)
+
+	mockStorage.EXPECT().Get(mock.Anything, id).Return(mockDevice, nil)
+
+	dh := NewDeviceHandler(mockStorage, mockPersistence.NewMockSignatureJournal(t), mockFactory)
+
+	params := signingapi.GetDeviceParams{
+		Deviceid: id,
+	}
+
+	res, err := dh.GetDevice(context.TODO(), params)
+
+	assert.Nil(t, err)
+
+	assert.Equal(t, algo, string(res.Response.SignatureAlgorithm))
+	assert.Equal(t, signingapi.NewOptDeviceResponsePadding(signingapi.DeviceResponsePaddingPSS), res.Response.Padding)
+	assert.Equal(t, signingapi.NewOptInt(32), res.Response.SaltLength)
+}
+
 func TestGetDeviceError(t *testing.T) {
 	id := uuid.New()
 
@@ -60,9 +91,9 @@ func TestGetDeviceError(t *testing.T) {
 
 	mockStorage := mockPersistence.NewMockStorage(t)
 	getErr := errors.New("Get Error")
-	mockStorage.EXPECT().Get(id).Return(nil, getErr)
+	mockStorage.EXPECT().Get(mock.Anything, id).Return(nil, getErr)
 
-	dh := NewDeviceHandler(mockStorage, mockFactory)
+	dh := NewDeviceHandler(mockStorage, mockPersistence.NewMockSignatureJournal(t), mockFactory)
 
 	params := signingapi.GetDeviceParams{
 		Deviceid: id,
@@ -82,9 +113,9 @@ func TestGetDeviceNotFound(t *testing.T) {
 	mockFactory := mockDomain.NewMockSigningDeviceFactory(t)
 
 	mockStorage := mockPersistence.NewMockStorage(t)
-	mockStorage.EXPECT().Get(id).Return(nil, nil)
+	mockStorage.EXPECT().Get(mock.Anything, id).Return(nil, persistence.ErrNotFound{})
 
-	dh := NewDeviceHandler(mockStorage, mockFactory)
+	dh := NewDeviceHandler(mockStorage, mockPersistence.NewMockSignatureJournal(t), mockFactory)
 
 	params := signingapi.GetDeviceParams{
 		Deviceid: id,
@@ -94,6 +125,6 @@ func TestGetDeviceNotFound(t *testing.T) {
 
 	assert.Nil(t, res)
 	if assert.Error(t, err) {
-		assert.IsType(t, errDeviceNotFound{}, err)
+		assert.IsType(t, persistence.ErrNotFound{}, err)
 	}
 }
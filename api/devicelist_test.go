@@ -4,51 +4,116 @@ import (
 	"context"
 	"errors"
 	"testing"
+	"time"
 
 	"github.com/casell/signing-service-challenge/domain"
+	"github.com/casell/signing-service-challenge/generated/signingapi"
 	mockDomain "github.com/casell/signing-service-challenge/mocks/github.com/casell/signing-service-challenge/domain"
 	mockPersistence "github.com/casell/signing-service-challenge/mocks/github.com/casell/signing-service-challenge/persistence"
+	"github.com/casell/signing-service-challenge/persistence"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 )
 
 func TestListDevice(t *testing.T) {
 	mockDevice := mockDomain.NewMockSigningDevice(t)
 	id := uuid.New()
 	label := "label"
+	createdAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
 	mockDevice.EXPECT().ID().Return(id)
 	mockDevice.EXPECT().Label().Return(&label)
+	mockDevice.EXPECT().SignatureAlgorithm().Return("ECC")
+	mockDevice.EXPECT().Status().Return(domain.STATUS_ACTIVE)
+	mockDevice.EXPECT().CounterAndLastSignature().Return(3, "signature")
+	mockDevice.EXPECT().CreatedAt().Return(createdAt)
+	mockDevice.EXPECT().LastSignedAt().Return(time.Time{})
 
 	mockFactory := mockDomain.NewMockSigningDeviceFactory(t)
 
 	mockStorage := mockPersistence.NewMockStorage(t)
-	mockStorage.EXPECT().List().Return([]domain.SigningDevice{mockDevice}, nil)
+	mockStorage.EXPECT().Query(mock.Anything, persistence.DeviceQuery{SortBy: persistence.SORT_BY_CREATED_AT, Limit: defaultDevicesLimit}).
+		Return(&persistence.DevicePage{Devices: []domain.SigningDevice{mockDevice}, NextCursor: "next"}, nil)
 
-	dh := NewDeviceHandler(mockStorage, mockFactory)
+	dh := NewDeviceHandler(mockStorage, mockPersistence.NewMockSignatureJournal(t), mockFactory)
 
-	res, err := dh.ListDevices(context.TODO())
+	res, err := dh.ListDevices(context.TODO(), signingapi.ListDevicesParams{})
 
 	assert.Nil(t, err)
-	if assert.Len(t, res, 1) {
-		assert.Equal(t, id, res[0].ID)
-		lab, ok := res[0].GetLabel().Get()
+	assert.Equal(t, signingapi.NewOptString("next"), res.NextCursor)
+	if assert.Len(t, res.Items, 1) {
+		assert.Equal(t, id, res.Items[0].ID)
+		lab, ok := res.Items[0].GetLabel().Get()
 		assert.True(t, ok)
 		assert.Equal(t, label, lab)
+		assert.Equal(t, signingapi.NewOptDeviceSummarySignatureAlgorithm(signingapi.DeviceSummarySignatureAlgorithmECC), res.Items[0].SignatureAlgorithm)
+		assert.Equal(t, signingapi.NewOptDeviceStatus(signingapi.DeviceStatusACTIVE), res.Items[0].Status)
+		assert.Equal(t, signingapi.NewOptInt(3), res.Items[0].Counter)
+		assert.Equal(t, signingapi.NewOptDateTime(createdAt), res.Items[0].CreatedAt)
+		assert.False(t, res.Items[0].LastSignedAt.IsSet())
 	}
 }
 
-func TestListDeviceEmpty(t *testing.T) {
+func TestListDeviceFields(t *testing.T) {
+	// Only the requested fields are read from the device.
+	mockDevice := mockDomain.NewMockSigningDevice(t)
+	id := uuid.New()
+	mockDevice.EXPECT().ID().Return(id)
+	mockDevice.EXPECT().CounterAndLastSignature().Return(3, "signature")
+
+	mockStorage := mockPersistence.NewMockStorage(t)
+	mockStorage.EXPECT().Query(mock.Anything, mock.Anything).Return(&persistence.DevicePage{Devices: []domain.SigningDevice{mockDevice}}, nil)
+
+	dh := NewDeviceHandler(mockStorage, mockPersistence.NewMockSignatureJournal(t), mockDomain.NewMockSigningDeviceFactory(t))
+
+	res, err := dh.ListDevices(context.TODO(), signingapi.ListDevicesParams{Fields: []signingapi.ListDevicesFieldsItem{signingapi.ListDevicesFieldsItemCounter}})
+
+	assert.Nil(t, err)
+	if assert.Len(t, res.Items, 1) {
+		assert.Equal(t, signingapi.DeviceSummary{ID: id, Counter: signingapi.NewOptInt(3)}, res.Items[0])
+	}
+}
+
+func TestListDeviceQuery(t *testing.T) {
 	mockFactory := mockDomain.NewMockSigningDeviceFactory(t)
 
+	expected := persistence.DeviceQuery{
+		Algorithm:   "ECC",
+		Status:      domain.STATUS_DISABLED,
+		LabelPrefix: "reg",
+		Metadata:    map[string]string{"site": "berlin", "url": "http://x"},
+		SortBy:      persistence.SORT_BY_LABEL,
+		Descending:  true,
+		Limit:       10,
+		Cursor:      "cursor",
+	}
 	mockStorage := mockPersistence.NewMockStorage(t)
-	mockStorage.EXPECT().List().Return([]domain.SigningDevice{}, nil)
+	mockStorage.EXPECT().Query(mock.Anything, expected).Return(&persistence.DevicePage{Devices: []domain.SigningDevice{}}, nil)
 
-	dh := NewDeviceHandler(mockStorage, mockFactory)
+	dh := NewDeviceHandler(mockStorage, mockPersistence.NewMockSignatureJournal(t), mockFactory)
 
-	res, err := dh.ListDevices(context.TODO())
+	res, err := dh.ListDevices(context.TODO(), signingapi.ListDevicesParams{
+		Limit:              signingapi.NewOptInt(10),
+		Cursor:             signingapi.NewOptString("cursor"),
+		SignatureAlgorithm: signingapi.NewOptListDevicesSignatureAlgorithm(signingapi.ListDevicesSignatureAlgorithmECC),
+		Status:             signingapi.NewOptDeviceStatus(signingapi.DeviceStatusDISABLED),
+		LabelPrefix:        signingapi.NewOptString("reg"),
+		Metadata:           []string{"site:berlin", "url:http://x"},
+		Sort:               signingapi.NewOptListDevicesSort(signingapi.ListDevicesSortMinusLabel),
+	})
 
 	assert.Nil(t, err)
-	assert.Len(t, res, 0)
+	assert.Len(t, res.Items, 0)
+	assert.False(t, res.NextCursor.IsSet())
+}
+
+func TestListDeviceInvalidMetadata(t *testing.T) {
+	dh := NewDeviceHandler(mockPersistence.NewMockStorage(t), mockPersistence.NewMockSignatureJournal(t), mockDomain.NewMockSigningDeviceFactory(t))
+
+	res, err := dh.ListDevices(context.TODO(), signingapi.ListDevicesParams{Metadata: []string{":berlin"}})
+
+	assert.Nil(t, res)
+	assert.IsType(t, errInvalidMetadataFilter{}, err)
 }
 
 func TestListDeviceError(t *testing.T) {
@@ -58,11 +123,11 @@ func TestListDeviceError(t *testing.T) {
 
 	mockStorage := mockPersistence.NewMockStorage(t)
 
-	mockStorage.EXPECT().List().Return(nil, listErr)
+	mockStorage.EXPECT().Query(mock.Anything, mock.Anything).Return(nil, listErr)
 
-	dh := NewDeviceHandler(mockStorage, mockFactory)
+	dh := NewDeviceHandler(mockStorage, mockPersistence.NewMockSignatureJournal(t), mockFactory)
 
-	res, err := dh.ListDevices(context.TODO())
+	res, err := dh.ListDevices(context.TODO(), signingapi.ListDevicesParams{})
 
 	assert.Nil(t, res)
 	if assert.Error(t, err) {
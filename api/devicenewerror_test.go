@@ -6,7 +6,10 @@ import (
 	"net/http"
 	"testing"
 
+	mycrypto "github.com/casell/signing-service-challenge/crypto"
 	"github.com/casell/signing-service-challenge/domain"
+	"github.com/casell/signing-service-challenge/persistence"
+	"github.com/ogen-go/ogen/ogenerrors"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -23,10 +26,37 @@ func TestNewErrorInvalidAlgorithm(t *testing.T) {
 	}
 }
 
+func TestNewErrorInvalidParameters(t *testing.T) {
+	var dh *DeviceHandler
+
+	err := mycrypto.ErrInvalidParameters{}
+	errResp := dh.NewError(context.TODO(), err)
+	assert.Equal(t, http.StatusBadRequest, errResp.GetStatusCode())
+	if assert.NotNil(t, errResp.GetResponse()) {
+		if assert.Len(t, errResp.GetResponse().Errors, 1) {
+			assert.Equal(t, err.Error(), errResp.GetResponse().Errors[0])
+		}
+	}
+}
+
+func TestNewErrorInvalidQuery(t *testing.T) {
+	var dh *DeviceHandler
+
+	for _, err := range []error{persistence.ErrInvalidCursor{}, persistence.ErrInvalidQuery{}, errInvalidMetadataFilter{}} {
+		errResp := dh.NewError(context.TODO(), err)
+		assert.Equal(t, http.StatusBadRequest, errResp.GetStatusCode())
+		if assert.NotNil(t, errResp.GetResponse()) {
+			if assert.Len(t, errResp.GetResponse().Errors, 1) {
+				assert.Equal(t, err.Error(), errResp.GetResponse().Errors[0])
+			}
+		}
+	}
+}
+
 func TestNewErrorDeviceNotFound(t *testing.T) {
 	var dh *DeviceHandler
 
-	err := errDeviceNotFound{deviceID: "42"}
+	err := persistence.ErrNotFound{}
 	errResp := dh.NewError(context.TODO(), err)
 	assert.Equal(t, http.StatusNotFound, errResp.GetStatusCode())
 	if assert.NotNil(t, errResp.GetResponse()) {
@@ -36,6 +66,109 @@ func TestNewErrorDeviceNotFound(t *testing.T) {
 	}
 }
 
+func TestNewErrorConflict(t *testing.T) {
+	var dh *DeviceHandler
+
+	for _, err := range []error{persistence.ErrAlreadyExists{}, persistence.ErrConflict{}, domain.ErrDeviceNotActive{}, domain.ErrInvalidTransition{}} {
+		errResp := dh.NewError(context.TODO(), err)
+		assert.Equal(t, http.StatusConflict, errResp.GetStatusCode())
+		if assert.NotNil(t, errResp.GetResponse()) {
+			if assert.Len(t, errResp.GetResponse().Errors, 1) {
+				assert.Equal(t, err.Error(), errResp.GetResponse().Errors[0])
+			}
+		}
+	}
+}
+
+func TestNewErrorIdempotencyKeyReused(t *testing.T) {
+	var dh *DeviceHandler
+
+	err := persistence.ErrIdempotencyKeyReused{}
+	errResp := dh.NewError(context.TODO(), err)
+	assert.Equal(t, http.StatusUnprocessableEntity, errResp.GetStatusCode())
+	if assert.NotNil(t, errResp.GetResponse()) {
+		if assert.Len(t, errResp.GetResponse().Errors, 1) {
+			assert.Equal(t, err.Error(), errResp.GetResponse().Errors[0])
+		}
+	}
+}
+
+func TestNewErrorPreconditionFailed(t *testing.T) {
+	var dh *DeviceHandler
+
+	for _, err := range []error{domain.ErrRevisionMismatch{}, errPreconditionFailed{}} {
+		errResp := dh.NewError(context.TODO(), err)
+		assert.Equal(t, http.StatusPreconditionFailed, errResp.GetStatusCode())
+		if assert.NotNil(t, errResp.GetResponse()) {
+			if assert.Len(t, errResp.GetResponse().Errors, 1) {
+				assert.Equal(t, err.Error(), errResp.GetResponse().Errors[0])
+			}
+		}
+	}
+}
+
+func TestNewErrorJob(t *testing.T) {
+	var dh *DeviceHandler
+
+	for err, status := range map[error]int{errInvalidCallbackURL{}: http.StatusBadRequest, errJobNotFound{}: http.StatusNotFound, errTooManyJobs{}: http.StatusTooManyRequests, errJobQueueFull{}: http.StatusServiceUnavailable} {
+		errResp := dh.NewError(context.TODO(), err)
+		assert.Equal(t, status, errResp.GetStatusCode())
+		if assert.NotNil(t, errResp.GetResponse()) {
+			if assert.Len(t, errResp.GetResponse().Errors, 1) {
+				assert.Equal(t, err.Error(), errResp.GetResponse().Errors[0])
+			}
+		}
+	}
+}
+
+func TestNewErrorWebhook(t *testing.T) {
+	var dh *DeviceHandler
+
+	for err, status := range map[error]int{errInvalidWebhookURL{}: http.StatusBadRequest, persistence.ErrWebhookNotFound{}: http.StatusNotFound} {
+		errResp := dh.NewError(context.TODO(), err)
+		assert.Equal(t, status, errResp.GetStatusCode())
+		if assert.NotNil(t, errResp.GetResponse()) {
+			if assert.Len(t, errResp.GetResponse().Errors, 1) {
+				assert.Equal(t, err.Error(), errResp.GetResponse().Errors[0])
+			}
+		}
+	}
+}
+
+func TestNewErrorAPIKey(t *testing.T) {
+	var dh *DeviceHandler
+
+	for err, status := range map[error]int{
+		errMissingAPIKey{}:              http.StatusUnauthorized,
+		errInvalidAPIKey{}:              http.StatusUnauthorized,
+		errScopeNotGranted{SCOPE_ADMIN}: http.StatusForbidden,
+		errInvalidScope{"root"}:         http.StatusBadRequest,
+		errNoScope{}:                    http.StatusBadRequest,
+		persistence.ErrAPIKeyNotFound{}: http.StatusNotFound,
+	} {
+		errResp := dh.NewError(context.TODO(), err)
+		assert.Equal(t, status, errResp.GetStatusCode())
+		if assert.NotNil(t, errResp.GetResponse()) {
+			if assert.Len(t, errResp.GetResponse().Errors, 1) {
+				assert.Equal(t, err.Error(), errResp.GetResponse().Errors[0])
+			}
+		}
+	}
+}
+
+func TestNewErrorSecurity(t *testing.T) {
+	var dh *DeviceHandler
+
+	// The generated server wraps the errors of the SecurityHandler, a request without a key satisfies no requirement.
+	errResp := dh.NewError(context.TODO(), &ogenerrors.SecurityError{Security: "ApiKeyAuth", Err: errScopeNotGranted{SCOPE_SIGN}})
+	assert.Equal(t, http.StatusForbidden, errResp.GetStatusCode())
+	assert.Equal(t, []string{errScopeNotGranted{SCOPE_SIGN}.Error()}, errResp.GetResponse().Errors)
+
+	errResp = dh.NewError(context.TODO(), &ogenerrors.SecurityError{Err: ogenerrors.ErrSecurityRequirementIsNotSatisfied})
+	assert.Equal(t, http.StatusUnauthorized, errResp.GetStatusCode())
+	assert.Equal(t, []string{errMissingAPIKey{}.Error()}, errResp.GetResponse().Errors)
+}
+
 func TestNewErrorDefault(t *testing.T) {
 	var dh *DeviceHandler
 
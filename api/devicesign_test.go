@@ -3,26 +3,30 @@ package api
 import (
 	"context"
 	"errors"
+	"net/url"
 	"testing"
 
+	"github.com/casell/signing-service-challenge/domain"
 	"github.com/casell/signing-service-challenge/generated/signingapi"
 	mockDomain "github.com/casell/signing-service-challenge/mocks/github.com/casell/signing-service-challenge/domain"
 	mockPersistence "github.com/casell/signing-service-challenge/mocks/github.com/casell/signing-service-challenge/persistence"
+	"github.com/casell/signing-service-challenge/persistence"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 )
 
-func TestSignTransactionGetError(t *testing.T) {
+func TestSignTransactionError(t *testing.T) {
 	id := uuid.New()
 	dataToBeSigned := "data"
 
 	mockFactory := mockDomain.NewMockSigningDeviceFactory(t)
 
 	mockStorage := mockPersistence.NewMockStorage(t)
-	getErr := errors.New("Get Error")
-	mockStorage.EXPECT().Get(id).Return(nil, getErr)
+	signErr := errors.New("Sign error")
+	mockStorage.EXPECT().SignAndCommit(mock.Anything, id, dataToBeSigned, "").Return(nil, signErr)
 
-	dh := NewDeviceHandler(mockStorage, mockFactory)
+	dh := NewDeviceHandler(mockStorage, mockPersistence.NewMockSignatureJournal(t), mockFactory)
 
 	params := signingapi.SignTransactionParams{
 		Deviceid: id,
@@ -36,7 +40,7 @@ func TestSignTransactionGetError(t *testing.T) {
 
 	assert.Nil(t, res)
 	if assert.Error(t, err) {
-		assert.Equal(t, getErr, err)
+		assert.Equal(t, signErr, err)
 	}
 }
 
@@ -47,9 +51,9 @@ func TestSignTransactionNoDevice(t *testing.T) {
 	mockFactory := mockDomain.NewMockSigningDeviceFactory(t)
 
 	mockStorage := mockPersistence.NewMockStorage(t)
-	mockStorage.EXPECT().Get(id).Return(nil, nil)
+	mockStorage.EXPECT().SignAndCommit(mock.Anything, id, dataToBeSigned, "").Return(nil, persistence.ErrNotFound{})
 
-	dh := NewDeviceHandler(mockStorage, mockFactory)
+	dh := NewDeviceHandler(mockStorage, mockPersistence.NewMockSignatureJournal(t), mockFactory)
 
 	params := signingapi.SignTransactionParams{
 		Deviceid: id,
@@ -63,11 +67,11 @@ func TestSignTransactionNoDevice(t *testing.T) {
 
 	assert.Nil(t, res)
 	if assert.Error(t, err) {
-		assert.IsType(t, errDeviceNotFound{}, err)
+		assert.IsType(t, persistence.ErrNotFound{}, err)
 	}
 }
 
-func TestSignTransactionErrorSigning(t *testing.T) {
+func TestSignTransaction(t *testing.T) {
 	id := uuid.New()
 	dataToBeSigned := "data"
 
@@ -75,16 +79,16 @@ func TestSignTransactionErrorSigning(t *testing.T) {
 
 	mockStorage := mockPersistence.NewMockStorage(t)
 
-	mockDevice := mockDomain.NewMockSigningDevice(t)
-	// mockDevice.EXPECT().ID().Return(id)
-
-	signErr := errors.New("Sign error")
-
-	mockDevice.EXPECT().Sign(dataToBeSigned).Return("", "", signErr)
+	record := &domain.SignatureRecord{
+		Counter:    3,
+		Data:       dataToBeSigned,
+		Signature:  "Signature",
+		SignedData: "Ext Data",
+	}
 
-	mockStorage.EXPECT().Get(id).Return(mockDevice, nil)
+	mockStorage.EXPECT().SignAndCommit(mock.Anything, id, dataToBeSigned, "").Return(record, nil)
 
-	dh := NewDeviceHandler(mockStorage, mockFactory)
+	dh := NewDeviceHandler(mockStorage, mockPersistence.NewMockSignatureJournal(t), mockFactory)
 
 	params := signingapi.SignTransactionParams{
 		Deviceid: id,
@@ -96,13 +100,17 @@ func TestSignTransactionErrorSigning(t *testing.T) {
 
 	res, err := dh.SignTransaction(context.TODO(), req, params)
 
-	assert.Nil(t, res)
-	if assert.Error(t, err) {
-		assert.Equal(t, signErr, err)
+	assert.Nil(t, err)
+	sig, ok := res.(*signingapi.SignatureResponse)
+	if !assert.True(t, ok) {
+		return
 	}
+	assert.Equal(t, record.Signature, sig.GetSignature())
+	assert.Equal(t, record.SignedData, sig.GetSignedData())
+	assert.Equal(t, int(record.Counter), sig.GetCounter())
 }
 
-func TestSignTransactionErrorPut(t *testing.T) {
+func TestSignTransactionIdempotencyKey(t *testing.T) {
 	id := uuid.New()
 	dataToBeSigned := "data"
 
@@ -110,22 +118,20 @@ func TestSignTransactionErrorPut(t *testing.T) {
 
 	mockStorage := mockPersistence.NewMockStorage(t)
 
-	mockDevice := mockDomain.NewMockSigningDevice(t)
-
-	signature := "Signature"
-	extData := "Ext Data"
-
-	mockDevice.EXPECT().Sign(dataToBeSigned).Return(signature, extData, nil)
-
-	mockStorage.EXPECT().Get(id).Return(mockDevice, nil)
+	record := &domain.SignatureRecord{
+		Counter:    1,
+		Data:       dataToBeSigned,
+		Signature:  "Signature",
+		SignedData: "Ext Data",
+	}
 
-	putErr := errors.New("Put error")
-	mockStorage.EXPECT().Put(mockDevice).Return(putErr)
+	mockStorage.EXPECT().SignAndCommit(mock.Anything, id, dataToBeSigned, "key").Return(record, nil)
 
-	dh := NewDeviceHandler(mockStorage, mockFactory)
+	dh := NewDeviceHandler(mockStorage, mockPersistence.NewMockSignatureJournal(t), mockFactory)
 
 	params := signingapi.SignTransactionParams{
-		Deviceid: id,
+		Deviceid:       id,
+		IdempotencyKey: signingapi.NewOptString("key"),
 	}
 
 	req := &signingapi.SignatureRequest{
@@ -134,44 +140,107 @@ func TestSignTransactionErrorPut(t *testing.T) {
 
 	res, err := dh.SignTransaction(context.TODO(), req, params)
 
-	assert.Nil(t, res)
-	if assert.Error(t, err) {
-		assert.Equal(t, putErr, err)
+	assert.Nil(t, err)
+	sig, ok := res.(*signingapi.SignatureResponse)
+	if !assert.True(t, ok) {
+		return
 	}
+	assert.Equal(t, record.Signature, sig.GetSignature())
+	assert.Equal(t, int(record.Counter), sig.GetCounter())
 }
 
-func TestSignTransaction(t *testing.T) {
+func TestSignTransactionAsync(t *testing.T) {
 	id := uuid.New()
 	dataToBeSigned := "data"
 
-	mockFactory := mockDomain.NewMockSigningDeviceFactory(t)
-
 	mockStorage := mockPersistence.NewMockStorage(t)
+	mockStorage.EXPECT().Get(mock.Anything, id).Return(mockDomain.NewMockSigningDevice(t), nil)
+	mockStorage.EXPECT().SignAndCommit(mock.Anything, id, dataToBeSigned, "key").
+		Return(&domain.SignatureRecord{Counter: 2, Signature: "Signature", SignedData: "Ext Data"}, nil)
 
-	mockDevice := mockDomain.NewMockSigningDevice(t)
+	dh := NewDeviceHandler(mockStorage, mockPersistence.NewMockSignatureJournal(t), mockDomain.NewMockSigningDeviceFactory(t))
 
-	signature := "Signature"
-	extData := "Ext Data"
+	params := signingapi.SignTransactionParams{
+		Deviceid:       id,
+		Async:          signingapi.NewOptBool(true),
+		IdempotencyKey: signingapi.NewOptString("key"),
+	}
 
-	mockDevice.EXPECT().Sign(dataToBeSigned).Return(signature, extData, nil)
+	res, err := dh.SignTransaction(context.TODO(), &signingapi.SignatureRequest{DataToBeSigned: dataToBeSigned}, params)
 
-	mockStorage.EXPECT().Get(id).Return(mockDevice, nil)
+	assert.Nil(t, err)
+	job, ok := res.(*signingapi.Job)
+	if !assert.True(t, ok) {
+		return
+	}
+	assert.Equal(t, id, job.DeviceId)
+
+	waitJob(t, dh.jobs, job.ID)
+	polled, err := dh.GetJob(context.TODO(), signingapi.GetJobParams{Jobid: job.ID})
+	assert.Nil(t, err)
+	assert.Equal(t, signingapi.JobStatusSUCCEEDED, polled.Status)
+	assert.Equal(t, "Signature", polled.Signature.Value.Signature)
+	assert.Equal(t, 2, polled.Signature.Value.Counter)
+}
+
+func TestSignTransactionAsyncNoDevice(t *testing.T) {
+	id := uuid.New()
 
-	mockStorage.EXPECT().Put(mockDevice).Return(nil)
+	mockStorage := mockPersistence.NewMockStorage(t)
+	mockStorage.EXPECT().Get(mock.Anything, id).Return(nil, persistence.ErrNotFound{})
 
-	dh := NewDeviceHandler(mockStorage, mockFactory)
+	dh := NewDeviceHandler(mockStorage, mockPersistence.NewMockSignatureJournal(t), mockDomain.NewMockSigningDeviceFactory(t))
 
 	params := signingapi.SignTransactionParams{
 		Deviceid: id,
+		Async:    signingapi.NewOptBool(true),
 	}
 
-	req := &signingapi.SignatureRequest{
-		DataToBeSigned: dataToBeSigned,
+	res, err := dh.SignTransaction(context.TODO(), &signingapi.SignatureRequest{DataToBeSigned: "data"}, params)
+
+	assert.Nil(t, res)
+	if assert.Error(t, err) {
+		assert.IsType(t, persistence.ErrNotFound{}, err)
 	}
+}
 
-	res, err := dh.SignTransaction(context.TODO(), req, params)
+func TestSignTransactionInvalidCallback(t *testing.T) {
+	dh := NewDeviceHandler(mockPersistence.NewMockStorage(t), mockPersistence.NewMockSignatureJournal(t), mockDomain.NewMockSigningDeviceFactory(t))
+
+	for _, tc := range []struct {
+		callbackURL string
+		async       bool
+	}{
+		{"http://example.com/callback", false},
+		{"ftp://example.com/callback", true},
+		{"/callback", true},
+		{"http://localhost:8080/callback", true},
+		{"http://169.254.169.254/latest/meta-data", true},
+		{"http://[::1]/callback", true},
+	} {
+		callbackURL, err := url.Parse(tc.callbackURL)
+		if !assert.NoError(t, err) {
+			continue
+		}
+		req := &signingapi.SignatureRequest{DataToBeSigned: "data", CallbackUrl: signingapi.NewOptURI(*callbackURL)}
+		params := signingapi.SignTransactionParams{Deviceid: uuid.New(), Async: signingapi.NewOptBool(tc.async)}
+
+		res, err := dh.SignTransaction(context.TODO(), req, params)
+
+		assert.Nil(t, res)
+		if assert.Error(t, err) {
+			assert.IsType(t, errInvalidCallbackURL{}, err)
+		}
+	}
+}
 
-	assert.Nil(t, err)
-	assert.Equal(t, signature, res.GetSignature())
-	assert.Equal(t, extData, res.GetSignedData())
+func TestGetJobNotFound(t *testing.T) {
+	dh := NewDeviceHandler(mockPersistence.NewMockStorage(t), mockPersistence.NewMockSignatureJournal(t), mockDomain.NewMockSigningDeviceFactory(t))
+
+	res, err := dh.GetJob(context.TODO(), signingapi.GetJobParams{Jobid: uuid.New()})
+
+	assert.Nil(t, res)
+	if assert.Error(t, err) {
+		assert.IsType(t, errJobNotFound{}, err)
+	}
 }
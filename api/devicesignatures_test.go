@@ -0,0 +1,219 @@
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/casell/signing-service-challenge/domain"
+	"github.com/casell/signing-service-challenge/generated/signingapi"
+	mockDomain "github.com/casell/signing-service-challenge/mocks/github.com/casell/signing-service-challenge/domain"
+	mockPersistence "github.com/casell/signing-service-challenge/mocks/github.com/casell/signing-service-challenge/persistence"
+	"github.com/casell/signing-service-challenge/persistence"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestListSignatures(t *testing.T) {
+	id := uuid.New()
+	timestamp := time.Now()
+	records := []domain.SignatureRecord{
+		{Counter: 10, Data: "data", SignedData: "10_data_prev", Signature: "sig", Timestamp: timestamp},
+	}
+
+	mockFactory := mockDomain.NewMockSigningDeviceFactory(t)
+	mockDevice := mockDomain.NewMockSigningDevice(t)
+
+	mockStorage := mockPersistence.NewMockStorage(t)
+	mockStorage.EXPECT().Get(mock.Anything, id).Return(mockDevice, nil)
+
+	mockJournal := mockPersistence.NewMockSignatureJournal(t)
+	mockJournal.EXPECT().List(mock.Anything, id, 10, 1).Return(records, 42, nil)
+
+	dh := NewDeviceHandler(mockStorage, mockJournal, mockFactory)
+
+	params := signingapi.ListSignaturesParams{
+		Deviceid: id,
+		Offset:   signingapi.NewOptInt(10),
+		Limit:    signingapi.NewOptInt(1),
+	}
+
+	res, err := dh.ListSignatures(context.TODO(), params)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 42, res.GetTotal())
+	if assert.Len(t, res.GetItems(), 1) {
+		item := res.GetItems()[0]
+		assert.Equal(t, 10, item.GetCounter())
+		assert.Equal(t, "data", item.GetData())
+		assert.Equal(t, "10_data_prev", item.GetSignedData())
+		assert.Equal(t, "sig", item.GetSignature())
+		assert.Equal(t, timestamp, item.GetTimestamp())
+	}
+}
+
+func TestListSignaturesDefaults(t *testing.T) {
+	id := uuid.New()
+
+	mockFactory := mockDomain.NewMockSigningDeviceFactory(t)
+	mockDevice := mockDomain.NewMockSigningDevice(t)
+
+	mockStorage := mockPersistence.NewMockStorage(t)
+	mockStorage.EXPECT().Get(mock.Anything, id).Return(mockDevice, nil)
+
+	mockJournal := mockPersistence.NewMockSignatureJournal(t)
+	mockJournal.EXPECT().List(mock.Anything, id, 0, defaultSignaturesLimit).Return([]domain.SignatureRecord{}, 0, nil)
+
+	dh := NewDeviceHandler(mockStorage, mockJournal, mockFactory)
+
+	res, err := dh.ListSignatures(context.TODO(), signingapi.ListSignaturesParams{Deviceid: id})
+
+	assert.Nil(t, err)
+	assert.Len(t, res.GetItems(), 0)
+}
+
+func TestListSignaturesNoDevice(t *testing.T) {
+	id := uuid.New()
+
+	mockFactory := mockDomain.NewMockSigningDeviceFactory(t)
+
+	mockStorage := mockPersistence.NewMockStorage(t)
+	mockStorage.EXPECT().Get(mock.Anything, id).Return(nil, persistence.ErrNotFound{})
+
+	dh := NewDeviceHandler(mockStorage, mockPersistence.NewMockSignatureJournal(t), mockFactory)
+
+	res, err := dh.ListSignatures(context.TODO(), signingapi.ListSignaturesParams{Deviceid: id})
+
+	assert.Nil(t, res)
+	if assert.Error(t, err) {
+		assert.IsType(t, persistence.ErrNotFound{}, err)
+	}
+}
+
+func TestGetSignature(t *testing.T) {
+	id := uuid.New()
+	record := &domain.SignatureRecord{Counter: 2, Data: "data", SignedData: "2_data_prev", Signature: "sig"}
+
+	mockFactory := mockDomain.NewMockSigningDeviceFactory(t)
+	mockStorage := mockPersistence.NewMockStorage(t)
+
+	mockJournal := mockPersistence.NewMockSignatureJournal(t)
+	mockJournal.EXPECT().Get(mock.Anything, id, uint(2)).Return(record, nil)
+
+	dh := NewDeviceHandler(mockStorage, mockJournal, mockFactory)
+
+	res, err := dh.GetSignature(context.TODO(), signingapi.GetSignatureParams{Deviceid: id, Counter: 2})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, res.GetCounter())
+	assert.Equal(t, "sig", res.GetSignature())
+}
+
+func TestGetSignatureNotFound(t *testing.T) {
+	id := uuid.New()
+
+	mockFactory := mockDomain.NewMockSigningDeviceFactory(t)
+	mockStorage := mockPersistence.NewMockStorage(t)
+
+	mockJournal := mockPersistence.NewMockSignatureJournal(t)
+	mockJournal.EXPECT().Get(mock.Anything, id, uint(2)).Return(nil, nil)
+
+	dh := NewDeviceHandler(mockStorage, mockJournal, mockFactory)
+
+	res, err := dh.GetSignature(context.TODO(), signingapi.GetSignatureParams{Deviceid: id, Counter: 2})
+
+	assert.Nil(t, res)
+	if assert.Error(t, err) {
+		assert.IsType(t, errSignatureNotFound{}, err)
+	}
+}
+
+func TestGetSignatureError(t *testing.T) {
+	id := uuid.New()
+
+	mockFactory := mockDomain.NewMockSigningDeviceFactory(t)
+	mockStorage := mockPersistence.NewMockStorage(t)
+
+	getErr := errors.New("Get error")
+	mockJournal := mockPersistence.NewMockSignatureJournal(t)
+	mockJournal.EXPECT().Get(mock.Anything, id, uint(2)).Return(nil, getErr)
+
+	dh := NewDeviceHandler(mockStorage, mockJournal, mockFactory)
+
+	res, err := dh.GetSignature(context.TODO(), signingapi.GetSignatureParams{Deviceid: id, Counter: 2})
+
+	assert.Nil(t, res)
+	if assert.Error(t, err) {
+		assert.Equal(t, getErr, err)
+	}
+}
+
+func TestVerifyStoredChain(t *testing.T) {
+	id := uuid.New()
+	initialSignature := base64.StdEncoding.EncodeToString([]byte(id.String()))
+	records := []domain.SignatureRecord{
+		{Counter: 0, Signature: "c2lnMA==", SignedData: "0_data_" + initialSignature},
+		{Counter: 1, Signature: "c2lnMQ==", SignedData: "1_data_c2lnMA=="},
+	}
+
+	mockDevice := mockDomain.NewMockSigningDevice(t)
+	mockDevice.EXPECT().ID().Return(id)
+	mockDevice.EXPECT().CounterAndLastSignature().Return(2, "c2lnMQ==")
+	for _, v := range records {
+		mockDevice.EXPECT().Verify(v.SignedData, v.Signature).Return(true, nil)
+	}
+
+	mockFactory := mockDomain.NewMockSigningDeviceFactory(t)
+
+	mockStorage := mockPersistence.NewMockStorage(t)
+	mockStorage.EXPECT().Get(mock.Anything, id).Return(mockDevice, nil)
+
+	mockJournal := mockPersistence.NewMockSignatureJournal(t)
+	mockJournal.EXPECT().List(mock.Anything, id, 0, chainVerificationPage).Return(records, 2, nil)
+
+	dh := NewDeviceHandler(mockStorage, mockJournal, mockFactory)
+
+	res, err := dh.VerifyStoredChain(context.TODO(), signingapi.VerifyStoredChainParams{Deviceid: id})
+
+	assert.Nil(t, err)
+	assert.True(t, res.GetValid())
+	assert.Equal(t, 2, res.GetLength())
+}
+
+func TestVerifyStoredChainMissingRecords(t *testing.T) {
+	id := uuid.New()
+	initialSignature := base64.StdEncoding.EncodeToString([]byte(id.String()))
+	records := []domain.SignatureRecord{
+		{Counter: 0, Signature: "c2lnMA==", SignedData: "0_data_" + initialSignature},
+	}
+
+	mockDevice := mockDomain.NewMockSigningDevice(t)
+	mockDevice.EXPECT().ID().Return(id)
+	mockDevice.EXPECT().CounterAndLastSignature().Return(2, "c2lnMQ==")
+	mockDevice.EXPECT().Verify(records[0].SignedData, records[0].Signature).Return(true, nil)
+
+	mockFactory := mockDomain.NewMockSigningDeviceFactory(t)
+
+	mockStorage := mockPersistence.NewMockStorage(t)
+	mockStorage.EXPECT().Get(mock.Anything, id).Return(mockDevice, nil)
+
+	mockJournal := mockPersistence.NewMockSignatureJournal(t)
+	mockJournal.EXPECT().List(mock.Anything, id, 0, chainVerificationPage).Return(records, 1, nil)
+	mockJournal.EXPECT().List(mock.Anything, id, 1, chainVerificationPage).Return([]domain.SignatureRecord{}, 1, nil)
+
+	dh := NewDeviceHandler(mockStorage, mockJournal, mockFactory)
+
+	res, err := dh.VerifyStoredChain(context.TODO(), signingapi.VerifyStoredChainParams{Deviceid: id})
+
+	assert.Nil(t, err)
+	assert.False(t, res.GetValid())
+	assert.Equal(t, 1, res.GetLength())
+	brokenLink, ok := res.GetBrokenLink().Get()
+	if assert.True(t, ok) {
+		assert.Equal(t, 1, brokenLink.GetCounter())
+		assert.Equal(t, signingapi.ChainBreakReasonCOUNTERGAP, brokenLink.GetReason())
+	}
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"context"
+	"testing"
+
+	"github.com/casell/signing-service-challenge/domain"
+	"github.com/casell/signing-service-challenge/generated/signingapi"
+	mockDomain "github.com/casell/signing-service-challenge/mocks/github.com/casell/signing-service-challenge/domain"
+	mockPersistence "github.com/casell/signing-service-challenge/mocks/github.com/casell/signing-service-challenge/persistence"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestSignTransactionBatch(t *testing.T) {
+	id := uuid.New()
+	dataToBeSigned := []string{"a", "b"}
+
+	mockStorage := mockPersistence.NewMockStorage(t)
+	mockStorage.EXPECT().SignBatchAndCommit(mock.Anything, id, dataToBeSigned).Return([]domain.SignatureRecord{
+		{Counter: 3, Data: "a", SignedData: "3_a_prev", Signature: "sig3"},
+		{Counter: 4, Data: "b", SignedData: "4_b_sig3", Signature: "sig4"},
+	}, nil)
+
+	dh := NewDeviceHandler(mockStorage, mockPersistence.NewMockSignatureJournal(t), mockDomain.NewMockSigningDeviceFactory(t))
+
+	res, err := dh.SignTransactionBatch(context.TODO(), &signingapi.BatchSignatureRequest{DataToBeSigned: dataToBeSigned}, signingapi.SignTransactionBatchParams{Deviceid: id})
+
+	assert.NoError(t, err)
+	assert.Equal(t, &signingapi.BatchSignatureResponse{Items: []signingapi.SignatureResponse{
+		{Signature: "sig3", SignedData: "3_a_prev", Counter: 3},
+		{Signature: "sig4", SignedData: "4_b_sig3", Counter: 4},
+	}}, res)
+}
+
+func TestSignTransactionBatchNotActive(t *testing.T) {
+	id := uuid.New()
+	dataToBeSigned := []string{"a"}
+
+	mockStorage := mockPersistence.NewMockStorage(t)
+	mockStorage.EXPECT().SignBatchAndCommit(mock.Anything, id, dataToBeSigned).Return(nil, domain.ErrDeviceNotActive{})
+
+	dh := NewDeviceHandler(mockStorage, mockPersistence.NewMockSignatureJournal(t), mockDomain.NewMockSigningDeviceFactory(t))
+
+	res, err := dh.SignTransactionBatch(context.TODO(), &signingapi.BatchSignatureRequest{DataToBeSigned: dataToBeSigned}, signingapi.SignTransactionBatchParams{Deviceid: id})
+
+	assert.Nil(t, res)
+	if assert.Error(t, err) {
+		assert.IsType(t, domain.ErrDeviceNotActive{}, err)
+	}
+}
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"context"
+	"testing"
+
+	"github.com/casell/signing-service-challenge/domain"
+	"github.com/casell/signing-service-challenge/generated/signingapi"
+	mockDomain "github.com/casell/signing-service-challenge/mocks/github.com/casell/signing-service-challenge/domain"
+	mockPersistence "github.com/casell/signing-service-challenge/mocks/github.com/casell/signing-service-challenge/persistence"
+	"github.com/casell/signing-service-challenge/persistence"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestSetDeviceStatus(t *testing.T) {
+	tests := []struct {
+		status domain.DeviceStatus
+		call   func(dh *DeviceHandler, id uuid.UUID) (*signingapi.DeviceResponse, error)
+	}{
+		{domain.STATUS_ACTIVE, func(dh *DeviceHandler, id uuid.UUID) (*signingapi.DeviceResponse, error) {
+			return dh.ActivateDevice(context.TODO(), signingapi.ActivateDeviceParams{Deviceid: id})
+		}},
+		{domain.STATUS_DISABLED, func(dh *DeviceHandler, id uuid.UUID) (*signingapi.DeviceResponse, error) {
+			return dh.DisableDevice(context.TODO(), signingapi.DisableDeviceParams{Deviceid: id})
+		}},
+		{domain.STATUS_DECOMMISSIONED, func(dh *DeviceHandler, id uuid.UUID) (*signingapi.DeviceResponse, error) {
+			return dh.DecommissionDevice(context.TODO(), signingapi.DecommissionDeviceParams{Deviceid: id})
+		}},
+	}
+
+	for _, test := range tests {
+		id := uuid.New()
+		mockDevice := setupMockDevice(t, id, string(signingapi.DeviceRequestSignatureAlgorithmRSA), "pub", "", "signature", 0, nil)
+
+		mockStorage := mockPersistence.NewMockStorage(t)
+		mockStorage.EXPECT().SetStatus(mock.Anything, id, test.status).Return(mockDevice, nil)
+
+		dh := NewDeviceHandler(mockStorage, mockPersistence.NewMockSignatureJournal(t), mockDomain.NewMockSigningDeviceFactory(t))
+
+		res, err := test.call(dh, id)
+
+		assert.Nil(t, err)
+		assert.Equal(t, id, res.ID)
+	}
+}
+
+func TestSetDeviceStatusError(t *testing.T) {
+	id := uuid.New()
+
+	mockStorage := mockPersistence.NewMockStorage(t)
+	mockStorage.EXPECT().SetStatus(mock.Anything, id, domain.STATUS_DISABLED).Return(nil, persistence.ErrNotFound{})
+
+	dh := NewDeviceHandler(mockStorage, mockPersistence.NewMockSignatureJournal(t), mockDomain.NewMockSigningDeviceFactory(t))
+
+	res, err := dh.DisableDevice(context.TODO(), signingapi.DisableDeviceParams{Deviceid: id})
+
+	assert.Nil(t, res)
+	if assert.Error(t, err) {
+		assert.IsType(t, persistence.ErrNotFound{}, err)
+	}
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"context"
+	"testing"
+
+	"github.com/casell/signing-service-challenge/domain"
+	"github.com/casell/signing-service-challenge/generated/signingapi"
+	mockDomain "github.com/casell/signing-service-challenge/mocks/github.com/casell/signing-service-challenge/domain"
+	mockPersistence "github.com/casell/signing-service-challenge/mocks/github.com/casell/signing-service-challenge/persistence"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func setupMockProfileDevice(t *testing.T, profile domain.DeviceProfile) domain.SigningDevice {
+	mockDevice := mockDomain.NewMockSigningDevice(t)
+	mockDevice.EXPECT().Profile().Return(profile)
+	return mockDevice
+}
+
+func TestUpdateDevice(t *testing.T) {
+	id := uuid.New()
+	oldLabel := "old"
+	newLabel := "new"
+	metadata := map[string]string{"site": "berlin"}
+
+	current := setupMockProfileDevice(t, domain.DeviceProfile{Label: &oldLabel, Metadata: metadata, Revision: 3})
+	updated := setupMockDevice(t, id, string(signingapi.DeviceRequestSignatureAlgorithmRSA), "pub", "priv", "signature", 0, &newLabel)
+
+	mockStorage := mockPersistence.NewMockStorage(t)
+	mockStorage.EXPECT().Get(mock.Anything, id).Return(current, nil)
+	mockStorage.EXPECT().UpdateProfile(mock.Anything, id, uint(3), &newLabel, metadata).Return(updated, nil)
+
+	dh := NewDeviceHandler(mockStorage, mockPersistence.NewMockSignatureJournal(t), mockDomain.NewMockSigningDeviceFactory(t))
+
+	req := &signingapi.DeviceUpdateRequest{Label: signingapi.NewOptNilString(newLabel)}
+	res, err := dh.UpdateDevice(context.TODO(), req, signingapi.UpdateDeviceParams{Deviceid: id, IfMatch: `"3"`})
+
+	assert.Nil(t, err)
+	assert.Equal(t, `"0"`, res.ETag)
+	assert.Equal(t, id, res.Response.ID)
+	assert.Equal(t, signingapi.NewOptString(newLabel), res.Response.Label)
+}
+
+func TestUpdateDeviceAnyETag(t *testing.T) {
+	id := uuid.New()
+	label := "label"
+
+	current := setupMockProfileDevice(t, domain.DeviceProfile{Label: &label, Metadata: map[string]string{"site": "berlin"}, Revision: 7})
+	updated := setupMockDevice(t, id, string(signingapi.DeviceRequestSignatureAlgorithmRSA), "pub", "priv", "signature", 0, nil)
+
+	mockStorage := mockPersistence.NewMockStorage(t)
+	mockStorage.EXPECT().Get(mock.Anything, id).Return(current, nil)
+	mockStorage.EXPECT().UpdateProfile(mock.Anything, id, uint(7), (*string)(nil), map[string]string{}).Return(updated, nil)
+
+	dh := NewDeviceHandler(mockStorage, mockPersistence.NewMockSignatureJournal(t), mockDomain.NewMockSigningDeviceFactory(t))
+
+	req := &signingapi.DeviceUpdateRequest{
+		Label:    signingapi.OptNilString{Set: true, Null: true},
+		Metadata: signingapi.NewOptDeviceMetadata(signingapi.DeviceMetadata{}),
+	}
+	_, err := dh.UpdateDevice(context.TODO(), req, signingapi.UpdateDeviceParams{Deviceid: id, IfMatch: "*"})
+
+	assert.Nil(t, err)
+}
+
+func TestUpdateDeviceInvalidETag(t *testing.T) {
+	id := uuid.New()
+
+	for _, etag := range []string{`W/"3"`, "3", `"three"`} {
+		mockStorage := mockPersistence.NewMockStorage(t)
+		mockStorage.EXPECT().Get(mock.Anything, id).Return(setupMockProfileDevice(t, domain.DeviceProfile{Revision: 3}), nil)
+
+		dh := NewDeviceHandler(mockStorage, mockPersistence.NewMockSignatureJournal(t), mockDomain.NewMockSigningDeviceFactory(t))
+
+		res, err := dh.UpdateDevice(context.TODO(), &signingapi.DeviceUpdateRequest{}, signingapi.UpdateDeviceParams{Deviceid: id, IfMatch: etag})
+
+		assert.Nil(t, res)
+		assert.IsType(t, errPreconditionFailed{}, err)
+	}
+}
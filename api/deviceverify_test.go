@@ -0,0 +1,113 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/casell/signing-service-challenge/generated/signingapi"
+	mockDomain "github.com/casell/signing-service-challenge/mocks/github.com/casell/signing-service-challenge/domain"
+	mockPersistence "github.com/casell/signing-service-challenge/mocks/github.com/casell/signing-service-challenge/persistence"
+	"github.com/casell/signing-service-challenge/persistence"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestVerifySignatureNoDevice(t *testing.T) {
+	id := uuid.New()
+
+	mockFactory := mockDomain.NewMockSigningDeviceFactory(t)
+
+	mockStorage := mockPersistence.NewMockStorage(t)
+	mockStorage.EXPECT().Get(mock.Anything, id).Return(nil, persistence.ErrNotFound{})
+
+	dh := NewDeviceHandler(mockStorage, mockPersistence.NewMockSignatureJournal(t), mockFactory)
+
+	params := signingapi.VerifySignatureParams{
+		Deviceid: id,
+	}
+
+	req := &signingapi.VerificationRequest{
+		SignedData: "data",
+		Signature:  "signature",
+	}
+
+	res, err := dh.VerifySignature(context.TODO(), req, params)
+
+	assert.Nil(t, res)
+	if assert.Error(t, err) {
+		assert.IsType(t, persistence.ErrNotFound{}, err)
+	}
+}
+
+func TestVerifySignatureError(t *testing.T) {
+	id := uuid.New()
+	signedData := "data"
+	signature := "signature"
+
+	mockFactory := mockDomain.NewMockSigningDeviceFactory(t)
+
+	mockDevice := mockDomain.NewMockSigningDevice(t)
+	verifyErr := errors.New("Verify error")
+	mockDevice.EXPECT().Verify(signedData, signature).Return(false, verifyErr)
+
+	mockStorage := mockPersistence.NewMockStorage(t)
+	mockStorage.EXPECT().Get(mock.Anything, id).Return(mockDevice, nil)
+
+	dh := NewDeviceHandler(mockStorage, mockPersistence.NewMockSignatureJournal(t), mockFactory)
+
+	params := signingapi.VerifySignatureParams{
+		Deviceid: id,
+	}
+
+	req := &signingapi.VerificationRequest{
+		SignedData: signedData,
+		Signature:  signature,
+	}
+
+	res, err := dh.VerifySignature(context.TODO(), req, params)
+
+	assert.Nil(t, res)
+	if assert.Error(t, err) {
+		assert.Equal(t, verifyErr, err)
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	testVerifySignature(t, true)
+}
+
+func TestVerifySignatureInvalid(t *testing.T) {
+	testVerifySignature(t, false)
+}
+
+func testVerifySignature(t *testing.T, valid bool) {
+	id := uuid.New()
+	signedData := "data"
+	signature := "signature"
+
+	mockFactory := mockDomain.NewMockSigningDeviceFactory(t)
+
+	mockDevice := mockDomain.NewMockSigningDevice(t)
+	mockDevice.EXPECT().Verify(signedData, signature).Return(valid, nil)
+
+	mockStorage := mockPersistence.NewMockStorage(t)
+	mockStorage.EXPECT().Get(mock.Anything, id).Return(mockDevice, nil)
+
+	dh := NewDeviceHandler(mockStorage, mockPersistence.NewMockSignatureJournal(t), mockFactory)
+
+	params := signingapi.VerifySignatureParams{
+		Deviceid: id,
+	}
+
+	req := &signingapi.VerificationRequest{
+		SignedData: signedData,
+		Signature:  signature,
+	}
+
+	res, err := dh.VerifySignature(context.TODO(), req, params)
+
+	assert.Nil(t, err)
+	assert.Equal(t, valid, res.GetValid())
+}
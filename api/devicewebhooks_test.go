@@ -0,0 +1,118 @@
+package api
+
+import (
+	"context"
+	"net/url"
+	"testing"
+
+	"github.com/casell/signing-service-challenge/generated/signingapi"
+	mockDomain "github.com/casell/signing-service-challenge/mocks/github.com/casell/signing-service-challenge/domain"
+	mockPersistence "github.com/casell/signing-service-challenge/mocks/github.com/casell/signing-service-challenge/persistence"
+	"github.com/casell/signing-service-challenge/persistence"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func newWebhookHandler(t *testing.T) *DeviceHandler {
+	return NewDeviceHandler(mockPersistence.NewMockStorage(t), mockPersistence.NewMockSignatureJournal(t), mockDomain.NewMockSigningDeviceFactory(t))
+}
+
+func mustParseURL(t *testing.T, rawURL string) url.URL {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *u
+}
+
+func TestCreateWebhook(t *testing.T) {
+	dh := newWebhookHandler(t)
+
+	res, err := dh.CreateWebhook(context.TODO(), &signingapi.WebhookRequest{
+		URL: mustParseURL(t, "https://example.com/hook"),
+		EventTypes: []signingapi.WebhookEventType{
+			signingapi.WebhookEventTypeSignatureCreated, signingapi.WebhookEventTypeDeviceDisabled, signingapi.WebhookEventTypeSignatureCreated,
+		},
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "https://example.com/hook", res.URL.String())
+	assert.Equal(t, []signingapi.WebhookEventType{signingapi.WebhookEventTypeSignatureCreated, signingapi.WebhookEventTypeDeviceDisabled}, res.EventTypes)
+	secret, ok := res.Secret.Get()
+	assert.True(t, ok)
+	assert.Len(t, secret, 2*webhookSecretSize)
+
+	stored, err := dh.webhooks.GetWebhook(context.TODO(), res.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, secret, stored.Secret)
+
+	// The secret is only returned on creation.
+	got, err := dh.GetWebhook(context.TODO(), signingapi.GetWebhookParams{Webhookid: res.ID})
+	assert.NoError(t, err)
+	assert.False(t, got.Secret.IsSet())
+	list, err := dh.ListWebhooks(context.TODO())
+	assert.NoError(t, err)
+	if assert.Len(t, list.Items, 1) {
+		assert.Equal(t, res.ID, list.Items[0].ID)
+		assert.False(t, list.Items[0].Secret.IsSet())
+	}
+}
+
+func TestCreateWebhookInvalidURL(t *testing.T) {
+	dh := newWebhookHandler(t)
+
+	for _, rawURL := range []string{"ftp://example.com/hook", "/hook", "http://localhost:8080/hook", "http://127.0.0.1/hook", "https://[fd00::1]/hook"} {
+		res, err := dh.CreateWebhook(context.TODO(), &signingapi.WebhookRequest{
+			URL:        mustParseURL(t, rawURL),
+			EventTypes: []signingapi.WebhookEventType{signingapi.WebhookEventTypeDeviceCreated},
+		})
+
+		assert.Nil(t, res)
+		assert.IsType(t, errInvalidWebhookURL{}, err)
+	}
+}
+
+func TestUpdateWebhook(t *testing.T) {
+	dh := newWebhookHandler(t)
+	created, err := dh.CreateWebhook(context.TODO(), &signingapi.WebhookRequest{
+		URL:        mustParseURL(t, "https://example.com/hook"),
+		EventTypes: []signingapi.WebhookEventType{signingapi.WebhookEventTypeDeviceCreated},
+	})
+	assert.NoError(t, err)
+
+	res, err := dh.UpdateWebhook(context.TODO(), &signingapi.WebhookUpdateRequest{
+		EventTypes: []signingapi.WebhookEventType{signingapi.WebhookEventTypeSignatureCreated},
+	}, signingapi.UpdateWebhookParams{Webhookid: created.ID})
+	assert.NoError(t, err)
+	assert.Equal(t, "https://example.com/hook", res.URL.String())
+	assert.Equal(t, []signingapi.WebhookEventType{signingapi.WebhookEventTypeSignatureCreated}, res.EventTypes)
+
+	res, err = dh.UpdateWebhook(context.TODO(), &signingapi.WebhookUpdateRequest{
+		URL: signingapi.NewOptURI(mustParseURL(t, "http://example.com/other")),
+	}, signingapi.UpdateWebhookParams{Webhookid: created.ID})
+	assert.NoError(t, err)
+	assert.Equal(t, "http://example.com/other", res.URL.String())
+	assert.Equal(t, []signingapi.WebhookEventType{signingapi.WebhookEventTypeSignatureCreated}, res.EventTypes)
+	assert.False(t, res.Secret.IsSet())
+
+	_, err = dh.UpdateWebhook(context.TODO(), &signingapi.WebhookUpdateRequest{
+		URL: signingapi.NewOptURI(mustParseURL(t, "example.com")),
+	}, signingapi.UpdateWebhookParams{Webhookid: created.ID})
+	assert.IsType(t, errInvalidWebhookURL{}, err)
+}
+
+func TestDeleteWebhook(t *testing.T) {
+	dh := newWebhookHandler(t)
+	created, err := dh.CreateWebhook(context.TODO(), &signingapi.WebhookRequest{
+		URL:        mustParseURL(t, "https://example.com/hook"),
+		EventTypes: []signingapi.WebhookEventType{signingapi.WebhookEventTypeDeviceCreated},
+	})
+	assert.NoError(t, err)
+
+	assert.NoError(t, dh.DeleteWebhook(context.TODO(), signingapi.DeleteWebhookParams{Webhookid: created.ID}))
+
+	_, err = dh.GetWebhook(context.TODO(), signingapi.GetWebhookParams{Webhookid: created.ID})
+	assert.IsType(t, persistence.ErrWebhookNotFound{}, err)
+	err = dh.DeleteWebhook(context.TODO(), signingapi.DeleteWebhookParams{Webhookid: uuid.New()})
+	assert.IsType(t, persistence.ErrWebhookNotFound{}, err)
+}
@@ -4,11 +4,111 @@ import (
 	"fmt"
 )
 
-type errDeviceNotFound struct {
+type errInvalidDeviceID struct {
 	deviceID string
 }
 
-func (e errDeviceNotFound) Error() string {
-	return fmt.Sprintf("device %s not found", e.deviceID)
+func (e errInvalidDeviceID) Error() string {
+	return fmt.Sprintf("device id %s is not valid", e.deviceID)
+}
+
+type errSignatureNotFound struct {
+	deviceID string
+	counter  int
+}
+
+func (e errSignatureNotFound) Error() string {
+	return fmt.Sprintf("signature %d of device %s not found", e.counter, e.deviceID)
+}
+
+type errPreconditionFailed struct {
+	deviceID string
+	etag     string
+}
+
+func (e errPreconditionFailed) Error() string {
+	return fmt.Sprintf("device %s does not match ETag %s", e.deviceID, e.etag)
+}
+
+type errInvalidMetadataFilter struct {
+	filter string
+}
+
+func (e errInvalidMetadataFilter) Error() string {
+	return fmt.Sprintf("metadata filter %q is not in the key:value form", e.filter)
+}
+
+type errInvalidCallbackURL struct {
+	url    string
+	reason string
+}
+
+func (e errInvalidCallbackURL) Error() string {
+	return fmt.Sprintf("callback URL %q is not valid: %s", e.url, e.reason)
+}
+
+type errTooManyJobs struct {
+	deviceID string
+	limit    int
+}
+
+func (e errTooManyJobs) Error() string {
+	return fmt.Sprintf("device %s already has %d pending jobs", e.deviceID, e.limit)
+}
+
+type errJobQueueFull struct{}
+
+func (e errJobQueueFull) Error() string {
+	return "too many pending jobs, retry later"
+}
+
+type errJobNotFound struct {
+	jobID string
+}
+
+func (e errJobNotFound) Error() string {
+	return fmt.Sprintf("job %s not found", e.jobID)
+}
+
+type errInvalidWebhookURL struct {
+	url    string
+	reason string
+}
+
+func (e errInvalidWebhookURL) Error() string {
+	return fmt.Sprintf("webhook URL %q is not valid: %s", e.url, e.reason)
+}
+
+type errMissingAPIKey struct{}
+
+func (e errMissingAPIKey) Error() string {
+	return fmt.Sprintf("missing API key, expected in the %s header", API_KEY_HEADER)
+}
+
+type errInvalidAPIKey struct{}
+
+func (e errInvalidAPIKey) Error() string {
+	return "invalid API key"
+}
+
+type errScopeNotGranted struct {
+	scope string
+}
+
+func (e errScopeNotGranted) Error() string {
+	return fmt.Sprintf("API key is not granted the %s scope", e.scope)
+}
+
+type errInvalidScope struct {
+	scope string
+}
+
+func (e errInvalidScope) Error() string {
+	return fmt.Sprintf("scope %q is not valid", e.scope)
+}
+
+type errNoScope struct{}
 
+func (e errNoScope) Error() string {
+	return "an API key needs at least one scope"
 }
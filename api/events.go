@@ -0,0 +1,237 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/casell/signing-service-challenge/domain"
+	"github.com/casell/signing-service-challenge/generated/signingapi"
+	"github.com/casell/signing-service-challenge/persistence"
+	"github.com/google/uuid"
+	ht "github.com/ogen-go/ogen/http"
+)
+
+const (
+	// EVENT_KEEPALIVE_INTERVAL is how often an idle event stream gets a comment, so that proxies keep it open.
+	EVENT_KEEPALIVE_INTERVAL = 15 * time.Second
+
+	eventReplayPage = 1000
+)
+
+// EventHandler streams device events as Server-Sent Events.
+type EventHandler struct {
+	store   persistence.Storage
+	journal persistence.SignatureJournal
+	bus     *domain.EventBus
+}
+
+// eventPayload is the data of a streamed event.
+type eventPayload struct {
+	Type      string                 `json:"type"`
+	DeviceID  uuid.UUID              `json:"deviceId"`
+	Time      time.Time              `json:"time"`
+	Status    domain.DeviceStatus    `json:"status,omitempty"`
+	Signature *eventSignaturePayload `json:"signature,omitempty"`
+}
+
+type eventSignaturePayload struct {
+	Counter    uint   `json:"counter"`
+	Data       string `json:"data"`
+	SignedData string `json:"signedData"`
+	Signature  string `json:"signature"`
+}
+
+// NewEventHandler creates an event handler streaming from bus, missed signatures are replayed from journal.
+func NewEventHandler(store persistence.Storage, journal persistence.SignatureJournal, bus *domain.EventBus) *EventHandler {
+	return &EventHandler{
+		store:   store,
+		journal: journal,
+		bus:     bus,
+	}
+}
+
+// Events streams the events of all the devices. Event ids are the bus sequence: with Last-Event-ID
+// the events published since are replayed, as far as the bus keeps them.
+func (h *EventHandler) Events(w http.ResponseWriter, r *http.Request) {
+	after := uint64(math.MaxUint64)
+	if lastEventID := r.Header.Get("Last-Event-ID"); lastEventID != "" {
+		sequence, err := strconv.ParseUint(lastEventID, 10, 64)
+		if err != nil {
+			WriteErrorResponse(w, http.StatusBadRequest, []string{fmt.Sprintf("Last-Event-ID %q is not valid", lastEventID)})
+			return
+		}
+		after = sequence
+	}
+
+	subscription, missed := h.bus.Subscribe(after)
+	defer subscription.Close()
+
+	stream := newEventStream(w)
+	for _, event := range missed {
+		if err := stream.send(strconv.FormatUint(event.Sequence, 10), event); err != nil {
+			return
+		}
+	}
+	h.forward(r, stream, subscription, func(event domain.Event) error {
+		return stream.send(strconv.FormatUint(event.Sequence, 10), event)
+	})
+}
+
+// DeviceEvents streams the events of a device. Signature events carry the signature counter as id:
+// with Last-Event-ID the signatures issued since are replayed from the journal, other events are not replayed.
+func (h *EventHandler) DeviceEvents(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(r.PathValue("deviceid"))
+	if err != nil {
+		WriteErrorResponse(w, http.StatusBadRequest, []string{errInvalidDeviceID{r.PathValue("deviceid")}.Error()})
+		return
+	}
+	next := uint(0)
+	resume := false
+	if lastEventID := r.Header.Get("Last-Event-ID"); lastEventID != "" {
+		counter, err := strconv.ParseUint(lastEventID, 10, 0)
+		if err != nil {
+			WriteErrorResponse(w, http.StatusBadRequest, []string{fmt.Sprintf("Last-Event-ID %q is not valid", lastEventID)})
+			return
+		}
+		next, resume = uint(counter)+1, true
+	}
+	if _, err := h.store.Get(r.Context(), id); err != nil {
+		if errors.As(err, &persistence.ErrNotFound{}) {
+			WriteErrorResponse(w, http.StatusNotFound, []string{err.Error()})
+			return
+		}
+		WriteErrorResponse(w, http.StatusInternalServerError, []string{err.Error()})
+		return
+	}
+
+	// Subscribe before reading the journal: a signature issued meanwhile is both replayed and delivered,
+	// next drops the second copy.
+	subscription, _ := h.bus.Subscribe(math.MaxUint64)
+	defer subscription.Close()
+
+	stream := newEventStream(w)
+	for resume {
+		records, _, err := h.journal.List(r.Context(), id, int(next), eventReplayPage)
+		if err != nil || len(records) == 0 {
+			break
+		}
+		for i := range records {
+			if err := stream.send(strconv.FormatUint(uint64(records[i].Counter), 10), domain.SignatureCreatedEvent(id, &records[i])); err != nil {
+				return
+			}
+			next = records[i].Counter + 1
+		}
+	}
+	h.forward(r, stream, subscription, func(event domain.Event) error {
+		if event.DeviceID != id {
+			return nil
+		}
+		if event.Record == nil {
+			return stream.send("", event)
+		}
+		if event.Record.Counter < next {
+			return nil
+		}
+		next = event.Record.Counter + 1
+		return stream.send(strconv.FormatUint(uint64(event.Record.Counter), 10), event)
+	})
+}
+
+// StreamEvents is part of the specification only: the stream is served by EventHandler.Events, the generated
+// server cannot stream responses.
+func (h *DeviceHandler) StreamEvents(ctx context.Context, params signingapi.StreamEventsParams) (signingapi.StreamEventsOK, error) {
+	return signingapi.StreamEventsOK{}, ht.ErrNotImplemented
+}
+
+// StreamDeviceEvents is part of the specification only: the stream is served by EventHandler.DeviceEvents.
+func (h *DeviceHandler) StreamDeviceEvents(ctx context.Context, params signingapi.StreamDeviceEventsParams) (signingapi.StreamDeviceEventsOK, error) {
+	return signingapi.StreamDeviceEventsOK{}, ht.ErrNotImplemented
+}
+
+// forward hands the events of subscription to send until the client goes away or the subscription is dropped.
+func (h *EventHandler) forward(r *http.Request, stream *eventStream, subscription *domain.Subscription, send func(domain.Event) error) {
+	keepalive := time.NewTicker(EVENT_KEEPALIVE_INTERVAL)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			if err := stream.comment("keepalive"); err != nil {
+				return
+			}
+		case event, ok := <-subscription.C:
+			if !ok {
+				return
+			}
+			if err := send(event); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// eventStream writes Server-Sent Events, flushing each of them.
+type eventStream struct {
+	w          http.ResponseWriter
+	controller *http.ResponseController
+}
+
+func newEventStream(w http.ResponseWriter) *eventStream {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	stream := &eventStream{w: w, controller: http.NewResponseController(w)}
+	stream.controller.Flush()
+	return stream
+}
+
+// newEventPayload returns the payload of event, announced as eventType.
+func newEventPayload(eventType string, event domain.Event) eventPayload {
+	payload := eventPayload{
+		Type:     eventType,
+		DeviceID: event.DeviceID,
+		Time:     event.Time,
+		Status:   event.Status,
+	}
+	if event.Record != nil {
+		payload.Signature = &eventSignaturePayload{
+			Counter:    event.Record.Counter,
+			Data:       event.Record.Data,
+			SignedData: event.Record.SignedData,
+			Signature:  event.Record.Signature,
+		}
+	}
+	return payload
+}
+
+// send writes event, with id unless empty: the client then keeps the previous Last-Event-ID.
+func (s *eventStream) send(id string, event domain.Event) error {
+	data, err := json.Marshal(newEventPayload(event.Type, event))
+	if err != nil {
+		return err
+	}
+
+	if id != "" {
+		if _, err := fmt.Fprintf(s.w, "id: %s\n", id); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", event.Type, data); err != nil {
+		return err
+	}
+	return s.controller.Flush()
+}
+
+func (s *eventStream) comment(text string) error {
+	if _, err := fmt.Fprintf(s.w, ": %s\n\n", text); err != nil {
+		return err
+	}
+	return s.controller.Flush()
+}
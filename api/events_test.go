@@ -0,0 +1,165 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	mycrypto "github.com/casell/signing-service-challenge/crypto"
+	"github.com/casell/signing-service-challenge/domain"
+	"github.com/casell/signing-service-challenge/persistence"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+type sseEvent struct {
+	id      string
+	event   string
+	payload eventPayload
+}
+
+// newEventServer serves the event streams of a memory store whose devices publish on the returned bus.
+func newEventServer(t *testing.T) (*httptest.Server, *persistence.MemoryStore, domain.SigningDeviceFactory, *domain.EventBus) {
+	bus := domain.NewEventBus(domain.DEFAULT_EVENT_HISTORY)
+	store := persistence.NewMemoryStore()
+	h := NewEventHandler(store, store.Journal(), bus)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /events", h.Events)
+	mux.HandleFunc("GET /device/{deviceid}/events", h.DeviceEvents)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server, store, domain.NewEventDeviceFactory(bus), bus
+}
+
+// openStream starts streaming path, the stream ends with the test.
+func openStream(t *testing.T, url string, lastEventID string) (*http.Response, *bufio.Reader) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { res.Body.Close() })
+	return res, bufio.NewReader(res.Body)
+}
+
+// readEvent reads the next event of the stream, skipping comments.
+func readEvent(t *testing.T, r *bufio.Reader) sseEvent {
+	var event sseEvent
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal("unable to read event", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && event.event != "":
+			return event
+		case strings.HasPrefix(line, "id: "):
+			event.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			event.event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event.payload); err != nil {
+				t.Fatal("unable to decode event", err)
+			}
+		}
+	}
+}
+
+func addEventDevice(t *testing.T, store persistence.Storage, factory domain.SigningDeviceFactory) domain.SigningDevice {
+	d, err := factory.New(uuid.Nil, domain.STATUS_ACTIVE, "ED25519", nil, mycrypto.Parameters{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Add(context.Background(), d); err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestDeviceEventsResume(t *testing.T) {
+	server, store, factory, _ := newEventServer(t)
+	d := addEventDevice(t, store, factory)
+	other := addEventDevice(t, store, factory)
+	for i := 0; i < 2; i++ {
+		if _, err := store.SignAndCommit(context.Background(), d.ID(), "data", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	res, stream := openStream(t, server.URL+"/device/"+d.ID().String()+"/events", "0")
+	assert.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+
+	event := readEvent(t, stream)
+	assert.Equal(t, "1", event.id)
+	assert.Equal(t, domain.EVENT_SIGNATURE_CREATED, event.event)
+	assert.Equal(t, uint(1), event.payload.Signature.Counter)
+
+	if _, err := store.SignAndCommit(context.Background(), other.ID(), "other", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.SignAndCommit(context.Background(), d.ID(), "live", ""); err != nil {
+		t.Fatal(err)
+	}
+	event = readEvent(t, stream)
+	assert.Equal(t, "2", event.id)
+	assert.Equal(t, "live", event.payload.Signature.Data)
+
+	if _, err := store.SetStatus(context.Background(), d.ID(), domain.STATUS_DISABLED); err != nil {
+		t.Fatal(err)
+	}
+	event = readEvent(t, stream)
+	assert.Equal(t, "", event.id)
+	assert.Equal(t, domain.EVENT_STATUS_CHANGED, event.event)
+	assert.Equal(t, domain.STATUS_DISABLED, event.payload.Status)
+}
+
+func TestDeviceEventsErrors(t *testing.T) {
+	server, store, factory, _ := newEventServer(t)
+	d := addEventDevice(t, store, factory)
+
+	for url, status := range map[string]int{
+		server.URL + "/device/" + uuid.NewString() + "/events": http.StatusNotFound,
+		server.URL + "/device/nope/events":                     http.StatusBadRequest,
+	} {
+		res, _ := openStream(t, url, "")
+		assert.Equal(t, status, res.StatusCode)
+	}
+
+	res, _ := openStream(t, server.URL+"/device/"+d.ID().String()+"/events", "last")
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+}
+
+func TestEventsResume(t *testing.T) {
+	server, store, factory, _ := newEventServer(t)
+	d := addEventDevice(t, store, factory)
+	if _, err := store.SignAndCommit(context.Background(), d.ID(), "data", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	_, stream := openStream(t, server.URL+"/events", "1")
+	event := readEvent(t, stream)
+	assert.Equal(t, "2", event.id)
+	assert.Equal(t, domain.EVENT_SIGNATURE_CREATED, event.event)
+	assert.Equal(t, d.ID(), event.payload.DeviceID)
+
+	other := addEventDevice(t, store, factory)
+	event = readEvent(t, stream)
+	assert.Equal(t, "3", event.id)
+	assert.Equal(t, domain.EVENT_DEVICE_CREATED, event.event)
+	assert.Equal(t, other.ID(), event.payload.DeviceID)
+	assert.Equal(t, domain.STATUS_ACTIVE, event.payload.Status)
+}
@@ -0,0 +1,341 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/casell/signing-service-challenge/generated/signingapi"
+	"github.com/casell/signing-service-challenge/generated/signinggrpc"
+	"github.com/casell/signing-service-challenge/persistence"
+	"github.com/google/uuid"
+	"github.com/ogen-go/ogen/validate"
+)
+
+// grpcCodes maps the HTTP status codes NewError answers with to gRPC codes.
+var grpcCodes = map[int]codes.Code{
+	http.StatusBadRequest:          codes.InvalidArgument,
+	http.StatusUnauthorized:        codes.Unauthenticated,
+	http.StatusForbidden:           codes.PermissionDenied,
+	http.StatusNotFound:            codes.NotFound,
+	http.StatusConflict:            codes.FailedPrecondition,
+	http.StatusPreconditionFailed:  codes.FailedPrecondition,
+	http.StatusUnprocessableEntity: codes.InvalidArgument,
+	http.StatusTooManyRequests:     codes.ResourceExhausted,
+	http.StatusServiceUnavailable:  codes.Unavailable,
+}
+
+// The parameters ogen validates while decoding REST requests, gRPC requests are checked against the same bounds.
+var (
+	idempotencyKeyBounds = validate.String{MinLength: 1, MinLengthSet: true, MaxLength: 255, MaxLengthSet: true}
+	devicesLimitBounds   = validate.Int{Min: 1, MinSet: true, Max: 1000, MaxSet: true}
+)
+
+// GRPCServer serves the gRPC SigningService. Requests are converted to their REST counterpart and
+// handled by a DeviceHandler, so both APIs share validation, storage and error mapping.
+type GRPCServer struct {
+	signinggrpc.UnimplementedSigningServiceServer
+
+	listenAddress string
+	handler       *DeviceHandler
+	auth          *Authenticator
+}
+
+// NewGRPCServer is a factory to instantiate a new GRPCServer serving handler, which can be shared with a Server.
+// Calls are authenticated with the API keys of handler.
+func NewGRPCServer(listenAddress string, handler *DeviceHandler) *GRPCServer {
+	return &GRPCServer{
+		listenAddress: listenAddress,
+		handler:       handler,
+		auth:          NewAuthenticator(handler.apikeys),
+	}
+}
+
+// Run registers the SigningService and starts the GRPCServer.
+func (s *GRPCServer) Run() error {
+	listener, err := net.Listen("tcp", s.listenAddress)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("gRPC server listening at %s...\n", s.listenAddress)
+
+	return s.newServer().Serve(listener)
+}
+
+// newServer returns a gRPC server authenticating calls and serving the SigningService.
+func (s *GRPCServer) newServer() *grpc.Server {
+	srv := grpc.NewServer(grpc.UnaryInterceptor(s.authorizeUnary), grpc.StreamInterceptor(s.authorizeStream))
+	signinggrpc.RegisterSigningServiceServer(srv, s)
+	return srv
+}
+
+// authorize checks the API key in the metadata of a call to method is granted the scope the method requires.
+func (s *GRPCServer) authorize(ctx context.Context, method string) error {
+	key := ""
+	if values := metadata.ValueFromIncomingContext(ctx, strings.ToLower(API_KEY_HEADER)); len(values) > 0 {
+		key = values[0]
+	}
+	if _, err := s.auth.AuthorizeOperation(ctx, key, path.Base(method)); err != nil {
+		return s.grpcError(ctx, err)
+	}
+	return nil
+}
+
+func (s *GRPCServer) authorizeUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if err := s.authorize(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (s *GRPCServer) authorizeStream(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := s.authorize(stream.Context(), info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, stream)
+}
+
+// grpcError converts err to the gRPC status matching the HTTP status NewError maps it to.
+func (s *GRPCServer) grpcError(ctx context.Context, err error) error {
+	if errors.As(err, &persistence.ErrAlreadyExists{}) {
+		return status.Error(codes.AlreadyExists, err.Error())
+	}
+	res := s.handler.NewError(ctx, err)
+	code, ok := grpcCodes[res.StatusCode]
+	if !ok {
+		code = codes.Internal
+	}
+	return status.Error(code, strings.Join(res.Response.Errors, "; "))
+}
+
+// invalidArgument reports a request failing the validation of the REST API.
+func invalidArgument(err error) error {
+	return status.Error(codes.InvalidArgument, err.Error())
+}
+
+func parseDeviceID(id string) (uuid.UUID, error) {
+	deviceID, err := uuid.Parse(id)
+	if err != nil {
+		return uuid.Nil, errInvalidDeviceID{id}
+	}
+	return deviceID, nil
+}
+
+// CreateDevice handles device creation requests.
+func (s *GRPCServer) CreateDevice(ctx context.Context, req *signinggrpc.CreateDeviceRequest) (*signinggrpc.Device, error) {
+	apiReq := signingapi.DeviceRequest{
+		SignatureAlgorithm: signingapi.DeviceRequestSignatureAlgorithm(req.SignatureAlgorithm),
+	}
+	if req.Id != "" {
+		id, err := parseDeviceID(req.Id)
+		if err != nil {
+			return nil, s.grpcError(ctx, err)
+		}
+		apiReq.ID.SetTo(id)
+	}
+	if req.Label != nil {
+		apiReq.Label.SetTo(*req.Label)
+	}
+	if req.KeySize != 0 {
+		apiReq.KeySize.SetTo(int(req.KeySize))
+	}
+	if req.Curve != "" {
+		apiReq.Curve.SetTo(signingapi.DeviceRequestCurve(req.Curve))
+	}
+	if req.HashAlgorithm != "" {
+		apiReq.HashAlgorithm.SetTo(signingapi.DeviceRequestHashAlgorithm(req.HashAlgorithm))
+	}
+	if req.Status != "" {
+		apiReq.Status.SetTo(signingapi.DeviceRequestStatus(req.Status))
+	}
+	if err := apiReq.Validate(); err != nil {
+		return nil, invalidArgument(err)
+	}
+
+	res, err := s.handler.CreateDevice(ctx, &apiReq)
+	if err != nil {
+		return nil, s.grpcError(ctx, err)
+	}
+	return convertToGRPCDevice(res), nil
+}
+
+// GetDevice handles device requests.
+func (s *GRPCServer) GetDevice(ctx context.Context, req *signinggrpc.GetDeviceRequest) (*signinggrpc.Device, error) {
+	id, err := parseDeviceID(req.Id)
+	if err != nil {
+		return nil, s.grpcError(ctx, err)
+	}
+	res, err := s.handler.GetDevice(ctx, signingapi.GetDeviceParams{Deviceid: id})
+	if err != nil {
+		return nil, s.grpcError(ctx, err)
+	}
+	return convertToGRPCDevice(&res.Response), nil
+}
+
+// ListDevices handles device list requests, summaries carry all their fields.
+func (s *GRPCServer) ListDevices(ctx context.Context, req *signinggrpc.ListDevicesRequest) (*signinggrpc.ListDevicesResponse, error) {
+	params := signingapi.ListDevicesParams{Metadata: req.Metadata}
+	if req.Limit != 0 {
+		if err := devicesLimitBounds.Validate(int64(req.Limit)); err != nil {
+			return nil, invalidArgument(fmt.Errorf("limit: %w", err))
+		}
+		params.Limit.SetTo(int(req.Limit))
+	}
+	if req.Cursor != "" {
+		params.Cursor.SetTo(req.Cursor)
+	}
+	if req.SignatureAlgorithm != "" {
+		params.SignatureAlgorithm.SetTo(signingapi.ListDevicesSignatureAlgorithm(req.SignatureAlgorithm))
+		if err := params.SignatureAlgorithm.Value.Validate(); err != nil {
+			return nil, invalidArgument(err)
+		}
+	}
+	if req.Status != "" {
+		params.Status.SetTo(signingapi.DeviceStatus(req.Status))
+		if err := params.Status.Value.Validate(); err != nil {
+			return nil, invalidArgument(err)
+		}
+	}
+	if req.LabelPrefix != "" {
+		params.LabelPrefix.SetTo(req.LabelPrefix)
+	}
+	if req.Sort != "" {
+		params.Sort.SetTo(signingapi.ListDevicesSort(req.Sort))
+		if err := params.Sort.Value.Validate(); err != nil {
+			return nil, invalidArgument(err)
+		}
+	}
+
+	list, err := s.handler.ListDevices(ctx, params)
+	if err != nil {
+		return nil, s.grpcError(ctx, err)
+	}
+	res := &signinggrpc.ListDevicesResponse{
+		Items:      make([]*signinggrpc.DeviceSummary, len(list.Items)),
+		NextCursor: list.NextCursor.Or(""),
+	}
+	for i, summary := range list.Items {
+		res.Items[i] = &signinggrpc.DeviceSummary{
+			Id:                 summary.ID.String(),
+			Label:              optString(summary.Label),
+			SignatureAlgorithm: string(summary.SignatureAlgorithm.Value),
+			Status:             string(summary.Status.Value),
+			Counter:            uint64(summary.Counter.Value),
+			CreatedAt:          optTimestamp(summary.CreatedAt),
+			LastSignedAt:       optTimestamp(summary.LastSignedAt),
+		}
+	}
+	return res, nil
+}
+
+// SignTransaction handles signing requests.
+func (s *GRPCServer) SignTransaction(ctx context.Context, req *signinggrpc.SignTransactionRequest) (*signinggrpc.Signature, error) {
+	id, err := parseDeviceID(req.DeviceId)
+	if err != nil {
+		return nil, s.grpcError(ctx, err)
+	}
+	params := signingapi.SignTransactionParams{Deviceid: id}
+	if req.IdempotencyKey != "" {
+		if err := idempotencyKeyBounds.Validate(req.IdempotencyKey); err != nil {
+			return nil, invalidArgument(fmt.Errorf("idempotency_key: %w", err))
+		}
+		params.IdempotencyKey.SetTo(req.IdempotencyKey)
+	}
+
+	res, err := s.handler.SignTransaction(ctx, &signingapi.SignatureRequest{DataToBeSigned: req.DataToBeSigned}, params)
+	if err != nil {
+		return nil, s.grpcError(ctx, err)
+	}
+	return convertToGRPCSignature(*res.(*signingapi.SignatureResponse)), nil
+}
+
+// SignTransactionBatch handles batch signing requests. The batch is signed as a whole before
+// the signatures are streamed in request order: a client going away mid-stream does not undo it.
+func (s *GRPCServer) SignTransactionBatch(req *signinggrpc.SignTransactionBatchRequest, stream signinggrpc.SigningService_SignTransactionBatchServer) error {
+	ctx := stream.Context()
+	id, err := parseDeviceID(req.DeviceId)
+	if err != nil {
+		return s.grpcError(ctx, err)
+	}
+	apiReq := signingapi.BatchSignatureRequest{DataToBeSigned: req.DataToBeSigned}
+	if err := apiReq.Validate(); err != nil {
+		return invalidArgument(err)
+	}
+
+	res, err := s.handler.SignTransactionBatch(ctx, &apiReq, signingapi.SignTransactionBatchParams{Deviceid: id})
+	if err != nil {
+		return s.grpcError(ctx, err)
+	}
+	for _, item := range res.Items {
+		if err := stream.Send(convertToGRPCSignature(item)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func convertToGRPCSignature(res signingapi.SignatureResponse) *signinggrpc.Signature {
+	return &signinggrpc.Signature{
+		Signature:  res.Signature,
+		SignedData: res.SignedData,
+		Counter:    uint64(res.Counter),
+	}
+}
+
+func convertToGRPCDevice(res *signingapi.DeviceResponse) *signinggrpc.Device {
+	device := &signinggrpc.Device{
+		Id:                 res.ID.String(),
+		Status:             string(res.Status),
+		Label:              optString(res.Label),
+		Metadata:           res.Metadata.Value,
+		SignatureAlgorithm: string(res.SignatureAlgorithm),
+		Counter:            uint64(res.Counter),
+		LastSignature:      res.LastSignature,
+		PublicKey:          res.PublicKey,
+		CreatedAt:          optTimestamp(res.CreatedAt),
+		LastSignedAt:       optTimestamp(res.LastSignedAt),
+	}
+	if keySize, ok := res.KeySize.Get(); ok {
+		device.KeySize = proto.Int32(int32(keySize))
+	}
+	if curve, ok := res.Curve.Get(); ok {
+		device.Curve = proto.String(string(curve))
+	}
+	if hash, ok := res.HashAlgorithm.Get(); ok {
+		device.HashAlgorithm = proto.String(string(hash))
+	}
+	if padding, ok := res.Padding.Get(); ok {
+		device.Padding = proto.String(string(padding))
+	}
+	if saltLength, ok := res.SaltLength.Get(); ok {
+		device.SaltLength = proto.Int32(int32(saltLength))
+	}
+	return device
+}
+
+func optString(o signingapi.OptString) *string {
+	if v, ok := o.Get(); ok {
+		return &v
+	}
+	return nil
+}
+
+func optTimestamp(o signingapi.OptDateTime) *timestamppb.Timestamp {
+	if v, ok := o.Get(); ok {
+		return timestamppb.New(v)
+	}
+	return nil
+}
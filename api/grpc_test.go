@@ -0,0 +1,253 @@
+package api
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/casell/signing-service-challenge/domain"
+	"github.com/casell/signing-service-challenge/generated/signinggrpc"
+	"github.com/casell/signing-service-challenge/persistence"
+	"github.com/google/uuid"
+)
+
+// apiKeyCredentials sends an API key along each call.
+type apiKeyCredentials string
+
+func (c apiKeyCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"x-api-key": string(c)}, nil
+}
+
+func (c apiKeyCredentials) RequireTransportSecurity() bool {
+	return false
+}
+
+// newGRPCClient serves the SigningService of a memory store in memory and returns a client connected to it,
+// calling with a key issued with scopes, every scope if none is given.
+func newGRPCClient(t *testing.T, scopes ...string) (signinggrpc.SigningServiceClient, *persistence.MemoryStore) {
+	if len(scopes) == 0 {
+		scopes = API_KEY_SCOPES
+	}
+	apikeys := persistence.NewMemoryAPIKeyStore()
+	key, _, err := IssueAPIKey(context.Background(), apikeys, "test", scopes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, store := serveGRPC(t, apikeys)
+	return dialGRPC(t, listener, key), store
+}
+
+// serveGRPC serves the SigningService of a memory store in memory, authenticating calls with apikeys.
+func serveGRPC(t *testing.T, apikeys persistence.APIKeyStorage) (*bufconn.Listener, *persistence.MemoryStore) {
+	store := persistence.NewMemoryStore()
+	listener := bufconn.Listen(1 << 20)
+	handler := NewDeviceHandlerWithOptions(DeviceHandlerOptions{
+		Store:         store,
+		Journal:       store.Journal(),
+		DeviceFactory: domain.NewDefaultDeviceFactory(),
+		APIKeys:       apikeys,
+	})
+	srv := NewGRPCServer("", handler).newServer()
+	go srv.Serve(listener)
+	t.Cleanup(srv.Stop)
+	return listener, store
+}
+
+// dialGRPC returns a client of the SigningService served on listener, calling with key unless it is empty.
+func dialGRPC(t *testing.T, listener *bufconn.Listener, key string) signinggrpc.SigningServiceClient {
+	options := []grpc.DialOption{
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	}
+	if key != "" {
+		options = append(options, grpc.WithPerRPCCredentials(apiKeyCredentials(key)))
+	}
+	conn, err := grpc.NewClient("passthrough:///bufnet", options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return signinggrpc.NewSigningServiceClient(conn)
+}
+
+func TestGRPCCreateAndGetDevice(t *testing.T) {
+	client, _ := newGRPCClient(t)
+	ctx := context.Background()
+	id := uuid.New()
+
+	created, err := client.CreateDevice(ctx, &signinggrpc.CreateDeviceRequest{Id: id.String(), SignatureAlgorithm: "ECC", Label: proto.String("register"), Curve: "P-256"})
+	assert.NoError(t, err)
+	assert.Equal(t, id.String(), created.Id)
+	assert.Equal(t, "ACTIVE", created.Status)
+	assert.Equal(t, "register", created.GetLabel())
+	assert.Equal(t, "P-256", created.GetCurve())
+	assert.Nil(t, created.KeySize)
+	assert.NotNil(t, created.CreatedAt)
+	assert.Nil(t, created.LastSignedAt)
+
+	got, err := client.GetDevice(ctx, &signinggrpc.GetDeviceRequest{Id: id.String()})
+	assert.NoError(t, err)
+	assert.True(t, proto.Equal(created, got))
+
+	_, err = client.CreateDevice(ctx, &signinggrpc.CreateDeviceRequest{Id: id.String(), SignatureAlgorithm: "ECC"})
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+}
+
+func TestGRPCErrors(t *testing.T) {
+	client, _ := newGRPCClient(t)
+	ctx := context.Background()
+
+	_, err := client.CreateDevice(ctx, &signinggrpc.CreateDeviceRequest{SignatureAlgorithm: "DSA"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = client.CreateDevice(ctx, &signinggrpc.CreateDeviceRequest{SignatureAlgorithm: "ED25519", Status: "DISABLED"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = client.GetDevice(ctx, &signinggrpc.GetDeviceRequest{Id: "not-a-uuid"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = client.GetDevice(ctx, &signinggrpc.GetDeviceRequest{Id: uuid.New().String()})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	_, err = client.ListDevices(ctx, &signinggrpc.ListDevicesRequest{Sort: "counter"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = client.ListDevices(ctx, &signinggrpc.ListDevicesRequest{Limit: -1})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = client.ListDevices(ctx, &signinggrpc.ListDevicesRequest{Limit: 1001})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	initialized, err := client.CreateDevice(ctx, &signinggrpc.CreateDeviceRequest{SignatureAlgorithm: "ED25519", Status: "INITIALIZED"})
+	assert.NoError(t, err)
+	_, err = client.SignTransaction(ctx, &signinggrpc.SignTransactionRequest{DeviceId: initialized.Id, DataToBeSigned: "data"})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	active, err := client.CreateDevice(ctx, &signinggrpc.CreateDeviceRequest{SignatureAlgorithm: "ED25519"})
+	assert.NoError(t, err)
+	_, err = client.SignTransaction(ctx, &signinggrpc.SignTransactionRequest{DeviceId: active.Id, DataToBeSigned: "data", IdempotencyKey: strings.Repeat("k", 256)})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestGRPCErrorCodes(t *testing.T) {
+	store := persistence.NewMemoryStore()
+	s := NewGRPCServer("", NewDeviceHandler(store, store.Journal(), domain.NewDefaultDeviceFactory()))
+	ctx := context.Background()
+
+	assert.Equal(t, codes.ResourceExhausted, status.Code(s.grpcError(ctx, errTooManyJobs{deviceID: uuid.NewString(), limit: MAX_PENDING_JOBS_PER_DEVICE})))
+	assert.Equal(t, codes.Unavailable, status.Code(s.grpcError(ctx, errJobQueueFull{})))
+}
+
+func TestGRPCListDevices(t *testing.T) {
+	client, _ := newGRPCClient(t)
+	ctx := context.Background()
+	for _, label := range []string{"b", "a", "c"} {
+		_, err := client.CreateDevice(ctx, &signinggrpc.CreateDeviceRequest{SignatureAlgorithm: "ED25519", Label: proto.String(label)})
+		assert.NoError(t, err)
+	}
+
+	first, err := client.ListDevices(ctx, &signinggrpc.ListDevicesRequest{Limit: 2, Sort: "label"})
+	assert.NoError(t, err)
+	if assert.Len(t, first.Items, 2) {
+		assert.Equal(t, "a", first.Items[0].GetLabel())
+		assert.Equal(t, "b", first.Items[1].GetLabel())
+		assert.Equal(t, "ED25519", first.Items[0].SignatureAlgorithm)
+		assert.Equal(t, "ACTIVE", first.Items[0].Status)
+	}
+	assert.NotEmpty(t, first.NextCursor)
+
+	second, err := client.ListDevices(ctx, &signinggrpc.ListDevicesRequest{Limit: 2, Sort: "label", Cursor: first.NextCursor})
+	assert.NoError(t, err)
+	if assert.Len(t, second.Items, 1) {
+		assert.Equal(t, "c", second.Items[0].GetLabel())
+	}
+	assert.Empty(t, second.NextCursor)
+}
+
+func TestGRPCSignTransaction(t *testing.T) {
+	client, store := newGRPCClient(t)
+	ctx := context.Background()
+	device, err := client.CreateDevice(ctx, &signinggrpc.CreateDeviceRequest{SignatureAlgorithm: "ED25519"})
+	assert.NoError(t, err)
+
+	first, err := client.SignTransaction(ctx, &signinggrpc.SignTransactionRequest{DeviceId: device.Id, DataToBeSigned: "data", IdempotencyKey: "key"})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), first.Counter)
+	again, err := client.SignTransaction(ctx, &signinggrpc.SignTransactionRequest{DeviceId: device.Id, DataToBeSigned: "data", IdempotencyKey: "key"})
+	assert.NoError(t, err)
+	assert.True(t, proto.Equal(first, again))
+
+	stream, err := client.SignTransactionBatch(ctx, &signinggrpc.SignTransactionBatchRequest{DeviceId: device.Id, DataToBeSigned: []string{"a", "b", "c"}})
+	assert.NoError(t, err)
+	counters := []uint64{}
+	for {
+		signature, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if !assert.NoError(t, err) {
+			return
+		}
+		counters = append(counters, signature.Counter)
+	}
+	assert.Equal(t, []uint64{1, 2, 3}, counters)
+
+	records, _, err := store.Journal().List(ctx, uuid.MustParse(device.Id), 0, 10)
+	assert.NoError(t, err)
+	assert.Len(t, records, 4)
+
+	stream, err = client.SignTransactionBatch(ctx, &signinggrpc.SignTransactionBatchRequest{DeviceId: device.Id})
+	assert.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestGRPCAuthentication(t *testing.T) {
+	apikeys := persistence.NewMemoryAPIKeyStore()
+	key, _, err := IssueAPIKey(context.Background(), apikeys, "reader", []string{SCOPE_READ})
+	assert.NoError(t, err)
+	listener, _ := serveGRPC(t, apikeys)
+	ctx := context.Background()
+
+	reader := dialGRPC(t, listener, key)
+	_, err = reader.ListDevices(ctx, &signinggrpc.ListDevicesRequest{})
+	assert.NoError(t, err)
+	_, err = reader.CreateDevice(ctx, &signinggrpc.CreateDeviceRequest{SignatureAlgorithm: "ED25519"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	stream, err := reader.SignTransactionBatch(ctx, &signinggrpc.SignTransactionBatchRequest{DeviceId: uuid.New().String(), DataToBeSigned: []string{"data"}})
+	assert.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = dialGRPC(t, listener, "").ListDevices(ctx, &signinggrpc.ListDevicesRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = dialGRPC(t, listener, "wrong").ListDevices(ctx, &signinggrpc.ListDevicesRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestGRPCServerSharesHandler(t *testing.T) {
+	store := persistence.NewMemoryStore()
+	webhooks := persistence.NewMemoryWebhookStore()
+	handler := NewDeviceHandlerWithOptions(DeviceHandlerOptions{
+		Store:             store,
+		Journal:           store.Journal(),
+		DeviceFactory:     domain.NewDefaultDeviceFactory(),
+		AggregationWindow: time.Second,
+		Webhooks:          webhooks,
+	})
+
+	assert.Same(t, webhooks, handler.webhooks)
+	assert.Equal(t, time.Second, handler.aggregator.window)
+	assert.NotNil(t, handler.apikeys)
+	// Both servers serve the same jobs, aggregation windows and webhooks.
+	assert.Same(t, handler, NewGRPCServer("", handler).handler)
+	assert.Same(t, handler, NewServer(handler, ServerOptions{}).handler)
+
+	defaults := NewDeviceHandler(store, store.Journal(), domain.NewDefaultDeviceFactory())
+	assert.Equal(t, DEFAULT_AGGREGATION_WINDOW, defaults.aggregator.window)
+}
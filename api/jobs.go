@@ -0,0 +1,269 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/casell/signing-service-challenge/domain"
+	"github.com/casell/signing-service-challenge/persistence"
+	"github.com/google/uuid"
+)
+
+const (
+	JOB_PENDING   = "PENDING"
+	JOB_RUNNING   = "RUNNING"
+	JOB_SUCCEEDED = "SUCCEEDED"
+	JOB_FAILED    = "FAILED"
+
+	// DEFAULT_JOB_RETENTION is how long completed jobs can be polled.
+	DEFAULT_JOB_RETENTION = time.Hour
+	// JOB_PURGE_INTERVAL is how often ExpireJobs looks for expired jobs.
+	JOB_PURGE_INTERVAL = time.Minute
+	// JOB_CALLBACK_TIMEOUT bounds the delivery of a job to its callback URL.
+	JOB_CALLBACK_TIMEOUT = 10 * time.Second
+	// MAX_PENDING_JOBS_PER_DEVICE is how many jobs can wait for a device before Submit refuses more.
+	MAX_PENDING_JOBS_PER_DEVICE = 100
+	// MAX_PENDING_JOBS is how many jobs can wait across all devices before Submit refuses more.
+	MAX_PENDING_JOBS = 10000
+)
+
+// Job is an asynchronous signing request. Record is set once the job succeeded, Err once it failed.
+type Job struct {
+	ID          uuid.UUID
+	DeviceID    uuid.UUID
+	Status      string
+	CreatedAt   time.Time
+	CompletedAt time.Time
+	Record      *domain.SignatureRecord
+	Err         error
+}
+
+// queuedJob is a Job together with what is needed to run it.
+type queuedJob struct {
+	Job
+	dataToBeSigned string
+	idempotencyKey string
+	callbackURL    string
+}
+
+// JobQueue runs signing jobs in the background. Jobs of the same device are run one at a time,
+// in submission order, so that the counter chain follows the order the requests were accepted in.
+// Jobs are kept in memory: pending jobs are lost on restart.
+type JobQueue struct {
+	store  persistence.Storage
+	client *http.Client
+
+	maxPendingPerDevice int
+	maxPending          int
+
+	lock sync.Mutex
+	jobs map[uuid.UUID]*queuedJob
+	// queues holds the jobs waiting for each device, a device is present while its worker runs.
+	queues map[uuid.UUID][]*queuedJob
+	// pending is the number of jobs waiting across queues.
+	pending int
+}
+
+// NewJobQueue returns a JobQueue signing with store and delivering callbacks with client.
+// At most MAX_PENDING_JOBS_PER_DEVICE jobs can wait for a device, and MAX_PENDING_JOBS overall.
+func NewJobQueue(store persistence.Storage, client *http.Client) *JobQueue {
+	return &JobQueue{
+		store:               store,
+		client:              client,
+		maxPendingPerDevice: MAX_PENDING_JOBS_PER_DEVICE,
+		maxPending:          MAX_PENDING_JOBS,
+		jobs:                make(map[uuid.UUID]*queuedJob),
+		queues:              make(map[uuid.UUID][]*queuedJob),
+	}
+}
+
+// Submit queues the signature of dataToBeSigned by device deviceID, see persistence.Storage.SignAndCommit.
+// When callbackURL is not empty the job is POSTed to it once completed.
+// A full queue is reported as errTooManyJobs for the device and errJobQueueFull overall.
+func (q *JobQueue) Submit(deviceID uuid.UUID, dataToBeSigned string, idempotencyKey string, callbackURL string) (Job, error) {
+	job := &queuedJob{
+		Job: Job{
+			ID:        uuid.New(),
+			DeviceID:  deviceID,
+			Status:    JOB_PENDING,
+			CreatedAt: time.Now().UTC(),
+		},
+		dataToBeSigned: dataToBeSigned,
+		idempotencyKey: idempotencyKey,
+		callbackURL:    callbackURL,
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	pending, running := q.queues[deviceID]
+	if len(pending) >= q.maxPendingPerDevice {
+		return Job{}, errTooManyJobs{deviceID: deviceID.String(), limit: q.maxPendingPerDevice}
+	}
+	if q.pending >= q.maxPending {
+		return Job{}, errJobQueueFull{}
+	}
+	q.jobs[job.ID] = job
+	q.queues[deviceID] = append(pending, job)
+	q.pending++
+	if !running {
+		go q.work(deviceID)
+	}
+	return job.Job, nil
+}
+
+// Get returns the job id, false if it is unknown or expired.
+func (q *JobQueue) Get(id uuid.UUID) (Job, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	job, ok := q.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return job.Job, true
+}
+
+// Purge forgets the jobs completed before the given time.
+func (q *JobQueue) Purge(before time.Time) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	for id, job := range q.jobs {
+		if !job.CompletedAt.IsZero() && job.CompletedAt.Before(before) {
+			delete(q.jobs, id)
+		}
+	}
+}
+
+// ExpireJobs purges, every interval, the jobs completed more than retention ago until ctx is done.
+func (q *JobQueue) ExpireJobs(ctx context.Context, retention time.Duration, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			q.Purge(now.Add(-retention))
+		}
+	}
+}
+
+// work runs the jobs of deviceID until its queue is empty.
+// Jobs outlive the request that submitted them, so they are not bound to its context.
+func (q *JobQueue) work(deviceID uuid.UUID) {
+	for {
+		q.lock.Lock()
+		pending := q.queues[deviceID]
+		if len(pending) == 0 {
+			delete(q.queues, deviceID)
+			q.lock.Unlock()
+			return
+		}
+		job := pending[0]
+		q.queues[deviceID] = pending[1:]
+		q.pending--
+		job.Status = JOB_RUNNING
+		q.lock.Unlock()
+
+		record, err := q.store.SignAndCommit(context.Background(), deviceID, job.dataToBeSigned, job.idempotencyKey)
+
+		q.lock.Lock()
+		job.CompletedAt = time.Now().UTC()
+		if err != nil {
+			job.Status = JOB_FAILED
+			job.Err = err
+		} else {
+			job.Status = JOB_SUCCEEDED
+			job.Record = record
+		}
+		completed := job.Job
+		q.lock.Unlock()
+
+		if job.callbackURL != "" {
+			go q.notify(job.callbackURL, completed)
+		}
+	}
+}
+
+// notify POSTs the completed job to callbackURL. Delivery is best effort: failures are only logged.
+func (q *JobQueue) notify(callbackURL string, job Job) {
+	apiJob := convertToApiJob(job)
+	body, err := apiJob.MarshalJSON()
+	if err != nil {
+		log.Printf("jobs: unable to encode job %s: %v", job.ID, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), JOB_CALLBACK_TIMEOUT)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, callbackURL, bytes.NewReader(body))
+	if err != nil {
+		log.Printf("jobs: unable to notify job %s: %v", job.ID, err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := q.client.Do(req)
+	if err != nil {
+		log.Printf("jobs: unable to notify job %s: %v", job.ID, err)
+		return
+	}
+	res.Body.Close()
+	if res.StatusCode >= 300 {
+		log.Printf("jobs: callback of job %s answered %s", job.ID, res.Status)
+	}
+}
+
+// NewCallbackClient returns the client jobs are delivered to their callback URL with, and webhooks with.
+// Callback and webhook URLs are chosen by clients, so the client refuses to connect to any address which is not public,
+// whatever the host name resolves to, also when following redirects. Proxies are not used, as they would hide the address.
+func NewCallbackClient(timeout time.Duration) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = (&net.Dialer{
+		Timeout: timeout,
+		Control: func(network string, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if !isPublicAddr(addrPort.Addr()) {
+				return errInvalidCallbackURL{url: address, reason: "not a public address"}
+			}
+			return nil
+		},
+	}).DialContext
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+// sharedAddressSpace is the carrier-grade NAT range of RFC 6598, not covered by netip.Addr.IsPrivate.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// isPublicAddr tells whether addr can be reached on the internet: loopback, private, link-local
+// (where cloud metadata services live), multicast and unspecified addresses are not.
+func isPublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsValid() && addr.IsGlobalUnicast() && !addr.IsPrivate() && !sharedAddressSpace.Contains(addr)
+}
+
+// checkCallbackHost rejects the callback hosts which are obviously not public: loopback names and
+// non-public IP literals. Names resolving to such addresses are refused when connecting, see NewCallbackClient.
+func checkCallbackHost(host string) string {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return "loopback host"
+	}
+	if addr, err := netip.ParseAddr(host); err == nil && !isPublicAddr(addr) {
+		return "not a public address"
+	}
+	return ""
+}
@@ -0,0 +1,211 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/casell/signing-service-challenge/domain"
+	"github.com/casell/signing-service-challenge/generated/signingapi"
+	mockPersistence "github.com/casell/signing-service-challenge/mocks/github.com/casell/signing-service-challenge/persistence"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// waitJob polls the job until it is completed.
+func waitJob(t *testing.T, q *JobQueue, id uuid.UUID) Job {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		job, ok := q.Get(id)
+		if !ok {
+			t.Fatalf("job %s not found", id)
+		}
+		if job.Status == JOB_SUCCEEDED || job.Status == JOB_FAILED {
+			return job
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("job %s not completed", id)
+	return Job{}
+}
+
+func mustSubmit(t *testing.T, q *JobQueue, deviceID uuid.UUID, data string, idempotencyKey string, callbackURL string) Job {
+	job, err := q.Submit(deviceID, data, idempotencyKey, callbackURL)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	return job
+}
+
+func TestJobQueueOrder(t *testing.T) {
+	id := uuid.New()
+
+	var lock sync.Mutex
+	signed := []string{}
+	mockStorage := mockPersistence.NewMockStorage(t)
+	mockStorage.EXPECT().SignAndCommit(mock.Anything, id, mock.Anything, "").
+		RunAndReturn(func(_ context.Context, _ uuid.UUID, data string, _ string) (*domain.SignatureRecord, error) {
+			lock.Lock()
+			defer lock.Unlock()
+			signed = append(signed, data)
+			return &domain.SignatureRecord{Counter: uint(len(signed) - 1), Data: data}, nil
+		})
+
+	q := NewJobQueue(mockStorage, http.DefaultClient)
+	jobs := make([]Job, 10)
+	for i := range jobs {
+		jobs[i] = mustSubmit(t, q, id, strconv.Itoa(i), "", "")
+		assert.Equal(t, JOB_PENDING, jobs[i].Status)
+	}
+
+	for i, job := range jobs {
+		completed := waitJob(t, q, job.ID)
+		assert.Equal(t, JOB_SUCCEEDED, completed.Status)
+		assert.Equal(t, uint(i), completed.Record.Counter)
+		assert.Equal(t, strconv.Itoa(i), completed.Record.Data)
+		assert.False(t, completed.CompletedAt.IsZero())
+	}
+}
+
+func TestJobQueueFailed(t *testing.T) {
+	id := uuid.New()
+
+	mockStorage := mockPersistence.NewMockStorage(t)
+	signErr := errors.New("Sign error")
+	mockStorage.EXPECT().SignAndCommit(mock.Anything, id, "data", "key").Return(nil, signErr)
+
+	q := NewJobQueue(mockStorage, http.DefaultClient)
+	job := waitJob(t, q, mustSubmit(t, q, id, "data", "key", "").ID)
+
+	assert.Equal(t, JOB_FAILED, job.Status)
+	assert.Equal(t, signErr, job.Err)
+	assert.Nil(t, job.Record)
+}
+
+func TestJobQueueCallback(t *testing.T) {
+	id := uuid.New()
+
+	mockStorage := mockPersistence.NewMockStorage(t)
+	mockStorage.EXPECT().SignAndCommit(mock.Anything, id, "data", "").
+		Return(&domain.SignatureRecord{Counter: 4, Signature: "sig", SignedData: "4_data_last"}, nil)
+
+	delivered := make(chan signingapi.Job, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var job signingapi.Job
+		if err := job.UnmarshalJSON(body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		delivered <- job
+	}))
+	defer server.Close()
+
+	q := NewJobQueue(mockStorage, server.Client())
+	job := mustSubmit(t, q, id, "data", "", server.URL)
+
+	select {
+	case callback := <-delivered:
+		assert.Equal(t, job.ID, callback.ID)
+		assert.Equal(t, signingapi.JobStatusSUCCEEDED, callback.Status)
+		assert.Equal(t, 4, callback.Signature.Value.Counter)
+	case <-time.After(5 * time.Second):
+		t.Fatal("callback not delivered")
+	}
+}
+
+func TestJobQueuePurge(t *testing.T) {
+	id := uuid.New()
+
+	mockStorage := mockPersistence.NewMockStorage(t)
+	mockStorage.EXPECT().SignAndCommit(mock.Anything, id, "data", "").Return(&domain.SignatureRecord{}, nil)
+
+	q := NewJobQueue(mockStorage, http.DefaultClient)
+	job := waitJob(t, q, mustSubmit(t, q, id, "data", "", "").ID)
+
+	q.Purge(job.CompletedAt)
+	_, ok := q.Get(job.ID)
+	assert.True(t, ok)
+
+	q.Purge(job.CompletedAt.Add(time.Nanosecond))
+	_, ok = q.Get(job.ID)
+	assert.False(t, ok)
+}
+
+func TestJobQueueLimits(t *testing.T) {
+	id := uuid.New()
+	other := uuid.New()
+
+	// Signing blocks until released, so that the jobs stay pending.
+	release := make(chan struct{})
+	mockStorage := mockPersistence.NewMockStorage(t)
+	mockStorage.EXPECT().SignAndCommit(mock.Anything, mock.Anything, mock.Anything, "").
+		RunAndReturn(func(context.Context, uuid.UUID, string, string) (*domain.SignatureRecord, error) {
+			<-release
+			return &domain.SignatureRecord{}, nil
+		})
+
+	q := NewJobQueue(mockStorage, http.DefaultClient)
+	q.maxPendingPerDevice = 2
+	q.maxPending = 3
+	jobs := []Job{mustSubmit(t, q, id, "running", "", "")}
+	// Wait for the first job to leave the queue.
+	for {
+		q.lock.Lock()
+		pending := q.pending
+		q.lock.Unlock()
+		if pending == 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	jobs = append(jobs, mustSubmit(t, q, id, "1", "", ""), mustSubmit(t, q, id, "2", "", ""))
+	_, err := q.Submit(id, "3", "", "")
+	assert.IsType(t, errTooManyJobs{}, err)
+
+	jobs = append(jobs, mustSubmit(t, q, other, "1", "", ""))
+	_, err = q.Submit(uuid.New(), "1", "", "")
+	assert.IsType(t, errJobQueueFull{}, err)
+
+	close(release)
+	for _, job := range jobs {
+		waitJob(t, q, job.ID)
+	}
+}
+
+func TestCallbackClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	_, err := NewCallbackClient(time.Second).Post(server.URL, "application/json", nil)
+	assert.ErrorAs(t, err, &errInvalidCallbackURL{})
+}
+
+func TestCheckCallbackHost(t *testing.T) {
+	for host, public := range map[string]bool{
+		"example.com":      true,
+		"93.184.215.14":    true,
+		"2606:4700::1111":  true,
+		"localhost":        false,
+		"api.localhost.":   false,
+		"127.0.0.1":        false,
+		"::1":              false,
+		"10.1.2.3":         false,
+		"192.168.0.1":      false,
+		"100.64.0.1":       false,
+		"169.254.169.254":  false,
+		"fe80::1":          false,
+		"fd00:ec2::254":    false,
+		"::ffff:127.0.0.1": false,
+		"0.0.0.0":          false,
+	} {
+		assert.Equal(t, public, checkCallbackHost(host) == "", host)
+	}
+}
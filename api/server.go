@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"io/fs"
 	"log"
@@ -10,7 +11,6 @@ import (
 
 	"github.com/casell/signing-service-challenge/domain"
 	"github.com/casell/signing-service-challenge/generated/signingapi"
-	"github.com/casell/signing-service-challenge/persistence"
 )
 
 // Response is the generic API response container.
@@ -28,24 +28,41 @@ type Server struct {
 	listenAddress string
 	spec          fs.FS
 	cors          bool
-	store         persistence.Storage
-	deviceFactory domain.SigningDeviceFactory
+	handler       *DeviceHandler
+	events        *domain.EventBus
 }
 
-// NewServer is a factory to instantiate a new Server.
-func NewServer(listenAddress string, spec fs.FS, cors bool) *Server {
+// ServerOptions configure a Server.
+type ServerOptions struct {
+	ListenAddress string
+	// Spec holds the openapi.yaml served alongside the API.
+	Spec fs.FS
+	CORS bool
+	// Events is the bus the devices publish on: it is streamed and delivered to the webhooks of the handler.
+	Events *domain.EventBus
+}
+
+// NewServer is a factory to instantiate a new Server serving handler, which can be shared with a GRPCServer.
+// Requests are authenticated with the API keys of handler.
+func NewServer(handler *DeviceHandler, opts ServerOptions) *Server {
 	return &Server{
-		listenAddress: listenAddress,
-		spec:          spec,
-		cors:          cors,
-		store:         persistence.NewMemoryStore(),
-		deviceFactory: domain.NewDefaultDeviceFactory(),
+		listenAddress: opts.ListenAddress,
+		spec:          opts.Spec,
+		cors:          opts.CORS,
+		handler:       handler,
+		events:        opts.Events,
 	}
 }
 
-// Run registers all HandlerFuncs for the existing HTTP routes and starts the Server.
+// Run registers all HandlerFuncs for the existing HTTP routes and starts the Server,
+// along with the expiry of the jobs and the delivery of the webhooks of its handler.
 func (s *Server) Run() error {
-	srv, err := signingapi.NewServer(NewDeviceHandler(s.store, s.deviceFactory))
+	handler := s.handler
+	go handler.jobs.ExpireJobs(context.Background(), DEFAULT_JOB_RETENTION, JOB_PURGE_INTERVAL)
+	go NewWebhookDispatcher(handler.webhooks, NewCallbackClient(WEBHOOK_DELIVERY_TIMEOUT)).Run(context.Background(), handler.store, s.events)
+
+	auth := NewAuthenticator(handler.apikeys)
+	srv, err := signingapi.NewServer(handler, auth)
 	if err != nil {
 		return err
 	}
@@ -55,6 +72,11 @@ func (s *Server) Run() error {
 
 	mux.Handle("/api/v1/", http.StripPrefix("/api/v1", srv))
 
+	// Event streams are served outside of the generated server, which cannot stream responses.
+	events := NewEventHandler(handler.store, handler.journal, s.events)
+	mux.HandleFunc("GET /api/v1/events", auth.RequireScope(SCOPE_READ, events.Events))
+	mux.HandleFunc("GET /api/v1/device/{deviceid}/events", auth.RequireScope(SCOPE_READ, events.DeviceEvents))
+
 	mux.Handle("/api/v1/openapi.yaml", http.StripPrefix("/api/v1", http.FileServer(http.FS(s.spec))))
 
 	var h http.Handler
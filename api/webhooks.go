@@ -0,0 +1,348 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/casell/signing-service-challenge/domain"
+	"github.com/casell/signing-service-challenge/persistence"
+	"github.com/google/uuid"
+)
+
+const (
+	WEBHOOK_DEVICE_CREATED    = "device.created"
+	WEBHOOK_SIGNATURE_CREATED = "signature.created"
+	WEBHOOK_DEVICE_DISABLED   = "device.disabled"
+
+	// WEBHOOK_MAX_ATTEMPTS is how many times a delivery is attempted before being given up on.
+	WEBHOOK_MAX_ATTEMPTS = 10
+	// WEBHOOK_RETRY_BASE is the delay before the first retry, doubled on each following one.
+	WEBHOOK_RETRY_BASE = time.Second
+	// WEBHOOK_RETRY_MAX bounds the delay between two attempts.
+	WEBHOOK_RETRY_MAX = time.Hour
+	// WEBHOOK_DELIVERY_TIMEOUT bounds a single delivery attempt.
+	WEBHOOK_DELIVERY_TIMEOUT = 10 * time.Second
+	// WEBHOOK_POLL_INTERVAL is how often the outbox is checked for deliveries due for a retry.
+	WEBHOOK_POLL_INTERVAL = time.Second
+
+	webhookDeliveryPage = 100
+	webhookEventPage    = 100
+)
+
+// WebhookDispatcher takes the device events over from the outbox of a Storage and turns them into deliveries
+// to the subscribed webhooks. An event leaves the device outbox only once its deliveries are in the outbox
+// of the WebhookStorage, so pending events and deliveries survive a crash, and are delivered at least once:
+// a receiver can tell a repeated delivery by its X-Webhook-Delivery header.
+type WebhookDispatcher struct {
+	store  persistence.WebhookStorage
+	client *http.Client
+	// wake is signalled when deliveries are enqueued.
+	wake chan struct{}
+}
+
+// NewWebhookDispatcher returns a WebhookDispatcher keeping deliveries in store and POSTing them with client.
+func NewWebhookDispatcher(store persistence.WebhookStorage, client *http.Client) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		store:  store,
+		client: client,
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+// webhookEventType returns the webhook event type event is delivered as, false if it is not delivered.
+func webhookEventType(event domain.Event) (string, bool) {
+	switch {
+	case event.Type == domain.EVENT_DEVICE_CREATED:
+		return WEBHOOK_DEVICE_CREATED, true
+	case event.Type == domain.EVENT_SIGNATURE_CREATED:
+		return WEBHOOK_SIGNATURE_CREATED, true
+	case event.Type == domain.EVENT_STATUS_CHANGED && event.Status == domain.STATUS_DISABLED:
+		return WEBHOOK_DEVICE_DISABLED, true
+	default:
+		return "", false
+	}
+}
+
+// Run takes the events of outbox over and delivers them until ctx is done. The outbox is checked every
+// WEBHOOK_POLL_INTERVAL and whenever bus, if any, publishes an event: the bus only wakes the dispatcher up,
+// an event it drops is still taken from the outbox.
+func (d *WebhookDispatcher) Run(ctx context.Context, outbox persistence.EventOutbox, bus *domain.EventBus) {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		d.deliver(ctx)
+	}()
+	defer wg.Wait()
+
+	ticker := time.NewTicker(WEBHOOK_POLL_INTERVAL)
+	defer ticker.Stop()
+	var published *domain.Subscription
+	defer func() {
+		if published != nil {
+			published.Close()
+		}
+	}()
+	for {
+		if bus != nil && published == nil {
+			published, _ = bus.Subscribe(math.MaxUint64)
+		}
+		d.takeOver(ctx, outbox)
+
+		var wakeup <-chan domain.Event
+		if published != nil {
+			wakeup = published.C
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case _, ok := <-wakeup:
+			if !ok {
+				published = nil
+				continue
+			}
+			// The next take over catches up with every event published meanwhile.
+			for len(wakeup) > 0 {
+				<-wakeup
+			}
+		}
+	}
+}
+
+// takeOver enqueues the pending events of outbox and removes them from it, page by page until none is left.
+// The IDs of the deliveries are derived from the event, so enqueueing again an event whose removal failed
+// does not duplicate its deliveries.
+func (d *WebhookDispatcher) takeOver(ctx context.Context, outbox persistence.EventOutbox) {
+	for ctx.Err() == nil {
+		events, err := outbox.PendingEvents(ctx, webhookEventPage)
+		if err != nil {
+			log.Printf("webhooks: unable to read the event outbox: %v", err)
+			return
+		}
+		if len(events) == 0 {
+			return
+		}
+		if err := d.Enqueue(ctx, events...); err != nil {
+			log.Printf("webhooks: unable to enqueue events: %v", err)
+			return
+		}
+		ids := make([]uuid.UUID, len(events))
+		for i, event := range events {
+			ids[i] = event.ID
+		}
+		if err := outbox.DeleteEvents(ctx, ids); err != nil {
+			log.Printf("webhooks: unable to remove events from the outbox: %v", err)
+			return
+		}
+		if len(events) < webhookEventPage {
+			return
+		}
+	}
+}
+
+// webhookDeliveryID returns the ID of the delivery of event to webhook webhookID.
+func webhookDeliveryID(event persistence.OutboxEvent, webhookID uuid.UUID) uuid.UUID {
+	return uuid.NewSHA1(event.ID, webhookID[:])
+}
+
+// Enqueue adds, all at once, a delivery of each event for each webhook subscribed to it.
+func (d *WebhookDispatcher) Enqueue(ctx context.Context, events ...persistence.OutboxEvent) error {
+	webhooks, err := d.store.ListWebhooks(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	deliveries := []persistence.WebhookDelivery{}
+	for _, event := range events {
+		eventType, ok := webhookEventType(event.Event)
+		if !ok {
+			continue
+		}
+		payload, err := json.Marshal(newEventPayload(eventType, event.Event))
+		if err != nil {
+			return fmt.Errorf("unable to encode event %s: %w", event.ID, err)
+		}
+		for _, webhook := range webhooks {
+			if !slices.Contains(webhook.EventTypes, eventType) {
+				continue
+			}
+			deliveries = append(deliveries, persistence.WebhookDelivery{
+				ID:            webhookDeliveryID(event, webhook.ID),
+				WebhookID:     webhook.ID,
+				EventType:     eventType,
+				Payload:       payload,
+				NextAttemptAt: now,
+				CreatedAt:     now,
+			})
+		}
+	}
+	if len(deliveries) == 0 {
+		return nil
+	}
+	if err := d.store.EnqueueDeliveries(ctx, deliveries); err != nil {
+		return err
+	}
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// deliver attempts the due deliveries whenever some are enqueued, and every WEBHOOK_POLL_INTERVAL
+// for the retries, until ctx is done.
+func (d *WebhookDispatcher) deliver(ctx context.Context) {
+	ticker := time.NewTicker(WEBHOOK_POLL_INTERVAL)
+	defer ticker.Stop()
+	for {
+		d.deliverDue(ctx, time.Now().UTC())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+// deliverDue attempts the deliveries due at now. The deliveries of a webhook are attempted in order,
+// different webhooks in parallel so that a slow receiver does not hold back the others.
+func (d *WebhookDispatcher) deliverDue(ctx context.Context, now time.Time) {
+	for {
+		due, err := d.store.DueDeliveries(ctx, now, webhookDeliveryPage)
+		if err != nil {
+			log.Printf("webhooks: unable to read the outbox: %v", err)
+			return
+		}
+		if len(due) == 0 {
+			return
+		}
+
+		byWebhook := make(map[uuid.UUID][]persistence.WebhookDelivery)
+		for _, delivery := range due {
+			byWebhook[delivery.WebhookID] = append(byWebhook[delivery.WebhookID], delivery)
+		}
+		var wg sync.WaitGroup
+		var settled atomic.Int64
+		for webhookID, deliveries := range byWebhook {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				settled.Add(int64(d.deliverWebhook(ctx, now, webhookID, deliveries)))
+			}()
+		}
+		wg.Wait()
+
+		// Once every due delivery is removed or rescheduled after now, a short page means none is left.
+		// A delivery which could be neither would come back in the next page: it is left to the next poll.
+		if settled.Load() < int64(len(due)) || len(due) < webhookDeliveryPage || ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// deliverWebhook attempts deliveries to webhook webhookID, rescheduling the failed ones.
+// It returns how many deliveries were removed from the outbox or rescheduled.
+func (d *WebhookDispatcher) deliverWebhook(ctx context.Context, now time.Time, webhookID uuid.UUID, deliveries []persistence.WebhookDelivery) int {
+	webhook, err := d.store.GetWebhook(ctx, webhookID)
+	if err != nil {
+		log.Printf("webhooks: unable to read webhook %s: %v", webhookID, err)
+		return 0
+	}
+	settled := 0
+	for _, delivery := range deliveries {
+		err := d.post(ctx, webhook, delivery)
+		if err == nil {
+			if d.remove(ctx, delivery) {
+				settled++
+			}
+			continue
+		}
+		delivery.Attempts++
+		delivery.LastError = err.Error()
+		if delivery.Attempts >= WEBHOOK_MAX_ATTEMPTS {
+			log.Printf("webhooks: giving up delivery %s to webhook %s after %d attempts: %v", delivery.ID, webhookID, delivery.Attempts, err)
+			if d.remove(ctx, delivery) {
+				settled++
+			}
+			continue
+		}
+		delivery.NextAttemptAt = now.Add(webhookRetryDelay(delivery.Attempts))
+		if err := d.store.PutDelivery(ctx, delivery); err != nil {
+			log.Printf("webhooks: unable to reschedule delivery %s: %v", delivery.ID, err)
+			continue
+		}
+		settled++
+	}
+	return settled
+}
+
+// remove deletes delivery from the outbox, it returns false if it could not.
+func (d *WebhookDispatcher) remove(ctx context.Context, delivery persistence.WebhookDelivery) bool {
+	if err := d.store.DeleteDelivery(ctx, delivery.ID); err != nil {
+		log.Printf("webhooks: unable to remove delivery %s: %v", delivery.ID, err)
+		return false
+	}
+	return true
+}
+
+// webhookRetryDelay returns the delay before the next attempt of a delivery which failed attempts times.
+func webhookRetryDelay(attempts int) time.Duration {
+	delay := WEBHOOK_RETRY_BASE
+	for i := 1; i < attempts && delay < WEBHOOK_RETRY_MAX; i++ {
+		delay *= 2
+	}
+	return min(delay, WEBHOOK_RETRY_MAX)
+}
+
+// post delivers delivery to webhook, any answer but a 2xx is a failure.
+func (d *WebhookDispatcher) post(ctx context.Context, webhook *persistence.Webhook, delivery persistence.WebhookDelivery) error {
+	ctx, cancel := context.WithTimeout(ctx, WEBHOOK_DELIVERY_TIMEOUT)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Id", webhook.ID.String())
+	req.Header.Set("X-Webhook-Delivery", delivery.ID.String())
+	req.Header.Set("X-Webhook-Event", delivery.EventType)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", SignWebhookPayload(webhook.Secret, timestamp, delivery.Payload))
+
+	res, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered %s", res.Status)
+	}
+	return nil
+}
+
+// SignWebhookPayload returns the X-Webhook-Signature of a delivery of payload at timestamp:
+// the hex HMAC-SHA256, keyed with the webhook secret, of the timestamp, a dot and the payload.
+func SignWebhookPayload(secret string, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,283 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	mycrypto "github.com/casell/signing-service-challenge/crypto"
+	"github.com/casell/signing-service-challenge/domain"
+	"github.com/casell/signing-service-challenge/persistence"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+type receivedWebhook struct {
+	header http.Header
+	body   []byte
+}
+
+// webhookReceiver records the deliveries it gets, answering them with the statuses in order and 204 afterwards.
+type webhookReceiver struct {
+	*httptest.Server
+
+	lock     sync.Mutex
+	statuses []int
+	received []receivedWebhook
+}
+
+func newWebhookReceiver(t *testing.T, statuses ...int) *webhookReceiver {
+	r := &webhookReceiver{statuses: statuses}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		r.lock.Lock()
+		defer r.lock.Unlock()
+		r.received = append(r.received, receivedWebhook{header: req.Header.Clone(), body: body})
+		status := http.StatusNoContent
+		if len(r.statuses) > 0 {
+			status, r.statuses = r.statuses[0], r.statuses[1:]
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *webhookReceiver) deliveries() []receivedWebhook {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]receivedWebhook{}, r.received...)
+}
+
+func addTestWebhook(t *testing.T, store persistence.WebhookStorage, url string, eventTypes ...string) persistence.Webhook {
+	webhook := persistence.Webhook{ID: uuid.New(), URL: url, EventTypes: eventTypes, Secret: "secret", CreatedAt: time.Now().UTC()}
+	if err := store.AddWebhook(context.Background(), webhook); err != nil {
+		t.Fatal(err)
+	}
+	return webhook
+}
+
+func pendingDeliveries(t *testing.T, store persistence.WebhookStorage) []persistence.WebhookDelivery {
+	due, err := store.DueDeliveries(context.Background(), time.Now().Add(365*24*time.Hour), 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return due
+}
+
+func outboxEvent(event domain.Event) persistence.OutboxEvent {
+	return persistence.OutboxEvent{ID: uuid.New(), Event: event}
+}
+
+func TestWebhookDispatcherRun(t *testing.T) {
+	receiver := newWebhookReceiver(t)
+	store := persistence.NewMemoryWebhookStore()
+	webhook := addTestWebhook(t, store, receiver.URL, WEBHOOK_SIGNATURE_CREATED, WEBHOOK_DEVICE_DISABLED)
+	addTestWebhook(t, store, receiver.URL+"/created", WEBHOOK_DEVICE_CREATED)
+
+	bus := domain.NewEventBus(domain.DEFAULT_EVENT_HISTORY)
+	devices := persistence.NewMemoryStore()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		NewWebhookDispatcher(store, http.DefaultClient).Run(ctx, devices, bus)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	d, err := domain.NewEventDeviceFactory(bus).New(uuid.Nil, domain.STATUS_ACTIVE, "ED25519", nil, mycrypto.Parameters{})
+	assert.NoError(t, err)
+	assert.NoError(t, devices.Add(context.Background(), d))
+	_, err = devices.SignAndCommit(context.Background(), d.ID(), "data", "")
+	assert.NoError(t, err)
+	_, err = devices.SetStatus(context.Background(), d.ID(), domain.STATUS_DISABLED)
+	assert.NoError(t, err)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(receiver.deliveries()) < 3 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	deliveries := receiver.deliveries()
+	if !assert.Len(t, deliveries, 3) {
+		return
+	}
+
+	byEvent := make(map[string]receivedWebhook)
+	for _, delivery := range deliveries {
+		byEvent[delivery.header.Get("X-Webhook-Event")] = delivery
+	}
+	signed := byEvent[WEBHOOK_SIGNATURE_CREATED]
+	assert.Equal(t, webhook.ID.String(), signed.header.Get("X-Webhook-Id"))
+	assert.NotEmpty(t, signed.header.Get("X-Webhook-Delivery"))
+	assert.Equal(t, SignWebhookPayload("secret", signed.header.Get("X-Webhook-Timestamp"), signed.body), signed.header.Get("X-Webhook-Signature"))
+	var payload eventPayload
+	assert.NoError(t, json.Unmarshal(signed.body, &payload))
+	assert.Equal(t, WEBHOOK_SIGNATURE_CREATED, payload.Type)
+	assert.Equal(t, d.ID(), payload.DeviceID)
+	if assert.NotNil(t, payload.Signature) {
+		assert.Equal(t, "data", payload.Signature.Data)
+	}
+
+	disabled := byEvent[WEBHOOK_DEVICE_DISABLED]
+	assert.NoError(t, json.Unmarshal(disabled.body, &payload))
+	assert.Equal(t, WEBHOOK_DEVICE_DISABLED, payload.Type)
+	assert.Equal(t, domain.STATUS_DISABLED, payload.Status)
+
+	assert.Contains(t, byEvent, WEBHOOK_DEVICE_CREATED)
+
+	// Delivered events leave the outbox.
+	for len(pendingDeliveries(t, store)) > 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Empty(t, pendingDeliveries(t, store))
+	events, err := devices.PendingEvents(context.Background(), 10)
+	assert.NoError(t, err)
+	assert.Empty(t, events)
+}
+
+func TestWebhookDispatcherTakeOver(t *testing.T) {
+	store := persistence.NewMemoryWebhookStore()
+	webhook := addTestWebhook(t, store, "http://example.com/hook", WEBHOOK_SIGNATURE_CREATED)
+	devices := persistence.NewMemoryStore()
+	ctx := context.Background()
+
+	// Events committed while no dispatcher runs, or never published, are taken from the outbox.
+	d, err := domain.NewDefaultDeviceFactory().New(uuid.Nil, domain.STATUS_ACTIVE, "ED25519", nil, mycrypto.Parameters{})
+	assert.NoError(t, err)
+	assert.NoError(t, devices.Add(ctx, d))
+	for i := 0; i < webhookEventPage+1; i++ {
+		_, err := devices.SignAndCommit(ctx, d.ID(), "data", "")
+		assert.NoError(t, err)
+	}
+	// The first event is device.created, which the webhook is not subscribed to.
+	events, err := devices.PendingEvents(ctx, 2)
+	assert.NoError(t, err)
+
+	dispatcher := NewWebhookDispatcher(store, http.DefaultClient)
+	dispatcher.takeOver(ctx, devices)
+	assert.Len(t, pendingDeliveries(t, store), webhookEventPage+1)
+	left, err := devices.PendingEvents(ctx, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, left)
+
+	// An event enqueued again, as when its removal from the outbox failed, is not delivered twice.
+	assert.NoError(t, dispatcher.Enqueue(ctx, events...))
+	assert.Len(t, pendingDeliveries(t, store), webhookEventPage+1)
+	ids := []uuid.UUID{}
+	for _, delivery := range pendingDeliveries(t, store) {
+		ids = append(ids, delivery.ID)
+	}
+	assert.Contains(t, ids, webhookDeliveryID(events[1], webhook.ID))
+}
+
+func TestWebhookDispatcherRetry(t *testing.T) {
+	receiver := newWebhookReceiver(t, http.StatusInternalServerError)
+	store := persistence.NewMemoryWebhookStore()
+	addTestWebhook(t, store, receiver.URL, WEBHOOK_DEVICE_CREATED)
+	d := NewWebhookDispatcher(store, http.DefaultClient)
+	ctx := context.Background()
+
+	assert.NoError(t, d.Enqueue(ctx, outboxEvent(domain.Event{Type: domain.EVENT_DEVICE_CREATED, DeviceID: uuid.New()})))
+	now := time.Now().UTC()
+	d.deliverDue(ctx, now)
+
+	pending := pendingDeliveries(t, store)
+	if assert.Len(t, pending, 1) {
+		assert.Equal(t, 1, pending[0].Attempts)
+		assert.Equal(t, now.Add(WEBHOOK_RETRY_BASE), pending[0].NextAttemptAt)
+		assert.Equal(t, "webhook answered 500 Internal Server Error", pending[0].LastError)
+	}
+
+	// Not retried before its time.
+	d.deliverDue(ctx, now)
+	assert.Len(t, receiver.deliveries(), 1)
+
+	d.deliverDue(ctx, now.Add(WEBHOOK_RETRY_BASE))
+	deliveries := receiver.deliveries()
+	if assert.Len(t, deliveries, 2) {
+		// A retry is the same delivery.
+		assert.Equal(t, deliveries[0].header.Get("X-Webhook-Delivery"), deliveries[1].header.Get("X-Webhook-Delivery"))
+		assert.Equal(t, deliveries[0].body, deliveries[1].body)
+	}
+	assert.Empty(t, pendingDeliveries(t, store))
+}
+
+func TestWebhookDispatcherGiveUp(t *testing.T) {
+	statuses := make([]int, WEBHOOK_MAX_ATTEMPTS+1)
+	for i := range statuses {
+		statuses[i] = http.StatusBadGateway
+	}
+	receiver := newWebhookReceiver(t, statuses...)
+	store := persistence.NewMemoryWebhookStore()
+	addTestWebhook(t, store, receiver.URL, WEBHOOK_DEVICE_CREATED)
+	d := NewWebhookDispatcher(store, http.DefaultClient)
+	ctx := context.Background()
+
+	assert.NoError(t, d.Enqueue(ctx, outboxEvent(domain.Event{Type: domain.EVENT_DEVICE_CREATED, DeviceID: uuid.New()})))
+	now := time.Now().UTC()
+	for i := 0; i <= WEBHOOK_MAX_ATTEMPTS; i++ {
+		d.deliverDue(ctx, now)
+		now = now.Add(WEBHOOK_RETRY_MAX)
+	}
+
+	assert.Len(t, receiver.deliveries(), WEBHOOK_MAX_ATTEMPTS)
+	assert.Empty(t, pendingDeliveries(t, store))
+}
+
+func TestWebhookDispatcherFilters(t *testing.T) {
+	store := persistence.NewMemoryWebhookStore()
+	addTestWebhook(t, store, "http://example.com/hook", WEBHOOK_DEVICE_DISABLED)
+	d := NewWebhookDispatcher(store, http.DefaultClient)
+	ctx := context.Background()
+
+	assert.NoError(t, d.Enqueue(ctx, outboxEvent(domain.Event{Type: domain.EVENT_DEVICE_CREATED}), outboxEvent(domain.Event{Type: domain.EVENT_STATUS_CHANGED, Status: domain.STATUS_ACTIVE})))
+	assert.Empty(t, pendingDeliveries(t, store))
+
+	assert.NoError(t, d.Enqueue(ctx, outboxEvent(domain.Event{Type: domain.EVENT_STATUS_CHANGED, Status: domain.STATUS_DISABLED})))
+	pending := pendingDeliveries(t, store)
+	if assert.Len(t, pending, 1) {
+		assert.Equal(t, WEBHOOK_DEVICE_DISABLED, pending[0].EventType)
+	}
+}
+
+func TestWebhookRetryDelay(t *testing.T) {
+	assert.Equal(t, WEBHOOK_RETRY_BASE, webhookRetryDelay(1))
+	assert.Equal(t, 4*WEBHOOK_RETRY_BASE, webhookRetryDelay(3))
+	assert.Equal(t, WEBHOOK_RETRY_MAX, webhookRetryDelay(100))
+}
+
+// unreadableWebhookStore fails to read the webhooks, so that no delivery can be attempted.
+type unreadableWebhookStore struct {
+	*persistence.MemoryWebhookStore
+	reads atomic.Int64
+}
+
+func (s *unreadableWebhookStore) GetWebhook(ctx context.Context, id uuid.UUID) (*persistence.Webhook, error) {
+	s.reads.Add(1)
+	return nil, errors.New("unreadable")
+}
+
+func TestWebhookDispatcherStuckPage(t *testing.T) {
+	store := &unreadableWebhookStore{MemoryWebhookStore: persistence.NewMemoryWebhookStore()}
+	addTestWebhook(t, store, "http://example.com/hook", WEBHOOK_DEVICE_CREATED)
+	d := NewWebhookDispatcher(store, http.DefaultClient)
+	ctx := context.Background()
+	for i := 0; i < webhookDeliveryPage; i++ {
+		assert.NoError(t, d.Enqueue(ctx, outboxEvent(domain.Event{Type: domain.EVENT_DEVICE_CREATED, DeviceID: uuid.New()})))
+	}
+
+	// A full page which could be neither delivered nor rescheduled is left to the next poll.
+	d.deliverDue(ctx, time.Now().UTC())
+	assert.Equal(t, int64(1), store.reads.Load())
+	assert.Len(t, pendingDeliveries(t, store), webhookDeliveryPage)
+}
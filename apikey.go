@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/casell/signing-service-challenge/api"
+	"github.com/google/uuid"
+)
+
+// APIKeyCommand is the command managing the API keys saved in STORAGE_DIR or SQL_DSN. It works on the storage
+// of a stopped service: a running one issues and revokes keys through its /api-keys endpoints.
+const APIKeyCommand = "apikey"
+
+const apiKeyUsage = `usage:
+  %[1]s apikey issue -name NAME -scopes SCOPE[,SCOPE...]
+  %[1]s apikey list
+  %[1]s apikey revoke ID
+`
+
+// runAPIKeyCommand runs the apikey command with args and returns its exit code.
+func runAPIKeyCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, apiKeyUsage, os.Args[0])
+		return 2
+	}
+	keys, closeKeys, err := openAPIKeyStorage()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to open API key storage: %v\n", err)
+		return 1
+	}
+	defer closeKeys()
+
+	ctx := context.Background()
+	switch args[0] {
+	case "issue":
+		flags := flag.NewFlagSet("apikey issue", flag.ContinueOnError)
+		name := flags.String("name", "", "name of the key")
+		scopes := flags.String("scopes", "", "comma separated scopes of the key, among "+strings.Join(api.API_KEY_SCOPES, ", "))
+		if err := flags.Parse(args[1:]); err != nil {
+			return 2
+		}
+		if *name == "" {
+			fmt.Fprintln(os.Stderr, "-name is required")
+			return 2
+		}
+		key, stored, err := api.IssueAPIKey(ctx, keys, *name, strings.Split(*scopes, ","))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to issue API key: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Issued API key %s with scopes %s, it is only shown once:\n%s\n", stored.ID, strings.Join(stored.Scopes, ","), key)
+	case "list":
+		list, err := keys.ListAPIKeys(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to list API keys: %v\n", err)
+			return 1
+		}
+		for _, key := range list {
+			fmt.Printf("%s\t%s\t%s\t%s\n", key.ID, key.Name, strings.Join(key.Scopes, ","), key.CreatedAt.Format(time.RFC3339))
+		}
+	case "revoke":
+		if len(args) != 2 {
+			fmt.Fprintf(os.Stderr, apiKeyUsage, os.Args[0])
+			return 2
+		}
+		id, err := uuid.Parse(args[1])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid API key ID %q: %v\n", args[1], err)
+			return 2
+		}
+		if err := keys.DeleteAPIKey(ctx, id); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to revoke API key: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Revoked API key %s\n", id)
+	default:
+		fmt.Fprintf(os.Stderr, apiKeyUsage, os.Args[0])
+		return 2
+	}
+	return 0
+}
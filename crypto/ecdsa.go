@@ -12,8 +12,17 @@ import (
 
 const (
 	ECC_ALGORITHM_NAME = "ECC"
+
+	ECC_DEFAULT_CURVE = "P-384"
 )
 
+// validCurves maps the accepted curve names to the related elliptic.Curve.
+var validCurves = map[string]elliptic.Curve{
+	elliptic.P256().Params().Name: elliptic.P256(),
+	elliptic.P384().Params().Name: elliptic.P384(),
+	elliptic.P521().Params().Name: elliptic.P521(),
+}
+
 func init() {
 	registerGenerator(&ECCGenerator{})
 }
@@ -26,15 +35,49 @@ func (g *ECCGenerator) Unmarshal(priv []byte) (KeyPair, error) {
 	return NewECCMarshaler().Decode(priv)
 }
 
+// UnmarshalPublic loads a public only ECCKeyPair from bytes.
+func (g *ECCGenerator) UnmarshalPublic(pub []byte) (KeyPair, error) {
+	return NewECCMarshaler().DecodePublic(pub)
+}
+
 // Algorithm returns the algorithm name as a string.
 func (g *ECCGenerator) Algorithm() string {
 	return ECC_ALGORITHM_NAME
 }
 
+// ResolveParameters validates curve and hash, applying defaults.
+func (g *ECCGenerator) ResolveParameters(params Parameters) (Parameters, error) {
+	if params.KeySize != 0 {
+		return Parameters{}, ErrInvalidParameters{ECC_ALGORITHM_NAME, "key size is not supported, use curve"}
+	}
+	if params.Curve == "" {
+		params.Curve = ECC_DEFAULT_CURVE
+	}
+	if _, ok := validCurves[params.Curve]; !ok {
+		return Parameters{}, ErrInvalidParameters{ECC_ALGORITHM_NAME, "unsupported curve " + params.Curve}
+	}
+	if params.Hash == "" {
+		params.Hash = DEFAULT_HASH_ALGORITHM
+	}
+	if _, err := hashFromString(ECC_ALGORITHM_NAME, params.Hash); err != nil {
+		return Parameters{}, err
+	}
+	return params, nil
+}
+
+// SignerOpts returns the options to be used when signing.
+func (g *ECCGenerator) SignerOpts(params Parameters) (crypto.SignerOpts, error) {
+	return hashFromString(ECC_ALGORITHM_NAME, params.Hash)
+}
+
 // Generate generates a new ECCKeyPair.
-func (g *ECCGenerator) Generate() (KeyPair, error) {
-	// Security has been ignored for the sake of simplicity.
-	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+func (g *ECCGenerator) Generate(params Parameters) (KeyPair, error) {
+	params, err := g.ResolveParameters(params)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(validCurves[params.Curve], rand.Reader)
 	if err != nil {
 		return nil, err
 	}
@@ -53,6 +96,9 @@ type ECCKeyPair struct {
 
 // PrivateKey returns the keypair's private key as `crytpo.Signer`.
 func (k *ECCKeyPair) PrivateKey() crypto.Signer {
+	if k.Private == nil {
+		return nil
+	}
 	return k.Private
 }
 
@@ -63,7 +109,20 @@ func (k *ECCKeyPair) PublicKey() crypto.PublicKey {
 
 // Equal verifies keypairs to be equals.
 func (k *ECCKeyPair) Equal(x KeyPair) bool {
-	return k.Private.Equal(x.PrivateKey())
+	return k.Public.Equal(x.PublicKey())
+}
+
+// PublicOnly returns a copy of the keypair holding just the public key.
+func (k *ECCKeyPair) PublicOnly() KeyPair {
+	return &ECCKeyPair{Public: k.Public}
+}
+
+// Discard overwrites the private scalar and drops it.
+func (k *ECCKeyPair) Discard() {
+	if k.Private != nil {
+		wipeInt(k.Private.D)
+	}
+	k.Private = nil
 }
 
 // Marshal encodes the keypair to be written on disk.
@@ -83,12 +142,21 @@ func NewECCMarshaler() ECCMarshaler {
 // Encode takes an ECCKeyPair and encodes it to be written on disk.
 // It returns the public and the private key as a byte slice.
 func (m ECCMarshaler) Encode(keyPair ECCKeyPair) ([]byte, []byte, error) {
-	privateKeyBytes, err := x509.MarshalECPrivateKey(keyPair.Private)
+	publicKeyBytes, err := x509.MarshalPKIXPublicKey(keyPair.Public)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	publicKeyBytes, err := x509.MarshalPKIXPublicKey(keyPair.Public)
+	encodedPublic := pem.EncodeToMemory(&pem.Block{
+		Type:  "PUBLIC_KEY",
+		Bytes: publicKeyBytes,
+	})
+
+	if keyPair.Private == nil {
+		return encodedPublic, nil, nil
+	}
+
+	privateKeyBytes, err := x509.MarshalECPrivateKey(keyPair.Private)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -98,11 +166,6 @@ func (m ECCMarshaler) Encode(keyPair ECCKeyPair) ([]byte, []byte, error) {
 		Bytes: privateKeyBytes,
 	})
 
-	encodedPublic := pem.EncodeToMemory(&pem.Block{
-		Type:  "PUBLIC_KEY",
-		Bytes: publicKeyBytes,
-	})
-
 	return encodedPublic, encodedPrivate, nil
 }
 
@@ -123,3 +186,24 @@ func (m ECCMarshaler) Decode(privateKeyBytes []byte) (*ECCKeyPair, error) {
 		Public:  &privateKey.PublicKey,
 	}, nil
 }
+
+// DecodePublic assembles a public only ECCKeyPair from an encoded public key.
+func (m ECCMarshaler) DecodePublic(publicKeyBytes []byte) (*ECCKeyPair, error) {
+	block, _ := pem.Decode(publicKeyBytes)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey, ok := key.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, errors.New("not an ECC public key")
+	}
+
+	return &ECCKeyPair{
+		Public: publicKey,
+	}, nil
+}
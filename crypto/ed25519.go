@@ -0,0 +1,191 @@
+package crypto
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+)
+
+const (
+	ED25519_ALGORITHM_NAME = "ED25519"
+)
+
+func init() {
+	registerGenerator(&ED25519Generator{})
+}
+
+// ED25519Generator generates an Ed25519 key pair.
+type ED25519Generator struct{}
+
+// Algorithm returns the algorithm name as a string.
+func (g *ED25519Generator) Algorithm() string {
+	return ED25519_ALGORITHM_NAME
+}
+
+// ResolveParameters validates the parameters: Ed25519 has a fixed key size and does not pre-hash,
+// so none of them can be set.
+func (g *ED25519Generator) ResolveParameters(params Parameters) (Parameters, error) {
+	if params != (Parameters{}) {
+		return Parameters{}, ErrInvalidParameters{ED25519_ALGORITHM_NAME, "key size, curve and hash are not supported"}
+	}
+	return params, nil
+}
+
+// SignerOpts returns the options to be used when signing.
+// Ed25519 signs the message itself, so no hash function is set.
+func (g *ED25519Generator) SignerOpts(params Parameters) (crypto.SignerOpts, error) {
+	return crypto.Hash(0), nil
+}
+
+// Generate generates a new ED25519KeyPair.
+func (g *ED25519Generator) Generate(params Parameters) (KeyPair, error) {
+	if _, err := g.ResolveParameters(params); err != nil {
+		return nil, err
+	}
+
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ED25519KeyPair{
+		Public:  public,
+		Private: private,
+	}, nil
+}
+
+// Unmarshal loads an ED25519KeyPair from bytes.
+func (g *ED25519Generator) Unmarshal(priv []byte) (KeyPair, error) {
+	return NewED25519Marshaler().Decode(priv)
+}
+
+// UnmarshalPublic loads a public only ED25519KeyPair from bytes.
+func (g *ED25519Generator) UnmarshalPublic(pub []byte) (KeyPair, error) {
+	return NewED25519Marshaler().DecodePublic(pub)
+}
+
+// ED25519KeyPair is a DTO that holds Ed25519 private and public keys.
+type ED25519KeyPair struct {
+	Public  ed25519.PublicKey
+	Private ed25519.PrivateKey
+}
+
+// PrivateKey returns the keypair's private key as `crypto.Signer`.
+func (k *ED25519KeyPair) PrivateKey() crypto.Signer {
+	if k.Private == nil {
+		return nil
+	}
+	return k.Private
+}
+
+// PublicKey returns the keypair's public key.
+func (k *ED25519KeyPair) PublicKey() crypto.PublicKey {
+	return k.Public
+}
+
+// Equal verifies keypairs to be equals.
+func (k *ED25519KeyPair) Equal(x KeyPair) bool {
+	return k.Public.Equal(x.PublicKey())
+}
+
+// PublicOnly returns a copy of the keypair holding just the public key.
+func (k *ED25519KeyPair) PublicOnly() KeyPair {
+	return &ED25519KeyPair{Public: k.Public}
+}
+
+// Discard overwrites the private key bytes and drops them.
+func (k *ED25519KeyPair) Discard() {
+	clear(k.Private)
+	k.Private = nil
+}
+
+// Marshal encodes the keypair to be written on disk.
+// It returns the public and the private key as a byte slice.
+func (k *ED25519KeyPair) Marshal() ([]byte, []byte, error) {
+	return NewED25519Marshaler().Encode(*k)
+}
+
+// ED25519Marshaler can encode and decode an Ed25519 key pair.
+type ED25519Marshaler struct{}
+
+// NewED25519Marshaler creates a new ED25519Marshaler.
+func NewED25519Marshaler() ED25519Marshaler {
+	return ED25519Marshaler{}
+}
+
+// Encode takes an ED25519KeyPair and encodes it to be written on disk
+// (PKCS#8 private key, PKIX public key).
+// It returns the public and the private key as a byte slice.
+func (m ED25519Marshaler) Encode(keyPair ED25519KeyPair) ([]byte, []byte, error) {
+	publicKeyBytes, err := x509.MarshalPKIXPublicKey(keyPair.Public)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	encodedPublic := pem.EncodeToMemory(&pem.Block{
+		Type:  "PUBLIC_KEY",
+		Bytes: publicKeyBytes,
+	})
+
+	if keyPair.Private == nil {
+		return encodedPublic, nil, nil
+	}
+
+	privateKeyBytes, err := x509.MarshalPKCS8PrivateKey(keyPair.Private)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	encodedPrivate := pem.EncodeToMemory(&pem.Block{
+		Type:  "PRIVATE_KEY",
+		Bytes: privateKeyBytes,
+	})
+
+	return encodedPublic, encodedPrivate, nil
+}
+
+// Decode assembles an ED25519KeyPair from an encoded private key.
+func (m ED25519Marshaler) Decode(privateKeyBytes []byte) (*ED25519KeyPair, error) {
+	block, _ := pem.Decode(privateKeyBytes)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errors.New("not an Ed25519 private key")
+	}
+
+	return &ED25519KeyPair{
+		Private: privateKey,
+		Public:  privateKey.Public().(ed25519.PublicKey),
+	}, nil
+}
+
+// DecodePublic assembles a public only ED25519KeyPair from an encoded public key.
+func (m ED25519Marshaler) DecodePublic(publicKeyBytes []byte) (*ED25519KeyPair, error) {
+	block, _ := pem.Decode(publicKeyBytes)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, errors.New("not an Ed25519 public key")
+	}
+
+	return &ED25519KeyPair{
+		Public: publicKey,
+	}, nil
+}
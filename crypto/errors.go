@@ -9,3 +9,18 @@ type ErrUnknownAlgorithm struct {
 func (e ErrUnknownAlgorithm) Error() string {
 	return fmt.Sprintf("algorithm: unknown signature algorithm %s", e.algorithm)
 }
+
+type ErrInvalidParameters struct {
+	algorithm string
+	reason    string
+}
+
+func (e ErrInvalidParameters) Error() string {
+	return fmt.Sprintf("algorithm: invalid parameters for %s: %s", e.algorithm, e.reason)
+}
+
+type ErrInvalidSignature struct{}
+
+func (e ErrInvalidSignature) Error() string {
+	return "signature: verification failed"
+}
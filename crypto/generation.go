@@ -41,21 +41,34 @@ func FromString(algorithmName string) (Generator, error) {
 type Generator interface {
 	// Algorithm returns the algorithm name as a string.
 	Algorithm() string
-	// Generate generates a new KeyPair.
-	Generate() (KeyPair, error)
+	// ResolveParameters validates the given parameters against the algorithm policy
+	// and returns them with defaults applied to the unset ones.
+	ResolveParameters(params Parameters) (Parameters, error)
+	// SignerOpts returns the options to be used when signing with the given parameters.
+	// A zero hash function means that the message is signed as is, without pre-hashing.
+	SignerOpts(params Parameters) (crypto.SignerOpts, error)
+	// Generate generates a new KeyPair using the given parameters.
+	Generate(params Parameters) (KeyPair, error)
 	// Unmarshal loads a KeyPair from bytes.
 	Unmarshal(priv []byte) (KeyPair, error)
+	// UnmarshalPublic loads a public only KeyPair from the encoded public key.
+	UnmarshalPublic(pub []byte) (KeyPair, error)
 }
 
-// KeyPair is a common interface to RSA, ECC, ... keypairs
+// KeyPair is a common interface to RSA, ECC, Ed25519, ... keypairs
 type KeyPair interface {
-	// PrivateKey returns the keypair's private key as `crypto.Signer`.
+	// PrivateKey returns the keypair's private key as `crypto.Signer`, nil for a public only keypair.
 	PrivateKey() crypto.Signer
 	// PublicKey returns the keypair's public key.
 	PublicKey() crypto.PublicKey
-	// Equal verifies keypairs to be equals.
+	// Equal verifies keypairs to be equals, comparing their public keys.
 	Equal(KeyPair) bool
 	// Marshal encodes the keypair to be written on disk.
-	// It returns the public and the private key as a byte slice.
+	// It returns the public and the private key as a byte slice, the latter nil for a public only keypair.
 	Marshal() ([]byte, []byte, error)
+	// PublicOnly returns a copy of the keypair holding just the public key.
+	PublicOnly() KeyPair
+	// Discard overwrites the private key material and drops it, leaving a public only keypair.
+	// Wiping is best effort: copies made by the runtime or the standard library cannot be reached.
+	Discard()
 }
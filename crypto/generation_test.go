@@ -1,6 +1,10 @@
 package crypto
 
 import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
 	"encoding/pem"
 	"testing"
 )
@@ -51,6 +55,16 @@ func TestFromStringECC(t *testing.T) {
 	fromStringinternal(algo, ECC_ALGORITHM_NAME, t)
 }
 
+func TestFromStringED25519(t *testing.T) {
+	algo := "ed25519"
+	fromStringinternal(algo, ED25519_ALGORITHM_NAME, t)
+}
+
+func TestFromStringRSAPSS(t *testing.T) {
+	algo := "rsa-pss"
+	fromStringinternal(algo, RSA_PSS_ALGORITHM_NAME, t)
+}
+
 func fromStringinternal(algo, expected string, t *testing.T) {
 	g, err := FromString(algo)
 	if err != nil {
@@ -101,6 +115,26 @@ func TestUnmarshalerBadECCPem(t *testing.T) {
 	}
 }
 
+func TestUnmarshalerBadED25519(t *testing.T) {
+	g := &ED25519Generator{}
+	_, err := g.Unmarshal([]byte{})
+	if err == nil {
+		t.Fatalf("expecting error unmarshalling empty bytes")
+	}
+}
+
+func TestUnmarshalerBadED25519Pem(t *testing.T) {
+	pbytes := pem.EncodeToMemory(&pem.Block{
+		Type:  "PRIVATE_KEY",
+		Bytes: []byte("privateKeyBytes"),
+	})
+	g := &ED25519Generator{}
+	_, err := g.Unmarshal(pbytes)
+	if err == nil {
+		t.Fatalf("expecting error unmarshalling bad key")
+	}
+}
+
 func TestMarshalerRSA(t *testing.T) {
 	g := &RSAGenerator{}
 	marshalerChecks(t, g)
@@ -111,9 +145,14 @@ func TestMarshalerECC(t *testing.T) {
 	marshalerChecks(t, g)
 }
 
+func TestMarshalerED25519(t *testing.T) {
+	g := &ED25519Generator{}
+	marshalerChecks(t, g)
+}
+
 func marshalerChecks(t *testing.T, g Generator) {
 	algorithmName := g.Algorithm()
-	kp, err := g.Generate()
+	kp, err := g.Generate(Parameters{})
 	if err != nil {
 		t.Fatalf("error generating %s %v", algorithmName, err)
 	}
@@ -131,3 +170,129 @@ func marshalerChecks(t *testing.T, g Generator) {
 		t.Fatalf("error restoring %s %v", algorithmName, err)
 	}
 }
+
+func TestResolveParametersDefaults(t *testing.T) {
+	tests := []struct {
+		g        Generator
+		expected Parameters
+	}{
+		{&RSAGenerator{}, Parameters{KeySize: RSA_DEFAULT_KEY_SIZE, Hash: DEFAULT_HASH_ALGORITHM}},
+		{&RSAPSSGenerator{}, Parameters{KeySize: RSA_DEFAULT_KEY_SIZE, Hash: DEFAULT_HASH_ALGORITHM}},
+		{&ECCGenerator{}, Parameters{Curve: ECC_DEFAULT_CURVE, Hash: DEFAULT_HASH_ALGORITHM}},
+		{&ED25519Generator{}, Parameters{}},
+	}
+	for _, test := range tests {
+		params, err := test.g.ResolveParameters(Parameters{})
+		if err != nil {
+			t.Fatalf("%s: unexpected error %v", test.g.Algorithm(), err)
+		}
+		if params != test.expected {
+			t.Fatalf("%s: got parameters %v, expected %v", test.g.Algorithm(), params, test.expected)
+		}
+	}
+}
+
+func TestResolveParametersInvalid(t *testing.T) {
+	tests := []struct {
+		g      Generator
+		params Parameters
+	}{
+		{&RSAGenerator{}, Parameters{KeySize: 1024}},
+		{&RSAGenerator{}, Parameters{KeySize: 2049}},
+		{&RSAGenerator{}, Parameters{Curve: "P-256"}},
+		{&RSAGenerator{}, Parameters{Hash: "MD5"}},
+		{&ECCGenerator{}, Parameters{Curve: "P-224"}},
+		{&ECCGenerator{}, Parameters{KeySize: 2048}},
+		{&ECCGenerator{}, Parameters{Hash: "SHA-1"}},
+		{&ED25519Generator{}, Parameters{Hash: "SHA-256"}},
+	}
+	for _, test := range tests {
+		params, err := test.g.ResolveParameters(test.params)
+		if err == nil {
+			t.Fatalf("%s: expected error for %v, got %v", test.g.Algorithm(), test.params, params)
+		}
+		if _, ok := err.(ErrInvalidParameters); !ok {
+			t.Fatalf("%s: expected ErrInvalidParameters, got %T", test.g.Algorithm(), err)
+		}
+	}
+}
+
+func TestGenerateWithParameters(t *testing.T) {
+	kp, err := (&ECCGenerator{}).Generate(Parameters{Curve: "P-256"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if curve := kp.(*ECCKeyPair).Public.Curve.Params().Name; curve != "P-256" {
+		t.Fatalf("got curve %s, expected P-256", curve)
+	}
+
+	kp, err = (&RSAGenerator{}).Generate(Parameters{KeySize: 3072})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := kp.(*RSAKeyPair).Public.Size() * 8; size != 3072 {
+		t.Fatalf("got key size %d, expected 3072", size)
+	}
+}
+
+func TestMarshalerPublicOnly(t *testing.T) {
+	for _, g := range []Generator{&RSAGenerator{}, &ECCGenerator{}, &ED25519Generator{}} {
+		algorithmName := g.Algorithm()
+		kp, err := g.Generate(Parameters{})
+		if err != nil {
+			t.Fatalf("error generating %s %v", algorithmName, err)
+		}
+		pub, _, err := kp.Marshal()
+		if err != nil {
+			t.Fatalf("error marshalling %s %v", algorithmName, err)
+		}
+
+		nkp, err := g.UnmarshalPublic(pub)
+		if err != nil {
+			t.Fatalf("error unmarshalling public %s %v", algorithmName, err)
+		}
+		if !nkp.Equal(kp) || nkp.PrivateKey() != nil {
+			t.Fatalf("error restoring public only %s", algorithmName)
+		}
+		npub, npriv, err := nkp.Marshal()
+		if err != nil || string(npub) != string(pub) || npriv != nil {
+			t.Fatalf("error marshalling public only %s %v", algorithmName, err)
+		}
+	}
+}
+
+func TestDiscard(t *testing.T) {
+	for _, g := range []Generator{&RSAGenerator{}, &ECCGenerator{}, &ED25519Generator{}} {
+		algorithmName := g.Algorithm()
+		kp, err := g.Generate(Parameters{})
+		if err != nil {
+			t.Fatalf("error generating %s %v", algorithmName, err)
+		}
+		public := kp.PublicOnly()
+		private := kp.PrivateKey()
+
+		kp.Discard()
+
+		if kp.PrivateKey() != nil {
+			t.Fatalf("%s: expected the private key to be dropped", algorithmName)
+		}
+		if !kp.Equal(public) {
+			t.Fatalf("%s: expected the public key to be kept", algorithmName)
+		}
+		if _, priv, err := kp.Marshal(); err != nil || priv != nil {
+			t.Fatalf("%s: expected no private key to be marshalled, got %v", algorithmName, err)
+		}
+		wiped := false
+		switch key := private.(type) {
+		case *rsa.PrivateKey:
+			wiped = key.D.Sign() == 0 && key.Primes[0].Sign() == 0 && key.Primes[1].Sign() == 0
+		case *ecdsa.PrivateKey:
+			wiped = key.D.Sign() == 0
+		case ed25519.PrivateKey:
+			wiped = bytes.Equal(key, make([]byte, len(key)))
+		}
+		if !wiped {
+			t.Fatalf("%s: expected the private key material to be wiped", algorithmName)
+		}
+	}
+}
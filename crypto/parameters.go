@@ -0,0 +1,49 @@
+package crypto
+
+import (
+	"crypto"
+	"slices"
+)
+
+const (
+	DEFAULT_HASH_ALGORITHM = "SHA-256"
+)
+
+// validHashes maps the accepted hash algorithm names to the related crypto.Hash.
+var validHashes = map[string]crypto.Hash{
+	crypto.SHA256.String(): crypto.SHA256,
+	crypto.SHA384.String(): crypto.SHA384,
+	crypto.SHA512.String(): crypto.SHA512,
+}
+
+// Parameters holds the algorithm dependent key generation and signing parameters.
+// Zero values mean "not set" and are replaced by the algorithm defaults when resolved.
+type Parameters struct {
+	// KeySize is the RSA modulus size in bits.
+	KeySize int
+	// Curve is the ECC curve name (e.g. P-384).
+	Curve string
+	// Hash is the name of the hash algorithm applied before signing (e.g. SHA-256).
+	Hash string
+}
+
+// GetValidHashes returns all hash algorithm names accepted as parameter.
+func GetValidHashes() []string {
+	list := make([]string, 0, len(validHashes))
+	for k := range validHashes {
+		list = append(list, k)
+	}
+	slices.Sort(list)
+	return list
+}
+
+// hashFromString returns the crypto.Hash with the given name, the default one if name is empty.
+func hashFromString(algorithm, name string) (crypto.Hash, error) {
+	if name == "" {
+		name = DEFAULT_HASH_ALGORITHM
+	}
+	if h, ok := validHashes[name]; ok {
+		return h, nil
+	}
+	return 0, ErrInvalidParameters{algorithm, "unsupported hash algorithm " + name}
+}
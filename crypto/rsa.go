@@ -7,10 +7,18 @@ import (
 	"crypto/x509"
 	"encoding/pem"
 	"errors"
+	"fmt"
 )
 
 const (
 	RSA_ALGORITHM_NAME = "RSA"
+
+	RSA_DEFAULT_KEY_SIZE = 2048
+	RSA_MIN_KEY_SIZE     = 2048
+	RSA_MAX_KEY_SIZE     = 4096
+
+	PKCS1V15_PADDING = "PKCS1V15"
+	PSS_PADDING      = "PSS"
 )
 
 func init() {
@@ -25,10 +33,40 @@ func (g *RSAGenerator) Algorithm() string {
 	return RSA_ALGORITHM_NAME
 }
 
+// ResolveParameters validates key size and hash, applying defaults.
+// The key size must be a multiple of 8 between RSA_MIN_KEY_SIZE and RSA_MAX_KEY_SIZE.
+func (g *RSAGenerator) ResolveParameters(params Parameters) (Parameters, error) {
+	if params.Curve != "" {
+		return Parameters{}, ErrInvalidParameters{RSA_ALGORITHM_NAME, "curve is not supported"}
+	}
+	if params.KeySize == 0 {
+		params.KeySize = RSA_DEFAULT_KEY_SIZE
+	}
+	if params.KeySize < RSA_MIN_KEY_SIZE || params.KeySize > RSA_MAX_KEY_SIZE || params.KeySize%8 != 0 {
+		return Parameters{}, ErrInvalidParameters{RSA_ALGORITHM_NAME, fmt.Sprintf("key size must be a multiple of 8 between %d and %d", RSA_MIN_KEY_SIZE, RSA_MAX_KEY_SIZE)}
+	}
+	if params.Hash == "" {
+		params.Hash = DEFAULT_HASH_ALGORITHM
+	}
+	if _, err := hashFromString(RSA_ALGORITHM_NAME, params.Hash); err != nil {
+		return Parameters{}, err
+	}
+	return params, nil
+}
+
+// SignerOpts returns the options to be used when signing (PKCS#1 v1.5 with the chosen hash).
+func (g *RSAGenerator) SignerOpts(params Parameters) (crypto.SignerOpts, error) {
+	return hashFromString(RSA_ALGORITHM_NAME, params.Hash)
+}
+
 // Generate generates a new RSAKeyPair.
-func (g *RSAGenerator) Generate() (KeyPair, error) {
-	// Security has been ignored for the sake of simplicity.
-	key, err := rsa.GenerateKey(rand.Reader, 512)
+func (g *RSAGenerator) Generate(params Parameters) (KeyPair, error) {
+	params, err := g.ResolveParameters(params)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, params.KeySize)
 	if err != nil {
 		return nil, err
 	}
@@ -44,6 +82,28 @@ func (g *RSAGenerator) Unmarshal(priv []byte) (KeyPair, error) {
 	return NewRSAMarshaler().Unmarshal(priv)
 }
 
+// UnmarshalPublic loads a public only RSAKeyPair from bytes.
+func (g *RSAGenerator) UnmarshalPublic(pub []byte) (KeyPair, error) {
+	return NewRSAMarshaler().UnmarshalPublic(pub)
+}
+
+// PaddingScheme returns the padding scheme and the salt length used when signing with
+// the given public key and options.
+// It returns an empty scheme for non RSA keys and a zero salt length for PKCS#1 v1.5.
+func PaddingScheme(publicKey crypto.PublicKey, opts crypto.SignerOpts) (string, int) {
+	if _, ok := publicKey.(*rsa.PublicKey); !ok {
+		return "", 0
+	}
+	pssOpts, ok := opts.(*rsa.PSSOptions)
+	if !ok {
+		return PKCS1V15_PADDING, 0
+	}
+	if pssOpts.SaltLength == rsa.PSSSaltLengthEqualsHash {
+		return PSS_PADDING, pssOpts.HashFunc().Size()
+	}
+	return PSS_PADDING, pssOpts.SaltLength
+}
+
 // RSAKeyPair is a DTO that holds RSA private and public keys.
 type RSAKeyPair struct {
 	Public  *rsa.PublicKey
@@ -52,6 +112,9 @@ type RSAKeyPair struct {
 
 // PrivateKey returns the keypair's private key as `crypto.Signer`.
 func (k *RSAKeyPair) PrivateKey() crypto.Signer {
+	if k.Private == nil {
+		return nil
+	}
 	return k.Private
 }
 
@@ -62,7 +125,26 @@ func (k *RSAKeyPair) PublicKey() crypto.PublicKey {
 
 // Equal verifies keypairs to be equals.
 func (k *RSAKeyPair) Equal(x KeyPair) bool {
-	return k.Private.Equal(x.PrivateKey())
+	return k.Public.Equal(x.PublicKey())
+}
+
+// PublicOnly returns a copy of the keypair holding just the public key.
+func (k *RSAKeyPair) PublicOnly() KeyPair {
+	return &RSAKeyPair{Public: k.Public}
+}
+
+// Discard overwrites the private exponent, the primes and the precomputed CRT values and drops them.
+func (k *RSAKeyPair) Discard() {
+	if k.Private != nil {
+		wipeInt(k.Private.D)
+		for _, p := range k.Private.Primes {
+			wipeInt(p)
+		}
+		wipeInt(k.Private.Precomputed.Dp)
+		wipeInt(k.Private.Precomputed.Dq)
+		wipeInt(k.Private.Precomputed.Qinv)
+	}
+	k.Private = nil
 }
 
 // Marshal encodes the keypair to be written on disk.
@@ -82,19 +164,24 @@ func NewRSAMarshaler() RSAMarshaler {
 // Marshal takes an RSAKeyPair and encodes it to be written on disk.
 // It returns the public and the private key as a byte slice.
 func (m RSAMarshaler) Marshal(keyPair RSAKeyPair) ([]byte, []byte, error) {
-	privateKeyBytes := x509.MarshalPKCS1PrivateKey(keyPair.Private)
 	publicKeyBytes := x509.MarshalPKCS1PublicKey(keyPair.Public)
 
-	encodedPrivate := pem.EncodeToMemory(&pem.Block{
-		Type:  "RSA_PRIVATE_KEY",
-		Bytes: privateKeyBytes,
-	})
-
 	encodePublic := pem.EncodeToMemory(&pem.Block{
 		Type:  "RSA_PUBLIC_KEY",
 		Bytes: publicKeyBytes,
 	})
 
+	if keyPair.Private == nil {
+		return encodePublic, nil, nil
+	}
+
+	privateKeyBytes := x509.MarshalPKCS1PrivateKey(keyPair.Private)
+
+	encodedPrivate := pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA_PRIVATE_KEY",
+		Bytes: privateKeyBytes,
+	})
+
 	return encodePublic, encodedPrivate, nil
 }
 
@@ -115,3 +202,19 @@ func (m RSAMarshaler) Unmarshal(privateKeyBytes []byte) (*RSAKeyPair, error) {
 		Public:  &privateKey.PublicKey,
 	}, nil
 }
+
+// UnmarshalPublic takes an encoded RSA public key and transforms it into a public only RSAKeyPair.
+func (m RSAMarshaler) UnmarshalPublic(publicKeyBytes []byte) (*RSAKeyPair, error) {
+	block, _ := pem.Decode(publicKeyBytes)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+	publicKey, err := x509.ParsePKCS1PublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RSAKeyPair{
+		Public: publicKey,
+	}, nil
+}
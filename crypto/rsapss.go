@@ -0,0 +1,38 @@
+package crypto
+
+import (
+	"crypto"
+	"crypto/rsa"
+)
+
+const (
+	RSA_PSS_ALGORITHM_NAME = "RSA-PSS"
+)
+
+func init() {
+	registerGenerator(&RSAPSSGenerator{})
+}
+
+// RSAPSSGenerator generates a RSA key pair meant to be used with RSASSA-PSS signatures.
+// Keys and parameters are the same as RSAGenerator ones, only the signing options differ.
+type RSAPSSGenerator struct {
+	RSAGenerator
+}
+
+// Algorithm returns the algorithm name as a string.
+func (g *RSAPSSGenerator) Algorithm() string {
+	return RSA_PSS_ALGORITHM_NAME
+}
+
+// SignerOpts returns the options to be used when signing.
+// The salt length is fixed to the hash length so that verifiers can rely on it.
+func (g *RSAPSSGenerator) SignerOpts(params Parameters) (crypto.SignerOpts, error) {
+	h, err := hashFromString(RSA_PSS_ALGORITHM_NAME, params.Hash)
+	if err != nil {
+		return nil, err
+	}
+	return &rsa.PSSOptions{
+		SaltLength: rsa.PSSSaltLengthEqualsHash,
+		Hash:       h,
+	}, nil
+}
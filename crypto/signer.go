@@ -1,6 +1,7 @@
 package crypto
 
 import (
+	"context"
 	"crypto"
 	"crypto/rand"
 	"fmt"
@@ -8,32 +9,40 @@ import (
 
 // Signer defines a contract for different types of signing implementations.
 type Signer interface {
-	Sign(dataToBeSigned []byte) ([]byte, error)
+	Sign(ctx context.Context, dataToBeSigned []byte) ([]byte, error)
 }
 
 // GenericSigner represents a base Signer implementation.
 type GenericSigner struct {
 	cryptoSigner crypto.Signer
-	cryptoHash   crypto.Hash
+	signerOpts   crypto.SignerOpts
 }
 
-// NewGenericSigner returns a generic signer given a crypto.Signer and a crypto.Hash.
-func NewGenericSigner(cryptoSigner crypto.Signer, cryptoHash crypto.Hash) (*GenericSigner, error) {
-	if !cryptoHash.Available() {
+// NewGenericSigner returns a generic signer given a crypto.Signer and the crypto.SignerOpts to sign with.
+// A crypto.Hash is a valid crypto.SignerOpts; crypto.Hash(0) selects pure (non-prehashed) signing.
+func NewGenericSigner(cryptoSigner crypto.Signer, signerOpts crypto.SignerOpts) (*GenericSigner, error) {
+	if cryptoHash := signerOpts.HashFunc(); cryptoHash != 0 && !cryptoHash.Available() {
 		return nil, fmt.Errorf("hash function '%s' not available", cryptoHash.String())
 	}
 	return &GenericSigner{
 		cryptoSigner: cryptoSigner,
-		cryptoHash:   cryptoHash,
+		signerOpts:   signerOpts,
 	}, nil
 }
 
-// Sign return the signature of the given data.
-func (s *GenericSigner) Sign(dataToBeSigned []byte) ([]byte, error) {
-	hasher := s.cryptoHash.New()
+// Sign return the signature of the given data, unless ctx is already done.
+func (s *GenericSigner) Sign(ctx context.Context, dataToBeSigned []byte) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	cryptoHash := s.signerOpts.HashFunc()
+	if cryptoHash == 0 {
+		return s.cryptoSigner.Sign(rand.Reader, dataToBeSigned, s.signerOpts)
+	}
+	hasher := cryptoHash.New()
 	_, err := hasher.Write(dataToBeSigned)
 	if err != nil {
 		return nil, err
 	}
-	return s.cryptoSigner.Sign(rand.Reader, hasher.Sum(nil), s.cryptoHash)
+	return s.cryptoSigner.Sign(rand.Reader, hasher.Sum(nil), s.signerOpts)
 }
@@ -1,8 +1,10 @@
 package crypto
 
 import (
+	"context"
 	"crypto"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/base64"
@@ -16,8 +18,8 @@ func TestNewGenericSigner(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	if gs.cryptoHash != crypto.SHA256 {
-		t.Fatalf("got hash: %v, expected: %v", gs.cryptoHash, crypto.SHA256)
+	if gs.signerOpts != crypto.SHA256 {
+		t.Fatalf("got hash: %v, expected: %v", gs.signerOpts, crypto.SHA256)
 	}
 	if gs.cryptoSigner != signer {
 		t.Fatalf("got signer: %v, expected: %v", gs.cryptoSigner, signer)
@@ -25,14 +27,14 @@ func TestNewGenericSigner(t *testing.T) {
 }
 
 func TestNewGenericSignerNoHASH(t *testing.T) {
-	gs, err := NewGenericSigner(&rsa.PrivateKey{}, 99)
+	gs, err := NewGenericSigner(&rsa.PrivateKey{}, crypto.Hash(99))
 	if err == nil {
 		t.Fatalf("Expected error got %v", gs)
 	}
 }
 
 func TestNewGenericSignerSign(t *testing.T) {
-	signer, err := (&RSAGenerator{}).Generate()
+	signer, err := (&RSAGenerator{}).Generate(Parameters{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -41,22 +43,119 @@ func TestNewGenericSignerSign(t *testing.T) {
 		t.Fatal(err)
 	}
 	x := []byte("foobar")
-	res, err := gs.Sign(x)
+	res, err := gs.Sign(context.Background(), x)
 
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	hasher := gs.cryptoHash.New()
+	hasher := gs.signerOpts.HashFunc().New()
 	_, _ = hasher.Write(x)
 
 	rsakeypair := signer.(*RSAKeyPair)
 
-	if err := rsa.VerifyPKCS1v15(rsakeypair.Public, gs.cryptoHash, hasher.Sum(nil), res); err != nil {
+	if err := rsa.VerifyPKCS1v15(rsakeypair.Public, gs.signerOpts.HashFunc(), hasher.Sum(nil), res); err != nil {
 		t.Fatal(err)
 	}
 }
 
+func TestNewGenericSignerPure(t *testing.T) {
+	gs, err := NewGenericSigner(&rsa.PrivateKey{}, crypto.Hash(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gs.signerOpts.HashFunc() != 0 {
+		t.Fatalf("got hash: %v, expected none", gs.signerOpts.HashFunc())
+	}
+}
+
+func TestNewGenericSignerSignED25519(t *testing.T) {
+	g := &ED25519Generator{}
+	signer, err := g.Generate(Parameters{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts, err := g.SignerOpts(Parameters{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gs, err := NewGenericSigner(signer.PrivateKey(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x := []byte("foobar")
+	res, err := gs.Sign(context.Background(), x)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	edkeypair := signer.(*ED25519KeyPair)
+
+	if !ed25519.Verify(edkeypair.Public, x, res) {
+		t.Fatal("not valid")
+	}
+}
+
+func TestNewGenericSignerSignRSAPSS(t *testing.T) {
+	g := &RSAPSSGenerator{}
+	signer, err := g.Generate(Parameters{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts, err := g.SignerOpts(Parameters{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gs, err := NewGenericSigner(signer.PrivateKey(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x := []byte("foobar")
+	res, err := gs.Sign(context.Background(), x)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hasher := crypto.SHA256.New()
+	_, _ = hasher.Write(x)
+
+	rsakeypair := signer.(*RSAKeyPair)
+
+	padding, saltLength := PaddingScheme(rsakeypair.Public, opts)
+	if padding != PSS_PADDING || saltLength != crypto.SHA256.Size() {
+		t.Fatalf("got padding %s with salt length %d, expected %s with %d", padding, saltLength, PSS_PADDING, crypto.SHA256.Size())
+	}
+
+	verifyOpts := &rsa.PSSOptions{SaltLength: saltLength}
+	if err := rsa.VerifyPSS(rsakeypair.Public, crypto.SHA256, hasher.Sum(nil), res, verifyOpts); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := rsa.VerifyPKCS1v15(rsakeypair.Public, crypto.SHA256, hasher.Sum(nil), res); err == nil {
+		t.Fatal("expected PSS signature not to verify as PKCS#1 v1.5")
+	}
+}
+
+func TestPaddingScheme(t *testing.T) {
+	rsaKeyPair, err := (&RSAGenerator{}).Generate(Parameters{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if padding, saltLength := PaddingScheme(rsaKeyPair.PublicKey(), crypto.SHA256); padding != PKCS1V15_PADDING || saltLength != 0 {
+		t.Fatalf("got padding %s with salt length %d, expected %s", padding, saltLength, PKCS1V15_PADDING)
+	}
+
+	eccKeyPair, err := (&ECCGenerator{}).Generate(Parameters{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if padding, _ := PaddingScheme(eccKeyPair.PublicKey(), crypto.SHA256); padding != "" {
+		t.Fatalf("got padding %s, expected none", padding)
+	}
+}
+
 func TestECC(t *testing.T) {
 	pems, _ := base64.StdEncoding.DecodeString("LS0tLS1CRUdJTiBQVUJMSUNfS0VZLS0tLS0KTUhZd0VBWUhLb1pJemowQ0FRWUZLNEVFQUNJRFlnQUU1b1BKMnNHOUp4SndDczNsWEFWWnl3V0JIamt2c291WQp1SHBxS2l6QkVuZGtxV0xFY0hwd1RsU1J4dlBZWXFGbDlyWVN4dTY2V0V0dmxqRDh1Y3ZDbUNjRG8vOWFpa0FnCko2NHFCL0lLdlc2ZUN0TmRTSWFUTGhESXhKaTFPL0FSCi0tLS0tRU5EIFBVQkxJQ19LRVktLS0tLQo=")
 	byt, _ := pem.Decode(pems)
@@ -71,3 +170,19 @@ func TestECC(t *testing.T) {
 		t.Fatal("not valid")
 	}
 }
+
+func TestNewGenericSignerSignCanceled(t *testing.T) {
+	signer, err := (&ECCGenerator{}).Generate(Parameters{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gs, err := NewGenericSigner(signer.PrivateKey(), crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if res, err := gs.Sign(ctx, []byte("foobar")); err != context.Canceled {
+		t.Fatalf("Expected canceled err, got %v, %v", res, err)
+	}
+}
@@ -0,0 +1,69 @@
+package crypto
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"fmt"
+)
+
+// Verifier defines a contract for different types of signature verification implementations.
+type Verifier interface {
+	Verify(signedData []byte, signature []byte) error
+}
+
+// GenericVerifier represents a base Verifier implementation, the counterpart of GenericSigner.
+type GenericVerifier struct {
+	publicKey  crypto.PublicKey
+	signerOpts crypto.SignerOpts
+}
+
+// NewGenericVerifier returns a generic verifier given a public key and the crypto.SignerOpts used to sign.
+func NewGenericVerifier(publicKey crypto.PublicKey, signerOpts crypto.SignerOpts) (*GenericVerifier, error) {
+	if cryptoHash := signerOpts.HashFunc(); cryptoHash != 0 && !cryptoHash.Available() {
+		return nil, fmt.Errorf("hash function '%s' not available", cryptoHash.String())
+	}
+	switch publicKey.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey:
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", publicKey)
+	}
+	return &GenericVerifier{
+		publicKey:  publicKey,
+		signerOpts: signerOpts,
+	}, nil
+}
+
+// Verify checks the signature of the given data, hashing and padding it the same way GenericSigner does.
+// It returns ErrInvalidSignature if the signature does not match.
+func (v *GenericVerifier) Verify(signedData []byte, signature []byte) error {
+	digest := signedData
+	cryptoHash := v.signerOpts.HashFunc()
+	if cryptoHash != 0 {
+		hasher := cryptoHash.New()
+		if _, err := hasher.Write(signedData); err != nil {
+			return err
+		}
+		digest = hasher.Sum(nil)
+	}
+
+	valid := false
+	switch publicKey := v.publicKey.(type) {
+	case *rsa.PublicKey:
+		if pssOpts, ok := v.signerOpts.(*rsa.PSSOptions); ok {
+			valid = rsa.VerifyPSS(publicKey, cryptoHash, digest, signature, pssOpts) == nil
+		} else {
+			valid = rsa.VerifyPKCS1v15(publicKey, cryptoHash, digest, signature) == nil
+		}
+	case *ecdsa.PublicKey:
+		valid = ecdsa.VerifyASN1(publicKey, digest, signature)
+	case ed25519.PublicKey:
+		valid = ed25519.Verify(publicKey, digest, signature)
+	}
+
+	if !valid {
+		return ErrInvalidSignature{}
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package crypto
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"testing"
+)
+
+func TestNewGenericVerifierNoHASH(t *testing.T) {
+	kp, err := (&ED25519Generator{}).Generate(Parameters{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gv, err := NewGenericVerifier(kp.PublicKey(), crypto.Hash(99))
+	if err == nil {
+		t.Fatalf("Expected error got %v", gv)
+	}
+}
+
+func TestNewGenericVerifierUnknownKey(t *testing.T) {
+	gv, err := NewGenericVerifier("not a key", crypto.SHA256)
+	if err == nil {
+		t.Fatalf("Expected error got %v", gv)
+	}
+}
+
+func TestGenericVerifier(t *testing.T) {
+	tests := []struct {
+		g      Generator
+		params Parameters
+	}{
+		{&RSAGenerator{}, Parameters{}},
+		{&RSAPSSGenerator{}, Parameters{Hash: "SHA-384"}},
+		{&ECCGenerator{}, Parameters{Curve: "P-256"}},
+		{&ED25519Generator{}, Parameters{}},
+	}
+
+	for _, test := range tests {
+		algorithmName := test.g.Algorithm()
+		kp, err := test.g.Generate(test.params)
+		if err != nil {
+			t.Fatalf("error generating %s %v", algorithmName, err)
+		}
+		params, err := test.g.ResolveParameters(test.params)
+		if err != nil {
+			t.Fatalf("error resolving %s parameters %v", algorithmName, err)
+		}
+		opts, err := test.g.SignerOpts(params)
+		if err != nil {
+			t.Fatalf("error getting %s signer options %v", algorithmName, err)
+		}
+		gs, err := NewGenericSigner(kp.PrivateKey(), opts)
+		if err != nil {
+			t.Fatalf("error creating %s signer %v", algorithmName, err)
+		}
+		gv, err := NewGenericVerifier(kp.PublicKey(), opts)
+		if err != nil {
+			t.Fatalf("error creating %s verifier %v", algorithmName, err)
+		}
+
+		x := []byte("foobar")
+		signature, err := gs.Sign(context.Background(), x)
+		if err != nil {
+			t.Fatalf("error signing with %s %v", algorithmName, err)
+		}
+
+		if err := gv.Verify(x, signature); err != nil {
+			t.Fatalf("expected %s signature to be valid, got %v", algorithmName, err)
+		}
+
+		if err := gv.Verify([]byte("foobaz"), signature); err == nil {
+			t.Fatalf("expected %s signature not to be valid for different data", algorithmName)
+		} else if _, ok := err.(ErrInvalidSignature); !ok {
+			t.Fatalf("expected ErrInvalidSignature for %s, got %T", algorithmName, err)
+		}
+	}
+}
+
+func TestGenericVerifierWrongPadding(t *testing.T) {
+	g := &RSAPSSGenerator{}
+	kp, err := g.Generate(Parameters{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts, err := g.SignerOpts(Parameters{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gs, err := NewGenericSigner(kp.PrivateKey(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature, err := gs.Sign(context.Background(), []byte("foobar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gv, err := NewGenericVerifier(kp.PublicKey(), crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := gv.Verify([]byte("foobar"), signature); err == nil {
+		t.Fatal("expected PSS signature not to verify as PKCS#1 v1.5")
+	}
+
+	gv, err = NewGenericVerifier(kp.PublicKey(), &rsa.PSSOptions{Hash: crypto.SHA256, SaltLength: rsa.PSSSaltLengthEqualsHash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := gv.Verify([]byte("foobar"), signature); err != nil {
+		t.Fatal(err)
+	}
+}
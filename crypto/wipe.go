@@ -0,0 +1,12 @@
+package crypto
+
+import "math/big"
+
+// wipeInt overwrites the words backing x and sets it to zero.
+func wipeInt(x *big.Int) {
+	if x == nil {
+		return
+	}
+	clear(x.Bits())
+	x.SetInt64(0)
+}
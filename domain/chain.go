@@ -0,0 +1,104 @@
+package domain
+
+import (
+	"encoding/base64"
+	"strconv"
+	"strings"
+)
+
+const (
+	CHAIN_BREAK_FORMAT            = "FORMAT"
+	CHAIN_BREAK_COUNTER_GAP       = "COUNTER_GAP"
+	CHAIN_BREAK_PREVIOUS_MISMATCH = "PREVIOUS_SIGNATURE_MISMATCH"
+	CHAIN_BREAK_INVALID_SIGNATURE = "INVALID_SIGNATURE"
+)
+
+// ChainLink is a signature together with the extended data it was computed on
+// (`<counter>_<data>_<last_signature>`).
+type ChainLink struct {
+	Signature  string
+	SignedData string
+}
+
+// ChainBreak describes the first link of a signature chain failing verification.
+type ChainBreak struct {
+	// Counter is the counter the broken link was expected to have.
+	Counter uint
+	// Reason is one of the CHAIN_BREAK_* constants.
+	Reason string
+}
+
+// ParseSignedData splits a `<counter>_<data>_<last_signature>` string into its parts.
+// Data can contain underscores, base64 encoded signatures cannot.
+func ParseSignedData(signedData string) (uint, string, string, bool) {
+	first := strings.Index(signedData, "_")
+	last := strings.LastIndex(signedData, "_")
+	if first < 0 || first == last {
+		return 0, "", "", false
+	}
+	counter, err := strconv.ParseUint(signedData[:first], 10, 0)
+	if err != nil {
+		return 0, "", "", false
+	}
+	return uint(counter), signedData[first+1 : last], signedData[last+1:], true
+}
+
+// ChainVerifier checks the links of a signature chain one at a time, in counter order from counter 0, so that
+// a chain can be verified as it is read. Each signed data must have the `<counter>_<data>_<last_signature>` format,
+// counters must have no gaps, each link must embed the previous signature (base64(device.id) for counter 0)
+// and every signature must verify.
+type ChainVerifier struct {
+	device            SigningDevice
+	counter           uint
+	previousSignature string
+}
+
+func NewChainVerifier(device SigningDevice) *ChainVerifier {
+	return &ChainVerifier{
+		device:            device,
+		previousSignature: base64.StdEncoding.EncodeToString([]byte(device.ID().String())),
+	}
+}
+
+// Length returns the number of links verified so far.
+func (v *ChainVerifier) Length() uint {
+	return v.counter
+}
+
+// Next checks link, the one expected to have counter Length(). It returns the break if the link is broken,
+// after which the verifier must not be used any longer.
+func (v *ChainVerifier) Next(link ChainLink) (*ChainBreak, error) {
+	counter, _, lastSignature, ok := ParseSignedData(link.SignedData)
+	if !ok {
+		return &ChainBreak{Counter: v.counter, Reason: CHAIN_BREAK_FORMAT}, nil
+	}
+	if counter != v.counter {
+		return &ChainBreak{Counter: v.counter, Reason: CHAIN_BREAK_COUNTER_GAP}, nil
+	}
+	if lastSignature != v.previousSignature {
+		return &ChainBreak{Counter: v.counter, Reason: CHAIN_BREAK_PREVIOUS_MISMATCH}, nil
+	}
+	valid, err := v.device.Verify(link.SignedData, link.Signature)
+	if err != nil {
+		return nil, err
+	}
+	if !valid {
+		return &ChainBreak{Counter: v.counter, Reason: CHAIN_BREAK_INVALID_SIGNATURE}, nil
+	}
+
+	v.previousSignature = link.Signature
+	v.counter++
+	return nil, nil
+}
+
+// VerifyChain walks links, which must start from counter 0 and be sorted by counter, with a ChainVerifier.
+// It returns the first broken link, nil if the whole chain is valid.
+func VerifyChain(device SigningDevice, links []ChainLink) (*ChainBreak, error) {
+	verifier := NewChainVerifier(device)
+	for _, link := range links {
+		if chainBreak, err := verifier.Next(link); chainBreak != nil || err != nil {
+			return chainBreak, err
+		}
+	}
+	return nil, nil
+}
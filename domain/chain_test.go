@@ -0,0 +1,95 @@
+package domain
+
+import (
+	"context"
+	"testing"
+
+	mycrypto "github.com/casell/signing-service-challenge/crypto"
+	"github.com/google/uuid"
+)
+
+func TestParseSignedData(t *testing.T) {
+	counter, data, lastSignature, ok := ParseSignedData("12_foo_bar_c2lnbmF0dXJl")
+	if !ok {
+		t.Fatal("expected signed data to be parsed")
+	}
+	if counter != 12 || data != "foo_bar" || lastSignature != "c2lnbmF0dXJl" {
+		t.Fatalf("unexpected parsing result: %d %s %s", counter, data, lastSignature)
+	}
+
+	for _, signedData := range []string{"", "12", "12_foo", "a_foo_bar", "-1_foo_bar"} {
+		if _, _, _, ok := ParseSignedData(signedData); ok {
+			t.Fatalf("expected %q not to be parsed", signedData)
+		}
+	}
+}
+
+func signChain(t *testing.T, d SigningDevice, length int) []ChainLink {
+	links := make([]ChainLink, 0, length)
+	for i := 0; i < length; i++ {
+		record, err := d.Sign(context.Background(), "test_data")
+		signature, signedData := record.Signature, record.SignedData
+		if err != nil {
+			t.Fatal("unexpected error signing", err)
+		}
+		links = append(links, ChainLink{Signature: signature, SignedData: signedData})
+	}
+	return links
+}
+
+func TestVerifyChain(t *testing.T) {
+	d, err := defaultDeviceFactory.New(uuid.Nil, STATUS_ACTIVE, "ECC", nil, mycrypto.Parameters{})
+	if err != nil {
+		t.Fatal("unexpected error creating device", err)
+	}
+
+	links := signChain(t, d, 5)
+
+	chainBreak, err := VerifyChain(d, links)
+	if err != nil {
+		t.Fatal("unexpected error verifying chain", err)
+	}
+	if chainBreak != nil {
+		t.Fatalf("expected chain to be valid, got %v", chainBreak)
+	}
+
+	chainBreak, err = VerifyChain(d, links[:0])
+	if err != nil || chainBreak != nil {
+		t.Fatalf("expected empty chain to be valid, got %v, %v", chainBreak, err)
+	}
+}
+
+func TestVerifyChainBroken(t *testing.T) {
+	d, err := defaultDeviceFactory.New(uuid.Nil, STATUS_ACTIVE, "ED25519", nil, mycrypto.Parameters{})
+	if err != nil {
+		t.Fatal("unexpected error creating device", err)
+	}
+
+	links := signChain(t, d, 4)
+	other := signChain(t, d, 1)
+
+	tests := []struct {
+		name     string
+		links    []ChainLink
+		expected ChainBreak
+	}{
+		{"format", []ChainLink{links[0], {Signature: links[1].Signature, SignedData: "garbage"}}, ChainBreak{1, CHAIN_BREAK_FORMAT}},
+		{"gap", []ChainLink{links[0], links[2]}, ChainBreak{1, CHAIN_BREAK_COUNTER_GAP}},
+		{"not from zero", links[1:], ChainBreak{0, CHAIN_BREAK_COUNTER_GAP}},
+		{"previous", []ChainLink{links[0], links[1], {Signature: other[0].Signature, SignedData: "2_test_" + other[0].Signature}}, ChainBreak{2, CHAIN_BREAK_PREVIOUS_MISMATCH}},
+		{"signature", []ChainLink{links[0], {Signature: links[0].Signature, SignedData: links[1].SignedData}}, ChainBreak{1, CHAIN_BREAK_INVALID_SIGNATURE}},
+	}
+
+	for _, test := range tests {
+		chainBreak, err := VerifyChain(d, test.links)
+		if err != nil {
+			t.Fatalf("%s: unexpected error verifying chain %v", test.name, err)
+		}
+		if chainBreak == nil {
+			t.Fatalf("%s: expected chain to be broken", test.name)
+		}
+		if *chainBreak != test.expected {
+			t.Fatalf("%s: expected break %v, got %v", test.name, test.expected, *chainBreak)
+		}
+	}
+}
@@ -1,9 +1,13 @@
 package domain
 
 import (
+	"context"
+	"crypto"
 	"encoding/base64"
 	"fmt"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	mycrypto "github.com/casell/signing-service-challenge/crypto"
 	"github.com/google/uuid"
@@ -12,12 +16,21 @@ import (
 type Device struct {
 	id                 uuid.UUID
 	signatureAlgorithm string
-	label              *string
+	profile            atomic.Pointer[DeviceProfile]
+	parameters         mycrypto.Parameters
 	signatureCounter   uint
 	lastSignatureB64   string
+	lastSignedAt       time.Time
 	signer             mycrypto.Signer
+	signerOpts         crypto.SignerOpts
 	keyPair            mycrypto.KeyPair
-	lock               *sync.RWMutex
+	status             DeviceStatus
+	createdAt          time.Time
+	// events is notified of the creation, signatures and transitions once committed, it can be nil.
+	events EventPublisher
+	lock   *sync.RWMutex
+	// profileLock serializes profile updates, readers load the profile without locking.
+	profileLock *sync.Mutex
 }
 
 func (d *Device) ID() uuid.UUID {
@@ -25,35 +38,230 @@ func (d *Device) ID() uuid.UUID {
 }
 
 func (d *Device) Label() *string {
-	return d.label
+	return d.profile.Load().Label
 }
 
 func (d *Device) SignatureAlgorithm() string {
 	return d.signatureAlgorithm
 }
 
+func (d *Device) CreatedAt() time.Time {
+	return d.createdAt
+}
+
+func (d *Device) Parameters() mycrypto.Parameters {
+	return d.parameters
+}
+
 func (d *Device) KeyPair() mycrypto.KeyPair {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
 	return d.keyPair
 }
 
+func (d *Device) Status() DeviceStatus {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	return d.status
+}
+
+func (d *Device) Padding() (string, int) {
+	return mycrypto.PaddingScheme(d.KeyPair().PublicKey(), d.signerOpts)
+}
+
 func (d *Device) CounterAndLastSignature() (uint, string) {
 	d.lock.RLock()
 	defer d.lock.RUnlock()
 	return d.signatureCounter, d.lastSignatureB64
 }
 
-func (d *Device) Sign(dataToBeSigned string) (string, string, error) {
+// LastSignedAt is zero for devices which never signed.
+func (d *Device) LastSignedAt() time.Time {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	return d.lastSignedAt
+}
+
+func (d *Device) State() DeviceState {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	return d.state()
+}
+
+// state returns the device state, the caller must hold the lock.
+func (d *Device) state() DeviceState {
+	profile := d.Profile()
+	return DeviceState{
+		ID:                 d.id,
+		SignatureAlgorithm: d.signatureAlgorithm,
+		Label:              profile.Label,
+		Metadata:           profile.Metadata,
+		Revision:           profile.Revision,
+		Parameters:         d.parameters,
+		SignatureCounter:   d.signatureCounter,
+		LastSignatureB64:   d.lastSignatureB64,
+		LastSignedAt:       d.lastSignedAt,
+		KeyPair:            d.keyPair,
+		Status:             d.status,
+		CreatedAt:          d.createdAt,
+	}
+}
+
+func (d *Device) Sign(ctx context.Context, dataToBeSigned string) (SignatureRecord, error) {
+	return d.SignAndCommit(ctx, dataToBeSigned, nil)
+}
+
+// SignAndCommit signs like Sign, but hands the record and the advanced state to commit before applying them.
+// The device is left untouched if commit fails, so the counter only moves once the outcome is persisted.
+// A ctx done while waiting for the device lock skips the signature altogether.
+func (d *Device) SignAndCommit(ctx context.Context, dataToBeSigned string, commit func(record SignatureRecord, state DeviceState) error) (SignatureRecord, error) {
+	var commitBatch func(records []SignatureRecord, state DeviceState) error
+	if commit != nil {
+		commitBatch = func(records []SignatureRecord, state DeviceState) error {
+			return commit(records[0], state)
+		}
+	}
+	records, err := d.SignBatchAndCommit(ctx, []string{dataToBeSigned}, commitBatch)
+	if err != nil {
+		return SignatureRecord{}, err
+	}
+	return records[0], nil
+}
+
+// SignBatchAndCommit signs every item in order under a single hold of the device lock, each signature chained
+// to the previous one with consecutive counters. The records and the final state are handed to commit at once:
+// if signing any item or commit fails, the device is left untouched and no record is returned.
+func (d *Device) SignBatchAndCommit(ctx context.Context, dataToBeSigned []string, commit func(records []SignatureRecord, state DeviceState) error) ([]SignatureRecord, error) {
 	d.lock.Lock()
 	defer d.lock.Unlock()
-	extendedDataToBeSigned := fmt.Sprintf("%d_%s_%s", d.signatureCounter, dataToBeSigned, d.lastSignatureB64)
-	signature, err := d.signer.Sign([]byte(extendedDataToBeSigned))
-	if err != nil {
-		return "", "", err
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if d.status != STATUS_ACTIVE {
+		return nil, ErrDeviceNotActive{deviceID: d.id.String(), status: d.status}
+	}
+
+	counter, lastSignatureB64 := d.signatureCounter, d.lastSignatureB64
+	records := make([]SignatureRecord, 0, len(dataToBeSigned))
+	for _, data := range dataToBeSigned {
+		extendedDataToBeSigned := fmt.Sprintf("%d_%s_%s", counter, data, lastSignatureB64)
+		signature, err := d.signer.Sign(ctx, []byte(extendedDataToBeSigned))
+		if err != nil {
+			return nil, err
+		}
+		lastSignatureB64 = base64.StdEncoding.EncodeToString(signature)
+
+		records = append(records, SignatureRecord{
+			Counter:    counter,
+			Data:       data,
+			SignedData: extendedDataToBeSigned,
+			Signature:  lastSignatureB64,
+			Timestamp:  time.Now().UTC(),
+		})
+		counter++
+	}
+	if len(records) == 0 {
+		return records, nil
+	}
+	lastSignedAt := records[len(records)-1].Timestamp
+
+	if commit != nil {
+		state := d.state()
+		state.SignatureCounter = counter
+		state.LastSignatureB64 = lastSignatureB64
+		state.LastSignedAt = lastSignedAt
+		if err := commit(records, state); err != nil {
+			return nil, err
+		}
+	}
+
+	d.signatureCounter = counter
+	d.lastSignatureB64 = lastSignatureB64
+	d.lastSignedAt = lastSignedAt
+
+	// Published under the lock, so that events follow the counter order.
+	if d.events != nil {
+		for i := range records {
+			d.events.Publish(SignatureCreatedEvent(d.id, &records[i]))
+		}
+	}
+
+	return records, nil
+}
+
+func (d *Device) CreateAndCommit(ctx context.Context, commit func(state DeviceState) error) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := commit(d.state()); err != nil {
+		return err
 	}
-	b64signature := base64.StdEncoding.EncodeToString(signature)
 
-	d.signatureCounter++
-	d.lastSignatureB64 = b64signature
+	// Published under the lock, so that the device is announced before its first signature.
+	if d.events != nil {
+		d.events.Publish(DeviceCreatedEvent(d.id, d.status))
+	}
+	return nil
+}
+
+func (d *Device) Transition(ctx context.Context, to DeviceStatus) error {
+	return d.TransitionAndCommit(ctx, to, nil)
+}
 
-	return b64signature, extendedDataToBeSigned, nil
+// TransitionAndCommit leaves the device untouched if commit fails, the private key of a decommissioned device
+// is only wiped once the public only state is persisted.
+func (d *Device) TransitionAndCommit(ctx context.Context, to DeviceStatus, commit func(state DeviceState) error) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if !to.IsValid() {
+		return ErrInvalidStatus{status: to}
+	}
+	if !d.status.CanTransitionTo(to) {
+		return ErrInvalidTransition{deviceID: d.id.String(), from: d.status, to: to}
+	}
+
+	state := d.state()
+	state.Status = to
+	if to == STATUS_DECOMMISSIONED {
+		state.KeyPair = d.keyPair.PublicOnly()
+	}
+	if commit != nil {
+		if err := commit(state); err != nil {
+			return err
+		}
+	}
+
+	if to == STATUS_DECOMMISSIONED {
+		d.keyPair.Discard()
+		d.keyPair = state.KeyPair
+		d.signer = nil
+	}
+	d.status = to
+	if d.events != nil {
+		d.events.Publish(StatusChangedEvent(d.id, to))
+	}
+	return nil
+}
+
+func (d *Device) Verify(signedData string, signatureB64 string) (bool, error) {
+	signature, err := base64.StdEncoding.DecodeString(signatureB64)
+	if err != nil {
+		return false, nil
+	}
+	verifier, err := mycrypto.NewGenericVerifier(d.KeyPair().PublicKey(), d.signerOpts)
+	if err != nil {
+		return false, err
+	}
+	if err := verifier.Verify([]byte(signedData), signature); err != nil {
+		if _, ok := err.(mycrypto.ErrInvalidSignature); ok {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
 }
@@ -1,9 +1,12 @@
 package domain
 
 import (
+	"context"
 	"crypto"
+	"crypto/ed25519"
 	"crypto/rsa"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"slices"
 	"strconv"
@@ -11,6 +14,7 @@ import (
 	"sync"
 	"testing"
 
+	mycrypto "github.com/casell/signing-service-challenge/crypto"
 	"github.com/google/uuid"
 )
 
@@ -18,7 +22,7 @@ var defaultDeviceFactory = NewDefaultDeviceFactory()
 
 func TestNewDevice(t *testing.T) {
 	label := "foo"
-	d, err := defaultDeviceFactory.New("RSA", &label)
+	d, err := defaultDeviceFactory.New(uuid.Nil, STATUS_ACTIVE, "RSA", &label, mycrypto.Parameters{})
 
 	if err != nil {
 		t.Fatal("unexpected error creating device", err)
@@ -50,15 +54,51 @@ func TestNewDevice(t *testing.T) {
 	}
 }
 
+func TestNewDeviceWithID(t *testing.T) {
+	id := uuid.New()
+	d, err := defaultDeviceFactory.New(id, STATUS_ACTIVE, "ECC", nil, mycrypto.Parameters{})
+	if err != nil {
+		t.Fatal("unexpected error creating device", err)
+	}
+
+	if d.ID() != id {
+		t.Fatalf("expected device id to be: %v, got %v", id, d.ID())
+	}
+
+	_, lastSignature := d.CounterAndLastSignature()
+	if lastSignature != base64.StdEncoding.EncodeToString([]byte(id.String())) {
+		t.Fatalf("expected device last signature to be base64(%s), got %s", id, lastSignature)
+	}
+}
+
 func TestNewDeviceInvalidAlgo(t *testing.T) {
-	d, err := defaultDeviceFactory.New("foo", nil)
+	d, err := defaultDeviceFactory.New(uuid.Nil, STATUS_ACTIVE, "foo", nil, mycrypto.Parameters{})
 	if err == nil {
 		t.Fatalf("expected invalid algorithm error, got %v", d)
 	}
 }
 
+func TestNewDeviceParameters(t *testing.T) {
+	d, err := defaultDeviceFactory.New(uuid.Nil, STATUS_ACTIVE, "ECC", nil, mycrypto.Parameters{Curve: "P-256", Hash: "SHA-512"})
+	if err != nil {
+		t.Fatal("unexpected error creating device", err)
+	}
+
+	expected := mycrypto.Parameters{Curve: "P-256", Hash: "SHA-512"}
+	if d.Parameters() != expected {
+		t.Fatalf("expected device parameters to be: %v, got %v", expected, d.Parameters())
+	}
+}
+
+func TestNewDeviceInvalidParameters(t *testing.T) {
+	d, err := defaultDeviceFactory.New(uuid.Nil, STATUS_ACTIVE, "RSA", nil, mycrypto.Parameters{KeySize: 512})
+	if err == nil {
+		t.Fatalf("expected invalid parameters error, got %v", d)
+	}
+}
+
 func TestRestoreDeviceInvalidAlgo(t *testing.T) {
-	d, err := defaultDeviceFactory.Restore(uuid.UUID{}, "foo", nil, 0, "", nil, nil)
+	d, err := defaultDeviceFactory.Restore(DeviceState{ID: uuid.UUID{}, SignatureAlgorithm: "foo"})
 	if err == nil {
 		t.Fatalf("expected invalid algorithm error, got %v", d)
 	}
@@ -66,21 +106,13 @@ func TestRestoreDeviceInvalidAlgo(t *testing.T) {
 
 func TestRestoreDevice(t *testing.T) {
 	label := "foo"
-	d, err := defaultDeviceFactory.New("RSA", &label)
+	d, err := defaultDeviceFactory.New(uuid.Nil, STATUS_ACTIVE, "RSA", &label, mycrypto.Parameters{})
 	if err != nil {
 		t.Fatal("unexpected error creating device", err)
 	}
 	counter, lastSignatureB64 := d.CounterAndLastSignature()
 
-	rd, err := defaultDeviceFactory.Restore(
-		d.ID(),
-		d.SignatureAlgorithm(),
-		d.Label(),
-		counter,
-		lastSignatureB64,
-		d.KeyPair(),
-		&sync.RWMutex{},
-	)
+	rd, err := defaultDeviceFactory.Restore(d.State())
 
 	if err != nil {
 		t.Fatal("unexpected error restoring device", err)
@@ -90,10 +122,18 @@ func TestRestoreDevice(t *testing.T) {
 		t.Fatalf("expected device algorithm to be: %v, got %v", d.SignatureAlgorithm(), rd.SignatureAlgorithm())
 	}
 
+	if d.Parameters() != rd.Parameters() {
+		t.Fatalf("expected device parameters to be: %v, got %v", d.Parameters(), rd.Parameters())
+	}
+
 	if d.Label() != rd.Label() {
 		t.Fatalf("expected device label to be: %v, got %v", d.Label(), rd.Label())
 	}
 
+	if d.CreatedAt().IsZero() || !d.CreatedAt().Equal(rd.CreatedAt()) {
+		t.Fatalf("expected device creation time to be: %v, got %v", d.CreatedAt(), rd.CreatedAt())
+	}
+
 	rcounter, rlastSignatureB64 := rd.CounterAndLastSignature()
 
 	if counter != rcounter {
@@ -134,7 +174,7 @@ func getSignedDataMetadata(signature, s string) (*signedDataMetadata, error) {
 }
 
 func TestSign(t *testing.T) {
-	d, err := defaultDeviceFactory.New("RSA", nil)
+	d, err := defaultDeviceFactory.New(uuid.Nil, STATUS_ACTIVE, "RSA", nil, mycrypto.Parameters{})
 	if err != nil {
 		t.Fatal("unexpected error creating device", err)
 	}
@@ -150,7 +190,8 @@ func TestSign(t *testing.T) {
 
 	for i := 0; i < requestsNo; i++ {
 		go func(i int, wg *sync.WaitGroup, out chan *signedDataMetadata) {
-			signature, dataToBeSigned, err := d.Sign("test")
+			record, err := d.Sign(context.Background(), "test")
+			signature, dataToBeSigned := record.Signature, record.SignedData
 			if err != nil {
 				out <- &signedDataMetadata{err: fmt.Errorf("iteration %d: %v", i, err)}
 			}
@@ -204,6 +245,132 @@ func TestSign(t *testing.T) {
 	}
 }
 
+func TestState(t *testing.T) {
+	label := "foo"
+	d, err := defaultDeviceFactory.New(uuid.Nil, STATUS_ACTIVE, "ECC", &label, mycrypto.Parameters{})
+	if err != nil {
+		t.Fatal("unexpected error creating device", err)
+	}
+	record, err := d.Sign(context.Background(), "test")
+	if err != nil {
+		t.Fatal("unexpected error signing", err)
+	}
+
+	state := d.State()
+
+	if state.ID != d.ID() || state.SignatureAlgorithm != d.SignatureAlgorithm() || state.Label != d.Label() || state.Parameters != d.Parameters() {
+		t.Fatalf("expected state to match device, got %v", state)
+	}
+	if state.SignatureCounter != 1 || state.LastSignatureB64 != record.Signature {
+		t.Fatalf("expected state counter 1 and last signature %s, got %d and %s", record.Signature, state.SignatureCounter, state.LastSignatureB64)
+	}
+	if !state.KeyPair.Equal(d.KeyPair()) {
+		t.Fatalf("expected state keypair to be %s, got %s", d.KeyPair(), state.KeyPair)
+	}
+}
+
+func TestSignRecord(t *testing.T) {
+	d, err := defaultDeviceFactory.New(uuid.Nil, STATUS_ACTIVE, "ECC", nil, mycrypto.Parameters{})
+	if err != nil {
+		t.Fatal("unexpected error creating device", err)
+	}
+	if !d.LastSignedAt().IsZero() {
+		t.Fatal("expected a new device to have never signed, got", d.LastSignedAt())
+	}
+
+	for i := uint(0); i < 2; i++ {
+		record, err := d.Sign(context.Background(), "test")
+		if err != nil {
+			t.Fatal("unexpected error signing", err)
+		}
+		if record.Counter != i {
+			t.Fatalf("expected record counter to be %d, got %d", i, record.Counter)
+		}
+		if record.Data != "test" {
+			t.Fatalf("expected record data to be test, got %s", record.Data)
+		}
+		if record.Timestamp.IsZero() {
+			t.Fatal("expected record timestamp to be set")
+		}
+		if _, lastSignature := d.CounterAndLastSignature(); lastSignature != record.Signature {
+			t.Fatalf("expected device last signature to be %s, got %s", record.Signature, lastSignature)
+		}
+		if !d.LastSignedAt().Equal(record.Timestamp) {
+			t.Fatalf("expected device last signed at to be %v, got %v", record.Timestamp, d.LastSignedAt())
+		}
+	}
+}
+
+func TestSignED25519(t *testing.T) {
+	d, err := defaultDeviceFactory.New(uuid.Nil, STATUS_ACTIVE, "ED25519", nil, mycrypto.Parameters{})
+	if err != nil {
+		t.Fatal("unexpected error creating device", err)
+	}
+
+	record, err := d.Sign(context.Background(), "test")
+	signature, dataToBeSigned := record.Signature, record.SignedData
+	if err != nil {
+		t.Fatal("unexpected error signing", err)
+	}
+
+	signatureBytes, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		t.Fatal("unexpected error decoding signature", err)
+	}
+
+	if !ed25519.Verify(d.KeyPair().PublicKey().(ed25519.PublicKey), []byte(dataToBeSigned), signatureBytes) {
+		t.Fatalf("signature %s is not valid for %s", signature, dataToBeSigned)
+	}
+
+	rd, err := defaultDeviceFactory.Restore(d.State())
+	if err != nil {
+		t.Fatal("unexpected error restoring device", err)
+	}
+
+	if _, err := rd.Sign(context.Background(), "test"); err != nil {
+		t.Fatal("unexpected error signing with restored device", err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	for _, algorithm := range []string{"RSA", "RSA-PSS", "ECC", "ED25519"} {
+		d, err := defaultDeviceFactory.New(uuid.Nil, STATUS_ACTIVE, algorithm, nil, mycrypto.Parameters{})
+		if err != nil {
+			t.Fatal("unexpected error creating device", err)
+		}
+
+		record, err := d.Sign(context.Background(), "test")
+		signature, dataToBeSigned := record.Signature, record.SignedData
+		if err != nil {
+			t.Fatal("unexpected error signing", err)
+		}
+
+		valid, err := d.Verify(dataToBeSigned, signature)
+		if err != nil {
+			t.Fatal("unexpected error verifying", err)
+		}
+		if !valid {
+			t.Fatalf("%s: expected signature %s to be valid for %s", algorithm, signature, dataToBeSigned)
+		}
+
+		valid, err = d.Verify(dataToBeSigned+"_", signature)
+		if err != nil {
+			t.Fatal("unexpected error verifying", err)
+		}
+		if valid {
+			t.Fatalf("%s: expected signature %s not to be valid for tampered data", algorithm, signature)
+		}
+
+		valid, err = d.Verify(dataToBeSigned, "not base64")
+		if err != nil {
+			t.Fatal("unexpected error verifying", err)
+		}
+		if valid {
+			t.Fatalf("%s: expected malformed signature not to be valid", algorithm)
+		}
+	}
+}
+
 func verifyRSA(hashalgo crypto.Hash, dataToBeSigned string, devicepublickey crypto.PublicKey, lastSignature string) error {
 	hasher := hashalgo.New()
 	_, _ = hasher.Write([]byte(dataToBeSigned))
@@ -217,3 +384,95 @@ func verifyRSA(hashalgo crypto.Hash, dataToBeSigned string, devicepublickey cryp
 
 	return rsa.VerifyPKCS1v15(publicKey, hashalgo, hasher.Sum(nil), lastSignatureBytes)
 }
+
+func TestSignAndCommit(t *testing.T) {
+	d, err := defaultDeviceFactory.New(uuid.Nil, STATUS_ACTIVE, "ECC", nil, mycrypto.Parameters{})
+	if err != nil {
+		t.Fatal("unexpected error creating device", err)
+	}
+	initialCounter, initialSignature := d.CounterAndLastSignature()
+
+	commitErr := fmt.Errorf("commit error")
+	_, err = d.SignAndCommit(context.Background(), "test", func(record SignatureRecord, state DeviceState) error {
+		if state.SignatureCounter != record.Counter+1 || state.LastSignatureB64 != record.Signature {
+			t.Fatalf("expected committed state to follow record %v, got %v", record, state)
+		}
+		return commitErr
+	})
+	if err != commitErr {
+		t.Fatalf("expected commit error, got %v", err)
+	}
+	if counter, lastSignature := d.CounterAndLastSignature(); counter != initialCounter || lastSignature != initialSignature {
+		t.Fatalf("expected device to be unchanged, got counter %d and last signature %s", counter, lastSignature)
+	}
+
+	record, err := d.SignAndCommit(context.Background(), "test", func(SignatureRecord, DeviceState) error { return nil })
+	if err != nil {
+		t.Fatal("unexpected error signing", err)
+	}
+	if counter, lastSignature := d.CounterAndLastSignature(); counter != initialCounter+1 || lastSignature != record.Signature {
+		t.Fatalf("expected device to advance, got counter %d and last signature %s", counter, lastSignature)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := d.Sign(ctx, "test"); err != context.Canceled {
+		t.Fatalf("expected canceled error, got %v", err)
+	}
+	if counter, _ := d.CounterAndLastSignature(); counter != initialCounter+1 {
+		t.Fatalf("expected device counter to stay %d, got %d", initialCounter+1, counter)
+	}
+}
+
+func TestSignBatchAndCommit(t *testing.T) {
+	d, err := defaultDeviceFactory.New(uuid.Nil, STATUS_ACTIVE, "ED25519", nil, mycrypto.Parameters{})
+	if err != nil {
+		t.Fatal("unexpected error creating device", err)
+	}
+	first, err := d.Sign(context.Background(), "single")
+	if err != nil {
+		t.Fatal("unexpected error signing", err)
+	}
+
+	commitErr := fmt.Errorf("commit error")
+	if _, err := d.SignBatchAndCommit(context.Background(), []string{"a", "b"}, func([]SignatureRecord, DeviceState) error { return commitErr }); err != commitErr {
+		t.Fatalf("expected commit error, got %v", err)
+	}
+	if counter, _ := d.CounterAndLastSignature(); counter != 1 {
+		t.Fatalf("expected a failed batch to leave the counter at 1, got %d", counter)
+	}
+
+	records, err := d.SignBatchAndCommit(context.Background(), []string{"a", "b", "c"}, func(records []SignatureRecord, state DeviceState) error {
+		if state.SignatureCounter != 4 || state.LastSignatureB64 != records[2].Signature {
+			t.Fatalf("expected committed state to follow the last record, got %v", state)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal("unexpected error signing", err)
+	}
+	if len(records) != 3 {
+		t.Fatal("expected 3 records, got", records)
+	}
+	if counter, lastSignature := d.CounterAndLastSignature(); counter != 4 || lastSignature != records[2].Signature {
+		t.Fatalf("expected device to advance past the batch, got counter %d and last signature %s", counter, lastSignature)
+	}
+
+	links := []ChainLink{{Signature: first.Signature, SignedData: first.SignedData}}
+	for i, record := range records {
+		if record.Counter != uint(i+1) {
+			t.Fatalf("expected consecutive counters, got %d at %d", record.Counter, i)
+		}
+		links = append(links, ChainLink{Signature: record.Signature, SignedData: record.SignedData})
+	}
+	if brk, err := VerifyChain(d, links); brk != nil || err != nil {
+		t.Fatalf("expected the batch to extend the chain, got %v, %v", brk, err)
+	}
+
+	if err := d.Transition(context.Background(), STATUS_DISABLED); err != nil {
+		t.Fatal("unexpected error disabling device", err)
+	}
+	if _, err := d.SignBatchAndCommit(context.Background(), []string{"a"}, nil); !errors.As(err, &ErrDeviceNotActive{}) {
+		t.Fatal("expected not active error, got", err)
+	}
+}
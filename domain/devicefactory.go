@@ -3,55 +3,94 @@ package domain
 import (
 	"crypto"
 	"encoding/base64"
+	"fmt"
+	"maps"
 	"sync"
+	"time"
 
 	mycrypto "github.com/casell/signing-service-challenge/crypto"
 	"github.com/google/uuid"
 )
 
-type DefaultDeviceFactory struct{}
+type DefaultDeviceFactory struct {
+	events EventPublisher
+}
 
 func NewDefaultDeviceFactory() *DefaultDeviceFactory {
 	return &DefaultDeviceFactory{}
 }
 
-func (f *DefaultDeviceFactory) Restore(id uuid.UUID, signatureAlgorithm string, label *string, signatureCounter uint, lastSignatureB64 string, keyPair mycrypto.KeyPair, lock *sync.RWMutex) (SigningDevice, error) {
-	if ok := mycrypto.IsValidAlgorithm(signatureAlgorithm); !ok {
-		return nil, &ErrInvalidAlgorithm{signatureAlgorithm}
+// NewEventDeviceFactory returns a factory of devices publishing their creation, signatures and transitions to events.
+func NewEventDeviceFactory(events EventPublisher) *DefaultDeviceFactory {
+	return &DefaultDeviceFactory{events: events}
+}
+
+// Restore rebuilds a device from its state. Only DECOMMISSIONED devices may come without a private key.
+func (f *DefaultDeviceFactory) Restore(state DeviceState) (SigningDevice, error) {
+	g, err := mycrypto.FromString(state.SignatureAlgorithm)
+	if err != nil {
+		return nil, &ErrInvalidAlgorithm{state.SignatureAlgorithm}
+	}
+	if !state.Status.IsValid() {
+		return nil, ErrInvalidStatus{status: state.Status}
 	}
-	s, err := mycrypto.NewGenericSigner(keyPair.PrivateKey(), crypto.SHA256)
+	if state.KeyPair.PrivateKey() == nil && state.Status != STATUS_DECOMMISSIONED {
+		return nil, fmt.Errorf("device %s is %s but has no private key", state.ID, state.Status)
+	}
+	s, opts, err := newSigner(g, state.Parameters, state.KeyPair)
 	if err != nil {
 		return nil, err
 	}
-	return &Device{
-		id:                 id,
-		signatureAlgorithm: signatureAlgorithm,
-		label:              label,
-		signatureCounter:   signatureCounter,
-		lastSignatureB64:   lastSignatureB64,
+	d := &Device{
+		id:                 state.ID,
+		signatureAlgorithm: state.SignatureAlgorithm,
+		parameters:         state.Parameters,
+		signatureCounter:   state.SignatureCounter,
+		lastSignatureB64:   state.LastSignatureB64,
+		lastSignedAt:       state.LastSignedAt,
 		signer:             s,
-		keyPair:            keyPair,
-		lock:               lock,
-	}, nil
+		signerOpts:         opts,
+		keyPair:            state.KeyPair,
+		status:             state.Status,
+		createdAt:          state.CreatedAt,
+		events:             f.events,
+		lock:               &sync.RWMutex{},
+		profileLock:        &sync.Mutex{},
+	}
+	d.profile.Store(&DeviceProfile{Label: state.Label, Metadata: maps.Clone(state.Metadata), Revision: state.Revision})
+	return d, nil
 }
 
-func (*DefaultDeviceFactory) New(signatureAlgorithm string, label *string) (SigningDevice, error) {
+// New creates a device with a fresh key pair. The device is identified by id, or by a random UUID when id is uuid.Nil.
+// A device can only be created INITIALIZED or ACTIVE.
+func (f *DefaultDeviceFactory) New(id uuid.UUID, status DeviceStatus, signatureAlgorithm string, label *string, parameters mycrypto.Parameters) (SigningDevice, error) {
 	g, err := mycrypto.FromString(signatureAlgorithm)
 	if err != nil {
 		return nil, &ErrInvalidAlgorithm{signatureAlgorithm}
 	}
+	if status != STATUS_INITIALIZED && status != STATUS_ACTIVE {
+		return nil, ErrInvalidStatus{status: status}
+	}
 
-	kp, err := g.Generate()
+	parameters, err = g.ResolveParameters(parameters)
 	if err != nil {
 		return nil, err
 	}
 
-	uniqueId, err := uuid.NewRandom()
+	kp, err := g.Generate(parameters)
 	if err != nil {
 		return nil, err
 	}
 
-	s, err := mycrypto.NewGenericSigner(kp.PrivateKey(), crypto.SHA256)
+	uniqueId := id
+	if uniqueId == uuid.Nil {
+		uniqueId, err = uuid.NewRandom()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	s, opts, err := newSigner(g, parameters, kp)
 	if err != nil {
 		return nil, err
 	}
@@ -59,12 +98,34 @@ func (*DefaultDeviceFactory) New(signatureAlgorithm string, label *string) (Sign
 	d := &Device{
 		id:                 uniqueId,
 		signatureAlgorithm: g.Algorithm(),
-		label:              label,
+		parameters:         parameters,
 		keyPair:            kp,
 		signer:             s,
+		signerOpts:         opts,
 		signatureCounter:   0,
 		lastSignatureB64:   base64.StdEncoding.EncodeToString([]byte(uniqueId.String())),
+		status:             status,
+		createdAt:          time.Now().UTC(),
+		events:             f.events,
 		lock:               &sync.RWMutex{},
+		profileLock:        &sync.Mutex{},
 	}
+	d.profile.Store(&DeviceProfile{Label: label})
 	return d, nil
 }
+
+// newSigner returns a nil Signer, but valid options, for a public only key pair.
+func newSigner(g mycrypto.Generator, parameters mycrypto.Parameters, keyPair mycrypto.KeyPair) (mycrypto.Signer, crypto.SignerOpts, error) {
+	opts, err := g.SignerOpts(parameters)
+	if err != nil {
+		return nil, nil, err
+	}
+	if keyPair.PrivateKey() == nil {
+		return nil, opts, nil
+	}
+	s, err := mycrypto.NewGenericSigner(keyPair.PrivateKey(), opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return s, opts, nil
+}
@@ -0,0 +1,24 @@
+package domain
+
+import (
+	"time"
+
+	mycrypto "github.com/casell/signing-service-challenge/crypto"
+	"github.com/google/uuid"
+)
+
+// DeviceState is the persistable state of a device, as needed to restore it.
+type DeviceState struct {
+	ID                 uuid.UUID
+	SignatureAlgorithm string
+	Label              *string
+	Metadata           map[string]string
+	Revision           uint
+	Parameters         mycrypto.Parameters
+	SignatureCounter   uint
+	LastSignatureB64   string
+	LastSignedAt       time.Time
+	KeyPair            mycrypto.KeyPair
+	Status             DeviceStatus
+	CreatedAt          time.Time
+}
@@ -9,3 +9,58 @@ type ErrInvalidAlgorithm struct {
 func (e ErrInvalidAlgorithm) Error() string {
 	return fmt.Sprintf("invalid algorithm %s", e.algorithm)
 }
+
+// ErrInvalidStatus is returned for an unknown device status.
+type ErrInvalidStatus struct {
+	status DeviceStatus
+}
+
+func (e ErrInvalidStatus) Error() string {
+	return fmt.Sprintf("invalid device status %s", e.status)
+}
+
+// ErrDeviceNotActive is returned when signing with a device which is not ACTIVE.
+type ErrDeviceNotActive struct {
+	deviceID string
+	status   DeviceStatus
+}
+
+func (e ErrDeviceNotActive) Error() string {
+	return fmt.Sprintf("device %s is %s, only %s devices can sign", e.deviceID, e.status, STATUS_ACTIVE)
+}
+
+// ErrInvalidTransition is returned when a device cannot move from its status to the requested one.
+type ErrInvalidTransition struct {
+	deviceID string
+	from     DeviceStatus
+	to       DeviceStatus
+}
+
+func (e ErrInvalidTransition) Error() string {
+	return fmt.Sprintf("device %s cannot go from %s to %s", e.deviceID, e.from, e.to)
+}
+
+// ErrInvalidMetadata is returned for device metadata exceeding the size limits.
+type ErrInvalidMetadata struct {
+	reason string
+}
+
+func (e ErrInvalidMetadata) Error() string {
+	return fmt.Sprintf("invalid metadata: %s", e.reason)
+}
+
+// ErrRevisionMismatch is returned when updating a device profile which changed since it was read.
+type ErrRevisionMismatch struct {
+	deviceID string
+	expected uint
+	actual   uint
+}
+
+func (e ErrRevisionMismatch) Error() string {
+	return fmt.Sprintf("device %s is at revision %d, not %d", e.deviceID, e.actual, e.expected)
+}
+
+// NewErrRevisionMismatch is for the stores which find the profile already updated when they commit it.
+func NewErrRevisionMismatch(deviceID string, expected uint, actual uint) ErrRevisionMismatch {
+	return ErrRevisionMismatch{deviceID: deviceID, expected: expected, actual: actual}
+}
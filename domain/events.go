@@ -0,0 +1,129 @@
+package domain
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	EVENT_DEVICE_CREATED    = "device.created"
+	EVENT_STATUS_CHANGED    = "device.status_changed"
+	EVENT_SIGNATURE_CREATED = "signature.created"
+
+	// DEFAULT_EVENT_HISTORY is how many events an EventBus keeps for subscribers resuming a stream.
+	DEFAULT_EVENT_HISTORY = 1024
+	// EVENT_SUBSCRIPTION_BUFFER is how many events a subscriber can lag behind before being dropped.
+	EVENT_SUBSCRIPTION_BUFFER = 256
+)
+
+// Event is something that happened to a device. Record is set for signatures, Status for the other events.
+type Event struct {
+	// Sequence orders the events published on a bus, starting from 1.
+	Sequence uint64
+	Type     string
+	DeviceID uuid.UUID
+	Time     time.Time
+	Status   DeviceStatus
+	Record   *SignatureRecord
+}
+
+// EventPublisher receives the events of the devices. Publish must not block.
+type EventPublisher interface {
+	Publish(event Event)
+}
+
+// DeviceCreatedEvent returns the event announcing the device deviceID was stored, in status.
+func DeviceCreatedEvent(deviceID uuid.UUID, status DeviceStatus) Event {
+	return Event{Type: EVENT_DEVICE_CREATED, DeviceID: deviceID, Time: time.Now().UTC(), Status: status}
+}
+
+// SignatureCreatedEvent returns the event announcing record, signed by the device deviceID.
+func SignatureCreatedEvent(deviceID uuid.UUID, record *SignatureRecord) Event {
+	return Event{Type: EVENT_SIGNATURE_CREATED, DeviceID: deviceID, Time: record.Timestamp, Record: record}
+}
+
+// StatusChangedEvent returns the event announcing the device deviceID moved to status.
+func StatusChangedEvent(deviceID uuid.UUID, status DeviceStatus) Event {
+	return Event{Type: EVENT_STATUS_CHANGED, DeviceID: deviceID, Time: time.Now().UTC(), Status: status}
+}
+
+// EventBus is an in-process EventPublisher fanning events out to subscribers.
+// The latest events are kept, so that a subscriber can resume from the last sequence it saw.
+type EventBus struct {
+	lock        sync.Mutex
+	sequence    uint64
+	history     []Event
+	historySize int
+	subscribers map[*Subscription]struct{}
+}
+
+// Subscription delivers the events published after it was taken. C is closed when the subscription is
+// closed, or when the subscriber lags too far behind: it can then subscribe again from the last sequence it saw.
+type Subscription struct {
+	C      <-chan Event
+	events chan Event
+	bus    *EventBus
+}
+
+func NewEventBus(historySize int) *EventBus {
+	return &EventBus{
+		historySize: historySize,
+		subscribers: make(map[*Subscription]struct{}),
+	}
+}
+
+// Publish assigns the next sequence to event and delivers it to the subscribers.
+func (b *EventBus) Publish(event Event) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.sequence++
+	event.Sequence = b.sequence
+	if b.historySize > 0 {
+		if len(b.history) == b.historySize {
+			b.history = b.history[1:]
+		}
+		b.history = append(b.history, event)
+	}
+	for s := range b.subscribers {
+		select {
+		case s.events <- event:
+		default:
+			delete(b.subscribers, s)
+			close(s.events)
+		}
+	}
+}
+
+// Subscribe returns a subscription to the events published from now on, together with the kept events
+// published after sequence. Nothing is lost or repeated between the two.
+func (b *EventBus) Subscribe(sequence uint64) (*Subscription, []Event) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	events := make(chan Event, EVENT_SUBSCRIPTION_BUFFER)
+	s := &Subscription{C: events, events: events, bus: b}
+	b.subscribers[s] = struct{}{}
+
+	missed := []Event{}
+	for _, event := range b.history {
+		if event.Sequence > sequence {
+			missed = append(missed, event)
+		}
+	}
+	return s, missed
+}
+
+// Close stops the delivery of events to s.
+func (s *Subscription) Close() {
+	b := s.bus
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if _, ok := b.subscribers[s]; ok {
+		delete(b.subscribers, s)
+		close(s.events)
+	}
+}
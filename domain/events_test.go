@@ -0,0 +1,92 @@
+package domain
+
+import (
+	"context"
+	"testing"
+
+	mycrypto "github.com/casell/signing-service-challenge/crypto"
+	"github.com/google/uuid"
+)
+
+func TestEventBusResume(t *testing.T) {
+	bus := NewEventBus(2)
+	for i := 0; i < 3; i++ {
+		bus.Publish(Event{Type: EVENT_DEVICE_CREATED})
+	}
+
+	s, missed := bus.Subscribe(1)
+	defer s.Close()
+	if len(missed) != 2 || missed[0].Sequence != 2 || missed[1].Sequence != 3 {
+		t.Fatalf("expected the kept events after 1, got %v", missed)
+	}
+
+	bus.Publish(Event{Type: EVENT_STATUS_CHANGED})
+	if event := <-s.C; event.Sequence != 4 || event.Type != EVENT_STATUS_CHANGED {
+		t.Fatalf("expected event 4 to be delivered, got %v", event)
+	}
+}
+
+func TestEventBusSlowSubscriber(t *testing.T) {
+	bus := NewEventBus(0)
+	s, _ := bus.Subscribe(0)
+	for i := 0; i < EVENT_SUBSCRIPTION_BUFFER+1; i++ {
+		bus.Publish(Event{})
+	}
+
+	received := 0
+	for range s.C {
+		received++
+	}
+	if received != EVENT_SUBSCRIPTION_BUFFER {
+		t.Fatalf("expected the buffered events before the subscription is dropped, got %d", received)
+	}
+	// Closing a dropped subscription is harmless.
+	s.Close()
+}
+
+func TestDeviceEvents(t *testing.T) {
+	bus := NewEventBus(DEFAULT_EVENT_HISTORY)
+	s, _ := bus.Subscribe(0)
+	defer s.Close()
+
+	d, err := NewEventDeviceFactory(bus).New(uuid.Nil, STATUS_ACTIVE, "ED25519", nil, mycrypto.Parameters{})
+	if err != nil {
+		t.Fatal("unexpected error creating device", err)
+	}
+	if err := d.CreateAndCommit(context.Background(), func(DeviceState) error { return nil }); err != nil {
+		t.Fatal("unexpected error committing device", err)
+	}
+	if _, err := d.SignBatchAndCommit(context.Background(), []string{"a", "b"}, nil); err != nil {
+		t.Fatal("unexpected error signing", err)
+	}
+	if err := d.Transition(context.Background(), STATUS_DISABLED); err != nil {
+		t.Fatal("unexpected error disabling device", err)
+	}
+
+	if event := <-s.C; event.Type != EVENT_DEVICE_CREATED || event.DeviceID != d.ID() || event.Status != STATUS_ACTIVE {
+		t.Fatalf("expected device created event, got %v", event)
+	}
+	for counter := uint(0); counter < 2; counter++ {
+		event := <-s.C
+		if event.Type != EVENT_SIGNATURE_CREATED || event.DeviceID != d.ID() || event.Record == nil || event.Record.Counter != counter {
+			t.Fatalf("expected signature %d event, got %v", counter, event)
+		}
+	}
+	if event := <-s.C; event.Type != EVENT_STATUS_CHANGED || event.Status != STATUS_DISABLED {
+		t.Fatalf("expected status changed event, got %v", event)
+	}
+
+	// Failed commits are not announced.
+	failed := func(DeviceState) error { return context.Canceled }
+	if err := d.TransitionAndCommit(context.Background(), STATUS_ACTIVE, failed); err == nil {
+		t.Fatal("expected commit error")
+	}
+	if err := d.CreateAndCommit(context.Background(), failed); err == nil {
+		t.Fatal("expected commit error")
+	}
+	select {
+	case event := <-s.C:
+		t.Fatalf("unexpected event %v", event)
+	default:
+	}
+}
@@ -0,0 +1,139 @@
+package domain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+)
+
+const (
+	// MERKLE_ROOT_PREFIX marks the data of a signature issued on a Merkle root: `merkle-sha256:<base64(root)>`.
+	MERKLE_ROOT_PREFIX = "merkle-sha256:"
+
+	MERKLE_SIBLING_LEFT  = "LEFT"
+	MERKLE_SIBLING_RIGHT = "RIGHT"
+
+	merkleLeafTag = 0x00
+	merkleNodeTag = 0x01
+)
+
+// MerkleProofStep is a sibling hash on the path from a leaf to the root.
+type MerkleProofStep struct {
+	Hash []byte
+	// Position is the side of the sibling, one of the MERKLE_SIBLING_* constants.
+	Position string
+}
+
+// MerkleTree is a SHA-256 Merkle tree. Leaves and inner nodes are hashed with different prefixes,
+// so that an inner node cannot pass for a leaf; a node without sibling is promoted to the next level as is.
+type MerkleTree struct {
+	levels [][][]byte
+}
+
+// NewMerkleTree builds the tree over leaves, which must not be empty.
+func NewMerkleTree(leaves []string) *MerkleTree {
+	level := make([][]byte, len(leaves))
+	for i, leaf := range leaves {
+		level[i] = merkleLeafHash(leaf)
+	}
+	levels := [][][]byte{level}
+	for len(level) > 1 {
+		next := make([][]byte, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+				continue
+			}
+			next = append(next, merkleNodeHash(level[i], level[i+1]))
+		}
+		levels = append(levels, next)
+		level = next
+	}
+	return &MerkleTree{levels: levels}
+}
+
+// Root returns the root hash of the tree.
+func (t *MerkleTree) Root() []byte {
+	return t.levels[len(t.levels)-1][0]
+}
+
+// Proof returns the inclusion proof of the leaf at index, from the leaf up to the root.
+func (t *MerkleTree) Proof(index int) []MerkleProofStep {
+	proof := []MerkleProofStep{}
+	for _, level := range t.levels[:len(t.levels)-1] {
+		sibling := index ^ 1
+		if sibling < len(level) {
+			position := MERKLE_SIBLING_RIGHT
+			if sibling < index {
+				position = MERKLE_SIBLING_LEFT
+			}
+			proof = append(proof, MerkleProofStep{Hash: level[sibling], Position: position})
+		}
+		index /= 2
+	}
+	return proof
+}
+
+// VerifyMerkleProof checks that proof leads from data to root.
+func VerifyMerkleProof(data string, proof []MerkleProofStep, root []byte) bool {
+	hash := merkleLeafHash(data)
+	for _, step := range proof {
+		switch step.Position {
+		case MERKLE_SIBLING_LEFT:
+			hash = merkleNodeHash(step.Hash, hash)
+		case MERKLE_SIBLING_RIGHT:
+			hash = merkleNodeHash(hash, step.Hash)
+		default:
+			return false
+		}
+	}
+	return bytes.Equal(hash, root)
+}
+
+// MerkleRootData returns the data signed for a Merkle root.
+func MerkleRootData(root []byte) string {
+	return MERKLE_ROOT_PREFIX + base64.StdEncoding.EncodeToString(root)
+}
+
+// ParseMerkleRootData is the inverse of MerkleRootData.
+func ParseMerkleRootData(data string) ([]byte, bool) {
+	encoded, ok := strings.CutPrefix(data, MERKLE_ROOT_PREFIX)
+	if !ok {
+		return nil, false
+	}
+	root, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(root) != sha256.Size {
+		return nil, false
+	}
+	return root, true
+}
+
+// VerifyInclusion checks that data was aggregated in the Merkle root signed by link:
+// the signed data must carry a root, proof must lead from data to it and the signature must verify.
+func VerifyInclusion(device SigningDevice, data string, proof []MerkleProofStep, link ChainLink) (bool, error) {
+	_, signed, _, ok := ParseSignedData(link.SignedData)
+	if !ok {
+		return false, nil
+	}
+	root, ok := ParseMerkleRootData(signed)
+	if !ok || !VerifyMerkleProof(data, proof, root) {
+		return false, nil
+	}
+	return device.Verify(link.SignedData, link.Signature)
+}
+
+func merkleLeafHash(data string) []byte {
+	h := sha256.New()
+	h.Write([]byte{merkleLeafTag})
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func merkleNodeHash(left []byte, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{merkleNodeTag})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
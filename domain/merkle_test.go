@@ -0,0 +1,86 @@
+package domain
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	mycrypto "github.com/casell/signing-service-challenge/crypto"
+	"github.com/google/uuid"
+)
+
+func TestMerkleProof(t *testing.T) {
+	for size := 1; size <= 9; size++ {
+		leaves := make([]string, size)
+		for i := range leaves {
+			leaves[i] = "tx" + strconv.Itoa(i)
+		}
+		tree := NewMerkleTree(leaves)
+		for i, leaf := range leaves {
+			proof := tree.Proof(i)
+			if !VerifyMerkleProof(leaf, proof, tree.Root()) {
+				t.Fatalf("expected proof of leaf %d of %d to verify", i, size)
+			}
+			if VerifyMerkleProof(leaf+"x", proof, tree.Root()) {
+				t.Fatalf("expected proof of leaf %d of %d not to verify other data", i, size)
+			}
+			if len(proof) > 0 {
+				proof[0].Position = map[string]string{MERKLE_SIBLING_LEFT: MERKLE_SIBLING_RIGHT, MERKLE_SIBLING_RIGHT: MERKLE_SIBLING_LEFT}[proof[0].Position]
+				if VerifyMerkleProof(leaf, proof, tree.Root()) {
+					t.Fatalf("expected swapped proof of leaf %d of %d not to verify", i, size)
+				}
+			}
+		}
+	}
+}
+
+func TestMerkleLeafIsNotANode(t *testing.T) {
+	tree := NewMerkleTree([]string{"a", "b"})
+	node := string(merkleLeafHash("a")) + string(merkleLeafHash("b"))
+	if VerifyMerkleProof(node, nil, tree.Root()) {
+		t.Fatal("expected the concatenation of the children not to pass for a leaf")
+	}
+}
+
+func TestParseMerkleRootData(t *testing.T) {
+	root := NewMerkleTree([]string{"a"}).Root()
+	parsed, ok := ParseMerkleRootData(MerkleRootData(root))
+	if !ok || string(parsed) != string(root) {
+		t.Fatalf("expected root to be parsed back, got %v", parsed)
+	}
+	for _, data := range []string{"", "a", MERKLE_ROOT_PREFIX + "!", MERKLE_ROOT_PREFIX + "YQ=="} {
+		if _, ok := ParseMerkleRootData(data); ok {
+			t.Fatalf("expected %q not to be parsed", data)
+		}
+	}
+}
+
+func TestVerifyInclusion(t *testing.T) {
+	d, err := defaultDeviceFactory.New(uuid.Nil, STATUS_ACTIVE, "ECC", nil, mycrypto.Parameters{})
+	if err != nil {
+		t.Fatal("unexpected error creating device", err)
+	}
+	leaves := []string{"a", "b", "c"}
+	tree := NewMerkleTree(leaves)
+	record, err := d.Sign(context.Background(), MerkleRootData(tree.Root()))
+	if err != nil {
+		t.Fatal("unexpected error signing", err)
+	}
+	link := ChainLink{Signature: record.Signature, SignedData: record.SignedData}
+
+	valid, err := VerifyInclusion(d, "c", tree.Proof(2), link)
+	if err != nil || !valid {
+		t.Fatalf("expected inclusion to verify, got %v, %v", valid, err)
+	}
+	if valid, _ := VerifyInclusion(d, "c", tree.Proof(1), link); valid {
+		t.Fatal("expected the proof of another leaf not to verify")
+	}
+
+	plain, err := d.Sign(context.Background(), "c")
+	if err != nil {
+		t.Fatal("unexpected error signing", err)
+	}
+	if valid, _ := VerifyInclusion(d, "c", nil, ChainLink{Signature: plain.Signature, SignedData: plain.SignedData}); valid {
+		t.Fatal("expected a signature not on a root not to verify")
+	}
+}
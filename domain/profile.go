@@ -0,0 +1,73 @@
+package domain
+
+import (
+	"context"
+	"maps"
+)
+
+const (
+	MAX_METADATA_ENTRIES      = 32
+	MAX_METADATA_KEY_LENGTH   = 64
+	MAX_METADATA_VALUE_LENGTH = 256
+)
+
+// DeviceProfile holds the descriptive attributes of a device, which can be edited without touching the signing state.
+// Revision grows by one on every update and backs optimistic concurrency between editors.
+type DeviceProfile struct {
+	Label    *string
+	Metadata map[string]string
+	Revision uint
+}
+
+// ValidateMetadata checks metadata against the size limits.
+func ValidateMetadata(metadata map[string]string) error {
+	if len(metadata) > MAX_METADATA_ENTRIES {
+		return ErrInvalidMetadata{reason: "too many entries"}
+	}
+	for k, v := range metadata {
+		if k == "" || len(k) > MAX_METADATA_KEY_LENGTH {
+			return ErrInvalidMetadata{reason: "invalid key length for " + k}
+		}
+		if len(v) > MAX_METADATA_VALUE_LENGTH {
+			return ErrInvalidMetadata{reason: "value too long for " + k}
+		}
+	}
+	return nil
+}
+
+// Profile returns a copy of the profile, it never waits for updates in flight.
+func (d *Device) Profile() DeviceProfile {
+	profile := *d.profile.Load()
+	profile.Metadata = maps.Clone(profile.Metadata)
+	return profile
+}
+
+func (d *Device) UpdateProfile(ctx context.Context, revision uint, label *string, metadata map[string]string) (DeviceProfile, error) {
+	return d.UpdateProfileAndCommit(ctx, revision, label, metadata, nil)
+}
+
+// UpdateProfileAndCommit replaces label and metadata if the profile is still at revision, handing the new profile
+// to commit before applying it. The profile is not guarded by the device lock, so signatures go on meanwhile.
+func (d *Device) UpdateProfileAndCommit(ctx context.Context, revision uint, label *string, metadata map[string]string, commit func(profile DeviceProfile) error) (DeviceProfile, error) {
+	d.profileLock.Lock()
+	defer d.profileLock.Unlock()
+	if err := ctx.Err(); err != nil {
+		return DeviceProfile{}, err
+	}
+	current := d.profile.Load()
+	if revision != current.Revision {
+		return DeviceProfile{}, ErrRevisionMismatch{deviceID: d.id.String(), expected: revision, actual: current.Revision}
+	}
+	if err := ValidateMetadata(metadata); err != nil {
+		return DeviceProfile{}, err
+	}
+
+	profile := DeviceProfile{Label: label, Metadata: maps.Clone(metadata), Revision: current.Revision + 1}
+	if commit != nil {
+		if err := commit(profile); err != nil {
+			return DeviceProfile{}, err
+		}
+	}
+	d.profile.Store(&profile)
+	return d.Profile(), nil
+}
@@ -0,0 +1,97 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	mycrypto "github.com/casell/signing-service-challenge/crypto"
+	"github.com/google/uuid"
+)
+
+func TestUpdateProfile(t *testing.T) {
+	label := "label"
+	d, err := defaultDeviceFactory.New(uuid.Nil, STATUS_ACTIVE, "ED25519", &label, mycrypto.Parameters{})
+	if err != nil {
+		t.Fatal("unexpected error creating device", err)
+	}
+	ctx := context.Background()
+
+	newLabel := "new label"
+	metadata := map[string]string{"site": "berlin"}
+	profile, err := d.UpdateProfile(ctx, 0, &newLabel, metadata)
+	if err != nil {
+		t.Fatal("unexpected error updating profile", err)
+	}
+	if profile.Revision != 1 || *profile.Label != newLabel || profile.Metadata["site"] != "berlin" {
+		t.Fatal("unexpected profile", profile)
+	}
+	metadata["site"] = "hamburg"
+	if d.Profile().Metadata["site"] != "berlin" {
+		t.Fatal("expected the device to keep its own copy of metadata")
+	}
+	if state := d.State(); state.Revision != 1 || *state.Label != newLabel {
+		t.Fatal("expected the state to carry the profile, got", state)
+	}
+
+	if _, err := d.UpdateProfile(ctx, 0, nil, nil); !errors.As(err, &ErrRevisionMismatch{}) {
+		t.Fatal("expected revision mismatch error, got", err)
+	}
+	failed := errors.New("commit failed")
+	if _, err := d.UpdateProfileAndCommit(ctx, 1, nil, nil, func(DeviceProfile) error { return failed }); err != failed {
+		t.Fatal("expected commit error, got", err)
+	}
+	if profile := d.Profile(); profile.Revision != 1 || *profile.Label != newLabel {
+		t.Fatal("expected a failed commit to leave the profile untouched, got", profile)
+	}
+}
+
+func TestValidateMetadata(t *testing.T) {
+	tooMany := map[string]string{}
+	for i := 0; i <= MAX_METADATA_ENTRIES; i++ {
+		tooMany[strings.Repeat("k", i+1)] = "v"
+	}
+	for _, metadata := range []map[string]string{
+		tooMany,
+		{"": "v"},
+		{strings.Repeat("k", MAX_METADATA_KEY_LENGTH+1): "v"},
+		{"k": strings.Repeat("v", MAX_METADATA_VALUE_LENGTH+1)},
+	} {
+		if err := ValidateMetadata(metadata); !errors.As(err, &ErrInvalidMetadata{}) {
+			t.Fatal("expected invalid metadata error, got", err)
+		}
+	}
+	if err := ValidateMetadata(map[string]string{"k": "v"}); err != nil {
+		t.Fatal("unexpected error", err)
+	}
+}
+
+func TestUpdateProfileDoesNotBlockSigning(t *testing.T) {
+	d, err := defaultDeviceFactory.New(uuid.Nil, STATUS_ACTIVE, "ED25519", nil, mycrypto.Parameters{})
+	if err != nil {
+		t.Fatal("unexpected error creating device", err)
+	}
+	ctx := context.Background()
+
+	signed := make(chan error)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_, err := d.UpdateProfileAndCommit(ctx, 0, nil, nil, func(DeviceProfile) error {
+			// The update is held open until the signature below completes.
+			_, err := d.SignAndCommit(ctx, "data", func(SignatureRecord, DeviceState) error { return nil })
+			signed <- err
+			return nil
+		})
+		if err != nil {
+			t.Error("unexpected error updating profile", err)
+		}
+	}()
+	if err := <-signed; err != nil {
+		t.Fatal("unexpected error signing", err)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,12 @@
+package domain
+
+import "time"
+
+// SignatureRecord is the outcome of a signing operation, as kept in the signature journal.
+type SignatureRecord struct {
+	Counter    uint
+	Data       string
+	SignedData string
+	Signature  string
+	Timestamp  time.Time
+}
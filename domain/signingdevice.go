@@ -1,6 +1,9 @@
 package domain
 
 import (
+	"context"
+	"time"
+
 	mycrypto "github.com/casell/signing-service-challenge/crypto"
 	"github.com/google/uuid"
 )
@@ -9,11 +12,37 @@ type SigningDevice interface {
 	ID() uuid.UUID
 	SignatureAlgorithm() string
 	Label() *string
+	Profile() DeviceProfile
+	// UpdateProfile replaces label and metadata, see UpdateProfileAndCommit.
+	UpdateProfile(ctx context.Context, revision uint, label *string, metadata map[string]string) (DeviceProfile, error)
+	// UpdateProfileAndCommit fails with ErrRevisionMismatch unless the profile is at revision, signing is not blocked meanwhile.
+	UpdateProfileAndCommit(ctx context.Context, revision uint, label *string, metadata map[string]string, commit func(profile DeviceProfile) error) (DeviceProfile, error)
+	Parameters() mycrypto.Parameters
+	// CreatedAt is zero for devices created before it was recorded.
+	CreatedAt() time.Time
 	KeyPair() mycrypto.KeyPair
+	Padding() (string, int)
 	CounterAndLastSignature() (uint, string)
-	Sign(dataToBeSigned string) (string, string, error)
+	// LastSignedAt is the time of the last signature, zero if the device never signed.
+	LastSignedAt() time.Time
+	State() DeviceState
+	// CreateAndCommit hands the state of the new device to commit, which stores it, and announces the device once stored.
+	CreateAndCommit(ctx context.Context, commit func(state DeviceState) error) error
+	Status() DeviceStatus
+	// Transition moves the device to status to, see TransitionAndCommit.
+	Transition(ctx context.Context, to DeviceStatus) error
+	// TransitionAndCommit moves the device to status to, handing the resulting state to commit before applying it.
+	// Moving to DECOMMISSIONED discards the private key: the state handed to commit has a public only key pair.
+	TransitionAndCommit(ctx context.Context, to DeviceStatus, commit func(state DeviceState) error) error
+	// Sign fails with ErrDeviceNotActive unless the device is ACTIVE.
+	Sign(ctx context.Context, dataToBeSigned string) (SignatureRecord, error)
+	SignAndCommit(ctx context.Context, dataToBeSigned string, commit func(record SignatureRecord, state DeviceState) error) (SignatureRecord, error)
+	// SignBatchAndCommit signs the items in order holding the device lock once, all of them or none.
+	SignBatchAndCommit(ctx context.Context, dataToBeSigned []string, commit func(records []SignatureRecord, state DeviceState) error) ([]SignatureRecord, error)
+	Verify(signedData string, signatureB64 string) (bool, error)
 }
 
 type SigningDeviceFactory interface {
-	New(signatureAlgorithm string, label *string) (SigningDevice, error)
+	New(id uuid.UUID, status DeviceStatus, signatureAlgorithm string, label *string, parameters mycrypto.Parameters) (SigningDevice, error)
+	Restore(state DeviceState) (SigningDevice, error)
 }
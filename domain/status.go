@@ -0,0 +1,40 @@
+package domain
+
+// DeviceStatus is the lifecycle state of a device.
+type DeviceStatus string
+
+const (
+	// STATUS_INITIALIZED devices are provisioned but cannot sign until activated.
+	STATUS_INITIALIZED DeviceStatus = "INITIALIZED"
+	// STATUS_ACTIVE devices sign.
+	STATUS_ACTIVE DeviceStatus = "ACTIVE"
+	// STATUS_DISABLED devices are temporarily barred from signing, e.g. a register reported lost.
+	STATUS_DISABLED DeviceStatus = "DISABLED"
+	// STATUS_DECOMMISSIONED devices are retired for good: the private key is discarded,
+	// the public key is kept to verify the signatures already issued.
+	STATUS_DECOMMISSIONED DeviceStatus = "DECOMMISSIONED"
+)
+
+// transitions lists the statuses reachable from each status, DECOMMISSIONED is terminal.
+var transitions = map[DeviceStatus][]DeviceStatus{
+	STATUS_INITIALIZED:    {STATUS_ACTIVE, STATUS_DECOMMISSIONED},
+	STATUS_ACTIVE:         {STATUS_DISABLED, STATUS_DECOMMISSIONED},
+	STATUS_DISABLED:       {STATUS_ACTIVE, STATUS_DECOMMISSIONED},
+	STATUS_DECOMMISSIONED: {},
+}
+
+// IsValid checks s to be a known status.
+func (s DeviceStatus) IsValid() bool {
+	_, ok := transitions[s]
+	return ok
+}
+
+// CanTransitionTo checks a device in status s to be allowed to move to status to.
+func (s DeviceStatus) CanTransitionTo(to DeviceStatus) bool {
+	for _, v := range transitions[s] {
+		if v == to {
+			return true
+		}
+	}
+	return false
+}
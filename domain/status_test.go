@@ -0,0 +1,133 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	mycrypto "github.com/casell/signing-service-challenge/crypto"
+	"github.com/google/uuid"
+)
+
+func TestNewDeviceStatus(t *testing.T) {
+	for _, status := range []DeviceStatus{STATUS_DISABLED, STATUS_DECOMMISSIONED, "FOO", ""} {
+		if _, err := defaultDeviceFactory.New(uuid.Nil, status, "ED25519", nil, mycrypto.Parameters{}); !errors.As(err, &ErrInvalidStatus{}) {
+			t.Fatalf("expected invalid status error creating %s device, got %v", status, err)
+		}
+	}
+
+	d, err := defaultDeviceFactory.New(uuid.Nil, STATUS_INITIALIZED, "ED25519", nil, mycrypto.Parameters{})
+	if err != nil {
+		t.Fatal("unexpected error creating device", err)
+	}
+	if d.Status() != STATUS_INITIALIZED {
+		t.Fatalf("expected device status to be %s, got %s", STATUS_INITIALIZED, d.Status())
+	}
+	if _, err := d.Sign(context.Background(), "data"); !errors.As(err, &ErrDeviceNotActive{}) {
+		t.Fatal("expected not active error signing with an initialized device, got", err)
+	}
+}
+
+func TestTransition(t *testing.T) {
+	d, err := defaultDeviceFactory.New(uuid.Nil, STATUS_INITIALIZED, "ECC", nil, mycrypto.Parameters{})
+	if err != nil {
+		t.Fatal("unexpected error creating device", err)
+	}
+	ctx := context.Background()
+
+	steps := []struct {
+		to      DeviceStatus
+		allowed bool
+		signs   bool
+	}{
+		{STATUS_DISABLED, false, false},
+		{STATUS_ACTIVE, true, true},
+		{STATUS_ACTIVE, false, true},
+		{STATUS_INITIALIZED, false, true},
+		{STATUS_DISABLED, true, false},
+		{STATUS_ACTIVE, true, true},
+		{"FOO", false, true},
+	}
+	for _, step := range steps {
+		err := d.Transition(ctx, step.to)
+		if step.allowed && err != nil {
+			t.Fatalf("unexpected error moving to %s: %v", step.to, err)
+		}
+		if !step.allowed && err == nil {
+			t.Fatalf("expected error moving to %s", step.to)
+		}
+		_, err = d.Sign(ctx, "data")
+		if step.signs != (err == nil) {
+			t.Fatalf("expected %s device to sign: %v, got %v", d.Status(), step.signs, err)
+		}
+	}
+}
+
+func TestTransitionCommitFailure(t *testing.T) {
+	d, err := defaultDeviceFactory.New(uuid.Nil, STATUS_ACTIVE, "ED25519", nil, mycrypto.Parameters{})
+	if err != nil {
+		t.Fatal("unexpected error creating device", err)
+	}
+
+	commitErr := errors.New("commit error")
+	err = d.TransitionAndCommit(context.Background(), STATUS_DECOMMISSIONED, func(state DeviceState) error {
+		if state.Status != STATUS_DECOMMISSIONED || state.KeyPair.PrivateKey() != nil {
+			t.Fatal("expected a decommissioned state without private key, got", state)
+		}
+		return commitErr
+	})
+	if err != commitErr {
+		t.Fatal("expected commit error, got", err)
+	}
+	if d.Status() != STATUS_ACTIVE || d.KeyPair().PrivateKey() == nil {
+		t.Fatal("expected the device to be untouched")
+	}
+	if _, err := d.Sign(context.Background(), "data"); err != nil {
+		t.Fatal("unexpected error signing", err)
+	}
+}
+
+func TestDecommission(t *testing.T) {
+	d, err := defaultDeviceFactory.New(uuid.Nil, STATUS_ACTIVE, "RSA", nil, mycrypto.Parameters{})
+	if err != nil {
+		t.Fatal("unexpected error creating device", err)
+	}
+	ctx := context.Background()
+
+	record, err := d.Sign(ctx, "data")
+	if err != nil {
+		t.Fatal("unexpected error signing", err)
+	}
+	if err := d.Transition(ctx, STATUS_DECOMMISSIONED); err != nil {
+		t.Fatal("unexpected error decommissioning", err)
+	}
+
+	if d.KeyPair().PrivateKey() != nil {
+		t.Fatal("expected the private key to be discarded")
+	}
+	if _, err := d.Sign(ctx, "data"); !errors.As(err, &ErrDeviceNotActive{}) {
+		t.Fatal("expected not active error signing with a decommissioned device, got", err)
+	}
+	if ok, err := d.Verify(record.SignedData, record.Signature); !ok || err != nil {
+		t.Fatal("expected a decommissioned device to verify its signatures, got", err)
+	}
+	for _, to := range []DeviceStatus{STATUS_ACTIVE, STATUS_DISABLED, STATUS_DECOMMISSIONED} {
+		if err := d.Transition(ctx, to); !errors.As(err, &ErrInvalidTransition{}) {
+			t.Fatalf("expected decommissioned to be terminal moving to %s, got %v", to, err)
+		}
+	}
+
+	r, err := defaultDeviceFactory.Restore(d.State())
+	if err != nil {
+		t.Fatal("unexpected error restoring a decommissioned device", err)
+	}
+	if ok, err := r.Verify(record.SignedData, record.Signature); !ok || err != nil {
+		t.Fatal("expected a restored decommissioned device to verify its signatures, got", err)
+	}
+
+	state := r.State()
+	state.Status = STATUS_ACTIVE
+	if _, err := defaultDeviceFactory.Restore(state); err == nil {
+		t.Fatal("expected error restoring an active device without private key")
+	}
+}
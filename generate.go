@@ -1,3 +1,4 @@
 package main
 
 //go:generate go run github.com/ogen-go/ogen/cmd/ogen@latest -package signingapi --target generated/signingapi --clean openapi/openapi.yaml
+//go:generate go run github.com/bufbuild/buf/cmd/buf@v1.36.0 generate
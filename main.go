@@ -1,19 +1,31 @@
 package main
 
 import (
+	"context"
 	"embed"
+	"fmt"
 	"io/fs"
 	"log"
 	"os"
 	"strconv"
+	"time"
 
 	"github.com/casell/signing-service-challenge/api"
+	"github.com/casell/signing-service-challenge/domain"
+	"github.com/casell/signing-service-challenge/persistence"
 )
 
 const (
-	ListenAddress = ":8080"
-	CorsEnvName   = "CORS_ENABLED"
-	CorsDefault   = false
+	ListenAddress     = ":8080"
+	GRPCListenAddress = ":9090"
+	CorsEnvName       = "CORS_ENABLED"
+	CorsDefault       = false
+	StorageDirEnvName = "STORAGE_DIR"
+
+	BootstrapAPIKeyEnvName = "BOOTSTRAP_API_KEY"
+
+	IdempotencyRetentionEnvName = "IDEMPOTENCY_RETENTION"
+	AggregationWindowEnvName    = "AGGREGATION_WINDOW"
 )
 
 //go:embed openapi/openapi.yaml
@@ -27,7 +39,27 @@ func getCORSFromEnv() (bool, error) {
 	return strconv.ParseBool(cors)
 }
 
+func getIdempotencyRetentionFromEnv() (time.Duration, error) {
+	retention, set := os.LookupEnv(IdempotencyRetentionEnvName)
+	if !set {
+		return persistence.DEFAULT_IDEMPOTENCY_RETENTION, nil
+	}
+	return time.ParseDuration(retention)
+}
+
+func getAggregationWindowFromEnv() (time.Duration, error) {
+	window, set := os.LookupEnv(AggregationWindowEnvName)
+	if !set {
+		return api.DEFAULT_AGGREGATION_WINDOW, nil
+	}
+	return time.ParseDuration(window)
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == APIKeyCommand {
+		os.Exit(runAPIKeyCommand(os.Args[2:]))
+	}
+
 	specFS, err := fs.Sub(spec, "openapi")
 	if err != nil {
 		log.Fatal("Unable to Sub on embedded openapi FS", err)
@@ -38,9 +70,70 @@ func main() {
 		log.Fatalf("Unable to parse %s variable: %v", CorsEnvName, err)
 	}
 
-	server := api.NewServer(ListenAddress, specFS, cors)
+	retention, err := getIdempotencyRetentionFromEnv()
+	if err != nil {
+		log.Fatalf("Unable to parse %s variable: %v", IdempotencyRetentionEnvName, err)
+	}
 
-	if err := server.Run(); err != nil {
+	aggregationWindow, err := getAggregationWindowFromEnv()
+	if err != nil {
+		log.Fatalf("Unable to parse %s variable: %v", AggregationWindowEnvName, err)
+	}
+
+	events := domain.NewEventBus(domain.DEFAULT_EVENT_HISTORY)
+	deviceFactory := domain.NewEventDeviceFactory(events)
+
+	stores, err := openStorage(deviceFactory)
+	if err != nil {
+		log.Fatalf("Unable to open the storage: %v", err)
+	}
+	store, journal, webhooks, apikeys := stores.store, stores.journal, stores.webhooks, stores.apikeys
+
+	bootstrapKey := os.Getenv(BootstrapAPIKeyEnvName)
+	issued, err := api.BootstrapAPIKey(context.Background(), apikeys, bootstrapKey)
+	if err != nil {
+		log.Fatalf("Unable to issue the bootstrap API key: %v", err)
+	}
+	if issued != "" && bootstrapKey == "" {
+		// The key is shown once, on stderr rather than through the logger, so it does not end up in collected logs.
+		log.Printf("No API key issued yet, issued a bootstrap key with scopes %v, printed on stderr", api.API_KEY_SCOPES)
+		fmt.Fprintf(os.Stderr, "Bootstrap API key (shown once, store it now): %s\n", issued)
+	} else if issued != "" {
+		log.Printf("No API key issued yet, issued the key of %s with scopes %v", BootstrapAPIKeyEnvName, api.API_KEY_SCOPES)
+	}
+
+	go persistence.ExpireIdempotencyKeys(context.Background(), store, retention, persistence.IDEMPOTENCY_PURGE_INTERVAL)
+
+	// The REST and gRPC servers share the handler, so that they serve the same jobs and aggregation windows.
+	handler := api.NewDeviceHandlerWithOptions(api.DeviceHandlerOptions{
+		Store:             store,
+		Journal:           journal,
+		DeviceFactory:     deviceFactory,
+		AggregationWindow: aggregationWindow,
+		Webhooks:          webhooks,
+		APIKeys:           apikeys,
+	})
+
+	grpcServer := api.NewGRPCServer(GRPCListenAddress, handler)
+	go func() {
+		if err := grpcServer.Run(); err != nil {
+			log.Fatal("Could not start gRPC server on ", GRPCListenAddress)
+		}
+	}()
+
+	server := api.NewServer(handler, api.ServerOptions{
+		ListenAddress: ListenAddress,
+		Spec:          specFS,
+		CORS:          cors,
+		Events:        events,
+	})
+
+	err = server.Run()
+	// log.Fatal skips deferred calls, the storage is released first.
+	if closeErr := stores.close(); closeErr != nil {
+		log.Printf("Unable to close the storage: %v", closeErr)
+	}
+	if err != nil {
 		log.Fatal("Could not start server on ", ListenAddress)
 	}
 }
@@ -0,0 +1,133 @@
+package persistence
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const apiKeysName = "apikeys"
+
+// APIKey is an issued API key. Only the hash of the key is kept: the key itself is shown once, when issued.
+type APIKey struct {
+	ID        uuid.UUID `json:"id"`
+	Name      string    `json:"name"`
+	Scopes    []string  `json:"scopes"`
+	Hash      string    `json:"hash"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// APIKeyStorage keeps the issued API keys.
+// A missing key is reported as ErrAPIKeyNotFound, except by GetAPIKeyByHash.
+type APIKeyStorage interface {
+	AddAPIKey(ctx context.Context, key APIKey) error
+	// GetAPIKeyByHash returns the key with the given hash, nil if there is none.
+	GetAPIKeyByHash(ctx context.Context, hash string) (*APIKey, error)
+	ListAPIKeys(ctx context.Context) ([]APIKey, error)
+	DeleteAPIKey(ctx context.Context, id uuid.UUID) error
+}
+
+// apiKeyState is the content of a MemoryAPIKeyStore, the keys by ID.
+type apiKeyState map[uuid.UUID]APIKey
+
+// apiKeyChange is a change of an apiKeyState, the only field set tells which.
+type apiKeyChange struct {
+	AddAPIKey    *APIKey    `json:"add_api_key,omitempty"`
+	DeleteAPIKey *uuid.UUID `json:"delete_api_key,omitempty"`
+}
+
+func (c apiKeyChange) check(state *apiKeyState) error {
+	switch {
+	case c.AddAPIKey != nil:
+		for _, stored := range *state {
+			if stored.ID == c.AddAPIKey.ID || stored.Hash == c.AddAPIKey.Hash {
+				return ErrAPIKeyAlreadyExists{keyID: c.AddAPIKey.ID}
+			}
+		}
+	case c.DeleteAPIKey != nil:
+		if _, ok := (*state)[*c.DeleteAPIKey]; !ok {
+			return ErrAPIKeyNotFound{keyID: *c.DeleteAPIKey}
+		}
+	}
+	return nil
+}
+
+func (c apiKeyChange) apply(state *apiKeyState) {
+	switch {
+	case c.AddAPIKey != nil:
+		(*state)[c.AddAPIKey.ID] = *c.AddAPIKey
+	case c.DeleteAPIKey != nil:
+		delete(*state, *c.DeleteAPIKey)
+	}
+}
+
+// MemoryAPIKeyStore is an APIKeyStorage kept in memory.
+type MemoryAPIKeyStore struct {
+	state *loggedState[apiKeyState, apiKeyChange]
+}
+
+func NewMemoryAPIKeyStore() *MemoryAPIKeyStore {
+	return &MemoryAPIKeyStore{state: newLoggedState[apiKeyState, apiKeyChange](make(apiKeyState))}
+}
+
+// FileAPIKeyStore is a MemoryAPIKeyStore saved in a directory: every change is appended to apikeys.log,
+// and every DEFAULT_SNAPSHOT_EVERY changes the whole state is written to apikeys.json and the log truncated.
+type FileAPIKeyStore struct {
+	*MemoryAPIKeyStore
+}
+
+// NewFileAPIKeyStore opens the API key storage saved in dir, creating it if needed.
+func NewFileAPIKeyStore(dir string) (*FileAPIKeyStore, error) {
+	state, err := openLoggedState[apiKeyState, apiKeyChange](dir, apiKeysName, make(apiKeyState))
+	if err != nil {
+		return nil, err
+	}
+	return &FileAPIKeyStore{MemoryAPIKeyStore: &MemoryAPIKeyStore{state: state}}, nil
+}
+
+// Close closes the log, the next open replays it.
+func (f *FileAPIKeyStore) Close() error {
+	return f.state.close()
+}
+
+func (m *MemoryAPIKeyStore) AddAPIKey(ctx context.Context, key APIKey) error {
+	return m.state.update(ctx, apiKeyChange{AddAPIKey: &key})
+}
+
+func (m *MemoryAPIKeyStore) GetAPIKeyByHash(ctx context.Context, hash string) (*APIKey, error) {
+	var found *APIKey
+	m.state.read(func(state *apiKeyState) {
+		for _, key := range *state {
+			if key.Hash == hash {
+				found = &key
+				return
+			}
+		}
+	})
+	return found, nil
+}
+
+// ListAPIKeys returns the keys sorted by creation time.
+func (m *MemoryAPIKeyStore) ListAPIKeys(ctx context.Context) ([]APIKey, error) {
+	var keys []APIKey
+	m.state.read(func(state *apiKeyState) {
+		keys = make([]APIKey, 0, len(*state))
+		for _, key := range *state {
+			keys = append(keys, key)
+		}
+	})
+	slices.SortFunc(keys, func(a, b APIKey) int {
+		if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID.String(), b.ID.String())
+	})
+	return keys, nil
+}
+
+func (m *MemoryAPIKeyStore) DeleteAPIKey(ctx context.Context, id uuid.UUID) error {
+	return m.state.update(ctx, apiKeyChange{DeleteAPIKey: &id})
+}
@@ -0,0 +1,137 @@
+package persistence
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// apiKeyStorages are the APIKeyStorage implementations checked against the same behaviour.
+var apiKeyStorages = []struct {
+	name string
+	new  func(t *testing.T) APIKeyStorage
+}{
+	{"memory", func(t *testing.T) APIKeyStorage { return NewMemoryAPIKeyStore() }},
+	{"file", func(t *testing.T) APIKeyStorage { return newFileAPIKeyStore(t, t.TempDir()) }},
+	{"sql", func(t *testing.T) APIKeyStorage {
+		db := openSQLite(t, filepath.Join(t.TempDir(), "store.db"))
+		db.SetMaxOpenConns(1)
+		return newSQLStore(t, db).APIKeys()
+	}},
+}
+
+func newFileAPIKeyStore(t *testing.T, dir string) *FileAPIKeyStore {
+	s, err := NewFileAPIKeyStore(dir)
+	if err != nil {
+		t.Fatal("Expected nil err opening API key store, got", err)
+	}
+	return s
+}
+
+func addAPIKey(t *testing.T, store APIKeyStorage, hash string, createdAt time.Time) APIKey {
+	key := APIKey{
+		ID:        uuid.New(),
+		Name:      "ci",
+		Scopes:    []string{"sign", "read"},
+		Hash:      hash,
+		CreatedAt: createdAt,
+	}
+	if err := store.AddAPIKey(context.Background(), key); err != nil {
+		t.Fatal("Expected nil ADD err, got", err)
+	}
+	return key
+}
+
+func TestAPIKeyStorage(t *testing.T) {
+	for _, tc := range apiKeyStorages {
+		t.Run(tc.name, func(t *testing.T) {
+			store := tc.new(t)
+			ctx := context.Background()
+			now := time.Now().UTC().Truncate(time.Second)
+
+			second := addAPIKey(t, store, "hash-2", now.Add(time.Second))
+			first := addAPIKey(t, store, "hash-1", now)
+			if err := store.AddAPIKey(ctx, first); !errors.As(err, &ErrAPIKeyAlreadyExists{}) {
+				t.Fatal("Expected already exists err, got", err)
+			}
+			duplicate := APIKey{ID: uuid.New(), Name: "other", Scopes: []string{"read"}, Hash: "hash-1", CreatedAt: now}
+			if err := store.AddAPIKey(ctx, duplicate); !errors.As(err, &ErrAPIKeyAlreadyExists{}) {
+				t.Fatal("Expected already exists err for the same hash, got", err)
+			}
+
+			list, err := store.ListAPIKeys(ctx)
+			if err != nil {
+				t.Fatal("Expected nil err, got", err)
+			}
+			if len(list) != 2 || list[0].ID != first.ID || list[1].ID != second.ID {
+				t.Fatal("Expected the keys by creation time, got", list)
+			}
+
+			got, err := store.GetAPIKeyByHash(ctx, "hash-1")
+			if err != nil {
+				t.Fatal("Expected nil err, got", err)
+			}
+			if got == nil || got.ID != first.ID || got.Name != "ci" || len(got.Scopes) != 2 || got.Scopes[0] != "sign" || !got.CreatedAt.Equal(now) {
+				t.Fatal("Expected the stored key, got", got)
+			}
+			if got, err := store.GetAPIKeyByHash(ctx, "unknown"); err != nil || got != nil {
+				t.Fatal("Expected no key for an unknown hash, got", got, err)
+			}
+
+			if err := store.DeleteAPIKey(ctx, first.ID); err != nil {
+				t.Fatal("Expected nil DELETE err, got", err)
+			}
+			if got, _ := store.GetAPIKeyByHash(ctx, "hash-1"); got != nil {
+				t.Fatal("Expected the key to be revoked, got", got)
+			}
+			if err := store.DeleteAPIKey(ctx, first.ID); !errors.As(err, &ErrAPIKeyNotFound{}) {
+				t.Fatal("Expected not found DELETE err, got", err)
+			}
+		})
+	}
+}
+
+func TestFileAPIKeyStoreReopen(t *testing.T) {
+	dir := t.TempDir()
+	key := addAPIKey(t, newFileAPIKeyStore(t, dir), "hash", time.Now().UTC())
+
+	got, err := newFileAPIKeyStore(t, dir).GetAPIKeyByHash(context.Background(), "hash")
+	if err != nil || got == nil || got.ID != key.ID || got.Name != key.Name {
+		t.Fatal("Expected the key to be restored, got", got, err)
+	}
+}
+
+func TestFileAPIKeyStoreSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	s := newFileAPIKeyStore(t, dir)
+	s.state.snapshotEvery = 2
+	ctx := context.Background()
+	now := time.Now().UTC()
+	revoked := addAPIKey(t, s, "revoked", now)
+	if s.state.log.entries != 1 {
+		t.Fatal("Expected a log entry, got entries", s.state.log.entries)
+	}
+	kept := addAPIKey(t, s, "kept", now)
+	if s.state.log.entries != 0 {
+		t.Fatal("Expected the log to be truncated, got entries", s.state.log.entries)
+	}
+	if err := s.DeleteAPIKey(ctx, revoked.ID); err != nil {
+		t.Fatal("Expected nil DELETE err, got", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal("Expected nil CLOSE err, got", err)
+	}
+
+	// Restored from apikeys.json, then the log.
+	keys, err := newFileAPIKeyStore(t, dir).ListAPIKeys(ctx)
+	if err != nil {
+		t.Fatal("Expected nil err, got", err)
+	}
+	if len(keys) != 1 || keys[0].ID != kept.ID {
+		t.Fatal("Expected the key left, got", keys)
+	}
+}
@@ -0,0 +1,95 @@
+package persistence
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/casell/signing-service-challenge/domain"
+)
+
+// journalOf returns the SignatureJournal of one of the storages.
+func journalOf(t *testing.T, store Storage) SignatureJournal {
+	switch s := store.(type) {
+	case *MemoryStore:
+		return s.Journal()
+	case *FileStore:
+		return s.Journal()
+	case *SQLStore:
+		return s.Journal()
+	}
+	t.Fatalf("Expected a known storage, got %T", store)
+	return nil
+}
+
+func TestSignBatchAndCommit(t *testing.T) {
+	for _, tc := range storages {
+		t.Run(tc.name, func(t *testing.T) {
+			store := tc.new(t)
+			d := addIdempotencyDevice(t, store)
+			ctx := context.Background()
+
+			if _, err := store.SignAndCommit(ctx, d.ID(), "single", ""); err != nil {
+				t.Fatal("Expected nil err, got", err)
+			}
+			records, err := store.SignBatchAndCommit(ctx, d.ID(), []string{"a", "b", "c"})
+			if err != nil {
+				t.Fatal("Expected nil err, got", err)
+			}
+			if len(records) != 3 {
+				t.Fatal("Expected 3 records, got", records)
+			}
+			for i, record := range records {
+				if record.Counter != uint(i+1) || record.Data != []string{"a", "b", "c"}[i] {
+					t.Fatalf("Expected record %d to sign %q, got %v", i+1, []string{"a", "b", "c"}[i], record)
+				}
+			}
+
+			stored, err := store.Get(ctx, d.ID())
+			if err != nil {
+				t.Fatal("Expected nil err, got", err)
+			}
+			if counter, lastSignature := stored.CounterAndLastSignature(); counter != 4 || lastSignature != records[2].Signature {
+				t.Fatalf("Expected counter 4 and the last batch signature, got %d and %s", counter, lastSignature)
+			}
+			checkRestored(t, store, journalOf(t, store), stored)
+		})
+	}
+}
+
+func TestSignBatchAndCommitNotActive(t *testing.T) {
+	for _, tc := range storages {
+		t.Run(tc.name, func(t *testing.T) {
+			store := tc.new(t)
+			d := addIdempotencyDevice(t, store)
+			ctx := context.Background()
+
+			if _, err := store.SetStatus(ctx, d.ID(), domain.STATUS_DISABLED); err != nil {
+				t.Fatal("Expected nil err, got", err)
+			}
+			if _, err := store.SignBatchAndCommit(ctx, d.ID(), []string{"a", "b"}); !errors.As(err, &domain.ErrDeviceNotActive{}) {
+				t.Fatal("Expected not active err, got", err)
+			}
+			if _, total, err := journalOf(t, store).List(ctx, d.ID(), 0, 10); err != nil || total != 0 {
+				t.Fatalf("Expected an empty journal, got %d records and %v", total, err)
+			}
+		})
+	}
+}
+
+func TestFileStoreSignBatchReopen(t *testing.T) {
+	dir := t.TempDir()
+	f := newFileStore(t, dir, 0)
+	d := addIdempotencyDevice(t, f)
+
+	if _, err := f.SignBatchAndCommit(context.Background(), d.ID(), []string{"a", "b", "c"}); err != nil {
+		t.Fatal("Expected nil err, got", err)
+	}
+	stored, err := f.Get(context.Background(), d.ID())
+	if err != nil {
+		t.Fatal("Expected nil err, got", err)
+	}
+	// Reopen without closing, so that the batch is replayed from the log.
+	rf := newFileStore(t, dir, 0)
+	checkRestored(t, rf, rf.Journal(), stored)
+}
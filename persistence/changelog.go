@@ -0,0 +1,242 @@
+package persistence
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// changeLog is an append-only log of JSON lines, each fsynced before append returns.
+// Its owner folds it into a snapshot every so often and truncates it.
+type changeLog struct {
+	name    string
+	file    *os.File
+	entries int
+}
+
+// openChangeLog opens (or creates) the log dir/name, handing each line to replay,
+// and leaves it open for appending. A torn last line, left by a crash in the middle of a write, is discarded.
+func openChangeLog(dir string, name string, replay func(line []byte) error) (*changeLog, error) {
+	file, err := os.OpenFile(filepath.Join(dir, name), os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	l := &changeLog{name: name, file: file}
+	if err := l.replay(replay); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *changeLog) replay(replay func(line []byte) error) error {
+	reader := bufio.NewReader(l.file)
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := replay(bytes.TrimSpace(line)); err != nil {
+			var syntaxErr *json.SyntaxError
+			if _, peekErr := reader.Peek(1); errors.As(err, &syntaxErr) && errors.Is(peekErr, io.EOF) {
+				break
+			}
+			return fmt.Errorf("%s: corrupted log at offset %d: %w", l.name, offset, err)
+		}
+		offset += int64(len(line))
+		l.entries++
+	}
+
+	if err := l.file.Truncate(offset); err != nil {
+		return err
+	}
+	_, err := l.file.Seek(offset, io.SeekStart)
+	return err
+}
+
+// append writes entry as a line and fsyncs it.
+// On failure the log is cut back, so that a partial line does not end up in the middle of it.
+func (l *changeLog) append(entry any) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	offset, err := l.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := l.file.Write(line); err != nil {
+		l.rewind(offset)
+		return err
+	}
+	if err := l.file.Sync(); err != nil {
+		l.rewind(offset)
+		return err
+	}
+	l.entries++
+	return nil
+}
+
+// rewind drops whatever was written to the log after offset.
+func (l *changeLog) rewind(offset int64) {
+	if err := l.file.Truncate(offset); err != nil {
+		log.Printf("%s: unable to rewind log: %v", l.name, err)
+		return
+	}
+	if _, err := l.file.Seek(offset, io.SeekStart); err != nil {
+		log.Printf("%s: unable to rewind log: %v", l.name, err)
+	}
+}
+
+// truncate empties the log, once its entries are in a snapshot.
+func (l *changeLog) truncate() error {
+	if err := l.file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := l.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := l.file.Sync(); err != nil {
+		return err
+	}
+	l.entries = 0
+	return nil
+}
+
+func (l *changeLog) close() error {
+	return l.file.Close()
+}
+
+// errUnchanged is returned by stateChange.check for a change leaving the state as it is, which is not logged.
+var errUnchanged = errors.New("state unchanged")
+
+// stateChange is a serializable change of a state of type S.
+type stateChange[S any] interface {
+	// check returns the error the change fails with, leaving state untouched.
+	check(state *S) error
+	// apply makes the change, it is also used to replay the log and must be idempotent.
+	apply(state *S)
+}
+
+// loggedState is a state kept in memory and changed through changes of type C.
+// When it has a log, every change is logged before being applied and every snapshotEvery changes
+// the state is written to the snapshot file and the log truncated, so a change costs a single append.
+type loggedState[S any, C stateChange[S]] struct {
+	lock  sync.RWMutex
+	state S
+
+	dir           string
+	snapshotName  string
+	snapshotEvery int
+	log           *changeLog
+}
+
+func newLoggedState[S any, C stateChange[S]](state S) *loggedState[S, C] {
+	return &loggedState[S, C]{state: state}
+}
+
+// openLoggedState restores state from the snapshot dir/name.json and the log dir/name.log, creating them if needed.
+func openLoggedState[S any, C stateChange[S]](dir string, name string, state S) (*loggedState[S, C], error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	l := &loggedState[S, C]{
+		state:         state,
+		dir:           dir,
+		snapshotName:  name + ".json",
+		snapshotEvery: DEFAULT_SNAPSHOT_EVERY,
+	}
+	data, err := os.ReadFile(filepath.Join(dir, l.snapshotName))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &l.state); err != nil {
+			return nil, fmt.Errorf("%s: corrupted snapshot: %w", l.snapshotName, err)
+		}
+	}
+	l.log, err = openChangeLog(dir, name+".log", func(line []byte) error {
+		var change C
+		if err := json.Unmarshal(line, &change); err != nil {
+			return err
+		}
+		change.apply(&l.state)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// update checks and applies change, logging it first if the state has a log.
+func (l *loggedState[S, C]) update(ctx context.Context, change C) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if err := change.check(&l.state); err != nil {
+		if errors.Is(err, errUnchanged) {
+			return nil
+		}
+		return err
+	}
+	if l.log != nil {
+		if err := l.log.append(change); err != nil {
+			return err
+		}
+	}
+	change.apply(&l.state)
+	if l.log != nil && l.log.entries >= l.snapshotEvery {
+		// The change is already durable, a failure is only logged and retried on the next change.
+		if err := l.snapshot(); err != nil {
+			log.Printf("%s: unable to take snapshot: %v", l.snapshotName, err)
+		}
+	}
+	return nil
+}
+
+// close closes the log. The caller must not update the state afterwards.
+func (l *loggedState[S, C]) close() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.log == nil {
+		return nil
+	}
+	return l.log.close()
+}
+
+// read calls f with the state read locked.
+func (l *loggedState[S, C]) read(f func(state *S)) {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	f(&l.state)
+}
+
+// snapshot writes the whole state and truncates the log. The caller must hold the write lock.
+func (l *loggedState[S, C]) snapshot() error {
+	data, err := json.Marshal(l.state)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(l.dir, l.snapshotName, data); err != nil {
+		return err
+	}
+	return l.log.truncate()
+}
@@ -0,0 +1,108 @@
+package persistence
+
+import (
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+type ErrOutOfSequence struct {
+	deviceID uuid.UUID
+	expected uint
+	got      uint
+}
+
+func (e ErrOutOfSequence) Error() string {
+	return fmt.Sprintf("journal: device %s expected signature counter %d, got %d", e.deviceID, e.expected, e.got)
+}
+
+// ErrConflict is returned when a device was changed concurrently since it was read.
+type ErrConflict struct {
+	deviceID uuid.UUID
+}
+
+func (e ErrConflict) Error() string {
+	return fmt.Sprintf("storage: device %s was modified concurrently", e.deviceID)
+}
+
+// ErrNotFound is returned when the requested device is not stored.
+type ErrNotFound struct {
+	deviceID uuid.UUID
+}
+
+func (e ErrNotFound) Error() string {
+	return fmt.Sprintf("storage: device %s not found", e.deviceID)
+}
+
+// ErrAlreadyExists is returned when adding a device whose ID is already stored.
+type ErrAlreadyExists struct {
+	deviceID uuid.UUID
+}
+
+func (e ErrAlreadyExists) Error() string {
+	return fmt.Sprintf("storage: device %s already exists", e.deviceID)
+}
+
+// ErrIdempotencyKeyReused is returned when an idempotency key is used again to sign different data.
+type ErrIdempotencyKeyReused struct {
+	deviceID uuid.UUID
+	key      string
+}
+
+func (e ErrIdempotencyKeyReused) Error() string {
+	return fmt.Sprintf("storage: idempotency key %q of device %s was used for different data", e.key, e.deviceID)
+}
+
+// ErrInvalidCursor is returned for a cursor which was not issued for the same query.
+type ErrInvalidCursor struct {
+	cursor string
+}
+
+func (e ErrInvalidCursor) Error() string {
+	return fmt.Sprintf("storage: invalid cursor %q", e.cursor)
+}
+
+// ErrInvalidQuery is returned for a device query which cannot be run.
+type ErrInvalidQuery struct {
+	reason string
+}
+
+func (e ErrInvalidQuery) Error() string {
+	return fmt.Sprintf("storage: invalid query: %s", e.reason)
+}
+
+// ErrWebhookNotFound is returned when the requested webhook is not stored.
+type ErrWebhookNotFound struct {
+	webhookID uuid.UUID
+}
+
+func (e ErrWebhookNotFound) Error() string {
+	return fmt.Sprintf("storage: webhook %s not found", e.webhookID)
+}
+
+// ErrWebhookAlreadyExists is returned when adding a webhook whose ID is already stored.
+type ErrWebhookAlreadyExists struct {
+	webhookID uuid.UUID
+}
+
+func (e ErrWebhookAlreadyExists) Error() string {
+	return fmt.Sprintf("storage: webhook %s already exists", e.webhookID)
+}
+
+// ErrAPIKeyNotFound is returned when the requested API key is not stored.
+type ErrAPIKeyNotFound struct {
+	keyID uuid.UUID
+}
+
+func (e ErrAPIKeyNotFound) Error() string {
+	return fmt.Sprintf("storage: API key %s not found", e.keyID)
+}
+
+// ErrAPIKeyAlreadyExists is returned when adding an API key whose ID or hash is already stored.
+type ErrAPIKeyAlreadyExists struct {
+	keyID uuid.UUID
+}
+
+func (e ErrAPIKeyAlreadyExists) Error() string {
+	return fmt.Sprintf("storage: API key %s already exists", e.keyID)
+}
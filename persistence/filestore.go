@@ -0,0 +1,854 @@
+package persistence
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/casell/signing-service-challenge/domain"
+	"github.com/google/uuid"
+)
+
+const (
+	DEFAULT_SNAPSHOT_EVERY = 1000
+
+	walFileName      = "wal.log"
+	snapshotFileName = "snapshot.json"
+	// journalSegmentPattern names the journal segments, numbered from 1 in the order they are written.
+	journalSegmentPattern = "journal-%08d.log"
+)
+
+// walEntry is a line of the write-ahead log: either a full device state or journal records,
+// possibly with the idempotency key they were signed with, and the outbox events of the change.
+// Signature holds a single record, Signatures the records of a batch. TakenOver lists the events
+// removed from the outbox.
+type walEntry struct {
+	Device      *storedDevice   `json:"device,omitempty"`
+	Signature   *walSignature   `json:"signature,omitempty"`
+	Signatures  []walSignature  `json:"signatures,omitempty"`
+	Idempotency *walIdempotency `json:"idempotency,omitempty"`
+	Events      []storedEvent   `json:"events,omitempty"`
+	TakenOver   []uuid.UUID     `json:"taken_over,omitempty"`
+}
+
+type walSignature struct {
+	DeviceID uuid.UUID `json:"device_id"`
+	storedSignature
+}
+
+type walIdempotency struct {
+	DeviceID uuid.UUID `json:"device_id"`
+	Key      string    `json:"key"`
+	idempotencyRecord
+}
+
+// snapshot is the state of the devices, with the idempotency keys and the outbox, but not their journal.
+// Signatures is only found in snapshots written before the journal segments, the next snapshot moves them to a segment.
+type snapshot struct {
+	Devices         []*storedDevice                            `json:"devices"`
+	Signatures      map[uuid.UUID][]storedSignature            `json:"signatures,omitempty"`
+	IdempotencyKeys map[uuid.UUID]map[string]idempotencyRecord `json:"idempotency_keys,omitempty"`
+	Events          []storedEvent                              `json:"events,omitempty"`
+}
+
+// FileStore is a durable Storage backed by a directory, Journal gives access to the related SignatureJournal.
+// Every change is appended to a write-ahead log and fsynced before being acknowledged. Every snapshotEvery
+// changes the signatures logged since the previous snapshot are written to a new journal segment, which is never
+// rewritten, the state of the devices to a snapshot, and the log is truncated: a snapshot does not grow with the
+// journal. Replaying the log over the segments and the snapshot is idempotent, so a crash between the steps is harmless.
+type FileStore struct {
+	dir           string
+	factory       domain.SigningDeviceFactory
+	snapshotEvery int
+
+	lock     sync.RWMutex
+	devices  map[uuid.UUID]domain.SigningDevice
+	stored   map[uuid.UUID]*storedDevice
+	records  map[uuid.UUID][]domain.SignatureRecord
+	keys     map[uuid.UUID]map[string]idempotencyRecord
+	events   map[uuid.UUID]storedEvent
+	sequence uint64
+	wal      *changeLog
+	// segments is the number of journal segments written, segmented how many records of each device they hold.
+	segments  int
+	segmented map[uuid.UUID]int
+	// purgePending is set while the log still holds the private key of a decommissioned device.
+	purgePending bool
+}
+
+// NewFileStore opens (or creates) the store in dir, restoring devices through factory.
+func NewFileStore(dir string, factory domain.SigningDeviceFactory, snapshotEvery int) (*FileStore, error) {
+	if snapshotEvery <= 0 {
+		snapshotEvery = DEFAULT_SNAPSHOT_EVERY
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	f := &FileStore{
+		dir:           dir,
+		factory:       factory,
+		snapshotEvery: snapshotEvery,
+		devices:       make(map[uuid.UUID]domain.SigningDevice),
+		stored:        make(map[uuid.UUID]*storedDevice),
+		records:       make(map[uuid.UUID][]domain.SignatureRecord),
+		keys:          make(map[uuid.UUID]map[string]idempotencyRecord),
+		events:        make(map[uuid.UUID]storedEvent),
+		segmented:     make(map[uuid.UUID]int),
+	}
+
+	if err := f.loadSegments(); err != nil {
+		return nil, err
+	}
+	if err := f.loadSnapshot(); err != nil {
+		return nil, err
+	}
+	if err := f.replayWAL(); err != nil {
+		return nil, err
+	}
+	for id, stored := range f.stored {
+		// Devices logged before the last signature time was recorded take it from their journal.
+		if records := f.records[id]; stored.LastSignedAt.IsZero() && len(records) > 0 {
+			stored.LastSignedAt = records[len(records)-1].Timestamp
+		}
+		device, err := unmarshalDevice(factory, stored)
+		if err != nil {
+			f.wal.close()
+			return nil, fmt.Errorf("filestore: unable to restore device %s: %w", id, err)
+		}
+		f.devices[id] = device
+	}
+
+	return f, nil
+}
+
+// loadSegments reads the journal segments in the order they were written.
+func (f *FileStore) loadSegments() error {
+	names, err := filepath.Glob(filepath.Join(f.dir, "journal-*.log"))
+	if err != nil {
+		return err
+	}
+	// The segment numbers are zero padded, the names sort in writing order.
+	slices.Sort(names)
+	for _, name := range names {
+		var number int
+		if _, err := fmt.Sscanf(filepath.Base(name), journalSegmentPattern, &number); err != nil {
+			continue
+		}
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		for decoder.More() {
+			var signature walSignature
+			if err := decoder.Decode(&signature); err != nil {
+				return fmt.Errorf("filestore: corrupted journal segment %s: %w", filepath.Base(name), err)
+			}
+			if err := f.applySignature(&signature); err != nil {
+				return fmt.Errorf("filestore: corrupted journal segment %s: %w", filepath.Base(name), err)
+			}
+		}
+		f.segments = max(f.segments, number)
+	}
+	for id, records := range f.records {
+		f.segmented[id] = len(records)
+	}
+	return nil
+}
+
+func (f *FileStore) loadSnapshot() error {
+	data, err := os.ReadFile(filepath.Join(f.dir, snapshotFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var s snapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("filestore: corrupted snapshot: %w", err)
+	}
+	for _, d := range s.Devices {
+		f.stored[d.ID] = d
+	}
+	for id, signatures := range s.Signatures {
+		for _, v := range signatures {
+			f.records[id] = append(f.records[id], unmarshalSignature(v))
+		}
+	}
+	for id, keys := range s.IdempotencyKeys {
+		f.keys[id] = keys
+	}
+	f.addEvents(s.Events)
+	return nil
+}
+
+// replayWAL applies the log entries and leaves the log open for appending.
+func (f *FileStore) replayWAL() error {
+	wal, err := openChangeLog(f.dir, walFileName, func(line []byte) error {
+		var entry walEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return err
+		}
+		return f.apply(&entry)
+	})
+	if err != nil {
+		return fmt.Errorf("filestore: %w", err)
+	}
+	f.wal = wal
+	return nil
+}
+
+// apply updates the persisted state with a log entry, skipping journal records already known.
+// Decommissioning is final, so a decommissioned device is only replaced by a later decommissioned state:
+// a log the snapshot of a decommission could not truncate must not bring its private key back.
+func (f *FileStore) apply(entry *walEntry) error {
+	if entry.Device != nil {
+		current, ok := f.stored[entry.Device.ID]
+		if !ok || current.Status != domain.STATUS_DECOMMISSIONED || entry.Device.Status == domain.STATUS_DECOMMISSIONED {
+			f.stored[entry.Device.ID] = entry.Device
+		}
+	}
+	if entry.Signature != nil {
+		if err := f.applySignature(entry.Signature); err != nil {
+			return err
+		}
+	}
+	for i := range entry.Signatures {
+		if err := f.applySignature(&entry.Signatures[i]); err != nil {
+			return err
+		}
+	}
+	if entry.Idempotency != nil {
+		f.addKey(entry.Idempotency)
+	}
+	f.addEvents(entry.Events)
+	for _, id := range entry.TakenOver {
+		delete(f.events, id)
+	}
+	return nil
+}
+
+// applySignature appends a logged journal record, unless it is already known.
+func (f *FileStore) applySignature(signature *walSignature) error {
+	id := signature.DeviceID
+	length := uint(len(f.records[id]))
+	switch {
+	case signature.Counter < length:
+	case signature.Counter == length:
+		f.records[id] = append(f.records[id], unmarshalSignature(signature.storedSignature))
+	default:
+		return ErrOutOfSequence{deviceID: id, expected: length, got: signature.Counter}
+	}
+	return nil
+}
+
+func marshalWALSignatures(deviceID uuid.UUID, records []domain.SignatureRecord) []walSignature {
+	signatures := make([]walSignature, len(records))
+	for i, record := range records {
+		signatures[i] = walSignature{DeviceID: deviceID, storedSignature: marshalSignature(record)}
+	}
+	return signatures
+}
+
+// addKey records an idempotency key. The caller must hold the write lock.
+func (f *FileStore) addKey(key *walIdempotency) {
+	if f.keys[key.DeviceID] == nil {
+		f.keys[key.DeviceID] = make(map[string]idempotencyRecord)
+	}
+	f.keys[key.DeviceID][key.Key] = key.idempotencyRecord
+}
+
+// newEvents numbers events for the outbox, in the order they are given. The caller must hold the write lock.
+func (f *FileStore) newEvents(events ...OutboxEvent) []storedEvent {
+	stored := make([]storedEvent, len(events))
+	for i, event := range events {
+		stored[i] = marshalEvent(event)
+		stored[i].Sequence = f.sequence + uint64(i) + 1
+	}
+	return stored
+}
+
+// addEvents puts events in the outbox, an event already there is left as it is. The caller must hold the write lock.
+func (f *FileStore) addEvents(events []storedEvent) {
+	for _, event := range events {
+		if _, ok := f.events[event.ID]; !ok {
+			f.events[event.ID] = event
+		}
+		f.sequence = max(f.sequence, event.Sequence)
+	}
+}
+
+// write appends an entry to the log and fsyncs it. The caller must hold the write lock.
+func (f *FileStore) write(entry *walEntry) error {
+	return f.wal.append(entry)
+}
+
+// maybeSnapshot takes a snapshot once enough entries are in the log, or while a purge is pending.
+// The caller must hold the write lock. The log entries are already durable, so a failure is only logged
+// and retried on the next write.
+func (f *FileStore) maybeSnapshot() {
+	if f.wal.entries < f.snapshotEvery && !f.purgePending {
+		return
+	}
+	if err := f.snapshot(); err != nil {
+		log.Printf("filestore: unable to take snapshot: %v", err)
+		return
+	}
+	f.purgePending = false
+}
+
+// decommission commits a decommissioned device with a snapshot rather than a log entry, so that once it
+// succeeds the private key, held by the earlier log entries, is gone from disk. The caller must hold the write lock.
+// When the snapshot cannot be written nothing changes and the error is returned. Once it is written the
+// device is decommissioned: if the log cannot be truncated the purge is retried on every write until it is.
+func (f *FileStore) decommission(entry *walEntry) error {
+	id := entry.Device.ID
+	previous := f.stored[id]
+	sequence := f.sequence
+	f.stored[id] = entry.Device
+	f.addEvents(entry.Events)
+
+	if err := f.writeSnapshot(); err != nil {
+		f.stored[id] = previous
+		for _, event := range entry.Events {
+			delete(f.events, event.ID)
+		}
+		f.sequence = sequence
+		return fmt.Errorf("filestore: unable to purge the private key of device %s: %w", id, err)
+	}
+	if err := f.wal.truncate(); err != nil {
+		log.Printf("filestore: unable to purge the private key of device %s from the log, retrying on the next write: %v", id, err)
+		f.purgePending = true
+	}
+	return nil
+}
+
+// snapshot writes the state to disk and truncates the log. The caller must hold the write lock.
+func (f *FileStore) snapshot() error {
+	if err := f.writeSnapshot(); err != nil {
+		return err
+	}
+	return f.wal.truncate()
+}
+
+// writeSnapshot writes the signatures logged since the previous snapshot to a new journal segment, then the
+// devices to the snapshot. The caller must hold the write lock.
+func (f *FileStore) writeSnapshot() error {
+	if err := f.writeSegment(); err != nil {
+		return err
+	}
+
+	s := snapshot{
+		Devices:         make([]*storedDevice, 0, len(f.stored)),
+		IdempotencyKeys: f.keys,
+		Events:          make([]storedEvent, 0, len(f.events)),
+	}
+	for _, v := range f.stored {
+		s.Devices = append(s.Devices, v)
+	}
+	for _, v := range f.events {
+		s.Events = append(s.Events, v)
+	}
+
+	data, err := json.Marshal(&s)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(f.dir, snapshotFileName, data)
+}
+
+// writeSegment writes the journal records which are in no segment yet to a new segment, if there are any.
+// The caller must hold the write lock.
+func (f *FileStore) writeSegment() error {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for id, records := range f.records {
+		for _, record := range records[f.segmented[id]:] {
+			if err := encoder.Encode(walSignature{DeviceID: id, storedSignature: marshalSignature(record)}); err != nil {
+				return err
+			}
+		}
+	}
+	if buf.Len() == 0 {
+		return nil
+	}
+
+	if err := writeFileAtomic(f.dir, fmt.Sprintf(journalSegmentPattern, f.segments+1), buf.Bytes()); err != nil {
+		return err
+	}
+	f.segments++
+	for id, records := range f.records {
+		f.segmented[id] = len(records)
+	}
+	return nil
+}
+
+// writeFileAtomic replaces dir/name with data: the file is written aside, fsynced and renamed over,
+// so that a crash leaves either the previous or the new content.
+func writeFileAtomic(dir string, name string, data []byte) error {
+	tmpName := filepath.Join(dir, name+".tmp")
+	tmp, err := os.OpenFile(tmpName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpName, filepath.Join(dir, name)); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// Close takes a last snapshot and closes the log.
+func (f *FileStore) Close() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	snapshotErr := f.snapshot()
+	if err := f.wal.close(); err != nil {
+		return err
+	}
+	return snapshotErr
+}
+
+func (f *FileStore) Add(ctx context.Context, x domain.SigningDevice) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return x.CreateAndCommit(ctx, func(state domain.DeviceState) error {
+		stored, err := marshalState(state)
+		if err != nil {
+			return err
+		}
+
+		f.lock.Lock()
+		defer f.lock.Unlock()
+
+		if _, ok := f.stored[stored.ID]; ok {
+			return ErrAlreadyExists{deviceID: stored.ID}
+		}
+		entry := &walEntry{Device: stored, Events: f.newEvents(newOutboxEvent(domain.DeviceCreatedEvent(stored.ID, state.Status)))}
+		if err := f.write(entry); err != nil {
+			return err
+		}
+		f.stored[stored.ID] = stored
+		f.devices[stored.ID] = x
+		f.addEvents(entry.Events)
+		f.maybeSnapshot()
+		return nil
+	})
+}
+
+func (f *FileStore) Put(ctx context.Context, x domain.SigningDevice) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	stored, err := marshalDevice(x)
+	if err != nil {
+		return err
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	current, ok := f.stored[stored.ID]
+	if !ok {
+		return ErrNotFound{deviceID: stored.ID}
+	}
+	if stored.SignatureCounter < current.SignatureCounter ||
+		(current.Status == domain.STATUS_DECOMMISSIONED && stored.Status != domain.STATUS_DECOMMISSIONED) {
+		return ErrConflict{deviceID: stored.ID}
+	}
+	if err := f.write(&walEntry{Device: stored}); err != nil {
+		return err
+	}
+	f.stored[stored.ID] = stored
+	f.devices[stored.ID] = x
+	f.maybeSnapshot()
+	return nil
+}
+
+func (f *FileStore) Get(ctx context.Context, id uuid.UUID) (domain.SigningDevice, error) {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	device, ok := f.devices[id]
+	if !ok {
+		return nil, ErrNotFound{deviceID: id}
+	}
+	return device, nil
+}
+
+func (f *FileStore) List(ctx context.Context) ([]domain.SigningDevice, error) {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	list := make([]domain.SigningDevice, 0, len(f.devices))
+	for _, v := range f.devices {
+		list = append(list, v)
+	}
+	return list, nil
+}
+
+// Query filters and sorts the devices in memory, it is linear in the number of devices.
+func (f *FileStore) Query(ctx context.Context, q DeviceQuery) (*DevicePage, error) {
+	devices, err := f.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return queryDevices(devices, q)
+}
+
+// SignAndCommit writes the advanced device state, the signature record, the idempotency key and the
+// signature event as a single log entry, so a crash can never persist one without the others.
+func (f *FileStore) SignAndCommit(ctx context.Context, id uuid.UUID, dataToBeSigned string, idempotencyKey string) (*domain.SignatureRecord, error) {
+	device, err := f.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if record, err := f.replay(ctx, id, dataToBeSigned, idempotencyKey); record != nil || err != nil {
+		return record, err
+	}
+
+	record, err := device.SignAndCommit(ctx, dataToBeSigned, func(record domain.SignatureRecord, state domain.DeviceState) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		stored, err := marshalState(state)
+		if err != nil {
+			return err
+		}
+
+		f.lock.Lock()
+		defer f.lock.Unlock()
+
+		// The profile may have been updated meanwhile, it is left as stored.
+		if current, ok := f.stored[id]; ok {
+			stored.setProfile(current.profile())
+		}
+		expected := uint(len(f.records[id]))
+		if record.Counter != expected {
+			return ErrOutOfSequence{deviceID: id, expected: expected, got: record.Counter}
+		}
+		entry := &walEntry{
+			Device:    stored,
+			Signature: &walSignature{DeviceID: id, storedSignature: marshalSignature(record)},
+			Events:    f.newEvents(signatureOutboxEvents(id, []domain.SignatureRecord{record})...),
+		}
+		if idempotencyKey != "" {
+			if _, ok := f.keys[id][idempotencyKey]; ok {
+				return errIdempotencyKeyTaken
+			}
+			entry.Idempotency = &walIdempotency{
+				DeviceID:          id,
+				Key:               idempotencyKey,
+				idempotencyRecord: idempotencyRecord{Counter: record.Counter, CreatedAt: time.Now()},
+			}
+		}
+		if err := f.write(entry); err != nil {
+			return err
+		}
+		f.stored[id] = stored
+		f.records[id] = append(f.records[id], record)
+		if entry.Idempotency != nil {
+			f.addKey(entry.Idempotency)
+		}
+		f.addEvents(entry.Events)
+		f.maybeSnapshot()
+		return nil
+	})
+	if errors.Is(err, errIdempotencyKeyTaken) {
+		return f.replay(ctx, id, dataToBeSigned, idempotencyKey)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// SignBatchAndCommit writes the advanced device state, all the records of the batch and their events as a single log entry.
+func (f *FileStore) SignBatchAndCommit(ctx context.Context, id uuid.UUID, dataToBeSigned []string) ([]domain.SignatureRecord, error) {
+	device, err := f.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return device.SignBatchAndCommit(ctx, dataToBeSigned, func(records []domain.SignatureRecord, state domain.DeviceState) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		stored, err := marshalState(state)
+		if err != nil {
+			return err
+		}
+
+		f.lock.Lock()
+		defer f.lock.Unlock()
+
+		// The profile may have been updated meanwhile, it is left as stored.
+		if current, ok := f.stored[id]; ok {
+			stored.setProfile(current.profile())
+		}
+		if err := checkSequence(id, uint(len(f.records[id])), records); err != nil {
+			return err
+		}
+		entry := &walEntry{
+			Device:     stored,
+			Signatures: marshalWALSignatures(id, records),
+			Events:     f.newEvents(signatureOutboxEvents(id, records)...),
+		}
+		if err := f.write(entry); err != nil {
+			return err
+		}
+		f.stored[id] = stored
+		f.records[id] = append(f.records[id], records...)
+		f.addEvents(entry.Events)
+		f.maybeSnapshot()
+		return nil
+	})
+}
+
+// SetStatus logs the new device state. Decommissioning also takes a snapshot, truncating the log,
+// so that the private key does not linger in the previous entries.
+func (f *FileStore) SetStatus(ctx context.Context, id uuid.UUID, status domain.DeviceStatus) (domain.SigningDevice, error) {
+	device, err := f.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = device.TransitionAndCommit(ctx, status, func(state domain.DeviceState) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		stored, err := marshalState(state)
+		if err != nil {
+			return err
+		}
+
+		f.lock.Lock()
+		defer f.lock.Unlock()
+
+		if current, ok := f.stored[id]; ok {
+			stored.setProfile(current.profile())
+		}
+		entry := &walEntry{Device: stored, Events: f.newEvents(newOutboxEvent(domain.StatusChangedEvent(id, status)))}
+		if status == domain.STATUS_DECOMMISSIONED {
+			return f.decommission(entry)
+		}
+		if err := f.write(entry); err != nil {
+			return err
+		}
+		f.stored[id] = stored
+		f.addEvents(entry.Events)
+		f.maybeSnapshot()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return device, nil
+}
+
+// UpdateProfile logs the stored device with the new profile: the signing state is left as it is,
+// so that signatures committed meanwhile are not rolled back.
+func (f *FileStore) UpdateProfile(ctx context.Context, id uuid.UUID, revision uint, label *string, metadata map[string]string) (domain.SigningDevice, error) {
+	device, err := f.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = device.UpdateProfileAndCommit(ctx, revision, label, metadata, func(profile domain.DeviceProfile) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		f.lock.Lock()
+		defer f.lock.Unlock()
+
+		current, ok := f.stored[id]
+		if !ok {
+			return ErrNotFound{deviceID: id}
+		}
+		stored := *current
+		stored.setProfile(profile)
+		if err := f.write(&walEntry{Device: &stored}); err != nil {
+			return err
+		}
+		f.stored[id] = &stored
+		f.maybeSnapshot()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return device, nil
+}
+
+// replay returns the record already issued for idempotencyKey, nil if the key is unused.
+func (f *FileStore) replay(ctx context.Context, id uuid.UUID, dataToBeSigned string, idempotencyKey string) (*domain.SignatureRecord, error) {
+	if idempotencyKey == "" {
+		return nil, nil
+	}
+	f.lock.RLock()
+	key, ok := f.keys[id][idempotencyKey]
+	f.lock.RUnlock()
+	if !ok {
+		return nil, nil
+	}
+	return replay(ctx, f.Journal(), id, idempotencyKey, key.Counter, dataToBeSigned)
+}
+
+// PurgeIdempotencyKeys only drops the keys from memory: the next snapshot leaves them out,
+// until then a restart restores them from the log and they are purged again.
+func (f *FileStore) PurgeIdempotencyKeys(ctx context.Context, before time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	for id, keys := range f.keys {
+		for k, v := range keys {
+			if v.CreatedAt.Before(before) {
+				delete(keys, k)
+			}
+		}
+		if len(keys) == 0 {
+			delete(f.keys, id)
+		}
+	}
+	return nil
+}
+
+// PendingEvents sorts the outbox in memory, it is linear in the number of pending events.
+func (f *FileStore) PendingEvents(ctx context.Context, limit int) ([]OutboxEvent, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f.lock.RLock()
+	pending := make([]storedEvent, 0, len(f.events))
+	for _, event := range f.events {
+		pending = append(pending, event)
+	}
+	f.lock.RUnlock()
+
+	slices.SortFunc(pending, func(a, b storedEvent) int {
+		return cmp.Compare(a.Sequence, b.Sequence)
+	})
+	events := make([]OutboxEvent, 0, min(limit, len(pending)))
+	for _, event := range pending[:min(limit, len(pending))] {
+		events = append(events, unmarshalEvent(event))
+	}
+	return events, nil
+}
+
+// DeleteEvents logs the IDs of the events taken over, the next snapshot leaves them out.
+func (f *FileStore) DeleteEvents(ctx context.Context, ids []uuid.UUID) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	takenOver := []uuid.UUID{}
+	for _, id := range ids {
+		if _, ok := f.events[id]; ok {
+			takenOver = append(takenOver, id)
+		}
+	}
+	if len(takenOver) == 0 {
+		return nil
+	}
+	if err := f.write(&walEntry{TakenOver: takenOver}); err != nil {
+		return err
+	}
+	for _, id := range takenOver {
+		delete(f.events, id)
+	}
+	f.maybeSnapshot()
+	return nil
+}
+
+// Journal returns the SignatureJournal sharing the store log.
+func (f *FileStore) Journal() *FileJournal {
+	return &FileJournal{store: f}
+}
+
+// FileJournal is the SignatureJournal view of a FileStore.
+type FileJournal struct {
+	store *FileStore
+}
+
+func (j *FileJournal) Append(ctx context.Context, deviceID uuid.UUID, records ...domain.SignatureRecord) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		return nil
+	}
+	f := j.store
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := checkSequence(deviceID, uint(len(f.records[deviceID])), records); err != nil {
+		return err
+	}
+	if err := f.write(&walEntry{Signatures: marshalWALSignatures(deviceID, records)}); err != nil {
+		return err
+	}
+	f.records[deviceID] = append(f.records[deviceID], records...)
+	f.maybeSnapshot()
+	return nil
+}
+
+func (j *FileJournal) Get(ctx context.Context, deviceID uuid.UUID, counter uint) (*domain.SignatureRecord, error) {
+	f := j.store
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	records := f.records[deviceID]
+	if counter >= uint(len(records)) {
+		return nil, nil
+	}
+	record := records[counter]
+	return &record, nil
+}
+
+func (j *FileJournal) List(ctx context.Context, deviceID uuid.UUID, offset int, limit int) ([]domain.SignatureRecord, int, error) {
+	f := j.store
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	records := f.records[deviceID]
+	return page(records, offset, limit), len(records), nil
+}
@@ -0,0 +1,357 @@
+package persistence
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	mycrypto "github.com/casell/signing-service-challenge/crypto"
+	"github.com/casell/signing-service-challenge/domain"
+	"github.com/google/uuid"
+)
+
+var deviceFactory = domain.NewDefaultDeviceFactory()
+
+func newFileStore(t *testing.T, dir string, snapshotEvery int) *FileStore {
+	f, err := NewFileStore(dir, deviceFactory, snapshotEvery)
+	if err != nil {
+		t.Fatal("Expected nil err opening store, got", err)
+	}
+	return f
+}
+
+// signAndStore signs with the device and persists the outcome through Put and Append.
+func signAndStore(t *testing.T, store Storage, journal SignatureJournal, d domain.SigningDevice, n int) {
+	for i := 0; i < n; i++ {
+		record, err := d.Sign(context.Background(), "data")
+		if err != nil {
+			t.Fatal("Expected nil err signing, got", err)
+		}
+		if err := store.Put(context.Background(), d); err != nil {
+			t.Fatal("Expected nil PUT err, got", err)
+		}
+		if err := journal.Append(context.Background(), d.ID(), record); err != nil {
+			t.Fatal("Expected nil APPEND err, got", err)
+		}
+	}
+}
+
+func checkRestored(t *testing.T, store Storage, journal SignatureJournal, d domain.SigningDevice) {
+	r, err := store.Get(context.Background(), d.ID())
+	if err != nil {
+		t.Fatal("Expected nil err, got", err)
+	}
+	if r == nil {
+		t.Fatalf("Expected to find device %s", d.ID())
+	}
+
+	counter, lastSignature := d.CounterAndLastSignature()
+	rcounter, rlastSignature := r.CounterAndLastSignature()
+	if counter != rcounter || lastSignature != rlastSignature {
+		t.Fatalf("Expected counter %d and last signature %s, got %d and %s", counter, lastSignature, rcounter, rlastSignature)
+	}
+	if r.SignatureAlgorithm() != d.SignatureAlgorithm() || r.Parameters() != d.Parameters() || *r.Label() != *d.Label() {
+		t.Fatalf("Expected %v, got %v", d.State(), r.State())
+	}
+	if !r.KeyPair().Equal(d.KeyPair()) {
+		t.Fatalf("Expected keypair %v, got %v", d.KeyPair(), r.KeyPair())
+	}
+
+	records, total, err := journal.List(context.Background(), d.ID(), 0, int(counter)+1)
+	if err != nil {
+		t.Fatal("Expected nil err, got", err)
+	}
+	if total != int(counter) || len(records) != int(counter) {
+		t.Fatalf("Expected %d journal records, got %d (total %d)", counter, len(records), total)
+	}
+
+	links := make([]domain.ChainLink, len(records))
+	for i, v := range records {
+		links[i] = domain.ChainLink{Signature: v.Signature, SignedData: v.SignedData}
+	}
+	chainBreak, err := domain.VerifyChain(r, links)
+	if err != nil || chainBreak != nil {
+		t.Fatalf("Expected restored chain to be valid, got %v, %v", chainBreak, err)
+	}
+}
+
+func TestFileStoreReopen(t *testing.T) {
+	dir := t.TempDir()
+	f := newFileStore(t, dir, 0)
+
+	label := "label"
+	d, err := deviceFactory.New(uuid.Nil, domain.STATUS_ACTIVE, "ECC", &label, mycrypto.Parameters{Curve: "P-256"})
+	if err != nil {
+		t.Fatal("Expected nil err creating device, got", err)
+	}
+	if err := f.Add(context.Background(), d); err != nil {
+		t.Fatal("Expected nil ADD err, got", err)
+	}
+	signAndStore(t, f, f.Journal(), d, 3)
+
+	// Simulate a crash: the log is not snapshotted nor closed.
+	rf := newFileStore(t, dir, 0)
+	checkRestored(t, rf, rf.Journal(), d)
+
+	// The restored device keeps on chaining.
+	r, _ := rf.Get(context.Background(), d.ID())
+	signAndStore(t, rf, rf.Journal(), r, 1)
+	if err := rf.Close(); err != nil {
+		t.Fatal("Expected nil CLOSE err, got", err)
+	}
+
+	rrf := newFileStore(t, dir, 0)
+	checkRestored(t, rrf, rrf.Journal(), r)
+}
+
+func TestFileStoreSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	f := newFileStore(t, dir, 2)
+
+	label := "label"
+	d, err := deviceFactory.New(uuid.Nil, domain.STATUS_ACTIVE, "ED25519", &label, mycrypto.Parameters{})
+	if err != nil {
+		t.Fatal("Expected nil err creating device, got", err)
+	}
+	if err := f.Add(context.Background(), d); err != nil {
+		t.Fatal("Expected nil ADD err, got", err)
+	}
+	signAndStore(t, f, f.Journal(), d, 2)
+
+	if _, err := os.Stat(filepath.Join(dir, snapshotFileName)); err != nil {
+		t.Fatal("Expected snapshot to be written, got", err)
+	}
+	if f.wal.entries >= 2 {
+		t.Fatal("Expected log to be truncated, got entries", f.wal.entries)
+	}
+
+	rf := newFileStore(t, dir, 2)
+	checkRestored(t, rf, rf.Journal(), d)
+}
+
+func TestFileStoreJournalSegments(t *testing.T) {
+	dir := t.TempDir()
+	f := newFileStore(t, dir, 2)
+
+	label := "label"
+	d, err := deviceFactory.New(uuid.Nil, domain.STATUS_ACTIVE, "ED25519", &label, mycrypto.Parameters{})
+	if err != nil {
+		t.Fatal("Expected nil err creating device, got", err)
+	}
+	if err := f.Add(context.Background(), d); err != nil {
+		t.Fatal("Expected nil ADD err, got", err)
+	}
+	if _, err := f.SignAndCommit(context.Background(), d.ID(), "data", ""); err != nil {
+		t.Fatal("Expected nil err, got", err)
+	}
+	first, err := os.ReadFile(filepath.Join(dir, fmt.Sprintf(journalSegmentPattern, 1)))
+	if err != nil {
+		t.Fatal("Expected the first segment to be written, got", err)
+	}
+	for i := 0; i < 4; i++ {
+		if _, err := f.SignAndCommit(context.Background(), d.ID(), "data", ""); err != nil {
+			t.Fatal("Expected nil err, got", err)
+		}
+	}
+
+	// Later snapshots write new segments, the first one is left as it is.
+	if f.segments != 3 {
+		t.Fatal("Expected 3 segments, got", f.segments)
+	}
+	if again, err := os.ReadFile(filepath.Join(dir, fmt.Sprintf(journalSegmentPattern, 1))); err != nil || !bytes.Equal(first, again) {
+		t.Fatal("Expected the first segment to be unchanged, got", string(again), err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, snapshotFileName))
+	if err != nil {
+		t.Fatal("Expected nil err reading the snapshot, got", err)
+	}
+	var s snapshot
+	if err := json.Unmarshal(data, &s); err != nil || len(s.Signatures) != 0 || len(s.Devices) != 1 {
+		t.Fatal("Expected a snapshot of the device only, got", string(data), err)
+	}
+
+	rf := newFileStore(t, dir, 2)
+	checkRestored(t, rf, rf.Journal(), d)
+	if rf.segments != 3 || rf.segmented[d.ID()] != 5 {
+		t.Fatal("Expected the segments to be counted, got", rf.segments, rf.segmented[d.ID()])
+	}
+}
+
+func TestFileStoreLegacySnapshot(t *testing.T) {
+	dir := t.TempDir()
+	f := newFileStore(t, dir, 0)
+
+	label := "label"
+	d, err := deviceFactory.New(uuid.Nil, domain.STATUS_ACTIVE, "ED25519", &label, mycrypto.Parameters{})
+	if err != nil {
+		t.Fatal("Expected nil err creating device, got", err)
+	}
+	if err := f.Add(context.Background(), d); err != nil {
+		t.Fatal("Expected nil ADD err, got", err)
+	}
+	signAndStore(t, f, f.Journal(), d, 3)
+
+	// A snapshot holding the journal, as written before the journal segments.
+	legacy := snapshot{Devices: []*storedDevice{f.stored[d.ID()]}, Signatures: map[uuid.UUID][]storedSignature{}}
+	for _, record := range f.records[d.ID()] {
+		legacy.Signatures[d.ID()] = append(legacy.Signatures[d.ID()], marshalSignature(record))
+	}
+	data, err := json.Marshal(&legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.wal.close()
+	if err := os.WriteFile(filepath.Join(dir, snapshotFileName), data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, walFileName)); err != nil {
+		t.Fatal(err)
+	}
+
+	rf := newFileStore(t, dir, 0)
+	checkRestored(t, rf, rf.Journal(), d)
+	if err := rf.Close(); err != nil {
+		t.Fatal("Expected nil CLOSE err, got", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, fmt.Sprintf(journalSegmentPattern, 1))); err != nil {
+		t.Fatal("Expected the journal to be moved to a segment, got", err)
+	}
+	rrf := newFileStore(t, dir, 0)
+	checkRestored(t, rrf, rrf.Journal(), d)
+}
+
+func TestFileStoreReplayIdempotent(t *testing.T) {
+	dir := t.TempDir()
+	f := newFileStore(t, dir, 0)
+
+	label := "label"
+	d, err := deviceFactory.New(uuid.Nil, domain.STATUS_ACTIVE, "ECC", &label, mycrypto.Parameters{})
+	if err != nil {
+		t.Fatal("Expected nil err creating device, got", err)
+	}
+	if err := f.Add(context.Background(), d); err != nil {
+		t.Fatal("Expected nil ADD err, got", err)
+	}
+	signAndStore(t, f, f.Journal(), d, 2)
+
+	wal, err := os.ReadFile(filepath.Join(dir, walFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal("Expected nil CLOSE err, got", err)
+	}
+
+	// Simulate a crash after the snapshot rename but before the log truncation.
+	if err := os.WriteFile(filepath.Join(dir, walFileName), wal, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	rf := newFileStore(t, dir, 0)
+	checkRestored(t, rf, rf.Journal(), d)
+}
+
+func TestFileStoreTornWrite(t *testing.T) {
+	dir := t.TempDir()
+	f := newFileStore(t, dir, 0)
+
+	label := "label"
+	d, err := deviceFactory.New(uuid.Nil, domain.STATUS_ACTIVE, "ECC", &label, mycrypto.Parameters{})
+	if err != nil {
+		t.Fatal("Expected nil err creating device, got", err)
+	}
+	if err := f.Add(context.Background(), d); err != nil {
+		t.Fatal("Expected nil ADD err, got", err)
+	}
+	signAndStore(t, f, f.Journal(), d, 1)
+
+	if _, err := f.wal.file.Write([]byte(`{"device":{"id":`)); err != nil {
+		t.Fatal(err)
+	}
+
+	rf := newFileStore(t, dir, 0)
+	checkRestored(t, rf, rf.Journal(), d)
+
+	signAndStore(t, rf, rf.Journal(), d, 1)
+	rrf := newFileStore(t, dir, 0)
+	checkRestored(t, rrf, rrf.Journal(), d)
+}
+
+func TestFileStoreCorrupted(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, walFileName), []byte("garbage\n{}\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := NewFileStore(dir, deviceFactory, 0)
+	if err == nil {
+		t.Fatal("Expected corrupted log error, got", f)
+	}
+}
+
+func TestFileStorePutNotFound(t *testing.T) {
+	f := newFileStore(t, t.TempDir(), 0)
+
+	d, err := deviceFactory.New(uuid.Nil, domain.STATUS_ACTIVE, "ECC", nil, mycrypto.Parameters{})
+	if err != nil {
+		t.Fatal("Expected nil err creating device, got", err)
+	}
+	if err := f.Put(context.Background(), d); !errors.As(err, &ErrNotFound{}) {
+		t.Fatal("Expected not found err, got", err)
+	}
+	list, err := f.List(context.Background())
+	if err != nil {
+		t.Fatal("Expected nil err, got", err)
+	}
+	if len(list) != 0 {
+		t.Fatal("Expected empty list, got", list)
+	}
+}
+
+func TestFileStoreSignAndCommit(t *testing.T) {
+	dir := t.TempDir()
+	f := newFileStore(t, dir, 0)
+
+	label := "label"
+	d, err := deviceFactory.New(uuid.Nil, domain.STATUS_ACTIVE, "RSA", &label, mycrypto.Parameters{})
+	if err != nil {
+		t.Fatal("Expected nil err creating device, got", err)
+	}
+	if err := f.Add(context.Background(), d); err != nil {
+		t.Fatal("Expected nil ADD err, got", err)
+	}
+	if err := f.Add(context.Background(), d); !errors.As(err, &ErrAlreadyExists{}) {
+		t.Fatal("Expected already exists err, got", err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := f.SignAndCommit(context.Background(), d.ID(), "data", ""); err != nil {
+			t.Fatal("Expected nil err, got", err)
+		}
+	}
+	if f.wal.entries != 4 {
+		t.Fatal("Expected a single log entry per signature, got entries", f.wal.entries)
+	}
+
+	rf := newFileStore(t, dir, 0)
+	checkRestored(t, rf, rf.Journal(), d)
+
+	// A stale device must not roll the counter back.
+	stale, err := deviceFactory.Restore(domain.DeviceState{
+		ID:                 d.ID(),
+		SignatureAlgorithm: d.SignatureAlgorithm(),
+		Label:              d.Label(),
+		Parameters:         d.Parameters(),
+		KeyPair:            d.KeyPair(),
+		Status:             domain.STATUS_ACTIVE,
+	})
+	if err != nil {
+		t.Fatal("Expected nil err restoring device, got", err)
+	}
+	if err := rf.Put(context.Background(), stale); !errors.As(err, &ErrConflict{}) {
+		t.Fatal("Expected conflict err, got", err)
+	}
+}
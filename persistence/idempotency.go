@@ -0,0 +1,62 @@
+package persistence
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/casell/signing-service-challenge/domain"
+	"github.com/google/uuid"
+)
+
+const (
+	// DEFAULT_IDEMPOTENCY_RETENTION is how long idempotency keys are kept by default.
+	DEFAULT_IDEMPOTENCY_RETENTION = 24 * time.Hour
+	// IDEMPOTENCY_PURGE_INTERVAL is how often ExpireIdempotencyKeys looks for expired keys.
+	IDEMPOTENCY_PURGE_INTERVAL = time.Minute
+)
+
+// idempotencyRecord binds an idempotency key to the counter of the signature it produced.
+type idempotencyRecord struct {
+	Counter   uint      `json:"counter"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// errIdempotencyKeyTaken is returned by a commit that lost the race for its idempotency key,
+// the caller replays the winning signature instead.
+var errIdempotencyKeyTaken = errors.New("idempotency: key already used")
+
+// replay returns the signature originally issued for an idempotency key bound to counter,
+// or ErrIdempotencyKeyReused if it was issued for different data.
+func replay(ctx context.Context, journal SignatureJournal, deviceID uuid.UUID, key string, counter uint, dataToBeSigned string) (*domain.SignatureRecord, error) {
+	record, err := journal.Get(ctx, deviceID, counter)
+	if err != nil {
+		return nil, err
+	}
+	if record == nil {
+		return nil, fmt.Errorf("idempotency: key %s of device %s refers to missing signature %d", key, deviceID, counter)
+	}
+	if record.Data != dataToBeSigned {
+		return nil, ErrIdempotencyKeyReused{deviceID: deviceID, key: key}
+	}
+	return record, nil
+}
+
+// ExpireIdempotencyKeys purges, every interval, the idempotency keys of store older than retention until ctx is done.
+// Keys are therefore honored for at least retention and at most retention plus interval.
+func ExpireIdempotencyKeys(ctx context.Context, store Storage, retention time.Duration, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := store.PurgeIdempotencyKeys(ctx, now.Add(-retention)); err != nil {
+				log.Printf("idempotency: unable to purge expired keys: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package persistence
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	mycrypto "github.com/casell/signing-service-challenge/crypto"
+	"github.com/casell/signing-service-challenge/domain"
+	"github.com/google/uuid"
+)
+
+// storages are the Storage implementations checked against the same behaviour.
+var storages = []struct {
+	name string
+	new  func(t *testing.T) Storage
+}{
+	{"memory", func(t *testing.T) Storage { return NewMemoryStore() }},
+	{"file", func(t *testing.T) Storage { return newFileStore(t, t.TempDir(), 0) }},
+	{"sql", func(t *testing.T) Storage {
+		db := openSQLite(t, filepath.Join(t.TempDir(), "store.db"))
+		db.SetMaxOpenConns(1)
+		return newSQLStore(t, db)
+	}},
+}
+
+func addIdempotencyDevice(t *testing.T, store Storage) domain.SigningDevice {
+	label := "label"
+	d, err := deviceFactory.New(uuid.Nil, domain.STATUS_ACTIVE, "ED25519", &label, mycrypto.Parameters{})
+	if err != nil {
+		t.Fatal("Expected nil err creating device, got", err)
+	}
+	if err := store.Add(context.Background(), d); err != nil {
+		t.Fatal("Expected nil ADD err, got", err)
+	}
+	return d
+}
+
+func TestIdempotencyKeyReplay(t *testing.T) {
+	for _, tc := range storages {
+		t.Run(tc.name, func(t *testing.T) {
+			store := tc.new(t)
+			d := addIdempotencyDevice(t, store)
+			ctx := context.Background()
+
+			first, err := store.SignAndCommit(ctx, d.ID(), "data", "key")
+			if err != nil {
+				t.Fatal("Expected nil err, got", err)
+			}
+			if _, err := store.SignAndCommit(ctx, d.ID(), "other", ""); err != nil {
+				t.Fatal("Expected nil err, got", err)
+			}
+
+			again, err := store.SignAndCommit(ctx, d.ID(), "data", "key")
+			if err != nil {
+				t.Fatal("Expected nil err, got", err)
+			}
+			if again.Counter != first.Counter || again.Signature != first.Signature {
+				t.Fatal("Expected the original signature, got", again, "instead of", first)
+			}
+			stored, err := store.Get(ctx, d.ID())
+			if err != nil {
+				t.Fatal("Expected nil err, got", err)
+			}
+			if counter, _ := stored.CounterAndLastSignature(); counter != 2 {
+				t.Fatal("Expected the replay not to sign, got counter", counter)
+			}
+
+			if _, err := store.SignAndCommit(ctx, d.ID(), "different", "key"); !errors.As(err, &ErrIdempotencyKeyReused{}) {
+				t.Fatal("Expected key reused err, got", err)
+			}
+
+			// Keys are scoped to the device.
+			other := addIdempotencyDevice(t, store)
+			record, err := store.SignAndCommit(ctx, other.ID(), "different", "key")
+			if err != nil {
+				t.Fatal("Expected nil err, got", err)
+			}
+			if record.Counter != 0 {
+				t.Fatal("Expected a new signature, got counter", record.Counter)
+			}
+		})
+	}
+}
+
+func TestIdempotencyKeyConcurrent(t *testing.T) {
+	for _, tc := range storages {
+		t.Run(tc.name, func(t *testing.T) {
+			store := tc.new(t)
+			d := addIdempotencyDevice(t, store)
+
+			const writers = 8
+			records := make([]*domain.SignatureRecord, writers)
+			var wg sync.WaitGroup
+			for i := 0; i < writers; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					record, err := store.SignAndCommit(context.Background(), d.ID(), "data", "key")
+					if err != nil {
+						t.Error("Expected nil err, got", err)
+						return
+					}
+					records[i] = record
+				}(i)
+			}
+			wg.Wait()
+
+			for _, v := range records {
+				if v == nil || v.Counter != 0 {
+					t.Fatal("Expected every writer to get the first signature, got", v)
+				}
+			}
+		})
+	}
+}
+
+func TestPurgeIdempotencyKeys(t *testing.T) {
+	for _, tc := range storages {
+		t.Run(tc.name, func(t *testing.T) {
+			store := tc.new(t)
+			d := addIdempotencyDevice(t, store)
+			ctx := context.Background()
+
+			if _, err := store.SignAndCommit(ctx, d.ID(), "data", "key"); err != nil {
+				t.Fatal("Expected nil err, got", err)
+			}
+			if err := store.PurgeIdempotencyKeys(ctx, time.Now().Add(-time.Hour)); err != nil {
+				t.Fatal("Expected nil PURGE err, got", err)
+			}
+			if record, err := store.SignAndCommit(ctx, d.ID(), "data", "key"); err != nil || record.Counter != 0 {
+				t.Fatal("Expected a recent key to be kept, got", record, err)
+			}
+
+			if err := store.PurgeIdempotencyKeys(ctx, time.Now().Add(time.Hour)); err != nil {
+				t.Fatal("Expected nil PURGE err, got", err)
+			}
+			if record, err := store.SignAndCommit(ctx, d.ID(), "different", "key"); err != nil || record.Counter != 1 {
+				t.Fatal("Expected a purged key to be usable again, got", record, err)
+			}
+		})
+	}
+}
+
+func TestFileStoreIdempotencyKeyReopen(t *testing.T) {
+	dir := t.TempDir()
+	f := newFileStore(t, dir, 0)
+	d := addIdempotencyDevice(t, f)
+	ctx := context.Background()
+
+	if _, err := f.SignAndCommit(ctx, d.ID(), "logged", "logged"); err != nil {
+		t.Fatal("Expected nil err, got", err)
+	}
+	rf := newFileStore(t, dir, 0)
+	if _, err := rf.SignAndCommit(ctx, d.ID(), "different", "logged"); !errors.As(err, &ErrIdempotencyKeyReused{}) {
+		t.Fatal("Expected the key restored from the log, got", err)
+	}
+
+	if _, err := rf.SignAndCommit(ctx, d.ID(), "snapshot", "snapshot"); err != nil {
+		t.Fatal("Expected nil err, got", err)
+	}
+	if err := rf.Close(); err != nil {
+		t.Fatal("Expected nil CLOSE err, got", err)
+	}
+	rrf := newFileStore(t, dir, 0)
+	for _, key := range []string{"logged", "snapshot"} {
+		if record, err := rrf.SignAndCommit(ctx, d.ID(), key, key); err != nil || record.Data != key {
+			t.Fatal("Expected the key restored from the snapshot, got", record, err)
+		}
+	}
+}
@@ -1,150 +1,349 @@
 package persistence
 
 import (
+	"cmp"
+	"context"
+	"errors"
+	"hash/maphash"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
 	"github.com/casell/signing-service-challenge/domain"
 	"github.com/google/uuid"
 )
 
-type operation interface {
-	out() chan *result
+// MEMORY_STORE_SHARDS is the number of independently locked partitions of a MemoryStore.
+const MEMORY_STORE_SHARDS = 32
+
+type memoryShard struct {
+	lock  sync.RWMutex
+	items map[uuid.UUID]domain.SigningDevice
+	// committed holds the state last committed for the devices in items.
+	committed map[uuid.UUID]memoryCommitted
+	// keys holds the idempotency keys of the devices in items.
+	keys map[uuid.UUID]map[string]idempotencyRecord
+	// events is the outbox of the devices in items, in sequence order.
+	events []memoryEvent
 }
 
-type baseOperation struct {
-	outch chan *result
+// memoryCommitted is what Put checks a device against. It is kept by the shard, as commits take the device lock
+// before the shard lock: reading the stored device under the shard lock could deadlock.
+type memoryCommitted struct {
+	counter uint
+	status  domain.DeviceStatus
 }
 
-func (o *baseOperation) out() chan *result {
-	return o.outch
+// memoryEvent is an OutboxEvent numbered in commit order across the shards.
+type memoryEvent struct {
+	sequence uint64
+	OutboxEvent
 }
 
-type getOperation struct {
-	operation
-	id uuid.UUID
+// MemoryStore is a Storage keeping devices in memory, spread over shards guarded by their own RWMutex
+// so operations on different devices do not contend with each other.
+type MemoryStore struct {
+	seed    maphash.Seed
+	shards  [MEMORY_STORE_SHARDS]memoryShard
+	journal *MemoryJournal
+	// sequence numbers the events, it is only advanced with the shard of the event locked.
+	sequence atomic.Uint64
 }
 
-type addOperation struct {
-	operation
-	data domain.SigningDevice
+func NewMemoryStore() *MemoryStore {
+	m := &MemoryStore{
+		seed:    maphash.MakeSeed(),
+		journal: NewMemoryJournal(),
+	}
+	for i := range m.shards {
+		m.shards[i].items = make(map[uuid.UUID]domain.SigningDevice)
+		m.shards[i].committed = make(map[uuid.UUID]memoryCommitted)
+		m.shards[i].keys = make(map[uuid.UUID]map[string]idempotencyRecord)
+	}
+	return m
 }
 
-type putOperation struct {
-	operation
-	data domain.SigningDevice
+func (m *MemoryStore) shard(id uuid.UUID) *memoryShard {
+	return &m.shards[maphash.Bytes(m.seed, id[:])%MEMORY_STORE_SHARDS]
 }
 
-type listOperation struct {
-	operation
+func (m *MemoryStore) Add(ctx context.Context, x domain.SigningDevice) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return x.CreateAndCommit(ctx, func(state domain.DeviceState) error {
+		id := x.ID()
+		s := m.shard(id)
+		s.lock.Lock()
+		defer s.lock.Unlock()
+
+		if _, ok := s.items[id]; ok {
+			return ErrAlreadyExists{deviceID: id}
+		}
+		s.items[id] = x
+		s.commit(id, state)
+		m.addEvents(s, newOutboxEvent(domain.DeviceCreatedEvent(id, state.Status)))
+		return nil
+	})
 }
 
-type result struct {
-	data domain.SigningDevice
-	err  error
+// commit records state as the committed state of the device id. The caller must hold the shard write lock.
+func (s *memoryShard) commit(id uuid.UUID, state domain.DeviceState) {
+	s.committed[id] = memoryCommitted{counter: state.SignatureCounter, status: state.Status}
 }
 
-type MemoryStore struct {
-	items   map[uuid.UUID]domain.SigningDevice
-	request chan operation
+// addEvents appends events to the outbox of shard s. The caller must hold the shard write lock.
+func (m *MemoryStore) addEvents(s *memoryShard, events ...OutboxEvent) {
+	for _, event := range events {
+		s.events = append(s.events, memoryEvent{sequence: m.sequence.Add(1), OutboxEvent: event})
+	}
 }
 
-func NewMemoryStore() *MemoryStore {
-	m := MemoryStore{
-		items:   make(map[uuid.UUID]domain.SigningDevice),
-		request: make(chan operation),
+// Put replaces the device, unless its counter is behind the stored one or the stored device is DECOMMISSIONED
+// and x is not: ErrConflict is returned then.
+func (m *MemoryStore) Put(ctx context.Context, x domain.SigningDevice) error {
+	if err := ctx.Err(); err != nil {
+		return err
 	}
-	go m.start()
-	return &m
+	id := x.ID()
+	counter, _ := x.CounterAndLastSignature()
+	status := x.Status()
+	s := m.shard(id)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.items[id]; !ok {
+		return ErrNotFound{deviceID: id}
+	}
+	current := s.committed[id]
+	if counter < current.counter || (current.status == domain.STATUS_DECOMMISSIONED && status != domain.STATUS_DECOMMISSIONED) {
+		return ErrConflict{deviceID: id}
+	}
+	s.items[id] = x
+	s.committed[id] = memoryCommitted{counter: counter, status: status}
+	return nil
 }
 
-func (m *MemoryStore) start() {
-	for r := range m.request {
-		switch x := r.(type) {
-		case *getOperation:
-			x.out() <- &result{
-				data: m.items[x.id],
-			}
-			close(x.out())
-		case *addOperation:
-			d := x.data
-			id := d.ID()
-			m.items[id] = d
-			x.out() <- &result{
-				data: m.items[id],
-			}
-			close(x.out())
-		case *putOperation:
-			d := x.data
-			id := d.ID()
-			_, ok := m.items[id]
-			if ok {
-				m.items[id] = d
-			}
-			x.out() <- &result{
-				data: m.items[id],
-			}
-			close(x.out())
-		case *listOperation:
-			for _, v := range m.items {
-				x.out() <- &result{data: v}
-			}
-			close(x.out())
+func (m *MemoryStore) Get(ctx context.Context, id uuid.UUID) (domain.SigningDevice, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s := m.shard(id)
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	device, ok := s.items[id]
+	if !ok {
+		return nil, ErrNotFound{deviceID: id}
+	}
+	return device, nil
+}
+
+// List returns a consistent snapshot: every shard is read locked before the first one is copied.
+func (m *MemoryStore) List(ctx context.Context) ([]domain.SigningDevice, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	size := 0
+	for i := range m.shards {
+		m.shards[i].lock.RLock()
+		size += len(m.shards[i].items)
+	}
+	defer func() {
+		for i := range m.shards {
+			m.shards[i].lock.RUnlock()
+		}
+	}()
+
+	list := make([]domain.SigningDevice, 0, size)
+	for i := range m.shards {
+		for _, v := range m.shards[i].items {
+			list = append(list, v)
 		}
 	}
+	return list, nil
 }
 
-func (m *MemoryStore) Add(x domain.SigningDevice) error {
-	out := make(chan *result)
-	m.request <- &addOperation{
-		operation: &baseOperation{
-			outch: out,
-		},
-		data: x,
+// Query filters and sorts a snapshot of the devices, it is linear in the number of devices.
+func (m *MemoryStore) Query(ctx context.Context, q DeviceQuery) (*DevicePage, error) {
+	devices, err := m.List(ctx)
+	if err != nil {
+		return nil, err
 	}
-	res := <-out
+	return queryDevices(devices, q)
+}
 
-	return res.err
+// Journal returns the SignatureJournal SignAndCommit appends to.
+func (m *MemoryStore) Journal() *MemoryJournal {
+	return m.journal
 }
 
-func (m *MemoryStore) Put(x domain.SigningDevice) error {
-	out := make(chan *result)
-	m.request <- &putOperation{
-		operation: &baseOperation{
-			outch: out,
-		},
-		data: x,
+// SignAndCommit relies on the device lock to serialize signatures, the shard is only locked for the lookup
+// and to store the record together with its idempotency key and event.
+func (m *MemoryStore) SignAndCommit(ctx context.Context, id uuid.UUID, dataToBeSigned string, idempotencyKey string) (*domain.SignatureRecord, error) {
+	device, err := m.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if record, err := m.replay(ctx, id, dataToBeSigned, idempotencyKey); record != nil || err != nil {
+		return record, err
 	}
-	res := <-out
 
-	return res.err
+	s := m.shard(id)
+	record, err := device.SignAndCommit(ctx, dataToBeSigned, func(record domain.SignatureRecord, state domain.DeviceState) error {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		if idempotencyKey != "" {
+			if _, ok := s.keys[id][idempotencyKey]; ok {
+				return errIdempotencyKeyTaken
+			}
+		}
+		if err := m.journal.Append(ctx, id, record); err != nil {
+			return err
+		}
+		if idempotencyKey != "" {
+			if s.keys[id] == nil {
+				s.keys[id] = make(map[string]idempotencyRecord)
+			}
+			s.keys[id][idempotencyKey] = idempotencyRecord{Counter: record.Counter, CreatedAt: time.Now()}
+		}
+		s.commit(id, state)
+		m.addEvents(s, signatureOutboxEvents(id, []domain.SignatureRecord{record})...)
+		return nil
+	})
+	if errors.Is(err, errIdempotencyKeyTaken) {
+		return m.replay(ctx, id, dataToBeSigned, idempotencyKey)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &record, nil
 }
 
-func (m *MemoryStore) Get(id uuid.UUID) (domain.SigningDevice, error) {
-	out := make(chan *result)
-	m.request <- &getOperation{
-		operation: &baseOperation{
-			outch: out,
-		},
-		id: id,
+func (m *MemoryStore) SignBatchAndCommit(ctx context.Context, id uuid.UUID, dataToBeSigned []string) ([]domain.SignatureRecord, error) {
+	device, err := m.Get(ctx, id)
+	if err != nil {
+		return nil, err
 	}
-	res := <-out
-	return res.data, res.err
+	s := m.shard(id)
+	return device.SignBatchAndCommit(ctx, dataToBeSigned, func(records []domain.SignatureRecord, state domain.DeviceState) error {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		if err := m.journal.Append(ctx, id, records...); err != nil {
+			return err
+		}
+		s.commit(id, state)
+		m.addEvents(s, signatureOutboxEvents(id, records)...)
+		return nil
+	})
 }
 
-func (m *MemoryStore) List() ([]domain.SigningDevice, error) {
-	out := make(chan *result)
-	m.request <- &listOperation{
-		operation: &baseOperation{
-			outch: out,
-		},
+func (m *MemoryStore) SetStatus(ctx context.Context, id uuid.UUID, status domain.DeviceStatus) (domain.SigningDevice, error) {
+	device, err := m.Get(ctx, id)
+	if err != nil {
+		return nil, err
 	}
+	s := m.shard(id)
+	err = device.TransitionAndCommit(ctx, status, func(state domain.DeviceState) error {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		s.commit(id, state)
+		m.addEvents(s, newOutboxEvent(domain.StatusChangedEvent(id, status)))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return device, nil
+}
 
-	list := make([]domain.SigningDevice, 0)
+func (m *MemoryStore) UpdateProfile(ctx context.Context, id uuid.UUID, revision uint, label *string, metadata map[string]string) (domain.SigningDevice, error) {
+	device, err := m.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := device.UpdateProfile(ctx, revision, label, metadata); err != nil {
+		return nil, err
+	}
+	return device, nil
+}
 
-	for res := range out {
-		if res.err != nil {
-			return nil, res.err
+// replay returns the record already issued for idempotencyKey, nil if the key is unused.
+func (m *MemoryStore) replay(ctx context.Context, id uuid.UUID, dataToBeSigned string, idempotencyKey string) (*domain.SignatureRecord, error) {
+	if idempotencyKey == "" {
+		return nil, nil
+	}
+	s := m.shard(id)
+	s.lock.RLock()
+	key, ok := s.keys[id][idempotencyKey]
+	s.lock.RUnlock()
+	if !ok {
+		return nil, nil
+	}
+	return replay(ctx, m.journal, id, idempotencyKey, key.Counter, dataToBeSigned)
+}
+
+func (m *MemoryStore) PurgeIdempotencyKeys(ctx context.Context, before time.Time) error {
+	for i := range m.shards {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		s := &m.shards[i]
+		s.lock.Lock()
+		for id, keys := range s.keys {
+			for k, v := range keys {
+				if v.CreatedAt.Before(before) {
+					delete(keys, k)
+				}
+			}
+			if len(keys) == 0 {
+				delete(s.keys, id)
+			}
 		}
-		list = append(list, res.data)
+		s.lock.Unlock()
 	}
+	return nil
+}
 
-	return list, nil
+// PendingEvents merges the outboxes of the shards, it is linear in the number of pending events.
+func (m *MemoryStore) PendingEvents(ctx context.Context, limit int) ([]OutboxEvent, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	pending := []memoryEvent{}
+	for i := range m.shards {
+		s := &m.shards[i]
+		s.lock.RLock()
+		pending = append(pending, s.events...)
+		s.lock.RUnlock()
+	}
+	slices.SortFunc(pending, func(a, b memoryEvent) int {
+		return cmp.Compare(a.sequence, b.sequence)
+	})
+
+	events := make([]OutboxEvent, 0, min(limit, len(pending)))
+	for _, event := range pending[:min(limit, len(pending))] {
+		events = append(events, event.OutboxEvent)
+	}
+	return events, nil
+}
+
+func (m *MemoryStore) DeleteEvents(ctx context.Context, ids []uuid.UUID) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	deleted := make(map[uuid.UUID]bool, len(ids))
+	for _, id := range ids {
+		deleted[id] = true
+	}
+	for i := range m.shards {
+		s := &m.shards[i]
+		s.lock.Lock()
+		s.events = slices.DeleteFunc(s.events, func(event memoryEvent) bool {
+			return deleted[event.ID]
+		})
+		s.lock.Unlock()
+	}
+	return nil
 }
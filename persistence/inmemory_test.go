@@ -1,9 +1,13 @@
 package persistence
 
 import (
+	"context"
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/casell/signing-service-challenge/crypto"
+	"github.com/casell/signing-service-challenge/domain"
 	"github.com/google/uuid"
 )
 
@@ -11,11 +15,47 @@ type dummySigningDevice struct {
 	id uuid.UUID
 }
 
-func (d *dummySigningDevice) Sign(dataToBeSigned string) (string, string, error) {
+func (d *dummySigningDevice) Sign(ctx context.Context, dataToBeSigned string) (domain.SignatureRecord, error) {
+	panic("unimplemented")
+}
+
+func (d *dummySigningDevice) SignAndCommit(ctx context.Context, dataToBeSigned string, commit func(record domain.SignatureRecord, state domain.DeviceState) error) (domain.SignatureRecord, error) {
+	panic("unimplemented")
+}
+
+func (d *dummySigningDevice) SignBatchAndCommit(ctx context.Context, dataToBeSigned []string, commit func(records []domain.SignatureRecord, state domain.DeviceState) error) ([]domain.SignatureRecord, error) {
+	panic("unimplemented")
+}
+
+func (d *dummySigningDevice) Verify(signedData string, signatureB64 string) (bool, error) {
 	panic("unimplemented")
 }
 
 func (d *dummySigningDevice) CounterAndLastSignature() (uint, string) {
+	return 0, ""
+}
+
+func (d *dummySigningDevice) LastSignedAt() time.Time {
+	panic("unimplemented")
+}
+
+func (d *dummySigningDevice) State() domain.DeviceState {
+	panic("unimplemented")
+}
+
+func (d *dummySigningDevice) CreateAndCommit(ctx context.Context, commit func(state domain.DeviceState) error) error {
+	return commit(domain.DeviceState{ID: d.id, Status: d.Status()})
+}
+
+func (d *dummySigningDevice) Status() domain.DeviceStatus {
+	return domain.STATUS_INITIALIZED
+}
+
+func (d *dummySigningDevice) Transition(ctx context.Context, to domain.DeviceStatus) error {
+	panic("unimplemented")
+}
+
+func (d *dummySigningDevice) TransitionAndCommit(ctx context.Context, to domain.DeviceStatus, commit func(state domain.DeviceState) error) error {
 	panic("unimplemented")
 }
 
@@ -23,10 +63,34 @@ func (d *dummySigningDevice) KeyPair() crypto.KeyPair {
 	panic("unimplemented")
 }
 
+func (d *dummySigningDevice) Parameters() crypto.Parameters {
+	panic("unimplemented")
+}
+
+func (d *dummySigningDevice) CreatedAt() time.Time {
+	panic("unimplemented")
+}
+
+func (d *dummySigningDevice) Padding() (string, int) {
+	panic("unimplemented")
+}
+
 func (d *dummySigningDevice) Label() *string {
 	panic("unimplemented")
 }
 
+func (d *dummySigningDevice) Profile() domain.DeviceProfile {
+	panic("unimplemented")
+}
+
+func (d *dummySigningDevice) UpdateProfile(ctx context.Context, revision uint, label *string, metadata map[string]string) (domain.DeviceProfile, error) {
+	panic("unimplemented")
+}
+
+func (d *dummySigningDevice) UpdateProfileAndCommit(ctx context.Context, revision uint, label *string, metadata map[string]string, commit func(profile domain.DeviceProfile) error) (domain.DeviceProfile, error) {
+	panic("unimplemented")
+}
+
 func (d *dummySigningDevice) SignatureAlgorithm() string {
 	panic("unimplemented")
 }
@@ -38,23 +102,23 @@ func (d *dummySigningDevice) ID() uuid.UUID {
 func TestGetEmpty(t *testing.T) {
 	imc := NewMemoryStore()
 	uid := uuid.New()
-	data, err := imc.Get(uid)
-	if err != nil {
-		t.Error("Expected nil err, got", err)
+	data, err := imc.Get(context.Background(), uid)
+	if !errors.As(err, &ErrNotFound{}) {
+		t.Error("Expected not found err, got", err)
 	}
 	if data != nil {
-		t.Fatal("Expected nil err, got", data)
+		t.Fatal("Expected nil data, got", data)
 	}
 }
 
 func TestPutNotFound(t *testing.T) {
 	imc := NewMemoryStore()
 	dev := &dummySigningDevice{id: uuid.New()}
-	err := imc.Put(dev)
-	if err != nil {
-		t.Fatal("Expected nil err, got", err)
+	err := imc.Put(context.Background(), dev)
+	if !errors.As(err, &ErrNotFound{}) {
+		t.Fatal("Expected not found err, got", err)
 	}
-	r, ok := imc.items[dev.ID()]
+	r, ok := imc.shard(dev.ID()).items[dev.ID()]
 	if ok {
 		t.Fatalf("Expected to not find item %s, got %v", dev.ID(), r)
 	}
@@ -63,13 +127,13 @@ func TestPutNotFound(t *testing.T) {
 func TestPutFound(t *testing.T) {
 	imc := NewMemoryStore()
 	dev := &dummySigningDevice{id: uuid.New()}
-	err := imc.Add(dev)
+	err := imc.Add(context.Background(), dev)
 	if err != nil {
 		t.Fatal("Expected nil err, got", err)
 	}
-	r, ok := imc.items[dev.ID()]
+	r, ok := imc.shard(dev.ID()).items[dev.ID()]
 	if !ok {
-		t.Fatalf("Expected to find item %s, got %v", dev.ID(), imc.items)
+		t.Fatalf("Expected to find item %s, got %v", dev.ID(), imc.shard(dev.ID()).items)
 	}
 	if r != dev {
 		t.Fatalf("Expected %v, got %v", dev, r)
@@ -77,16 +141,61 @@ func TestPutFound(t *testing.T) {
 
 }
 
+func TestPutConflict(t *testing.T) {
+	imc := NewMemoryStore()
+	ctx := context.Background()
+	d, err := deviceFactory.New(uuid.Nil, domain.STATUS_ACTIVE, "ED25519", nil, crypto.Parameters{})
+	if err != nil {
+		t.Fatal("Expected nil err creating device, got", err)
+	}
+	if err := imc.Add(ctx, d); err != nil {
+		t.Fatal("Expected nil ADD err, got", err)
+	}
+	stale, err := deviceFactory.Restore(d.State())
+	if err != nil {
+		t.Fatal("Expected nil err restoring device, got", err)
+	}
+	if _, err := imc.SignAndCommit(ctx, d.ID(), "data", ""); err != nil {
+		t.Fatal("Expected nil err signing, got", err)
+	}
+
+	// The counter cannot go backwards.
+	if err := imc.Put(ctx, stale); !errors.As(err, &ErrConflict{}) {
+		t.Fatal("Expected conflict err, got", err)
+	}
+	fresh, err := deviceFactory.Restore(d.State())
+	if err != nil {
+		t.Fatal("Expected nil err restoring device, got", err)
+	}
+	if err := imc.Put(ctx, fresh); err != nil {
+		t.Fatal("Expected nil PUT err, got", err)
+	}
+
+	// DECOMMISSIONED is terminal.
+	if _, err := imc.SetStatus(ctx, d.ID(), domain.STATUS_DECOMMISSIONED); err != nil {
+		t.Fatal("Expected nil err decommissioning, got", err)
+	}
+	if err := imc.Put(ctx, d); !errors.As(err, &ErrConflict{}) {
+		t.Fatal("Expected conflict err, got", err)
+	}
+	if r, _ := imc.Get(ctx, d.ID()); r.Status() != domain.STATUS_DECOMMISSIONED {
+		t.Fatal("Expected the decommissioned device, got", r.Status())
+	}
+}
+
 func TestAdd(t *testing.T) {
 	imc := NewMemoryStore()
 	dev := &dummySigningDevice{id: uuid.New()}
-	err := imc.Add(dev)
+	err := imc.Add(context.Background(), dev)
 	if err != nil {
 		t.Fatal("Expected nil err, got", err)
 	}
-	r, ok := imc.items[dev.ID()]
+	if err := imc.Add(context.Background(), &dummySigningDevice{id: dev.ID()}); !errors.As(err, &ErrAlreadyExists{}) {
+		t.Fatal("Expected already exists err, got", err)
+	}
+	r, ok := imc.shard(dev.ID()).items[dev.ID()]
 	if !ok {
-		t.Fatalf("Expected to find item %s, got %v", dev.ID(), imc.items)
+		t.Fatalf("Expected to find item %s, got %v", dev.ID(), imc.shard(dev.ID()).items)
 	}
 	if r != dev {
 		t.Fatalf("Expected %v, got %v", dev, r)
@@ -95,7 +204,7 @@ func TestAdd(t *testing.T) {
 
 func TestEmptyList(t *testing.T) {
 	imc := NewMemoryStore()
-	res, err := imc.List()
+	res, err := imc.List(context.Background())
 	if err != nil {
 		t.Fatal("Expected nil err, got", err)
 	}
@@ -111,11 +220,11 @@ func TestFilledList(t *testing.T) {
 	imc := NewMemoryStore()
 
 	dev := &dummySigningDevice{id: uuid.New()}
-	err := imc.Add(dev)
+	err := imc.Add(context.Background(), dev)
 	if err != nil {
 		t.Fatal("Expected nil PUT err, got", err)
 	}
-	res, err := imc.List()
+	res, err := imc.List(context.Background())
 	if err != nil {
 		t.Fatal("Expected nil err, got", err)
 	}
@@ -129,3 +238,66 @@ func TestFilledList(t *testing.T) {
 		t.Fatalf("Expected res to contain %v, got %v", dev.ID(), res[0])
 	}
 }
+
+func TestSignAndCommitNotFound(t *testing.T) {
+	imc := NewMemoryStore()
+	record, err := imc.SignAndCommit(context.Background(), uuid.New(), "data", "")
+	if !errors.As(err, &ErrNotFound{}) || record != nil {
+		t.Fatalf("Expected nil record and not found err, got %v, %v", record, err)
+	}
+}
+
+func TestSignAndCommit(t *testing.T) {
+	imc := NewMemoryStore()
+	dev, err := deviceFactory.New(uuid.Nil, domain.STATUS_ACTIVE, "ECC", nil, crypto.Parameters{})
+	if err != nil {
+		t.Fatal("Expected nil err creating device, got", err)
+	}
+	if err := imc.Add(context.Background(), dev); err != nil {
+		t.Fatal("Expected nil ADD err, got", err)
+	}
+
+	record, err := imc.SignAndCommit(context.Background(), dev.ID(), "data", "")
+	if err != nil {
+		t.Fatal("Expected nil err, got", err)
+	}
+	if counter, lastSignature := dev.CounterAndLastSignature(); counter != 1 || lastSignature != record.Signature {
+		t.Fatalf("Expected device to advance, got counter %d and last signature %s", counter, lastSignature)
+	}
+	if journaled, _ := imc.Journal().Get(context.Background(), dev.ID(), 0); journaled == nil || *journaled != *record {
+		t.Fatalf("Expected %v to be journaled, got %v", record, journaled)
+	}
+
+	// A journal out of step with the device makes the commit fail, the counter must not move.
+	if err := imc.Journal().Append(context.Background(), dev.ID(), domain.SignatureRecord{Counter: 1}); err != nil {
+		t.Fatal("Expected nil APPEND err, got", err)
+	}
+	if _, err := imc.SignAndCommit(context.Background(), dev.ID(), "data", ""); err == nil {
+		t.Fatal("Expected out of sequence err")
+	}
+	if counter, _ := dev.CounterAndLastSignature(); counter != 1 {
+		t.Fatal("Expected device counter to stay 1, got", counter)
+	}
+}
+
+func TestCanceledContext(t *testing.T) {
+	imc := NewMemoryStore()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := imc.Get(ctx, uuid.New()); err != context.Canceled {
+		t.Fatal("Expected canceled err, got", err)
+	}
+	if err := imc.Add(ctx, &dummySigningDevice{id: uuid.New()}); err != context.Canceled {
+		t.Fatal("Expected canceled err, got", err)
+	}
+
+	// Callers giving up must not wedge the store.
+	res, err := imc.List(context.Background())
+	if err != nil {
+		t.Fatal("Expected nil err, got", err)
+	}
+	if len(res) != 0 {
+		t.Fatal("Expected empty res, got", res)
+	}
+}
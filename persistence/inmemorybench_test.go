@@ -0,0 +1,89 @@
+package persistence
+
+import (
+	"context"
+	"math/rand/v2"
+	"testing"
+
+	mycrypto "github.com/casell/signing-service-challenge/crypto"
+	"github.com/casell/signing-service-challenge/domain"
+	"github.com/google/uuid"
+)
+
+const benchmarkDevices = 256
+
+var memoryStores = []struct {
+	name string
+	new  func() Storage
+}{
+	{"sharded", func() Storage { return NewMemoryStore() }},
+	{"loop", func() Storage { return newLoopMemoryStore() }},
+}
+
+func addBenchmarkDevices(b *testing.B, store Storage) []uuid.UUID {
+	ids := make([]uuid.UUID, benchmarkDevices)
+	for i := range ids {
+		d, err := deviceFactory.New(uuid.Nil, domain.STATUS_ACTIVE, "ED25519", nil, mycrypto.Parameters{})
+		if err != nil {
+			b.Fatal(err)
+		}
+		if err := store.Add(context.Background(), d); err != nil {
+			b.Fatal(err)
+		}
+		ids[i] = d.ID()
+	}
+	return ids
+}
+
+// benchmarkSign signs concurrently with random devices, listing all of them every listEvery operations.
+func benchmarkSign(b *testing.B, listEvery int) {
+	for _, bc := range memoryStores {
+		b.Run(bc.name, func(b *testing.B) {
+			store := bc.new()
+			ids := addBenchmarkDevices(b, store)
+			ctx := context.Background()
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for i := 1; pb.Next(); i++ {
+					if listEvery > 0 && i%listEvery == 0 {
+						if _, err := store.List(ctx); err != nil {
+							b.Error(err)
+						}
+						continue
+					}
+					if _, err := store.SignAndCommit(ctx, ids[rand.IntN(len(ids))], "data", ""); err != nil {
+						b.Error(err)
+					}
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkMemoryStoreSign(b *testing.B) {
+	benchmarkSign(b, 0)
+}
+
+func BenchmarkMemoryStoreSignAndList(b *testing.B) {
+	benchmarkSign(b, 100)
+}
+
+func BenchmarkMemoryStoreGet(b *testing.B) {
+	for _, bc := range memoryStores {
+		b.Run(bc.name, func(b *testing.B) {
+			store := bc.new()
+			ids := addBenchmarkDevices(b, store)
+			ctx := context.Background()
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, err := store.Get(ctx, ids[rand.IntN(len(ids))]); err != nil {
+						b.Error(err)
+					}
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package persistence
+
+import (
+	"context"
+	"hash/maphash"
+	"sync"
+
+	"github.com/casell/signing-service-challenge/domain"
+	"github.com/google/uuid"
+)
+
+type journalShard struct {
+	lock    sync.RWMutex
+	records map[uuid.UUID][]domain.SignatureRecord
+}
+
+// MemoryJournal is a SignatureJournal kept in memory, spread over MEMORY_STORE_SHARDS shards
+// guarded by their own RWMutex, so appends to different devices do not contend with each other.
+type MemoryJournal struct {
+	seed   maphash.Seed
+	shards [MEMORY_STORE_SHARDS]journalShard
+}
+
+func NewMemoryJournal() *MemoryJournal {
+	j := &MemoryJournal{seed: maphash.MakeSeed()}
+	for i := range j.shards {
+		j.shards[i].records = make(map[uuid.UUID][]domain.SignatureRecord)
+	}
+	return j
+}
+
+func (j *MemoryJournal) shard(deviceID uuid.UUID) *journalShard {
+	return &j.shards[maphash.Bytes(j.seed, deviceID[:])%MEMORY_STORE_SHARDS]
+}
+
+func (j *MemoryJournal) Append(ctx context.Context, deviceID uuid.UUID, records ...domain.SignatureRecord) error {
+	s := j.shard(deviceID)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if err := checkSequence(deviceID, uint(len(s.records[deviceID])), records); err != nil {
+		return err
+	}
+	s.records[deviceID] = append(s.records[deviceID], records...)
+	return nil
+}
+
+// checkSequence verifies that records follow each other starting from the expected counter.
+func checkSequence(deviceID uuid.UUID, expected uint, records []domain.SignatureRecord) error {
+	for _, record := range records {
+		if record.Counter != expected {
+			return ErrOutOfSequence{deviceID: deviceID, expected: expected, got: record.Counter}
+		}
+		expected++
+	}
+	return nil
+}
+
+func (j *MemoryJournal) Get(ctx context.Context, deviceID uuid.UUID, counter uint) (*domain.SignatureRecord, error) {
+	s := j.shard(deviceID)
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	records := s.records[deviceID]
+	if counter >= uint(len(records)) {
+		return nil, nil
+	}
+	record := records[counter]
+	return &record, nil
+}
+
+func (j *MemoryJournal) List(ctx context.Context, deviceID uuid.UUID, offset int, limit int) ([]domain.SignatureRecord, int, error) {
+	s := j.shard(deviceID)
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	records := s.records[deviceID]
+	return page(records, offset, limit), len(records), nil
+}
+
+// page returns a copy of the [offset, offset+limit) window of records.
+func page(records []domain.SignatureRecord, offset int, limit int) []domain.SignatureRecord {
+	if offset < 0 || offset >= len(records) || limit <= 0 {
+		return []domain.SignatureRecord{}
+	}
+	end := min(offset+limit, len(records))
+	list := make([]domain.SignatureRecord, end-offset)
+	copy(list, records[offset:end])
+	return list
+}
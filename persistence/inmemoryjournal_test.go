@@ -0,0 +1,92 @@
+package persistence
+
+import (
+	"context"
+	"testing"
+
+	"github.com/casell/signing-service-challenge/domain"
+	"github.com/google/uuid"
+)
+
+func TestJournalGetEmpty(t *testing.T) {
+	j := NewMemoryJournal()
+	record, err := j.Get(context.Background(), uuid.New(), 0)
+	if err != nil {
+		t.Fatal("Expected nil err, got", err)
+	}
+	if record != nil {
+		t.Fatal("Expected nil record, got", record)
+	}
+}
+
+func TestJournalAppendOutOfSequence(t *testing.T) {
+	j := NewMemoryJournal()
+	err := j.Append(context.Background(), uuid.New(), domain.SignatureRecord{Counter: 1})
+	if _, ok := err.(ErrOutOfSequence); !ok {
+		t.Fatal("Expected ErrOutOfSequence, got", err)
+	}
+}
+
+func TestJournalAppendBatch(t *testing.T) {
+	j := NewMemoryJournal()
+	id := uuid.New()
+	err := j.Append(context.Background(), id, domain.SignatureRecord{Counter: 0}, domain.SignatureRecord{Counter: 2})
+	if _, ok := err.(ErrOutOfSequence); !ok {
+		t.Fatal("Expected ErrOutOfSequence, got", err)
+	}
+	if _, total, _ := j.List(context.Background(), id, 0, 10); total != 0 {
+		t.Fatal("Expected a broken batch not to be appended, got total", total)
+	}
+
+	if err := j.Append(context.Background(), id, domain.SignatureRecord{Counter: 0}, domain.SignatureRecord{Counter: 1}); err != nil {
+		t.Fatal("Expected nil err, got", err)
+	}
+	if _, total, _ := j.List(context.Background(), id, 0, 10); total != 2 {
+		t.Fatal("Expected total to be 2, got", total)
+	}
+}
+
+func TestJournalAppend(t *testing.T) {
+	j := NewMemoryJournal()
+	id := uuid.New()
+	for i := uint(0); i < 5; i++ {
+		if err := j.Append(context.Background(), id, domain.SignatureRecord{Counter: i, Data: "data"}); err != nil {
+			t.Fatal("Expected nil err, got", err)
+		}
+	}
+
+	record, err := j.Get(context.Background(), id, 3)
+	if err != nil {
+		t.Fatal("Expected nil err, got", err)
+	}
+	if record == nil || record.Counter != 3 {
+		t.Fatal("Expected record with counter 3, got", record)
+	}
+
+	list, total, err := j.List(context.Background(), id, 3, 10)
+	if err != nil {
+		t.Fatal("Expected nil err, got", err)
+	}
+	if total != 5 {
+		t.Fatal("Expected total to be 5, got", total)
+	}
+	if len(list) != 2 || list[0].Counter != 3 || list[1].Counter != 4 {
+		t.Fatal("Expected records 3 and 4, got", list)
+	}
+
+	list, _, err = j.List(context.Background(), id, 5, 10)
+	if err != nil {
+		t.Fatal("Expected nil err, got", err)
+	}
+	if list == nil || len(list) != 0 {
+		t.Fatal("Expected empty non-nil list, got", list)
+	}
+
+	list, total, err = j.List(context.Background(), uuid.New(), 0, 10)
+	if err != nil {
+		t.Fatal("Expected nil err, got", err)
+	}
+	if len(list) != 0 || total != 0 {
+		t.Fatal("Expected empty list, got", list, total)
+	}
+}
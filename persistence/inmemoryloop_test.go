@@ -0,0 +1,231 @@
+package persistence
+
+import (
+	"context"
+	"time"
+
+	"github.com/casell/signing-service-challenge/domain"
+	"github.com/google/uuid"
+)
+
+type operation interface {
+	out() chan *result
+}
+
+type baseOperation struct {
+	outch chan *result
+}
+
+func (o *baseOperation) out() chan *result {
+	return o.outch
+}
+
+type getOperation struct {
+	operation
+	id uuid.UUID
+}
+
+type addOperation struct {
+	operation
+	data domain.SigningDevice
+}
+
+type putOperation struct {
+	operation
+	data domain.SigningDevice
+}
+
+type listOperation struct {
+	operation
+}
+
+type result struct {
+	data domain.SigningDevice
+	list []domain.SigningDevice
+	err  error
+}
+
+// loopMemoryStore is the former MemoryStore, serving every operation from a single goroutine.
+// It is kept as the baseline of the benchmarks.
+type loopMemoryStore struct {
+	items   map[uuid.UUID]domain.SigningDevice
+	request chan operation
+	journal *MemoryJournal
+}
+
+func newLoopMemoryStore() *loopMemoryStore {
+	m := loopMemoryStore{
+		items:   make(map[uuid.UUID]domain.SigningDevice),
+		request: make(chan operation),
+		journal: NewMemoryJournal(),
+	}
+	go m.start()
+	return &m
+}
+
+// start serves the operations one at a time. Replies go to a buffered channel,
+// so a caller that gave up waiting never blocks the loop.
+func (m *loopMemoryStore) start() {
+	for r := range m.request {
+		switch x := r.(type) {
+		case *getOperation:
+			x.out() <- &result{
+				data: m.items[x.id],
+			}
+		case *addOperation:
+			d := x.data
+			id := d.ID()
+			m.items[id] = d
+			x.out() <- &result{
+				data: m.items[id],
+			}
+		case *putOperation:
+			d := x.data
+			id := d.ID()
+			_, ok := m.items[id]
+			if ok {
+				m.items[id] = d
+			}
+			x.out() <- &result{
+				data: m.items[id],
+			}
+		case *listOperation:
+			list := make([]domain.SigningDevice, 0, len(m.items))
+			for _, v := range m.items {
+				list = append(list, v)
+			}
+			x.out() <- &result{list: list}
+		}
+		close(r.out())
+	}
+}
+
+// do hands op to the serving loop and waits for its result, giving up as soon as ctx is done.
+func (m *loopMemoryStore) do(ctx context.Context, op operation) (*result, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	select {
+	case m.request <- op:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	select {
+	case res := <-op.out():
+		return res, res.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func newBaseOperation() *baseOperation {
+	return &baseOperation{
+		outch: make(chan *result, 1),
+	}
+}
+
+func (m *loopMemoryStore) Add(ctx context.Context, x domain.SigningDevice) error {
+	_, err := m.do(ctx, &addOperation{
+		operation: newBaseOperation(),
+		data:      x,
+	})
+	return err
+}
+
+func (m *loopMemoryStore) Put(ctx context.Context, x domain.SigningDevice) error {
+	_, err := m.do(ctx, &putOperation{
+		operation: newBaseOperation(),
+		data:      x,
+	})
+	return err
+}
+
+func (m *loopMemoryStore) Get(ctx context.Context, id uuid.UUID) (domain.SigningDevice, error) {
+	res, err := m.do(ctx, &getOperation{
+		operation: newBaseOperation(),
+		id:        id,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.data, nil
+}
+
+func (m *loopMemoryStore) List(ctx context.Context) ([]domain.SigningDevice, error) {
+	res, err := m.do(ctx, &listOperation{
+		operation: newBaseOperation(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.list, nil
+}
+
+// SignAndCommit ignores idempotencyKey, the benchmarks do not use it.
+func (m *loopMemoryStore) SignAndCommit(ctx context.Context, id uuid.UUID, dataToBeSigned string, _ string) (*domain.SignatureRecord, error) {
+	device, err := m.Get(ctx, id)
+	if err != nil || device == nil {
+		return nil, err
+	}
+
+	record, err := device.SignAndCommit(ctx, dataToBeSigned, func(record domain.SignatureRecord, _ domain.DeviceState) error {
+		return m.journal.Append(ctx, id, record)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+func (m *loopMemoryStore) PurgeIdempotencyKeys(ctx context.Context, before time.Time) error {
+	return nil
+}
+
+func (m *loopMemoryStore) SignBatchAndCommit(ctx context.Context, id uuid.UUID, dataToBeSigned []string) ([]domain.SignatureRecord, error) {
+	device, err := m.Get(ctx, id)
+	if err != nil || device == nil {
+		return nil, err
+	}
+	return device.SignBatchAndCommit(ctx, dataToBeSigned, func(records []domain.SignatureRecord, _ domain.DeviceState) error {
+		return m.journal.Append(ctx, id, records...)
+	})
+}
+
+func (m *loopMemoryStore) SetStatus(ctx context.Context, id uuid.UUID, status domain.DeviceStatus) (domain.SigningDevice, error) {
+	device, err := m.Get(ctx, id)
+	if err != nil || device == nil {
+		return nil, err
+	}
+	if err := device.Transition(ctx, status); err != nil {
+		return nil, err
+	}
+	return device, nil
+}
+
+func (m *loopMemoryStore) UpdateProfile(ctx context.Context, id uuid.UUID, revision uint, label *string, metadata map[string]string) (domain.SigningDevice, error) {
+	device, err := m.Get(ctx, id)
+	if err != nil || device == nil {
+		return nil, err
+	}
+	if _, err := device.UpdateProfile(ctx, revision, label, metadata); err != nil {
+		return nil, err
+	}
+	return device, nil
+}
+
+func (m *loopMemoryStore) Query(ctx context.Context, q DeviceQuery) (*DevicePage, error) {
+	devices, err := m.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return queryDevices(devices, q)
+}
+
+// PendingEvents reports no event, the baseline keeps no outbox.
+func (m *loopMemoryStore) PendingEvents(ctx context.Context, limit int) ([]OutboxEvent, error) {
+	return []OutboxEvent{}, nil
+}
+
+func (m *loopMemoryStore) DeleteEvents(ctx context.Context, ids []uuid.UUID) error {
+	return nil
+}
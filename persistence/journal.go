@@ -0,0 +1,19 @@
+package persistence
+
+import (
+	"context"
+
+	"github.com/casell/signing-service-challenge/domain"
+	"github.com/google/uuid"
+)
+
+// SignatureJournal is an append-only log of the signatures produced by each device.
+type SignatureJournal interface {
+	// Append adds records to the device journal, records must be appended in counter order.
+	// Either all the records are appended or none is.
+	Append(ctx context.Context, deviceID uuid.UUID, records ...domain.SignatureRecord) error
+	// Get returns the record with the given counter, nil if missing.
+	Get(ctx context.Context, deviceID uuid.UUID, counter uint) (*domain.SignatureRecord, error)
+	// List returns up to limit records starting from offset, together with the total number of records.
+	List(ctx context.Context, deviceID uuid.UUID, offset int, limit int) ([]domain.SignatureRecord, int, error)
+}
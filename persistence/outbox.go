@@ -0,0 +1,80 @@
+package persistence
+
+import (
+	"context"
+	"time"
+
+	"github.com/casell/signing-service-challenge/domain"
+	"github.com/google/uuid"
+)
+
+// OutboxEvent is a device event committed together with the change it reports.
+type OutboxEvent struct {
+	ID uuid.UUID
+	domain.Event
+}
+
+// EventOutbox keeps the device events until their consumer has taken them over. Storage writes an event
+// in the same commit as the change it reports, so that neither is persisted without the other:
+// device.created on Add, signature.created on every signature and device.status_changed on SetStatus.
+type EventOutbox interface {
+	// PendingEvents returns up to limit events not yet taken over, oldest first.
+	PendingEvents(ctx context.Context, limit int) ([]OutboxEvent, error)
+	// DeleteEvents removes the events once taken over, unknown IDs are ignored.
+	DeleteEvents(ctx context.Context, ids []uuid.UUID) error
+}
+
+func newOutboxEvent(event domain.Event) OutboxEvent {
+	return OutboxEvent{ID: uuid.New(), Event: event}
+}
+
+func signatureOutboxEvents(deviceID uuid.UUID, records []domain.SignatureRecord) []OutboxEvent {
+	events := make([]OutboxEvent, len(records))
+	for i := range records {
+		events[i] = newOutboxEvent(domain.SignatureCreatedEvent(deviceID, &records[i]))
+	}
+	return events
+}
+
+// storedEvent is the serialized form of an OutboxEvent. Sequence orders the events of a FileStore.
+type storedEvent struct {
+	ID        uuid.UUID           `json:"id"`
+	Sequence  uint64              `json:"sequence,omitempty"`
+	Type      string              `json:"type"`
+	DeviceID  uuid.UUID           `json:"device_id"`
+	Time      time.Time           `json:"time"`
+	Status    domain.DeviceStatus `json:"status,omitempty"`
+	Signature *storedSignature    `json:"signature,omitempty"`
+}
+
+func marshalEvent(event OutboxEvent) storedEvent {
+	stored := storedEvent{
+		ID:       event.ID,
+		Type:     event.Event.Type,
+		DeviceID: event.Event.DeviceID,
+		Time:     event.Event.Time,
+		Status:   event.Event.Status,
+	}
+	if event.Event.Record != nil {
+		signature := marshalSignature(*event.Event.Record)
+		stored.Signature = &signature
+	}
+	return stored
+}
+
+func unmarshalEvent(stored storedEvent) OutboxEvent {
+	event := OutboxEvent{
+		ID: stored.ID,
+		Event: domain.Event{
+			Type:     stored.Type,
+			DeviceID: stored.DeviceID,
+			Time:     stored.Time,
+			Status:   stored.Status,
+		},
+	}
+	if stored.Signature != nil {
+		record := unmarshalSignature(*stored.Signature)
+		event.Event.Record = &record
+	}
+	return event
+}
@@ -0,0 +1,140 @@
+package persistence
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	mycrypto "github.com/casell/signing-service-challenge/crypto"
+	"github.com/casell/signing-service-challenge/domain"
+	"github.com/google/uuid"
+)
+
+func pendingEvents(t *testing.T, outbox EventOutbox, limit int) []OutboxEvent {
+	events, err := outbox.PendingEvents(context.Background(), limit)
+	if err != nil {
+		t.Fatal("Expected nil err, got", err)
+	}
+	return events
+}
+
+func TestEventOutbox(t *testing.T) {
+	for _, tc := range storages {
+		t.Run(tc.name, func(t *testing.T) {
+			store := tc.new(t)
+			ctx := context.Background()
+			d := addIdempotencyDevice(t, store)
+
+			if _, err := store.SignAndCommit(ctx, d.ID(), "single", "key"); err != nil {
+				t.Fatal("Expected nil err, got", err)
+			}
+			// A replayed signature is not reported again.
+			if _, err := store.SignAndCommit(ctx, d.ID(), "single", "key"); err != nil {
+				t.Fatal("Expected nil err, got", err)
+			}
+			if _, err := store.SignBatchAndCommit(ctx, d.ID(), []string{"first", "second"}); err != nil {
+				t.Fatal("Expected nil err, got", err)
+			}
+			if _, err := store.SetStatus(ctx, d.ID(), domain.STATUS_DISABLED); err != nil {
+				t.Fatal("Expected nil err, got", err)
+			}
+			// A failed transition is not reported.
+			if _, err := store.SetStatus(ctx, d.ID(), domain.STATUS_INITIALIZED); err == nil {
+				t.Fatal("Expected invalid transition err, got nil")
+			}
+
+			events := pendingEvents(t, store, 10)
+			if len(events) != 5 {
+				t.Fatal("Expected 5 events, got", events)
+			}
+			for i, expected := range []string{domain.EVENT_DEVICE_CREATED, domain.EVENT_SIGNATURE_CREATED, domain.EVENT_SIGNATURE_CREATED, domain.EVENT_SIGNATURE_CREATED, domain.EVENT_STATUS_CHANGED} {
+				if events[i].Type != expected || events[i].DeviceID != d.ID() || events[i].ID == uuid.Nil {
+					t.Fatal("Expected a", expected, "event, got", events[i])
+				}
+			}
+			for i, data := range []string{"single", "first", "second"} {
+				record := events[i+1].Record
+				if record == nil || record.Counter != uint(i) || record.Data != data || record.Signature == "" {
+					t.Fatal("Expected the signature", i, "got", record)
+				}
+			}
+			if events[4].Status != domain.STATUS_DISABLED {
+				t.Fatal("Expected the new status, got", events[4].Status)
+			}
+
+			if limited := pendingEvents(t, store, 2); len(limited) != 2 || limited[0].ID != events[0].ID || limited[1].ID != events[1].ID {
+				t.Fatal("Expected the limit to apply, got", limited)
+			}
+			if err := store.DeleteEvents(ctx, []uuid.UUID{events[0].ID, events[1].ID, uuid.New()}); err != nil {
+				t.Fatal("Expected nil DELETE err, got", err)
+			}
+			if left := pendingEvents(t, store, 10); len(left) != 3 || left[0].ID != events[2].ID {
+				t.Fatal("Expected the events left, got", left)
+			}
+		})
+	}
+}
+
+func TestFileStoreEventOutboxReopen(t *testing.T) {
+	dir := t.TempDir()
+	f := newFileStore(t, dir, 0)
+	d := addIdempotencyDevice(t, f)
+	ctx := context.Background()
+	if _, err := f.SignAndCommit(ctx, d.ID(), "logged", ""); err != nil {
+		t.Fatal("Expected nil err, got", err)
+	}
+	created := pendingEvents(t, f, 1)
+	if err := f.DeleteEvents(ctx, []uuid.UUID{created[0].ID}); err != nil {
+		t.Fatal("Expected nil DELETE err, got", err)
+	}
+
+	rf := newFileStore(t, dir, 0)
+	events := pendingEvents(t, rf, 10)
+	if len(events) != 1 || events[0].Type != domain.EVENT_SIGNATURE_CREATED || events[0].Record.Data != "logged" {
+		t.Fatal("Expected the pending event restored from the log, got", events)
+	}
+	if _, err := rf.SignAndCommit(ctx, d.ID(), "snapshot", ""); err != nil {
+		t.Fatal("Expected nil err, got", err)
+	}
+	if err := rf.Close(); err != nil {
+		t.Fatal("Expected nil CLOSE err, got", err)
+	}
+
+	rrf := newFileStore(t, dir, 0)
+	restored := pendingEvents(t, rrf, 10)
+	if len(restored) != 2 || restored[0].ID != events[0].ID || restored[1].Record.Data != "snapshot" {
+		t.Fatal("Expected the pending events restored from the snapshot in order, got", restored)
+	}
+}
+
+func TestAddPublishesDeviceCreated(t *testing.T) {
+	for _, tc := range storages {
+		t.Run(tc.name, func(t *testing.T) {
+			store := tc.new(t)
+			bus := domain.NewEventBus(domain.DEFAULT_EVENT_HISTORY)
+			s, _ := bus.Subscribe(0)
+			defer s.Close()
+
+			d, err := domain.NewEventDeviceFactory(bus).New(uuid.Nil, domain.STATUS_INITIALIZED, "ED25519", nil, mycrypto.Parameters{})
+			if err != nil {
+				t.Fatal("Expected nil err creating device, got", err)
+			}
+			if err := store.Add(context.Background(), d); err != nil {
+				t.Fatal("Expected nil ADD err, got", err)
+			}
+			if event := <-s.C; event.Type != domain.EVENT_DEVICE_CREATED || event.DeviceID != d.ID() || event.Status != domain.STATUS_INITIALIZED {
+				t.Fatal("Expected the device created event, got", event)
+			}
+
+			// A device which is not stored is not announced.
+			if err := store.Add(context.Background(), d); !errors.As(err, &ErrAlreadyExists{}) {
+				t.Fatal("Expected already exists err, got", err)
+			}
+			select {
+			case event := <-s.C:
+				t.Fatal("Unexpected event", event)
+			default:
+			}
+		})
+	}
+}
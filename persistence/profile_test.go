@@ -0,0 +1,120 @@
+package persistence
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/casell/signing-service-challenge/domain"
+	"github.com/google/uuid"
+)
+
+func TestUpdateProfile(t *testing.T) {
+	for _, tc := range storages {
+		t.Run(tc.name, func(t *testing.T) {
+			store := tc.new(t)
+			d := addIdempotencyDevice(t, store)
+			ctx := context.Background()
+
+			label := "new label"
+			if _, err := store.UpdateProfile(ctx, d.ID(), 0, &label, map[string]string{"site": "berlin"}); err != nil {
+				t.Fatal("Expected nil err, got", err)
+			}
+			if _, err := store.UpdateProfile(ctx, d.ID(), 0, nil, nil); !errors.As(err, &domain.ErrRevisionMismatch{}) {
+				t.Fatal("Expected revision mismatch err, got", err)
+			}
+			if _, err := store.SignAndCommit(ctx, d.ID(), "data", ""); err != nil {
+				t.Fatal("Expected nil err, got", err)
+			}
+
+			stored, err := store.Get(ctx, d.ID())
+			if err != nil {
+				t.Fatal("Expected nil err, got", err)
+			}
+			profile := stored.Profile()
+			if profile.Revision != 1 || *profile.Label != label || profile.Metadata["site"] != "berlin" {
+				t.Fatal("Expected the profile to survive signing, got", profile)
+			}
+			if counter, _ := stored.CounterAndLastSignature(); counter != 1 {
+				t.Fatal("Expected counter 1, got", counter)
+			}
+
+			if _, err := store.UpdateProfile(ctx, uuid.New(), 0, nil, nil); !errors.As(err, &ErrNotFound{}) {
+				t.Fatal("Expected not found err, got", err)
+			}
+		})
+	}
+}
+
+func TestUpdateProfileConcurrentSigning(t *testing.T) {
+	for _, tc := range storages {
+		t.Run(tc.name, func(t *testing.T) {
+			store := tc.new(t)
+			d := addIdempotencyDevice(t, store)
+			ctx := context.Background()
+
+			const rounds = 20
+			var wg sync.WaitGroup
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < rounds; i++ {
+					if _, err := store.SignAndCommit(ctx, d.ID(), "data", ""); err != nil {
+						t.Error("Expected nil SIGN err, got", err)
+						return
+					}
+				}
+			}()
+			go func() {
+				defer wg.Done()
+				for i := 0; i < rounds; i++ {
+					if _, err := store.UpdateProfile(ctx, d.ID(), uint(i), nil, map[string]string{"round": "x"}); err != nil {
+						t.Error("Expected nil UPDATE err, got", err)
+						return
+					}
+				}
+			}()
+			wg.Wait()
+
+			stored, err := store.Get(ctx, d.ID())
+			if err != nil {
+				t.Fatal("Expected nil err, got", err)
+			}
+			if counter, _ := stored.CounterAndLastSignature(); counter != rounds {
+				t.Fatal("Expected no signature to be lost, got counter", counter)
+			}
+			if revision := stored.Profile().Revision; revision != rounds {
+				t.Fatal("Expected no update to be lost, got revision", revision)
+			}
+		})
+	}
+}
+
+func TestFileStoreUpdateProfileReopen(t *testing.T) {
+	dir := t.TempDir()
+	f := newFileStore(t, dir, 0)
+	d := addIdempotencyDevice(t, f)
+	ctx := context.Background()
+
+	if _, err := f.UpdateProfile(ctx, d.ID(), 0, nil, map[string]string{"site": "berlin"}); err != nil {
+		t.Fatal("Expected nil err, got", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := f.SignAndCommit(ctx, d.ID(), "data", ""); err != nil {
+			t.Fatal("Expected nil err, got", err)
+		}
+	}
+
+	rf := newFileStore(t, dir, 0)
+	stored, err := rf.Get(ctx, d.ID())
+	if err != nil {
+		t.Fatal("Expected nil err, got", err)
+	}
+	if profile := stored.Profile(); profile.Revision != 1 || profile.Label != nil || profile.Metadata["site"] != "berlin" {
+		t.Fatal("Expected the profile restored from the log, got", profile)
+	}
+	if counter, _ := stored.CounterAndLastSignature(); counter != 2 {
+		t.Fatal("Expected counter 2, got", counter)
+	}
+}
@@ -0,0 +1,185 @@
+package persistence
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/casell/signing-service-challenge/domain"
+	"github.com/google/uuid"
+)
+
+// DeviceSortField is the key Query sorts devices by, ties are broken by device ID so the order is stable.
+type DeviceSortField string
+
+const (
+	SORT_BY_CREATED_AT DeviceSortField = "createdAt"
+	SORT_BY_LABEL      DeviceSortField = "label"
+)
+
+// DeviceQuery selects a page of devices. Empty filters match every device,
+// devices without a label sort as if their label was empty.
+type DeviceQuery struct {
+	Algorithm   string
+	Status      domain.DeviceStatus
+	LabelPrefix string
+	// Metadata matches devices having all the given entries.
+	Metadata   map[string]string
+	SortBy     DeviceSortField
+	Descending bool
+	// Limit bounds the page size, zero or less returns every matching device.
+	Limit int
+	// Cursor is the NextCursor of the previous page, empty for the first one.
+	Cursor string
+}
+
+// DevicePage is a page of devices matching a DeviceQuery, NextCursor is empty on the last page.
+type DevicePage struct {
+	Devices    []domain.SigningDevice
+	NextCursor string
+}
+
+// deviceCursor is the sort key of the last device of a page, opaque to clients.
+// The sort is part of it, so that a cursor is not used with a different order.
+type deviceCursor struct {
+	SortBy     DeviceSortField `json:"s"`
+	Descending bool            `json:"d,omitempty"`
+	CreatedAt  time.Time       `json:"c"`
+	Label      string          `json:"l,omitempty"`
+	ID         uuid.UUID       `json:"i"`
+}
+
+func (q DeviceQuery) sortBy() DeviceSortField {
+	if q.SortBy == "" {
+		return SORT_BY_CREATED_AT
+	}
+	return q.SortBy
+}
+
+func (q DeviceQuery) cursorOf(device domain.SigningDevice) deviceCursor {
+	return deviceCursor{
+		SortBy:     q.sortBy(),
+		Descending: q.Descending,
+		CreatedAt:  device.CreatedAt(),
+		Label:      labelKey(device.Label()),
+		ID:         device.ID(),
+	}
+}
+
+func labelKey(label *string) string {
+	if label == nil {
+		return ""
+	}
+	return *label
+}
+
+func encodeCursor(cursor deviceCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor returns nil for the first page.
+func (q DeviceQuery) decodeCursor() (*deviceCursor, error) {
+	if q.Cursor == "" {
+		return nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor{cursor: q.Cursor}
+	}
+	var cursor deviceCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, ErrInvalidCursor{cursor: q.Cursor}
+	}
+	if cursor.SortBy != q.sortBy() || cursor.Descending != q.Descending {
+		return nil, ErrInvalidCursor{cursor: q.Cursor}
+	}
+	return &cursor, nil
+}
+
+func (q DeviceQuery) validate() error {
+	switch q.SortBy {
+	case "", SORT_BY_CREATED_AT, SORT_BY_LABEL:
+		return nil
+	}
+	return ErrInvalidQuery{reason: "unknown sort field " + string(q.SortBy)}
+}
+
+func (q DeviceQuery) matches(device domain.SigningDevice) bool {
+	if q.Algorithm != "" && device.SignatureAlgorithm() != q.Algorithm {
+		return false
+	}
+	if q.Status != "" && device.Status() != q.Status {
+		return false
+	}
+	profile := device.Profile()
+	if q.LabelPrefix != "" && !strings.HasPrefix(labelKey(profile.Label), q.LabelPrefix) {
+		return false
+	}
+	for k, v := range q.Metadata {
+		if value, ok := profile.Metadata[k]; !ok || value != v {
+			return false
+		}
+	}
+	return true
+}
+
+// compare orders two cursors according to the query sort.
+func (q DeviceQuery) compare(a, b deviceCursor) int {
+	var c int
+	switch q.sortBy() {
+	case SORT_BY_LABEL:
+		c = strings.Compare(a.Label, b.Label)
+	default:
+		c = a.CreatedAt.Compare(b.CreatedAt)
+	}
+	if c == 0 {
+		c = strings.Compare(a.ID.String(), b.ID.String())
+	}
+	if q.Descending {
+		return -c
+	}
+	return c
+}
+
+// queryDevices runs q over devices held in memory.
+func queryDevices(devices []domain.SigningDevice, q DeviceQuery) (*DevicePage, error) {
+	if err := q.validate(); err != nil {
+		return nil, err
+	}
+	after, err := q.decodeCursor()
+	if err != nil {
+		return nil, err
+	}
+
+	type keyed struct {
+		device domain.SigningDevice
+		key    deviceCursor
+	}
+	matched := make([]keyed, 0, len(devices))
+	for _, device := range devices {
+		if !q.matches(device) {
+			continue
+		}
+		key := q.cursorOf(device)
+		if after != nil && q.compare(key, *after) <= 0 {
+			continue
+		}
+		matched = append(matched, keyed{device: device, key: key})
+	}
+	slices.SortFunc(matched, func(a, b keyed) int {
+		return q.compare(a.key, b.key)
+	})
+
+	page := &DevicePage{Devices: make([]domain.SigningDevice, 0, len(matched))}
+	if q.Limit > 0 && len(matched) > q.Limit {
+		matched = matched[:q.Limit]
+		page.NextCursor = encodeCursor(matched[len(matched)-1].key)
+	}
+	for _, v := range matched {
+		page.Devices = append(page.Devices, v.device)
+	}
+	return page, nil
+}
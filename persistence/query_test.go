@@ -0,0 +1,254 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	mycrypto "github.com/casell/signing-service-challenge/crypto"
+	"github.com/casell/signing-service-challenge/domain"
+	"github.com/google/uuid"
+)
+
+// addQueryDevice adds a device created at the given time, with label and metadata.
+func addQueryDevice(t *testing.T, store Storage, algorithm string, label *string, metadata map[string]string, createdAt time.Time) domain.SigningDevice {
+	d, err := deviceFactory.New(uuid.Nil, domain.STATUS_ACTIVE, algorithm, nil, mycrypto.Parameters{})
+	if err != nil {
+		t.Fatal("Expected nil err creating device, got", err)
+	}
+	state := d.State()
+	state.CreatedAt = createdAt
+	if d, err = deviceFactory.Restore(state); err != nil {
+		t.Fatal("Expected nil err restoring device, got", err)
+	}
+	if err := store.Add(context.Background(), d); err != nil {
+		t.Fatal("Expected nil ADD err, got", err)
+	}
+	if _, err := store.UpdateProfile(context.Background(), d.ID(), 0, label, metadata); err != nil {
+		t.Fatal("Expected nil UPDATE err, got", err)
+	}
+	return d
+}
+
+func pageIDs(page *DevicePage) []uuid.UUID {
+	ids := make([]uuid.UUID, len(page.Devices))
+	for i, d := range page.Devices {
+		ids[i] = d.ID()
+	}
+	return ids
+}
+
+func equalIDs(a, b []uuid.UUID) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestQueryDevices(t *testing.T) {
+	for _, tc := range storages {
+		t.Run(tc.name, func(t *testing.T) {
+			store := tc.new(t)
+			ctx := context.Background()
+			base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+			alpha, alps, beta, gamma := "alpha", "alps", "beta", "gamma"
+			berlin := map[string]string{"site": "berlin"}
+
+			devices := []domain.SigningDevice{
+				addQueryDevice(t, store, "ED25519", &alpha, berlin, base),
+				addQueryDevice(t, store, "ECC", &beta, map[string]string{"site": "hamburg"}, base.Add(time.Second)),
+				addQueryDevice(t, store, "ED25519", nil, nil, base.Add(2*time.Second)),
+				addQueryDevice(t, store, "ED25519", &alps, map[string]string{"site": "berlin", "floor": "2"}, base.Add(3*time.Second)),
+				addQueryDevice(t, store, "ECC", &gamma, nil, base.Add(4*time.Second)),
+			}
+			if _, err := store.SetStatus(ctx, devices[2].ID(), domain.STATUS_DISABLED); err != nil {
+				t.Fatal("Expected nil err, got", err)
+			}
+			ids := func(indexes ...int) []uuid.UUID {
+				res := make([]uuid.UUID, len(indexes))
+				for i, v := range indexes {
+					res[i] = devices[v].ID()
+				}
+				return res
+			}
+
+			tests := []struct {
+				name     string
+				query    DeviceQuery
+				expected []uuid.UUID
+			}{
+				{"all", DeviceQuery{}, ids(0, 1, 2, 3, 4)},
+				{"descending", DeviceQuery{Descending: true}, ids(4, 3, 2, 1, 0)},
+				{"label", DeviceQuery{SortBy: SORT_BY_LABEL}, ids(2, 0, 3, 1, 4)},
+				{"algorithm", DeviceQuery{Algorithm: "ED25519"}, ids(0, 2, 3)},
+				{"status", DeviceQuery{Status: domain.STATUS_DISABLED}, ids(2)},
+				{"label prefix", DeviceQuery{LabelPrefix: "al"}, ids(0, 3)},
+				{"label prefix wildcard", DeviceQuery{LabelPrefix: "al_"}, ids()},
+				{"metadata", DeviceQuery{Metadata: berlin}, ids(0, 3)},
+				{"metadata all entries", DeviceQuery{Metadata: map[string]string{"site": "berlin", "floor": "2"}}, ids(3)},
+				{"combined", DeviceQuery{Algorithm: "ECC", LabelPrefix: "g"}, ids(4)},
+			}
+			for _, test := range tests {
+				page, err := store.Query(ctx, test.query)
+				if err != nil {
+					t.Fatal(test.name, "expected nil err, got", err)
+				}
+				if !equalIDs(pageIDs(page), test.expected) || page.NextCursor != "" {
+					t.Fatal(test.name, "expected", test.expected, "got", pageIDs(page), page.NextCursor)
+				}
+			}
+		})
+	}
+}
+
+func TestQueryDevicesPagination(t *testing.T) {
+	for _, tc := range storages {
+		t.Run(tc.name, func(t *testing.T) {
+			store := tc.new(t)
+			ctx := context.Background()
+			base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+			same := "same"
+
+			expected := make([]uuid.UUID, 0)
+			for i := 0; i < 5; i++ {
+				// Equal sort keys are ordered by ID.
+				d := addQueryDevice(t, store, "ED25519", &same, nil, base)
+				expected = append(expected, d.ID())
+			}
+			full, err := store.Query(ctx, DeviceQuery{SortBy: SORT_BY_LABEL, Descending: true})
+			if err != nil {
+				t.Fatal("Expected nil err, got", err)
+			}
+
+			var walked []uuid.UUID
+			query := DeviceQuery{SortBy: SORT_BY_LABEL, Descending: true, Limit: 2}
+			for pages := 1; ; pages++ {
+				page, err := store.Query(ctx, query)
+				if err != nil {
+					t.Fatal("Expected nil err, got", err)
+				}
+				walked = append(walked, pageIDs(page)...)
+				if page.NextCursor == "" {
+					if pages != 3 {
+						t.Fatal("Expected 3 pages, got", pages)
+					}
+					break
+				}
+				query.Cursor = page.NextCursor
+			}
+			if !equalIDs(walked, pageIDs(full)) || len(walked) != len(expected) {
+				t.Fatal("Expected the pages to add up to", pageIDs(full), "got", walked)
+			}
+
+			if _, err := store.Query(ctx, DeviceQuery{Cursor: "garbage"}); !errors.As(err, &ErrInvalidCursor{}) {
+				t.Fatal("Expected invalid cursor err, got", err)
+			}
+			page, _ := store.Query(ctx, DeviceQuery{SortBy: SORT_BY_LABEL, Limit: 1})
+			if _, err := store.Query(ctx, DeviceQuery{Limit: 1, Cursor: page.NextCursor}); !errors.As(err, &ErrInvalidCursor{}) {
+				t.Fatal("Expected a cursor of another sort to be rejected, got", err)
+			}
+			if _, err := store.Query(ctx, DeviceQuery{SortBy: "counter"}); !errors.As(err, &ErrInvalidQuery{}) {
+				t.Fatal("Expected invalid query err, got", err)
+			}
+		})
+	}
+}
+
+func TestSQLStoreMigrateBackfill(t *testing.T) {
+	db := openSQLite(t, filepath.Join(t.TempDir(), "store.db"))
+	ctx := context.Background()
+
+	// Bring the schema to the version before the metadata index and the device timestamps.
+	s := &SQLStore{db: db, placeholder: QUESTION_PLACEHOLDER, factory: deviceFactory}
+	if _, err := db.Exec("CREATE TABLE schema_migrations (version INTEGER NOT NULL PRIMARY KEY)"); err != nil {
+		t.Fatal("Expected nil err, got", err)
+	}
+	const before = 8
+	for i := 0; i < before; i++ {
+		err := s.inTx(ctx, func(tx *sql.Tx) error {
+			if err := migrations[i](ctx, s, tx); err != nil {
+				return err
+			}
+			_, err := tx.Exec("INSERT INTO schema_migrations (version) VALUES (?)", i+1)
+			return err
+		})
+		if err != nil {
+			t.Fatal("Expected nil migration err, got", err)
+		}
+	}
+
+	d, err := deviceFactory.New(uuid.Nil, domain.STATUS_ACTIVE, "ED25519", nil, mycrypto.Parameters{})
+	if err != nil {
+		t.Fatal("Expected nil err creating device, got", err)
+	}
+	stored, err := marshalDevice(d)
+	if err != nil {
+		t.Fatal("Expected nil err, got", err)
+	}
+	_, err = db.Exec(`INSERT INTO devices (id, signature_algorithm, key_size, curve, hash, signature_counter, last_signature,
+		private_key, version, public_key, metadata) VALUES (?, ?, 0, '', '', 0, ?, ?, 1, ?, ?)`,
+		stored.ID.String(), stored.SignatureAlgorithm, stored.LastSignatureB64, string(stored.PrivateKey), string(stored.PublicKey), `{"site":"berlin"}`)
+	if err != nil {
+		t.Fatal("Expected nil INSERT err, got", err)
+	}
+	signedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	_, err = db.Exec("INSERT INTO signatures (device_id, counter, data, signed_data, signature, created_at) VALUES (?, 0, '', '', '', ?)",
+		stored.ID.String(), signedAt)
+	if err != nil {
+		t.Fatal("Expected nil INSERT err, got", err)
+	}
+
+	if err := s.Migrate(ctx); err != nil {
+		t.Fatal("Expected nil MIGRATE err, got", err)
+	}
+	page, err := s.Query(ctx, DeviceQuery{Metadata: map[string]string{"site": "berlin"}})
+	if err != nil {
+		t.Fatal("Expected nil err, got", err)
+	}
+	if len(page.Devices) != 1 || page.Devices[0].ID() != d.ID() {
+		t.Fatal("Expected the device to be found by its migrated metadata, got", pageIDs(page))
+	}
+	if page.Devices[0].CreatedAt().IsZero() {
+		t.Fatal("Expected the creation time to be backfilled")
+	}
+	if !page.Devices[0].LastSignedAt().Equal(signedAt) {
+		t.Fatal("Expected the last signature time to be backfilled from the journal, got", page.Devices[0].LastSignedAt())
+	}
+}
+
+func TestDeviceTimestamps(t *testing.T) {
+	for _, tc := range storages {
+		t.Run(tc.name, func(t *testing.T) {
+			store := tc.new(t)
+			d := addIdempotencyDevice(t, store)
+			ctx := context.Background()
+
+			stored, err := store.Get(ctx, d.ID())
+			if err != nil {
+				t.Fatal("Expected nil err, got", err)
+			}
+			if !stored.CreatedAt().Equal(d.CreatedAt()) || !stored.LastSignedAt().IsZero() {
+				t.Fatal("Expected creation time", d.CreatedAt(), "and no last signature, got", stored.CreatedAt(), stored.LastSignedAt())
+			}
+
+			record, err := store.SignAndCommit(ctx, d.ID(), "data", "")
+			if err != nil {
+				t.Fatal("Expected nil err, got", err)
+			}
+			if stored, err = store.Get(ctx, d.ID()); err != nil {
+				t.Fatal("Expected nil err, got", err)
+			}
+			if !stored.LastSignedAt().Equal(record.Timestamp) {
+				t.Fatal("Expected last signature time", record.Timestamp, "got", stored.LastSignedAt())
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package persistence
+
+import (
+	"time"
+
+	mycrypto "github.com/casell/signing-service-challenge/crypto"
+	"github.com/casell/signing-service-challenge/domain"
+	"github.com/google/uuid"
+)
+
+// storedDevice is the serialized form of a domain.DeviceState.
+// The key pair is kept as the PEM encoded keys produced by KeyPair.Marshal, decommissioned devices have no private key.
+// Devices stored before lifecycle states were introduced have no status and are ACTIVE.
+type storedDevice struct {
+	ID                 uuid.UUID           `json:"id"`
+	SignatureAlgorithm string              `json:"signature_algorithm"`
+	Label              *string             `json:"label,omitempty"`
+	KeySize            int                 `json:"key_size,omitempty"`
+	Curve              string              `json:"curve,omitempty"`
+	Hash               string              `json:"hash,omitempty"`
+	SignatureCounter   uint                `json:"signature_counter"`
+	LastSignatureB64   string              `json:"last_signature"`
+	LastSignedAt       time.Time           `json:"last_signed_at"`
+	PrivateKey         []byte              `json:"private_key,omitempty"`
+	PublicKey          []byte              `json:"public_key,omitempty"`
+	Status             domain.DeviceStatus `json:"status,omitempty"`
+	Metadata           map[string]string   `json:"metadata,omitempty"`
+	Revision           uint                `json:"revision,omitempty"`
+	CreatedAt          time.Time           `json:"created_at"`
+}
+
+// setProfile overwrites the profile fields, profile updates only touch these.
+func (s *storedDevice) setProfile(profile domain.DeviceProfile) {
+	s.Label = profile.Label
+	s.Metadata = profile.Metadata
+	s.Revision = profile.Revision
+}
+
+func (s *storedDevice) profile() domain.DeviceProfile {
+	return domain.DeviceProfile{Label: s.Label, Metadata: s.Metadata, Revision: s.Revision}
+}
+
+// storedSignature is the serialized form of a domain.SignatureRecord.
+type storedSignature struct {
+	Counter    uint      `json:"counter"`
+	Data       string    `json:"data"`
+	SignedData string    `json:"secured_data_to_be_signed"`
+	Signature  string    `json:"signature"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+func marshalDevice(device domain.SigningDevice) (*storedDevice, error) {
+	return marshalState(device.State())
+}
+
+func marshalState(state domain.DeviceState) (*storedDevice, error) {
+	pub, priv, err := state.KeyPair.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	return &storedDevice{
+		ID:                 state.ID,
+		SignatureAlgorithm: state.SignatureAlgorithm,
+		Label:              state.Label,
+		KeySize:            state.Parameters.KeySize,
+		Curve:              state.Parameters.Curve,
+		Hash:               state.Parameters.Hash,
+		SignatureCounter:   state.SignatureCounter,
+		LastSignatureB64:   state.LastSignatureB64,
+		LastSignedAt:       state.LastSignedAt,
+		PrivateKey:         priv,
+		PublicKey:          pub,
+		Status:             state.Status,
+		Metadata:           state.Metadata,
+		Revision:           state.Revision,
+		CreatedAt:          state.CreatedAt,
+	}, nil
+}
+
+func unmarshalDevice(factory domain.SigningDeviceFactory, stored *storedDevice) (domain.SigningDevice, error) {
+	g, err := mycrypto.FromString(stored.SignatureAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	var keyPair mycrypto.KeyPair
+	if len(stored.PrivateKey) > 0 {
+		keyPair, err = g.Unmarshal(stored.PrivateKey)
+	} else {
+		keyPair, err = g.UnmarshalPublic(stored.PublicKey)
+	}
+	if err != nil {
+		return nil, err
+	}
+	status := stored.Status
+	if status == "" {
+		status = domain.STATUS_ACTIVE
+	}
+	return factory.Restore(domain.DeviceState{
+		ID:                 stored.ID,
+		SignatureAlgorithm: stored.SignatureAlgorithm,
+		Label:              stored.Label,
+		Metadata:           stored.Metadata,
+		Revision:           stored.Revision,
+		Parameters: mycrypto.Parameters{
+			KeySize: stored.KeySize,
+			Curve:   stored.Curve,
+			Hash:    stored.Hash,
+		},
+		SignatureCounter: stored.SignatureCounter,
+		LastSignatureB64: stored.LastSignatureB64,
+		LastSignedAt:     stored.LastSignedAt,
+		KeyPair:          keyPair,
+		Status:           status,
+		CreatedAt:        stored.CreatedAt,
+	})
+}
+
+func marshalSignature(record domain.SignatureRecord) storedSignature {
+	return storedSignature{
+		Counter:    record.Counter,
+		Data:       record.Data,
+		SignedData: record.SignedData,
+		Signature:  record.Signature,
+		Timestamp:  record.Timestamp,
+	}
+}
+
+func unmarshalSignature(stored storedSignature) domain.SignatureRecord {
+	return domain.SignatureRecord{
+		Counter:    stored.Counter,
+		Data:       stored.Data,
+		SignedData: stored.SignedData,
+		Signature:  stored.Signature,
+		Timestamp:  stored.Timestamp,
+	}
+}
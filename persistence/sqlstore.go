@@ -0,0 +1,1183 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/casell/signing-service-challenge/domain"
+	"github.com/google/uuid"
+)
+
+// SQLPlaceholder is the bind parameter syntax understood by the database driver.
+type SQLPlaceholder int
+
+const (
+	// QUESTION_PLACEHOLDER binds parameters as ?, e.g. SQLite and MySQL.
+	QUESTION_PLACEHOLDER SQLPlaceholder = iota
+	// DOLLAR_PLACEHOLDER binds parameters as $1, $2, ..., e.g. PostgreSQL.
+	DOLLAR_PLACEHOLDER
+)
+
+// SQL_SIGN_ATTEMPTS bounds how many times SignAndCommit retries after losing a race with another writer.
+const SQL_SIGN_ATTEMPTS = 5
+
+// migrations are applied in order, the index plus one is the schema version.
+// Only portable types and constraints are used so the schema runs unchanged on SQLite, PostgreSQL and MySQL.
+var migrations = []migration{
+	statement(`CREATE TABLE devices (
+		id VARCHAR(36) NOT NULL PRIMARY KEY,
+		signature_algorithm VARCHAR(32) NOT NULL,
+		label VARCHAR(255),
+		key_size INTEGER NOT NULL,
+		curve VARCHAR(16) NOT NULL,
+		hash VARCHAR(16) NOT NULL,
+		signature_counter BIGINT NOT NULL,
+		last_signature TEXT NOT NULL,
+		private_key TEXT NOT NULL,
+		version BIGINT NOT NULL
+	)`),
+	statement(`CREATE TABLE signatures (
+		device_id VARCHAR(36) NOT NULL REFERENCES devices (id),
+		counter BIGINT NOT NULL,
+		data TEXT NOT NULL,
+		signed_data TEXT NOT NULL,
+		signature TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (device_id, counter)
+	)`),
+	statement(`CREATE TABLE idempotency_keys (
+		device_id VARCHAR(36) NOT NULL REFERENCES devices (id),
+		idempotency_key VARCHAR(255) NOT NULL,
+		counter BIGINT NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (device_id, idempotency_key)
+	)`),
+	statement(`CREATE INDEX idempotency_keys_created_at ON idempotency_keys (created_at)`),
+	statement(`ALTER TABLE devices ADD COLUMN status VARCHAR(16) NOT NULL DEFAULT 'ACTIVE'`),
+	statement(`ALTER TABLE devices ADD COLUMN public_key TEXT`),
+	statement(`ALTER TABLE devices ADD COLUMN metadata TEXT`),
+	statement(`ALTER TABLE devices ADD COLUMN revision BIGINT NOT NULL DEFAULT 0`),
+	statement(`CREATE TABLE device_metadata (
+		device_id VARCHAR(36) NOT NULL REFERENCES devices (id),
+		meta_key VARCHAR(64) NOT NULL,
+		meta_value VARCHAR(256) NOT NULL,
+		PRIMARY KEY (device_id, meta_key)
+	)`),
+	indexMetadata,
+	statement(`CREATE INDEX device_metadata_entry ON device_metadata (meta_key, meta_value)`),
+	statement(`ALTER TABLE devices ADD COLUMN created_at TIMESTAMP`),
+	backfillCreatedAt,
+	statement(`CREATE INDEX devices_created_at ON devices (created_at, id)`),
+	statement(`CREATE INDEX devices_label ON devices (label, id)`),
+	statement(`ALTER TABLE devices ADD COLUMN last_signed_at TIMESTAMP`),
+	statement(`UPDATE devices SET last_signed_at = (SELECT MAX(created_at) FROM signatures WHERE signatures.device_id = devices.id)`),
+	statement(`CREATE TABLE webhooks (
+		id VARCHAR(36) NOT NULL PRIMARY KEY,
+		url TEXT NOT NULL,
+		event_types TEXT NOT NULL,
+		secret VARCHAR(255) NOT NULL,
+		created_at TIMESTAMP NOT NULL
+	)`),
+	statement(`CREATE TABLE webhook_deliveries (
+		id VARCHAR(36) NOT NULL PRIMARY KEY,
+		webhook_id VARCHAR(36) NOT NULL REFERENCES webhooks (id),
+		event_type VARCHAR(64) NOT NULL,
+		payload TEXT NOT NULL,
+		attempts INTEGER NOT NULL,
+		next_attempt_at TIMESTAMP NOT NULL,
+		last_error TEXT,
+		created_at TIMESTAMP NOT NULL
+	)`),
+	statement(`CREATE INDEX webhook_deliveries_next_attempt_at ON webhook_deliveries (next_attempt_at)`),
+	statement(`CREATE TABLE api_keys (
+		id VARCHAR(36) NOT NULL PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		scopes TEXT NOT NULL,
+		hash VARCHAR(64) NOT NULL UNIQUE,
+		created_at TIMESTAMP NOT NULL
+	)`),
+	statement(`CREATE TABLE event_outbox (
+		id VARCHAR(36) NOT NULL PRIMARY KEY,
+		event TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL
+	)`),
+	statement(`CREATE INDEX event_outbox_created_at ON event_outbox (created_at, id)`),
+}
+
+// migration brings the schema one version up, within the transaction recording the new version.
+type migration func(ctx context.Context, s *SQLStore, tx *sql.Tx) error
+
+// statement is a migration made of a single statement.
+func statement(query string) migration {
+	return func(ctx context.Context, _ *SQLStore, tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, query)
+		return err
+	}
+}
+
+// indexMetadata fills device_metadata, which Query filters on, from the metadata column.
+func indexMetadata(ctx context.Context, s *SQLStore, tx *sql.Tx) error {
+	rows, err := tx.QueryContext(ctx, "SELECT id, metadata FROM devices WHERE metadata IS NOT NULL")
+	if err != nil {
+		return err
+	}
+	metadata := make(map[uuid.UUID]map[string]string)
+	for rows.Next() {
+		var (
+			id      uuid.UUID
+			encoded string
+			decoded map[string]string
+		)
+		if err := rows.Scan(&id, &encoded); err != nil {
+			rows.Close()
+			return err
+		}
+		if err := json.Unmarshal([]byte(encoded), &decoded); err != nil {
+			rows.Close()
+			return fmt.Errorf("invalid metadata of device %s: %w", id, err)
+		}
+		metadata[id] = decoded
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for id, m := range metadata {
+		if err := s.writeMetadata(ctx, tx, id, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backfillCreatedAt stamps the devices created before the creation time was recorded with the migration time.
+func backfillCreatedAt(ctx context.Context, s *SQLStore, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, s.rebind("UPDATE devices SET created_at = ? WHERE created_at IS NULL"), time.Now().UTC())
+	return err
+}
+
+const deviceColumns = "id, signature_algorithm, label, key_size, curve, hash, signature_counter, last_signature, private_key, public_key, status, metadata, revision, created_at, last_signed_at"
+
+// SQLStore is a Storage backed by a database/sql database, Journal gives access to the related SignatureJournal.
+// Devices are restored from the database on every read so several service instances can share it.
+// Counter updates are optimistic: a Put only succeeds if nobody else advanced the device since it was read,
+// and the (device_id, counter) primary key guarantees a counter is never journaled twice.
+type SQLStore struct {
+	db          *sql.DB
+	placeholder SQLPlaceholder
+	factory     domain.SigningDeviceFactory
+}
+
+// NewSQLStore wraps db, restoring devices through factory, and brings the schema up to date.
+func NewSQLStore(db *sql.DB, placeholder SQLPlaceholder, factory domain.SigningDeviceFactory) (*SQLStore, error) {
+	s := &SQLStore{
+		db:          db,
+		placeholder: placeholder,
+		factory:     factory,
+	}
+	if err := s.Migrate(context.Background()); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Migrate applies the pending migrations, each one in its own transaction.
+func (s *SQLStore) Migrate(ctx context.Context) error {
+	if _, err := s.db.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER NOT NULL PRIMARY KEY)"); err != nil {
+		return fmt.Errorf("sqlstore: unable to create migrations table: %w", err)
+	}
+
+	var current int
+	if err := s.db.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&current); err != nil {
+		return fmt.Errorf("sqlstore: unable to read schema version: %w", err)
+	}
+
+	for i := current; i < len(migrations); i++ {
+		err := s.inTx(ctx, func(tx *sql.Tx) error {
+			if err := migrations[i](ctx, s, tx); err != nil {
+				return err
+			}
+			_, err := tx.ExecContext(ctx, s.rebind("INSERT INTO schema_migrations (version) VALUES (?)"), i+1)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("sqlstore: migration %d failed: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// rebind rewrites the ? placeholders of query to the driver syntax.
+func (s *SQLStore) rebind(query string) string {
+	if s.placeholder != DOLLAR_PLACEHOLDER {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func (s *SQLStore) inTx(ctx context.Context, f func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := f(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// nullTime stores the zero time as NULL.
+func nullTime(t time.Time) sql.NullTime {
+	if t.IsZero() {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: t.UTC(), Valid: true}
+}
+
+// encodeMetadata stores metadata as a JSON object, NULL when empty.
+func encodeMetadata(metadata map[string]string) (sql.NullString, error) {
+	if len(metadata) == 0 {
+		return sql.NullString{}, nil
+	}
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	return sql.NullString{String: string(data), Valid: true}, nil
+}
+
+// writeMetadata replaces the device_metadata entries of the device, which Query filters on.
+func (s *SQLStore) writeMetadata(ctx context.Context, tx *sql.Tx, id uuid.UUID, metadata map[string]string) error {
+	if _, err := tx.ExecContext(ctx, s.rebind("DELETE FROM device_metadata WHERE device_id = ?"), id.String()); err != nil {
+		return err
+	}
+	for k, v := range metadata {
+		_, err := tx.ExecContext(ctx, s.rebind("INSERT INTO device_metadata (device_id, meta_key, meta_value) VALUES (?, ?, ?)"), id.String(), k, v)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func (s *SQLStore) scanDevice(row rowScanner) (domain.SigningDevice, error) {
+	var (
+		stored     storedDevice
+		label      sql.NullString
+		privateKey string
+		publicKey  sql.NullString
+		metadata   sql.NullString
+		lastSigned sql.NullTime
+	)
+	err := row.Scan(&stored.ID, &stored.SignatureAlgorithm, &label, &stored.KeySize, &stored.Curve, &stored.Hash,
+		&stored.SignatureCounter, &stored.LastSignatureB64, &privateKey, &publicKey, &stored.Status, &metadata, &stored.Revision, &stored.CreatedAt, &lastSigned)
+	if err != nil {
+		return nil, err
+	}
+	if metadata.Valid {
+		if err := json.Unmarshal([]byte(metadata.String), &stored.Metadata); err != nil {
+			return nil, fmt.Errorf("sqlstore: invalid metadata of device %s: %w", stored.ID, err)
+		}
+	}
+	if label.Valid {
+		stored.Label = &label.String
+	}
+	stored.CreatedAt = stored.CreatedAt.UTC()
+	if lastSigned.Valid {
+		stored.LastSignedAt = lastSigned.Time.UTC()
+	}
+	stored.PrivateKey = []byte(privateKey)
+	stored.PublicKey = []byte(publicKey.String)
+
+	device, err := unmarshalDevice(s.factory, &stored)
+	if err != nil {
+		return nil, fmt.Errorf("sqlstore: unable to restore device %s: %w", stored.ID, err)
+	}
+	return device, nil
+}
+
+func (s *SQLStore) List(ctx context.Context) ([]domain.SigningDevice, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+deviceColumns+" FROM devices")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := make([]domain.SigningDevice, 0)
+	for rows.Next() {
+		device, err := s.scanDevice(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, device)
+	}
+	return list, rows.Err()
+}
+
+// Query runs the filters, the sort and the keyset pagination in the database.
+// Label prefixes are matched with LIKE, so the database collation applies.
+func (s *SQLStore) Query(ctx context.Context, q DeviceQuery) (*DevicePage, error) {
+	if err := q.validate(); err != nil {
+		return nil, err
+	}
+	after, err := q.decodeCursor()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		where []string
+		args  []any
+	)
+	if q.Algorithm != "" {
+		where = append(where, "signature_algorithm = ?")
+		args = append(args, q.Algorithm)
+	}
+	if q.Status != "" {
+		where = append(where, "status = ?")
+		args = append(args, string(q.Status))
+	}
+	if q.LabelPrefix != "" {
+		where = append(where, "label LIKE ? ESCAPE '!'")
+		args = append(args, escapeLike(q.LabelPrefix)+"%")
+	}
+	for k, v := range q.Metadata {
+		where = append(where, "EXISTS (SELECT 1 FROM device_metadata m WHERE m.device_id = devices.id AND m.meta_key = ? AND m.meta_value = ?)")
+		args = append(args, k, v)
+	}
+
+	key := "created_at"
+	if q.sortBy() == SORT_BY_LABEL {
+		key = "COALESCE(label, '')"
+	}
+	direction, comparison := "ASC", ">"
+	if q.Descending {
+		direction, comparison = "DESC", "<"
+	}
+	if after != nil {
+		where = append(where, fmt.Sprintf("(%[1]s %[2]s ? OR (%[1]s = ? AND id %[2]s ?))", key, comparison))
+		var value any = after.CreatedAt.UTC()
+		if q.sortBy() == SORT_BY_LABEL {
+			value = after.Label
+		}
+		args = append(args, value, value, after.ID.String())
+	}
+
+	query := "SELECT " + deviceColumns + " FROM devices"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += fmt.Sprintf(" ORDER BY %[1]s %[2]s, id %[2]s", key, direction)
+	if q.Limit > 0 {
+		// One more row tells whether there is a next page.
+		query += " LIMIT ?"
+		args = append(args, q.Limit+1)
+	}
+
+	rows, err := s.db.QueryContext(ctx, s.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	page := &DevicePage{Devices: make([]domain.SigningDevice, 0)}
+	for rows.Next() {
+		device, err := s.scanDevice(rows)
+		if err != nil {
+			return nil, err
+		}
+		page.Devices = append(page.Devices, device)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if q.Limit > 0 && len(page.Devices) > q.Limit {
+		page.Devices = page.Devices[:q.Limit]
+		page.NextCursor = encodeCursor(q.cursorOf(page.Devices[q.Limit-1]))
+	}
+	return page, nil
+}
+
+// escapeLike escapes the LIKE wildcards of a literal. The escape character is !, as backslash
+// is itself an escape in MySQL string literals.
+func escapeLike(literal string) string {
+	return strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(literal)
+}
+
+func (s *SQLStore) Get(ctx context.Context, id uuid.UUID) (domain.SigningDevice, error) {
+	row := s.db.QueryRowContext(ctx, s.rebind("SELECT "+deviceColumns+" FROM devices WHERE id = ?"), id.String())
+	device, err := s.scanDevice(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound{deviceID: id}
+	}
+	return device, err
+}
+
+type rowQuerier interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func (s *SQLStore) exists(ctx context.Context, q rowQuerier, id uuid.UUID) (bool, error) {
+	var exists int
+	err := q.QueryRowContext(ctx, s.rebind("SELECT 1 FROM devices WHERE id = ?"), id.String()).Scan(&exists)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *SQLStore) Add(ctx context.Context, x domain.SigningDevice) error {
+	return x.CreateAndCommit(ctx, func(state domain.DeviceState) error {
+		return s.add(ctx, state)
+	})
+}
+
+// add inserts the device of state together with the event announcing it.
+func (s *SQLStore) add(ctx context.Context, state domain.DeviceState) error {
+	stored, err := marshalState(state)
+	if err != nil {
+		return err
+	}
+	metadata, err := encodeMetadata(stored.Metadata)
+	if err != nil {
+		return err
+	}
+	err = s.inTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, s.rebind("INSERT INTO devices ("+deviceColumns+", version) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 1)"),
+			stored.ID.String(), stored.SignatureAlgorithm, stored.Label, stored.KeySize, stored.Curve, stored.Hash,
+			int64(stored.SignatureCounter), stored.LastSignatureB64, string(stored.PrivateKey), string(stored.PublicKey), string(stored.Status),
+			metadata, int64(stored.Revision), stored.CreatedAt.UTC(), nullTime(stored.LastSignedAt))
+		if err != nil {
+			return err
+		}
+		if err := s.writeMetadata(ctx, tx, stored.ID, stored.Metadata); err != nil {
+			return err
+		}
+		return s.insertEvents(ctx, tx, newOutboxEvent(domain.DeviceCreatedEvent(stored.ID, state.Status)))
+	})
+	if err != nil {
+		// Duplicated keys are reported differently by every driver, look the device up instead.
+		if exists, existsErr := s.exists(ctx, s.db, stored.ID); existsErr == nil && exists {
+			return ErrAlreadyExists{deviceID: stored.ID}
+		}
+	}
+	return err
+}
+
+// Put stores the device state if it is a descendant of the stored one: either the counter moved forward,
+// or it is unchanged together with the last signature. Anything else means another writer signed with
+// the same device in the meantime and ErrConflict is returned.
+func (s *SQLStore) Put(ctx context.Context, x domain.SigningDevice) error {
+	stored, err := marshalDevice(x)
+	if err != nil {
+		return err
+	}
+	counter := int64(stored.SignatureCounter)
+	metadata, err := encodeMetadata(stored.Metadata)
+	if err != nil {
+		return err
+	}
+
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, s.rebind(`UPDATE devices
+			SET label = ?, metadata = ?, revision = ?, signature_counter = ?, last_signature = ?, last_signed_at = ?, version = version + 1
+			WHERE id = ? AND (signature_counter < ? OR (signature_counter = ? AND last_signature = ?))`),
+			stored.Label, metadata, int64(stored.Revision), counter, stored.LastSignatureB64, nullTime(stored.LastSignedAt),
+			stored.ID.String(), counter, counter, stored.LastSignatureB64)
+		if err != nil {
+			return err
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if affected > 0 {
+			return s.writeMetadata(ctx, tx, stored.ID, stored.Metadata)
+		}
+
+		exists, err := s.exists(ctx, tx, stored.ID)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return ErrNotFound{deviceID: stored.ID}
+		}
+		return ErrConflict{deviceID: stored.ID}
+	})
+}
+
+// SignAndCommit updates the device and inserts the signature record, its event and the idempotency key in the same transaction.
+// The update is a compare-and-swap on the counter and last signature the device was read with:
+// if another instance signed in the meantime the device is read again and the signature redone,
+// unless the other instance used the same idempotency key, whose signature is then returned.
+func (s *SQLStore) SignAndCommit(ctx context.Context, id uuid.UUID, dataToBeSigned string, idempotencyKey string) (*domain.SignatureRecord, error) {
+	var err error
+	for attempt := 0; attempt < SQL_SIGN_ATTEMPTS; attempt++ {
+		if record, err := s.replay(ctx, id, dataToBeSigned, idempotencyKey); record != nil || err != nil {
+			return record, err
+		}
+		var record *domain.SignatureRecord
+		record, err = s.signAndCommit(ctx, id, dataToBeSigned, idempotencyKey)
+		if err != nil && idempotencyKey != "" && !errors.As(err, &ErrConflict{}) {
+			// Another instance may have signed with the key after the device was read: duplicated keys
+			// are reported differently by every driver, look the key up instead.
+			if record, replayErr := s.replay(ctx, id, dataToBeSigned, idempotencyKey); record != nil || replayErr != nil {
+				return record, replayErr
+			}
+		}
+		if !errors.As(err, &ErrConflict{}) {
+			return record, err
+		}
+	}
+	return nil, err
+}
+
+// replay returns the record already issued for idempotencyKey, nil if the key is unused.
+func (s *SQLStore) replay(ctx context.Context, id uuid.UUID, dataToBeSigned string, idempotencyKey string) (*domain.SignatureRecord, error) {
+	if idempotencyKey == "" {
+		return nil, nil
+	}
+	var counter int64
+	err := s.db.QueryRowContext(ctx, s.rebind("SELECT counter FROM idempotency_keys WHERE device_id = ? AND idempotency_key = ?"),
+		id.String(), idempotencyKey).Scan(&counter)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return replay(ctx, s.Journal(), id, idempotencyKey, uint(counter), dataToBeSigned)
+}
+
+func (s *SQLStore) signAndCommit(ctx context.Context, id uuid.UUID, dataToBeSigned string, idempotencyKey string) (*domain.SignatureRecord, error) {
+	device, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	counter, lastSignature := device.CounterAndLastSignature()
+
+	record, err := device.SignAndCommit(ctx, dataToBeSigned, func(record domain.SignatureRecord, state domain.DeviceState) error {
+		return s.inTx(ctx, func(tx *sql.Tx) error {
+			if err := s.advance(ctx, tx, id, counter, lastSignature, state); err != nil {
+				return err
+			}
+			if err := s.insertSignature(ctx, tx, id, record); err != nil {
+				return err
+			}
+			if err := s.insertEvents(ctx, tx, signatureOutboxEvents(id, []domain.SignatureRecord{record})...); err != nil {
+				return err
+			}
+			if idempotencyKey == "" {
+				return nil
+			}
+			_, err = tx.ExecContext(ctx, s.rebind("INSERT INTO idempotency_keys (device_id, idempotency_key, counter, created_at) VALUES (?, ?, ?, ?)"),
+				id.String(), idempotencyKey, int64(record.Counter), time.Now().UTC())
+			return err
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// SignBatchAndCommit updates the device and inserts all the records of the batch and their events in the same transaction,
+// retrying like SignAndCommit when another writer gets in between.
+func (s *SQLStore) SignBatchAndCommit(ctx context.Context, id uuid.UUID, dataToBeSigned []string) ([]domain.SignatureRecord, error) {
+	var err error
+	for attempt := 0; attempt < SQL_SIGN_ATTEMPTS; attempt++ {
+		var records []domain.SignatureRecord
+		records, err = s.signBatchAndCommit(ctx, id, dataToBeSigned)
+		if !errors.As(err, &ErrConflict{}) {
+			return records, err
+		}
+	}
+	return nil, err
+}
+
+func (s *SQLStore) signBatchAndCommit(ctx context.Context, id uuid.UUID, dataToBeSigned []string) ([]domain.SignatureRecord, error) {
+	device, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	counter, lastSignature := device.CounterAndLastSignature()
+
+	return device.SignBatchAndCommit(ctx, dataToBeSigned, func(records []domain.SignatureRecord, state domain.DeviceState) error {
+		return s.inTx(ctx, func(tx *sql.Tx) error {
+			if err := s.advance(ctx, tx, id, counter, lastSignature, state); err != nil {
+				return err
+			}
+			for _, record := range records {
+				if err := s.insertSignature(ctx, tx, id, record); err != nil {
+					return err
+				}
+			}
+			return s.insertEvents(ctx, tx, signatureOutboxEvents(id, records)...)
+		})
+	})
+}
+
+// advance compares-and-swaps the counter and last signature the device was read with for the ones in state,
+// a concurrent signature or transition results in ErrConflict.
+func (s *SQLStore) advance(ctx context.Context, tx *sql.Tx, id uuid.UUID, counter uint, lastSignature string, state domain.DeviceState) error {
+	res, err := tx.ExecContext(ctx, s.rebind(`UPDATE devices
+		SET signature_counter = ?, last_signature = ?, last_signed_at = ?, version = version + 1
+		WHERE id = ? AND signature_counter = ? AND last_signature = ? AND status = ?`),
+		int64(state.SignatureCounter), state.LastSignatureB64, state.LastSignedAt.UTC(),
+		id.String(), int64(counter), lastSignature, string(domain.STATUS_ACTIVE))
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrConflict{deviceID: id}
+	}
+	return nil
+}
+
+// SetStatus compares-and-swaps the status the device was read with, a concurrent transition results in ErrConflict.
+// Decommissioning overwrites the private key column. The status event is inserted in the same transaction.
+func (s *SQLStore) SetStatus(ctx context.Context, id uuid.UUID, status domain.DeviceStatus) (domain.SigningDevice, error) {
+	device, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	from := device.Status()
+
+	err = device.TransitionAndCommit(ctx, status, func(state domain.DeviceState) error {
+		stored, err := marshalState(state)
+		if err != nil {
+			return err
+		}
+		return s.inTx(ctx, func(tx *sql.Tx) error {
+			res, err := tx.ExecContext(ctx, s.rebind(`UPDATE devices
+				SET status = ?, private_key = ?, public_key = ?, version = version + 1
+				WHERE id = ? AND status = ?`),
+				string(stored.Status), string(stored.PrivateKey), string(stored.PublicKey),
+				id.String(), string(from))
+			if err != nil {
+				return err
+			}
+			affected, err := res.RowsAffected()
+			if err != nil {
+				return err
+			}
+			if affected == 0 {
+				return ErrConflict{deviceID: id}
+			}
+			return s.insertEvents(ctx, tx, newOutboxEvent(domain.StatusChangedEvent(id, status)))
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return device, nil
+}
+
+// UpdateProfile compares-and-swaps the revision, a concurrent update results in domain.ErrRevisionMismatch.
+// Only the profile columns are written, so it does not race with the signing state.
+func (s *SQLStore) UpdateProfile(ctx context.Context, id uuid.UUID, revision uint, label *string, metadata map[string]string) (domain.SigningDevice, error) {
+	device, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = device.UpdateProfileAndCommit(ctx, revision, label, metadata, func(profile domain.DeviceProfile) error {
+		encoded, err := encodeMetadata(profile.Metadata)
+		if err != nil {
+			return err
+		}
+		return s.inTx(ctx, func(tx *sql.Tx) error {
+			res, err := tx.ExecContext(ctx, s.rebind(`UPDATE devices
+				SET label = ?, metadata = ?, revision = ?, version = version + 1
+				WHERE id = ? AND revision = ?`),
+				profile.Label, encoded, int64(profile.Revision),
+				id.String(), int64(revision))
+			if err != nil {
+				return err
+			}
+			affected, err := res.RowsAffected()
+			if err != nil {
+				return err
+			}
+			if affected == 0 {
+				return s.revisionMismatch(ctx, tx, id, revision)
+			}
+			return s.writeMetadata(ctx, tx, id, profile.Metadata)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return device, nil
+}
+
+// revisionMismatch reports the revision the profile of id was updated to, in place of revision.
+func (s *SQLStore) revisionMismatch(ctx context.Context, tx *sql.Tx, id uuid.UUID, revision uint) error {
+	var actual int64
+	err := tx.QueryRowContext(ctx, s.rebind("SELECT revision FROM devices WHERE id = ?"), id.String()).Scan(&actual)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound{deviceID: id}
+	}
+	if err != nil {
+		return err
+	}
+	return domain.NewErrRevisionMismatch(id.String(), revision, uint(actual))
+}
+
+func (s *SQLStore) PurgeIdempotencyKeys(ctx context.Context, before time.Time) error {
+	_, err := s.db.ExecContext(ctx, s.rebind("DELETE FROM idempotency_keys WHERE created_at < ?"), before.UTC())
+	return err
+}
+
+func (s *SQLStore) insertSignature(ctx context.Context, tx *sql.Tx, deviceID uuid.UUID, record domain.SignatureRecord) error {
+	stored := marshalSignature(record)
+	_, err := tx.ExecContext(ctx, s.rebind("INSERT INTO signatures (device_id, "+signatureColumns+") VALUES (?, ?, ?, ?, ?, ?)"),
+		deviceID.String(), int64(stored.Counter), stored.Data, stored.SignedData, stored.Signature, stored.Timestamp.UTC())
+	return err
+}
+
+func (s *SQLStore) insertEvents(ctx context.Context, tx *sql.Tx, events ...OutboxEvent) error {
+	for _, event := range events {
+		data, err := json.Marshal(marshalEvent(event))
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, s.rebind("INSERT INTO event_outbox (id, event, created_at) VALUES (?, ?, ?)"),
+			event.ID.String(), string(data), event.Event.Time.UTC())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SQLStore) PendingEvents(ctx context.Context, limit int) ([]OutboxEvent, error) {
+	rows, err := s.db.QueryContext(ctx, s.rebind("SELECT event FROM event_outbox ORDER BY created_at, id LIMIT ?"), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []OutboxEvent{}
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var stored storedEvent
+		if err := json.Unmarshal([]byte(data), &stored); err != nil {
+			return nil, err
+		}
+		events = append(events, unmarshalEvent(stored))
+	}
+	return events, rows.Err()
+}
+
+func (s *SQLStore) DeleteEvents(ctx context.Context, ids []uuid.UUID) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		for _, id := range ids {
+			if _, err := tx.ExecContext(ctx, s.rebind("DELETE FROM event_outbox WHERE id = ?"), id.String()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Journal returns the SignatureJournal stored in the same database.
+func (s *SQLStore) Journal() *SQLJournal {
+	return &SQLJournal{store: s}
+}
+
+// SQLJournal is the SignatureJournal view of a SQLStore.
+type SQLJournal struct {
+	store *SQLStore
+}
+
+const signatureColumns = "counter, data, signed_data, signature, created_at"
+
+func scanSignature(row rowScanner) (domain.SignatureRecord, error) {
+	var stored storedSignature
+	if err := row.Scan(&stored.Counter, &stored.Data, &stored.SignedData, &stored.Signature, &stored.Timestamp); err != nil {
+		return domain.SignatureRecord{}, err
+	}
+	stored.Timestamp = stored.Timestamp.UTC()
+	return unmarshalSignature(stored), nil
+}
+
+func (j *SQLJournal) Append(ctx context.Context, deviceID uuid.UUID, records ...domain.SignatureRecord) error {
+	s := j.store
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		var next uint
+		err := tx.QueryRowContext(ctx, s.rebind("SELECT COALESCE(MAX(counter) + 1, 0) FROM signatures WHERE device_id = ?"), deviceID.String()).Scan(&next)
+		if err != nil {
+			return err
+		}
+		if err := checkSequence(deviceID, next, records); err != nil {
+			return err
+		}
+		for _, record := range records {
+			if err := s.insertSignature(ctx, tx, deviceID, record); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (j *SQLJournal) Get(ctx context.Context, deviceID uuid.UUID, counter uint) (*domain.SignatureRecord, error) {
+	s := j.store
+	row := s.db.QueryRowContext(ctx, s.rebind("SELECT "+signatureColumns+" FROM signatures WHERE device_id = ? AND counter = ?"), deviceID.String(), int64(counter))
+	record, err := scanSignature(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+func (j *SQLJournal) List(ctx context.Context, deviceID uuid.UUID, offset int, limit int) ([]domain.SignatureRecord, int, error) {
+	s := j.store
+
+	var total int
+	if err := s.db.QueryRowContext(ctx, s.rebind("SELECT COUNT(*) FROM signatures WHERE device_id = ?"), deviceID.String()).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	if offset < 0 || offset >= total || limit <= 0 {
+		return []domain.SignatureRecord{}, total, nil
+	}
+
+	rows, err := s.db.QueryContext(ctx, s.rebind("SELECT "+signatureColumns+" FROM signatures WHERE device_id = ? ORDER BY counter LIMIT ? OFFSET ?"),
+		deviceID.String(), limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	records := make([]domain.SignatureRecord, 0, limit)
+	for rows.Next() {
+		record, err := scanSignature(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		records = append(records, record)
+	}
+	return records, total, rows.Err()
+}
+
+// Webhooks returns the WebhookStorage stored in the same database.
+func (s *SQLStore) Webhooks() *SQLWebhookStore {
+	return &SQLWebhookStore{store: s}
+}
+
+// SQLWebhookStore is the WebhookStorage view of a SQLStore.
+type SQLWebhookStore struct {
+	store *SQLStore
+}
+
+const (
+	webhookColumns  = "id, url, event_types, secret, created_at"
+	deliveryColumns = "id, webhook_id, event_type, payload, attempts, next_attempt_at, last_error, created_at"
+)
+
+func scanWebhook(row rowScanner) (Webhook, error) {
+	var (
+		webhook    Webhook
+		eventTypes string
+	)
+	if err := row.Scan(&webhook.ID, &webhook.URL, &eventTypes, &webhook.Secret, &webhook.CreatedAt); err != nil {
+		return Webhook{}, err
+	}
+	if err := json.Unmarshal([]byte(eventTypes), &webhook.EventTypes); err != nil {
+		return Webhook{}, fmt.Errorf("invalid event types of webhook %s: %w", webhook.ID, err)
+	}
+	webhook.CreatedAt = webhook.CreatedAt.UTC()
+	return webhook, nil
+}
+
+func scanDelivery(row rowScanner) (WebhookDelivery, error) {
+	var (
+		delivery  WebhookDelivery
+		payload   string
+		lastError sql.NullString
+	)
+	err := row.Scan(&delivery.ID, &delivery.WebhookID, &delivery.EventType, &payload, &delivery.Attempts, &delivery.NextAttemptAt, &lastError, &delivery.CreatedAt)
+	if err != nil {
+		return WebhookDelivery{}, err
+	}
+	delivery.Payload = []byte(payload)
+	delivery.LastError = lastError.String
+	delivery.NextAttemptAt = delivery.NextAttemptAt.UTC()
+	delivery.CreatedAt = delivery.CreatedAt.UTC()
+	return delivery, nil
+}
+
+func (w *SQLWebhookStore) AddWebhook(ctx context.Context, webhook Webhook) error {
+	s := w.store
+	eventTypes, err := json.Marshal(webhook.EventTypes)
+	if err != nil {
+		return err
+	}
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		var n int
+		if err := tx.QueryRowContext(ctx, s.rebind("SELECT COUNT(*) FROM webhooks WHERE id = ?"), webhook.ID.String()).Scan(&n); err != nil {
+			return err
+		}
+		if n > 0 {
+			return ErrWebhookAlreadyExists{webhookID: webhook.ID}
+		}
+		_, err := tx.ExecContext(ctx, s.rebind("INSERT INTO webhooks ("+webhookColumns+") VALUES (?, ?, ?, ?, ?)"),
+			webhook.ID.String(), webhook.URL, string(eventTypes), webhook.Secret, webhook.CreatedAt.UTC())
+		return err
+	})
+}
+
+func (w *SQLWebhookStore) GetWebhook(ctx context.Context, id uuid.UUID) (*Webhook, error) {
+	s := w.store
+	webhook, err := scanWebhook(s.db.QueryRowContext(ctx, s.rebind("SELECT "+webhookColumns+" FROM webhooks WHERE id = ?"), id.String()))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrWebhookNotFound{webhookID: id}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &webhook, nil
+}
+
+// ListWebhooks returns the webhooks sorted by creation time.
+func (w *SQLWebhookStore) ListWebhooks(ctx context.Context) ([]Webhook, error) {
+	rows, err := w.store.db.QueryContext(ctx, "SELECT "+webhookColumns+" FROM webhooks ORDER BY created_at, id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []Webhook{}
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, rows.Err()
+}
+
+func (w *SQLWebhookStore) PutWebhook(ctx context.Context, webhook Webhook) error {
+	s := w.store
+	eventTypes, err := json.Marshal(webhook.EventTypes)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.ExecContext(ctx, s.rebind("UPDATE webhooks SET url = ?, event_types = ?, secret = ? WHERE id = ?"),
+		webhook.URL, string(eventTypes), webhook.Secret, webhook.ID.String())
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrWebhookNotFound{webhookID: webhook.ID}
+	}
+	return nil
+}
+
+func (w *SQLWebhookStore) DeleteWebhook(ctx context.Context, id uuid.UUID) error {
+	s := w.store
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, s.rebind("DELETE FROM webhook_deliveries WHERE webhook_id = ?"), id.String()); err != nil {
+			return err
+		}
+		res, err := tx.ExecContext(ctx, s.rebind("DELETE FROM webhooks WHERE id = ?"), id.String())
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return ErrWebhookNotFound{webhookID: id}
+		}
+		return nil
+	})
+}
+
+func (w *SQLWebhookStore) EnqueueDeliveries(ctx context.Context, deliveries []WebhookDelivery) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+	s := w.store
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		for _, delivery := range deliveries {
+			var count int
+			err := tx.QueryRowContext(ctx, s.rebind("SELECT COUNT(*) FROM webhook_deliveries WHERE id = ?"), delivery.ID.String()).Scan(&count)
+			if err != nil {
+				return err
+			}
+			if count > 0 {
+				continue
+			}
+			_, err = tx.ExecContext(ctx, s.rebind("INSERT INTO webhook_deliveries ("+deliveryColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?)"),
+				delivery.ID.String(), delivery.WebhookID.String(), delivery.EventType, string(delivery.Payload), delivery.Attempts,
+				delivery.NextAttemptAt.UTC(), sql.NullString{String: delivery.LastError, Valid: delivery.LastError != ""}, delivery.CreatedAt.UTC())
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (w *SQLWebhookStore) DueDeliveries(ctx context.Context, now time.Time, limit int) ([]WebhookDelivery, error) {
+	s := w.store
+	rows, err := s.db.QueryContext(ctx, s.rebind("SELECT "+deliveryColumns+" FROM webhook_deliveries WHERE next_attempt_at <= ? ORDER BY next_attempt_at LIMIT ?"),
+		now.UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	due := []WebhookDelivery{}
+	for rows.Next() {
+		delivery, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		due = append(due, delivery)
+	}
+	return due, rows.Err()
+}
+
+func (w *SQLWebhookStore) PutDelivery(ctx context.Context, delivery WebhookDelivery) error {
+	s := w.store
+	_, err := s.db.ExecContext(ctx, s.rebind("UPDATE webhook_deliveries SET attempts = ?, next_attempt_at = ?, last_error = ? WHERE id = ?"),
+		delivery.Attempts, delivery.NextAttemptAt.UTC(), sql.NullString{String: delivery.LastError, Valid: delivery.LastError != ""}, delivery.ID.String())
+	return err
+}
+
+func (w *SQLWebhookStore) DeleteDelivery(ctx context.Context, id uuid.UUID) error {
+	s := w.store
+	_, err := s.db.ExecContext(ctx, s.rebind("DELETE FROM webhook_deliveries WHERE id = ?"), id.String())
+	return err
+}
+
+// APIKeys returns the APIKeyStorage stored in the same database.
+func (s *SQLStore) APIKeys() *SQLAPIKeyStore {
+	return &SQLAPIKeyStore{store: s}
+}
+
+// SQLAPIKeyStore is the APIKeyStorage view of a SQLStore.
+type SQLAPIKeyStore struct {
+	store *SQLStore
+}
+
+const apiKeyColumns = "id, name, scopes, hash, created_at"
+
+func scanAPIKey(row rowScanner) (APIKey, error) {
+	var (
+		key    APIKey
+		scopes string
+	)
+	if err := row.Scan(&key.ID, &key.Name, &scopes, &key.Hash, &key.CreatedAt); err != nil {
+		return APIKey{}, err
+	}
+	if err := json.Unmarshal([]byte(scopes), &key.Scopes); err != nil {
+		return APIKey{}, fmt.Errorf("invalid scopes of API key %s: %w", key.ID, err)
+	}
+	key.CreatedAt = key.CreatedAt.UTC()
+	return key, nil
+}
+
+func (k *SQLAPIKeyStore) AddAPIKey(ctx context.Context, key APIKey) error {
+	s := k.store
+	scopes, err := json.Marshal(key.Scopes)
+	if err != nil {
+		return err
+	}
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		var n int
+		if err := tx.QueryRowContext(ctx, s.rebind("SELECT COUNT(*) FROM api_keys WHERE id = ? OR hash = ?"), key.ID.String(), key.Hash).Scan(&n); err != nil {
+			return err
+		}
+		if n > 0 {
+			return ErrAPIKeyAlreadyExists{keyID: key.ID}
+		}
+		_, err := tx.ExecContext(ctx, s.rebind("INSERT INTO api_keys ("+apiKeyColumns+") VALUES (?, ?, ?, ?, ?)"),
+			key.ID.String(), key.Name, string(scopes), key.Hash, key.CreatedAt.UTC())
+		return err
+	})
+}
+
+func (k *SQLAPIKeyStore) GetAPIKeyByHash(ctx context.Context, hash string) (*APIKey, error) {
+	s := k.store
+	key, err := scanAPIKey(s.db.QueryRowContext(ctx, s.rebind("SELECT "+apiKeyColumns+" FROM api_keys WHERE hash = ?"), hash))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// ListAPIKeys returns the keys sorted by creation time.
+func (k *SQLAPIKeyStore) ListAPIKeys(ctx context.Context) ([]APIKey, error) {
+	rows, err := k.store.db.QueryContext(ctx, "SELECT "+apiKeyColumns+" FROM api_keys ORDER BY created_at, id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (k *SQLAPIKeyStore) DeleteAPIKey(ctx context.Context, id uuid.UUID) error {
+	s := k.store
+	res, err := s.db.ExecContext(ctx, s.rebind("DELETE FROM api_keys WHERE id = ?"), id.String())
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrAPIKeyNotFound{keyID: id}
+	}
+	return nil
+}
@@ -0,0 +1,293 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	mycrypto "github.com/casell/signing-service-challenge/crypto"
+	"github.com/casell/signing-service-challenge/domain"
+	"github.com/google/uuid"
+	_ "modernc.org/sqlite"
+)
+
+func openSQLite(t *testing.T, path string) *sql.DB {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal("Expected nil err opening database, got", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func newSQLStore(t *testing.T, db *sql.DB) *SQLStore {
+	s, err := NewSQLStore(db, QUESTION_PLACEHOLDER, deviceFactory)
+	if err != nil {
+		t.Fatal("Expected nil err opening store, got", err)
+	}
+	return s
+}
+
+func TestSQLStoreReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.db")
+	s := newSQLStore(t, openSQLite(t, path))
+
+	label := "label"
+	d, err := deviceFactory.New(uuid.Nil, domain.STATUS_ACTIVE, "RSA-PSS", &label, mycrypto.Parameters{})
+	if err != nil {
+		t.Fatal("Expected nil err creating device, got", err)
+	}
+	if err := s.Add(context.Background(), d); err != nil {
+		t.Fatal("Expected nil ADD err, got", err)
+	}
+	signAndStore(t, s, s.Journal(), d, 3)
+
+	// A second instance sharing the database, migrations are not applied twice.
+	rs := newSQLStore(t, openSQLite(t, path))
+	checkRestored(t, rs, rs.Journal(), d)
+
+	r, _ := rs.Get(context.Background(), d.ID())
+	signAndStore(t, rs, rs.Journal(), r, 1)
+	checkRestored(t, s, s.Journal(), r)
+
+	list, err := s.List(context.Background())
+	if err != nil {
+		t.Fatal("Expected nil err, got", err)
+	}
+	if len(list) != 1 || list[0].ID() != d.ID() {
+		t.Fatal("Expected one device, got", list)
+	}
+}
+
+func TestSQLStoreNoLabel(t *testing.T) {
+	s := newSQLStore(t, openSQLite(t, filepath.Join(t.TempDir(), "store.db")))
+
+	d, err := deviceFactory.New(uuid.Nil, domain.STATUS_ACTIVE, "ED25519", nil, mycrypto.Parameters{})
+	if err != nil {
+		t.Fatal("Expected nil err creating device, got", err)
+	}
+	if err := s.Add(context.Background(), d); err != nil {
+		t.Fatal("Expected nil ADD err, got", err)
+	}
+	r, err := s.Get(context.Background(), d.ID())
+	if err != nil {
+		t.Fatal("Expected nil err, got", err)
+	}
+	if r == nil || r.Label() != nil {
+		t.Fatal("Expected device without label, got", r)
+	}
+}
+
+func TestSQLStoreConcurrentWriters(t *testing.T) {
+	s := newSQLStore(t, openSQLite(t, filepath.Join(t.TempDir(), "store.db")))
+
+	label := "label"
+	d, err := deviceFactory.New(uuid.Nil, domain.STATUS_ACTIVE, "ECC", &label, mycrypto.Parameters{})
+	if err != nil {
+		t.Fatal("Expected nil err creating device, got", err)
+	}
+	if err := s.Add(context.Background(), d); err != nil {
+		t.Fatal("Expected nil ADD err, got", err)
+	}
+
+	// Two instances read the same state and both sign the next counter.
+	first, _ := s.Get(context.Background(), d.ID())
+	second, _ := s.Get(context.Background(), d.ID())
+	signAndStore(t, s, s.Journal(), first, 1)
+
+	record, err := second.Sign(context.Background(), "data")
+	if err != nil {
+		t.Fatal("Expected nil err signing, got", err)
+	}
+	if err := s.Put(context.Background(), second); !errors.As(err, &ErrConflict{}) {
+		t.Fatal("Expected conflict err, got", err)
+	}
+	if err := s.Journal().Append(context.Background(), d.ID(), record); err == nil {
+		t.Fatal("Expected duplicated counter to be rejected")
+	}
+	checkRestored(t, s, s.Journal(), first)
+}
+
+func TestSQLStorePutNotFound(t *testing.T) {
+	s := newSQLStore(t, openSQLite(t, filepath.Join(t.TempDir(), "store.db")))
+
+	d, err := deviceFactory.New(uuid.Nil, domain.STATUS_ACTIVE, "ECC", nil, mycrypto.Parameters{})
+	if err != nil {
+		t.Fatal("Expected nil err creating device, got", err)
+	}
+	if err := s.Put(context.Background(), d); !errors.As(err, &ErrNotFound{}) {
+		t.Fatal("Expected not found err, got", err)
+	}
+	r, err := s.Get(context.Background(), d.ID())
+	if !errors.As(err, &ErrNotFound{}) || r != nil {
+		t.Fatalf("Expected nil device and not found err, got %v, %v", r, err)
+	}
+	if err := s.Add(context.Background(), d); err != nil {
+		t.Fatal("Expected nil ADD err, got", err)
+	}
+	if err := s.Add(context.Background(), d); !errors.As(err, &ErrAlreadyExists{}) {
+		t.Fatal("Expected already exists err, got", err)
+	}
+}
+
+func TestSQLJournal(t *testing.T) {
+	s := newSQLStore(t, openSQLite(t, filepath.Join(t.TempDir(), "store.db")))
+	j := s.Journal()
+
+	d, err := deviceFactory.New(uuid.Nil, domain.STATUS_ACTIVE, "ECC", nil, mycrypto.Parameters{})
+	if err != nil {
+		t.Fatal("Expected nil err creating device, got", err)
+	}
+	if err := s.Add(context.Background(), d); err != nil {
+		t.Fatal("Expected nil ADD err, got", err)
+	}
+	signAndStore(t, s, j, d, 5)
+
+	record, err := j.Get(context.Background(), d.ID(), 2)
+	if err != nil || record == nil || record.Counter != 2 {
+		t.Fatalf("Expected record 2, got %v, %v", record, err)
+	}
+	if record.Timestamp.IsZero() {
+		t.Fatal("Expected record timestamp to be set")
+	}
+	if record, err := j.Get(context.Background(), d.ID(), 5); err != nil || record != nil {
+		t.Fatalf("Expected missing record, got %v, %v", record, err)
+	}
+
+	records, total, err := j.List(context.Background(), d.ID(), 3, 10)
+	if err != nil {
+		t.Fatal("Expected nil err, got", err)
+	}
+	if total != 5 || len(records) != 2 || records[0].Counter != 3 || records[1].Counter != 4 {
+		t.Fatalf("Expected records 3 and 4 of 5, got %v (total %d)", records, total)
+	}
+
+	record.Counter = 7
+	if err := j.Append(context.Background(), d.ID(), *record); !errors.As(err, &ErrOutOfSequence{}) {
+		t.Fatal("Expected out of sequence err, got", err)
+	}
+}
+
+func TestSQLStoreRebind(t *testing.T) {
+	s := &SQLStore{placeholder: DOLLAR_PLACEHOLDER}
+	if q := s.rebind("SELECT 1 WHERE a = ? AND b = ?"); q != "SELECT 1 WHERE a = $1 AND b = $2" {
+		t.Fatal("Unexpected query", q)
+	}
+}
+
+func TestSQLStoreSignAndCommitConcurrent(t *testing.T) {
+	db := openSQLite(t, filepath.Join(t.TempDir(), "store.db"))
+	db.SetMaxOpenConns(1)
+	s := newSQLStore(t, db)
+
+	label := "label"
+	d, err := deviceFactory.New(uuid.Nil, domain.STATUS_ACTIVE, "ED25519", &label, mycrypto.Parameters{})
+	if err != nil {
+		t.Fatal("Expected nil err creating device, got", err)
+	}
+	if err := s.Add(context.Background(), d); err != nil {
+		t.Fatal("Expected nil ADD err, got", err)
+	}
+
+	requestsNo := 10
+	errs := make(chan error, requestsNo)
+	wg := sync.WaitGroup{}
+	wg.Add(requestsNo)
+	for i := 0; i < requestsNo; i++ {
+		go func() {
+			defer wg.Done()
+			_, err := s.SignAndCommit(context.Background(), d.ID(), "data", "")
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	signed := 0
+	for err := range errs {
+		switch {
+		case err == nil:
+			signed++
+		case !errors.As(err, &ErrConflict{}):
+			t.Fatal("Expected nil or conflict err, got", err)
+		}
+	}
+
+	r, err := s.Get(context.Background(), d.ID())
+	if err != nil {
+		t.Fatal("Expected nil err, got", err)
+	}
+	if counter, _ := r.CounterAndLastSignature(); int(counter) != signed {
+		t.Fatalf("Expected counter %d, got %d", signed, counter)
+	}
+	checkRestored(t, s, s.Journal(), r)
+
+	if record, err := s.SignAndCommit(context.Background(), uuid.New(), "data", ""); !errors.As(err, &ErrNotFound{}) || record != nil {
+		t.Fatalf("Expected nil record and not found err, got %v, %v", record, err)
+	}
+}
+
+// interleavedProfileDevice lets another instance update the profile between the read and the commit of its own.
+type interleavedProfileDevice struct {
+	domain.SigningDevice
+	before func()
+}
+
+func (d interleavedProfileDevice) UpdateProfileAndCommit(ctx context.Context, revision uint, label *string, metadata map[string]string, commit func(profile domain.DeviceProfile) error) (domain.DeviceProfile, error) {
+	d.before()
+	return d.SigningDevice.UpdateProfileAndCommit(ctx, revision, label, metadata, commit)
+}
+
+type interleavedProfileFactory struct {
+	domain.SigningDeviceFactory
+	before func()
+}
+
+func (f interleavedProfileFactory) Restore(state domain.DeviceState) (domain.SigningDevice, error) {
+	d, err := f.SigningDeviceFactory.Restore(state)
+	if err != nil {
+		return nil, err
+	}
+	return interleavedProfileDevice{SigningDevice: d, before: f.before}, nil
+}
+
+func TestSQLStoreUpdateProfileConcurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.db")
+	other := newSQLStore(t, openSQLite(t, path))
+
+	d, err := deviceFactory.New(uuid.Nil, domain.STATUS_ACTIVE, "ED25519", nil, mycrypto.Parameters{})
+	if err != nil {
+		t.Fatal("Expected nil err creating device, got", err)
+	}
+	if err := other.Add(context.Background(), d); err != nil {
+		t.Fatal("Expected nil ADD err, got", err)
+	}
+
+	label := "label"
+	s, err := NewSQLStore(openSQLite(t, path), QUESTION_PLACEHOLDER, interleavedProfileFactory{
+		SigningDeviceFactory: deviceFactory,
+		before: func() {
+			if _, err := other.UpdateProfile(context.Background(), d.ID(), 0, &label, nil); err != nil {
+				t.Fatal("Expected nil err updating from the other instance, got", err)
+			}
+		},
+	})
+	if err != nil {
+		t.Fatal("Expected nil err opening store, got", err)
+	}
+
+	// Both instances read revision 0, the other one commits first.
+	if _, err := s.UpdateProfile(context.Background(), d.ID(), 0, nil, map[string]string{"site": "berlin"}); !errors.As(err, &domain.ErrRevisionMismatch{}) {
+		t.Fatal("Expected revision mismatch err, got", err)
+	}
+	r, err := other.Get(context.Background(), d.ID())
+	if err != nil {
+		t.Fatal("Expected nil err, got", err)
+	}
+	if profile := r.Profile(); profile.Revision != 1 || len(profile.Metadata) != 0 {
+		t.Fatal("Expected the profile of the other instance, got", profile)
+	}
+}
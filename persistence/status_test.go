@@ -0,0 +1,183 @@
+package persistence
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/casell/signing-service-challenge/domain"
+	"github.com/google/uuid"
+)
+
+func TestSetStatus(t *testing.T) {
+	for _, tc := range storages {
+		t.Run(tc.name, func(t *testing.T) {
+			store := tc.new(t)
+			d := addIdempotencyDevice(t, store)
+			ctx := context.Background()
+
+			if _, err := store.SetStatus(ctx, d.ID(), domain.STATUS_DISABLED); err != nil {
+				t.Fatal("Expected nil err, got", err)
+			}
+			if _, err := store.SignAndCommit(ctx, d.ID(), "data", ""); !errors.As(err, &domain.ErrDeviceNotActive{}) {
+				t.Fatal("Expected not active err, got", err)
+			}
+			if _, err := store.SetStatus(ctx, d.ID(), domain.STATUS_ACTIVE); err != nil {
+				t.Fatal("Expected nil err, got", err)
+			}
+			record, err := store.SignAndCommit(ctx, d.ID(), "data", "")
+			if err != nil {
+				t.Fatal("Expected nil err, got", err)
+			}
+
+			device, err := store.SetStatus(ctx, d.ID(), domain.STATUS_DECOMMISSIONED)
+			if err != nil {
+				t.Fatal("Expected nil err, got", err)
+			}
+			if device.Status() != domain.STATUS_DECOMMISSIONED {
+				t.Fatal("Expected a decommissioned device, got", device.Status())
+			}
+			if _, err := store.SetStatus(ctx, d.ID(), domain.STATUS_ACTIVE); !errors.As(err, &domain.ErrInvalidTransition{}) {
+				t.Fatal("Expected invalid transition err, got", err)
+			}
+
+			stored, err := store.Get(ctx, d.ID())
+			if err != nil {
+				t.Fatal("Expected nil err, got", err)
+			}
+			if stored.Status() != domain.STATUS_DECOMMISSIONED || stored.KeyPair().PrivateKey() != nil {
+				t.Fatal("Expected a decommissioned device without private key, got", stored.Status())
+			}
+			if ok, err := stored.Verify(record.SignedData, record.Signature); !ok || err != nil {
+				t.Fatal("Expected the public key to be kept, got", err)
+			}
+
+			if _, err := store.SetStatus(ctx, uuid.New(), domain.STATUS_ACTIVE); !errors.As(err, &ErrNotFound{}) {
+				t.Fatal("Expected not found err, got", err)
+			}
+		})
+	}
+}
+
+func TestFileStoreDecommission(t *testing.T) {
+	dir := t.TempDir()
+	f := newFileStore(t, dir, 0)
+	d := addIdempotencyDevice(t, f)
+	ctx := context.Background()
+
+	signAndStore(t, f, f.Journal(), d, 3)
+	if _, err := f.SetStatus(ctx, d.ID(), domain.STATUS_DECOMMISSIONED); err != nil {
+		t.Fatal("Expected nil err, got", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal("Expected nil err listing", dir, err)
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal("Expected nil err reading", name, err)
+		}
+		if bytes.Contains(data, []byte("PRIVATE_KEY")) {
+			t.Fatal("Expected no private key left in", name)
+		}
+	}
+
+	rf := newFileStore(t, dir, 0)
+	r, err := rf.Get(ctx, d.ID())
+	if err != nil {
+		t.Fatal("Expected nil err, got", err)
+	}
+	if r.Status() != domain.STATUS_DECOMMISSIONED {
+		t.Fatal("Expected a decommissioned device, got", r.Status())
+	}
+	checkRestored(t, rf, rf.Journal(), d)
+}
+
+func TestSQLStoreDecommission(t *testing.T) {
+	db := openSQLite(t, filepath.Join(t.TempDir(), "store.db"))
+	s := newSQLStore(t, db)
+	d := addIdempotencyDevice(t, s)
+	ctx := context.Background()
+
+	if _, err := s.SetStatus(ctx, d.ID(), domain.STATUS_DECOMMISSIONED); err != nil {
+		t.Fatal("Expected nil err, got", err)
+	}
+
+	var privateKey string
+	if err := db.QueryRow("SELECT private_key FROM devices WHERE id = ?", d.ID().String()).Scan(&privateKey); err != nil {
+		t.Fatal("Expected nil err, got", err)
+	}
+	if privateKey != "" {
+		t.Fatal("Expected the private key to be removed")
+	}
+}
+
+func TestFileStoreDecommissionSnapshotFailure(t *testing.T) {
+	dir := t.TempDir()
+	f := newFileStore(t, dir, 0)
+	d := addIdempotencyDevice(t, f)
+	ctx := context.Background()
+
+	// A directory in the way of the snapshot makes writing it fail.
+	blocker := filepath.Join(dir, snapshotFileName+".tmp")
+	if err := os.Mkdir(blocker, 0o700); err != nil {
+		t.Fatal("Expected nil err, got", err)
+	}
+	if _, err := f.SetStatus(ctx, d.ID(), domain.STATUS_DECOMMISSIONED); err == nil {
+		t.Fatal("Expected the snapshot err, got nil")
+	}
+	if d.Status() != domain.STATUS_ACTIVE {
+		t.Fatal("Expected the device to stay active, got", d.Status())
+	}
+	if events := pendingEvents(t, f, 10); len(events) != 1 || events[0].Type != domain.EVENT_DEVICE_CREATED {
+		t.Fatal("Expected no status change event, got", events)
+	}
+	if _, err := f.SignAndCommit(ctx, d.ID(), "data", ""); err != nil {
+		t.Fatal("Expected nil err, got", err)
+	}
+	if rf := newFileStore(t, dir, 0); rf.stored[d.ID()].Status != domain.STATUS_ACTIVE {
+		t.Fatal("Expected the device restored active, got", rf.stored[d.ID()].Status)
+	}
+
+	if err := os.Remove(blocker); err != nil {
+		t.Fatal("Expected nil err, got", err)
+	}
+	if _, err := f.SetStatus(ctx, d.ID(), domain.STATUS_DECOMMISSIONED); err != nil {
+		t.Fatal("Expected nil err, got", err)
+	}
+}
+
+func TestFileStoreDecommissionStaleLog(t *testing.T) {
+	dir := t.TempDir()
+	f := newFileStore(t, dir, 0)
+	d := addIdempotencyDevice(t, f)
+	ctx := context.Background()
+	stale, err := os.ReadFile(filepath.Join(dir, walFileName))
+	if err != nil {
+		t.Fatal("Expected nil err, got", err)
+	}
+	if _, err := f.SetStatus(ctx, d.ID(), domain.STATUS_DECOMMISSIONED); err != nil {
+		t.Fatal("Expected nil err, got", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal("Expected nil CLOSE err, got", err)
+	}
+
+	// The log left as it was before the decommission, as when truncating it failed.
+	if err := os.WriteFile(filepath.Join(dir, walFileName), stale, 0o600); err != nil {
+		t.Fatal("Expected nil err, got", err)
+	}
+	r, err := newFileStore(t, dir, 0).Get(ctx, d.ID())
+	if err != nil {
+		t.Fatal("Expected nil err, got", err)
+	}
+	if r.Status() != domain.STATUS_DECOMMISSIONED || r.KeyPair().PrivateKey() != nil {
+		t.Fatal("Expected the device to stay decommissioned, got", r.Status())
+	}
+}
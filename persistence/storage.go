@@ -1,13 +1,39 @@
 package persistence
 
 import (
+	"context"
+	"time"
+
 	"github.com/casell/signing-service-challenge/domain"
 	"github.com/google/uuid"
 )
 
+// Storage keeps the signing devices, and the outbox of the events reporting their changes.
+// A missing device is reported as ErrNotFound, adding an existing ID as ErrAlreadyExists
+// and a concurrent modification as ErrConflict.
 type Storage interface {
-	List() ([]domain.SigningDevice, error)
-	Get(id uuid.UUID) (domain.SigningDevice, error)
-	Add(x domain.SigningDevice) error
-	Put(x domain.SigningDevice) error
+	EventOutbox
+	List(ctx context.Context) ([]domain.SigningDevice, error)
+	// Query returns the page of devices matching q, an invalid cursor fails with ErrInvalidCursor.
+	Query(ctx context.Context, q DeviceQuery) (*DevicePage, error)
+	Get(ctx context.Context, id uuid.UUID) (domain.SigningDevice, error)
+	Add(ctx context.Context, x domain.SigningDevice) error
+	// Put replaces a stored device, with ErrConflict if its counter went backwards or it left DECOMMISSIONED.
+	Put(ctx context.Context, x domain.SigningDevice) error
+	// SignAndCommit signs data with the device id, persisting the advanced counter together with the
+	// signature journal record: when an error is returned nothing was stored and the device is unchanged.
+	// A non empty idempotencyKey is stored with the signature: signing the same data with it again returns
+	// the original record, signing different data fails with ErrIdempotencyKeyReused.
+	SignAndCommit(ctx context.Context, id uuid.UUID, dataToBeSigned string, idempotencyKey string) (*domain.SignatureRecord, error)
+	// SignBatchAndCommit signs every element of dataToBeSigned in order, chaining the signatures with consecutive
+	// counters, see domain.SigningDevice.SignBatchAndCommit. The batch is persisted all or nothing.
+	SignBatchAndCommit(ctx context.Context, id uuid.UUID, dataToBeSigned []string) ([]domain.SignatureRecord, error)
+	// SetStatus moves the device id to status, see domain.SigningDevice.TransitionAndCommit.
+	// Decommissioning a device also removes its private key from the storage.
+	SetStatus(ctx context.Context, id uuid.UUID, status domain.DeviceStatus) (domain.SigningDevice, error)
+	// UpdateProfile replaces label and metadata of the device id, provided its profile is still at revision,
+	// see domain.SigningDevice.UpdateProfileAndCommit. Signatures are not held up by the update.
+	UpdateProfile(ctx context.Context, id uuid.UUID, revision uint, label *string, metadata map[string]string) (domain.SigningDevice, error)
+	// PurgeIdempotencyKeys forgets the idempotency keys stored before the given time.
+	PurgeIdempotencyKeys(ctx context.Context, before time.Time) error
 }
@@ -0,0 +1,238 @@
+package persistence
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const webhooksName = "webhooks"
+
+// Webhook is an endpoint registered to receive the events of the given types.
+// Secret is the key deliveries are HMAC-signed with.
+type Webhook struct {
+	ID         uuid.UUID `json:"id"`
+	URL        string    `json:"url"`
+	EventTypes []string  `json:"event_types"`
+	Secret     string    `json:"secret"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// WebhookDelivery is an event waiting in the outbox to be delivered to a webhook.
+// Payload is the request body, fixed when the event is enqueued.
+type WebhookDelivery struct {
+	ID            uuid.UUID `json:"id"`
+	WebhookID     uuid.UUID `json:"webhook_id"`
+	EventType     string    `json:"event_type"`
+	Payload       []byte    `json:"payload"`
+	Attempts      int       `json:"attempts"`
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+	LastError     string    `json:"last_error,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// WebhookStorage keeps the registered webhooks and the outbox of their pending deliveries.
+// A missing webhook is reported as ErrWebhookNotFound.
+type WebhookStorage interface {
+	AddWebhook(ctx context.Context, webhook Webhook) error
+	GetWebhook(ctx context.Context, id uuid.UUID) (*Webhook, error)
+	ListWebhooks(ctx context.Context) ([]Webhook, error)
+	PutWebhook(ctx context.Context, webhook Webhook) error
+	// DeleteWebhook removes the webhook id together with its pending deliveries.
+	DeleteWebhook(ctx context.Context, id uuid.UUID) error
+	// EnqueueDeliveries adds deliveries to the outbox, all or nothing. A delivery whose ID is already there is skipped.
+	EnqueueDeliveries(ctx context.Context, deliveries []WebhookDelivery) error
+	// DueDeliveries returns up to limit deliveries whose next attempt is not after now, oldest first.
+	DueDeliveries(ctx context.Context, now time.Time, limit int) ([]WebhookDelivery, error)
+	// PutDelivery stores the outcome of a failed attempt, a delivery no longer in the outbox is ignored.
+	PutDelivery(ctx context.Context, delivery WebhookDelivery) error
+	// DeleteDelivery removes a delivery from the outbox, once delivered or given up on.
+	DeleteDelivery(ctx context.Context, id uuid.UUID) error
+}
+
+// webhookState is the content of a MemoryWebhookStore.
+type webhookState struct {
+	Webhooks   map[uuid.UUID]Webhook         `json:"webhooks"`
+	Deliveries map[uuid.UUID]WebhookDelivery `json:"deliveries"`
+}
+
+// webhookChange is a change of a webhookState, the only field set tells which.
+type webhookChange struct {
+	AddWebhook        *Webhook          `json:"add_webhook,omitempty"`
+	PutWebhook        *Webhook          `json:"put_webhook,omitempty"`
+	DeleteWebhook     *uuid.UUID        `json:"delete_webhook,omitempty"`
+	EnqueueDeliveries []WebhookDelivery `json:"enqueue_deliveries,omitempty"`
+	PutDelivery       *WebhookDelivery  `json:"put_delivery,omitempty"`
+	DeleteDelivery    *uuid.UUID        `json:"delete_delivery,omitempty"`
+}
+
+func (c webhookChange) check(state *webhookState) error {
+	switch {
+	case c.AddWebhook != nil:
+		if _, ok := state.Webhooks[c.AddWebhook.ID]; ok {
+			return ErrWebhookAlreadyExists{webhookID: c.AddWebhook.ID}
+		}
+	case c.PutWebhook != nil:
+		if _, ok := state.Webhooks[c.PutWebhook.ID]; !ok {
+			return ErrWebhookNotFound{webhookID: c.PutWebhook.ID}
+		}
+	case c.DeleteWebhook != nil:
+		if _, ok := state.Webhooks[*c.DeleteWebhook]; !ok {
+			return ErrWebhookNotFound{webhookID: *c.DeleteWebhook}
+		}
+	case c.EnqueueDeliveries != nil:
+		for _, delivery := range c.EnqueueDeliveries {
+			if _, ok := state.Deliveries[delivery.ID]; !ok {
+				return nil
+			}
+		}
+		return errUnchanged
+	case c.PutDelivery != nil:
+		if _, ok := state.Deliveries[c.PutDelivery.ID]; !ok {
+			return errUnchanged
+		}
+	case c.DeleteDelivery != nil:
+		if _, ok := state.Deliveries[*c.DeleteDelivery]; !ok {
+			return errUnchanged
+		}
+	}
+	return nil
+}
+
+func (c webhookChange) apply(state *webhookState) {
+	switch {
+	case c.AddWebhook != nil:
+		state.Webhooks[c.AddWebhook.ID] = *c.AddWebhook
+	case c.PutWebhook != nil:
+		state.Webhooks[c.PutWebhook.ID] = *c.PutWebhook
+	case c.DeleteWebhook != nil:
+		id := *c.DeleteWebhook
+		delete(state.Webhooks, id)
+		maps.DeleteFunc(state.Deliveries, func(_ uuid.UUID, delivery WebhookDelivery) bool {
+			return delivery.WebhookID == id
+		})
+	case c.EnqueueDeliveries != nil:
+		for _, delivery := range c.EnqueueDeliveries {
+			if _, ok := state.Deliveries[delivery.ID]; !ok {
+				state.Deliveries[delivery.ID] = delivery
+			}
+		}
+	case c.PutDelivery != nil:
+		if _, ok := state.Deliveries[c.PutDelivery.ID]; ok {
+			state.Deliveries[c.PutDelivery.ID] = *c.PutDelivery
+		}
+	case c.DeleteDelivery != nil:
+		delete(state.Deliveries, *c.DeleteDelivery)
+	}
+}
+
+func newWebhookState() webhookState {
+	return webhookState{
+		Webhooks:   make(map[uuid.UUID]Webhook),
+		Deliveries: make(map[uuid.UUID]WebhookDelivery),
+	}
+}
+
+// MemoryWebhookStore is a WebhookStorage kept in memory.
+type MemoryWebhookStore struct {
+	state *loggedState[webhookState, webhookChange]
+}
+
+func NewMemoryWebhookStore() *MemoryWebhookStore {
+	return &MemoryWebhookStore{state: newLoggedState[webhookState, webhookChange](newWebhookState())}
+}
+
+// FileWebhookStore is a MemoryWebhookStore saved in a directory: every change is appended to webhooks.log,
+// and every DEFAULT_SNAPSHOT_EVERY changes the whole state is written to webhooks.json and the log truncated.
+type FileWebhookStore struct {
+	*MemoryWebhookStore
+}
+
+// NewFileWebhookStore opens the webhook storage saved in dir, creating it if needed.
+func NewFileWebhookStore(dir string) (*FileWebhookStore, error) {
+	state, err := openLoggedState[webhookState, webhookChange](dir, webhooksName, newWebhookState())
+	if err != nil {
+		return nil, err
+	}
+	return &FileWebhookStore{MemoryWebhookStore: &MemoryWebhookStore{state: state}}, nil
+}
+
+// Close closes the log, the next open replays it.
+func (f *FileWebhookStore) Close() error {
+	return f.state.close()
+}
+
+func (m *MemoryWebhookStore) AddWebhook(ctx context.Context, webhook Webhook) error {
+	return m.state.update(ctx, webhookChange{AddWebhook: &webhook})
+}
+
+func (m *MemoryWebhookStore) GetWebhook(ctx context.Context, id uuid.UUID) (*Webhook, error) {
+	var webhook Webhook
+	var ok bool
+	m.state.read(func(state *webhookState) {
+		webhook, ok = state.Webhooks[id]
+	})
+	if !ok {
+		return nil, ErrWebhookNotFound{webhookID: id}
+	}
+	return &webhook, nil
+}
+
+// ListWebhooks returns the webhooks sorted by creation time.
+func (m *MemoryWebhookStore) ListWebhooks(ctx context.Context) ([]Webhook, error) {
+	webhooks := []Webhook{}
+	m.state.read(func(state *webhookState) {
+		for _, webhook := range state.Webhooks {
+			webhooks = append(webhooks, webhook)
+		}
+	})
+	slices.SortFunc(webhooks, func(a, b Webhook) int {
+		if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID.String(), b.ID.String())
+	})
+	return webhooks, nil
+}
+
+func (m *MemoryWebhookStore) PutWebhook(ctx context.Context, webhook Webhook) error {
+	return m.state.update(ctx, webhookChange{PutWebhook: &webhook})
+}
+
+func (m *MemoryWebhookStore) DeleteWebhook(ctx context.Context, id uuid.UUID) error {
+	return m.state.update(ctx, webhookChange{DeleteWebhook: &id})
+}
+
+func (m *MemoryWebhookStore) EnqueueDeliveries(ctx context.Context, deliveries []WebhookDelivery) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+	return m.state.update(ctx, webhookChange{EnqueueDeliveries: deliveries})
+}
+
+func (m *MemoryWebhookStore) DueDeliveries(ctx context.Context, now time.Time, limit int) ([]WebhookDelivery, error) {
+	due := []WebhookDelivery{}
+	m.state.read(func(state *webhookState) {
+		for _, delivery := range state.Deliveries {
+			if !delivery.NextAttemptAt.After(now) {
+				due = append(due, delivery)
+			}
+		}
+	})
+	slices.SortFunc(due, func(a, b WebhookDelivery) int {
+		return a.NextAttemptAt.Compare(b.NextAttemptAt)
+	})
+	return due[:min(limit, len(due))], nil
+}
+
+func (m *MemoryWebhookStore) PutDelivery(ctx context.Context, delivery WebhookDelivery) error {
+	return m.state.update(ctx, webhookChange{PutDelivery: &delivery})
+}
+
+func (m *MemoryWebhookStore) DeleteDelivery(ctx context.Context, id uuid.UUID) error {
+	return m.state.update(ctx, webhookChange{DeleteDelivery: &id})
+}
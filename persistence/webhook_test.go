@@ -0,0 +1,248 @@
+package persistence
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// webhookStorages are the WebhookStorage implementations checked against the same behaviour.
+var webhookStorages = []struct {
+	name string
+	new  func(t *testing.T) WebhookStorage
+}{
+	{"memory", func(t *testing.T) WebhookStorage { return NewMemoryWebhookStore() }},
+	{"file", func(t *testing.T) WebhookStorage { return newFileWebhookStore(t, t.TempDir()) }},
+	{"sql", func(t *testing.T) WebhookStorage {
+		db := openSQLite(t, filepath.Join(t.TempDir(), "store.db"))
+		db.SetMaxOpenConns(1)
+		return newSQLStore(t, db).Webhooks()
+	}},
+}
+
+func newFileWebhookStore(t *testing.T, dir string) *FileWebhookStore {
+	s, err := NewFileWebhookStore(dir)
+	if err != nil {
+		t.Fatal("Expected nil err opening webhook store, got", err)
+	}
+	return s
+}
+
+func addWebhook(t *testing.T, store WebhookStorage, createdAt time.Time) Webhook {
+	webhook := Webhook{
+		ID:         uuid.New(),
+		URL:        "http://example.com/hook",
+		EventTypes: []string{"device.created", "signature.created"},
+		Secret:     "secret",
+		CreatedAt:  createdAt,
+	}
+	if err := store.AddWebhook(context.Background(), webhook); err != nil {
+		t.Fatal("Expected nil ADD err, got", err)
+	}
+	return webhook
+}
+
+func TestWebhookRegistry(t *testing.T) {
+	for _, tc := range webhookStorages {
+		t.Run(tc.name, func(t *testing.T) {
+			store := tc.new(t)
+			ctx := context.Background()
+			now := time.Now().UTC().Truncate(time.Second)
+
+			second := addWebhook(t, store, now.Add(time.Second))
+			first := addWebhook(t, store, now)
+			if err := store.AddWebhook(ctx, first); !errors.As(err, &ErrWebhookAlreadyExists{}) {
+				t.Fatal("Expected already exists err, got", err)
+			}
+
+			list, err := store.ListWebhooks(ctx)
+			if err != nil {
+				t.Fatal("Expected nil err, got", err)
+			}
+			if len(list) != 2 || list[0].ID != first.ID || list[1].ID != second.ID {
+				t.Fatal("Expected the webhooks by creation time, got", list)
+			}
+
+			first.URL = "http://example.com/other"
+			first.EventTypes = []string{"device.disabled"}
+			if err := store.PutWebhook(ctx, first); err != nil {
+				t.Fatal("Expected nil PUT err, got", err)
+			}
+			got, err := store.GetWebhook(ctx, first.ID)
+			if err != nil {
+				t.Fatal("Expected nil err, got", err)
+			}
+			if got.URL != first.URL || len(got.EventTypes) != 1 || got.EventTypes[0] != "device.disabled" || got.Secret != "secret" || !got.CreatedAt.Equal(now) {
+				t.Fatal("Expected the updated webhook, got", got)
+			}
+
+			if err := store.DeleteWebhook(ctx, first.ID); err != nil {
+				t.Fatal("Expected nil DELETE err, got", err)
+			}
+			if _, err := store.GetWebhook(ctx, first.ID); !errors.As(err, &ErrWebhookNotFound{}) {
+				t.Fatal("Expected not found err, got", err)
+			}
+			if err := store.PutWebhook(ctx, first); !errors.As(err, &ErrWebhookNotFound{}) {
+				t.Fatal("Expected not found PUT err, got", err)
+			}
+			if err := store.DeleteWebhook(ctx, first.ID); !errors.As(err, &ErrWebhookNotFound{}) {
+				t.Fatal("Expected not found DELETE err, got", err)
+			}
+		})
+	}
+}
+
+func TestWebhookOutbox(t *testing.T) {
+	for _, tc := range webhookStorages {
+		t.Run(tc.name, func(t *testing.T) {
+			store := tc.new(t)
+			ctx := context.Background()
+			now := time.Now().UTC().Truncate(time.Second)
+			webhook := addWebhook(t, store, now)
+			other := addWebhook(t, store, now)
+
+			delivery := func(webhookID uuid.UUID, at time.Time) WebhookDelivery {
+				return WebhookDelivery{
+					ID:            uuid.New(),
+					WebhookID:     webhookID,
+					EventType:     "signature.created",
+					Payload:       []byte(`{"type":"signature.created"}`),
+					NextAttemptAt: at,
+					CreatedAt:     now,
+				}
+			}
+			late := delivery(webhook.ID, now.Add(2*time.Second))
+			early := delivery(webhook.ID, now)
+			later := delivery(webhook.ID, now.Add(time.Hour))
+			orphan := delivery(other.ID, now.Add(time.Second))
+			if err := store.EnqueueDeliveries(ctx, []WebhookDelivery{late, early, later, orphan}); err != nil {
+				t.Fatal("Expected nil ENQUEUE err, got", err)
+			}
+
+			due, err := store.DueDeliveries(ctx, now.Add(2*time.Second), 10)
+			if err != nil {
+				t.Fatal("Expected nil err, got", err)
+			}
+			if len(due) != 3 || due[0].ID != early.ID || due[1].ID != orphan.ID || due[2].ID != late.ID {
+				t.Fatal("Expected the due deliveries oldest first, got", due)
+			}
+			if string(due[0].Payload) != string(early.Payload) || due[0].EventType != early.EventType || due[0].WebhookID != webhook.ID {
+				t.Fatal("Expected the stored delivery, got", due[0])
+			}
+			if due, _ := store.DueDeliveries(ctx, now.Add(2*time.Second), 1); len(due) != 1 || due[0].ID != early.ID {
+				t.Fatal("Expected the limit to apply, got", due)
+			}
+
+			early.Attempts = 1
+			early.LastError = "502 Bad Gateway"
+			early.NextAttemptAt = now.Add(3 * time.Second)
+			if err := store.PutDelivery(ctx, early); err != nil {
+				t.Fatal("Expected nil PUT err, got", err)
+			}
+			due, _ = store.DueDeliveries(ctx, now.Add(3*time.Second), 10)
+			if len(due) != 3 || due[2].ID != early.ID || due[2].Attempts != 1 || due[2].LastError != "502 Bad Gateway" {
+				t.Fatal("Expected the rescheduled delivery last, got", due)
+			}
+			// Enqueueing a delivery again leaves the pending one as it is.
+			if err := store.EnqueueDeliveries(ctx, []WebhookDelivery{delivery(webhook.ID, now), {ID: early.ID, WebhookID: webhook.ID, EventType: "signature.created", Payload: []byte(`{}`), NextAttemptAt: now, CreatedAt: now}}); err != nil {
+				t.Fatal("Expected nil ENQUEUE err, got", err)
+			}
+			due, _ = store.DueDeliveries(ctx, now.Add(3*time.Second), 10)
+			if len(due) != 4 || due[3].ID != early.ID || due[3].Attempts != 1 {
+				t.Fatal("Expected the pending delivery to be kept, got", due)
+			}
+			if err := store.DeleteDelivery(ctx, due[0].ID); err != nil {
+				t.Fatal("Expected nil DELETE err, got", err)
+			}
+
+			if err := store.DeleteDelivery(ctx, late.ID); err != nil {
+				t.Fatal("Expected nil DELETE err, got", err)
+			}
+			// Deleting a webhook drops its pending deliveries.
+			if err := store.DeleteWebhook(ctx, other.ID); err != nil {
+				t.Fatal("Expected nil DELETE err, got", err)
+			}
+			due, _ = store.DueDeliveries(ctx, now.Add(time.Hour), 10)
+			if len(due) != 2 || due[0].ID != early.ID || due[1].ID != later.ID {
+				t.Fatal("Expected the remaining deliveries, got", due)
+			}
+		})
+	}
+}
+
+func TestFileWebhookStoreReopen(t *testing.T) {
+	dir := t.TempDir()
+	s := newFileWebhookStore(t, dir)
+	now := time.Now().UTC()
+	webhook := addWebhook(t, s, now)
+	delivery := WebhookDelivery{ID: uuid.New(), WebhookID: webhook.ID, EventType: "device.created", Payload: []byte(`{}`), NextAttemptAt: now, CreatedAt: now}
+	if err := s.EnqueueDeliveries(context.Background(), []WebhookDelivery{delivery}); err != nil {
+		t.Fatal("Expected nil ENQUEUE err, got", err)
+	}
+
+	rs := newFileWebhookStore(t, dir)
+	if got, err := rs.GetWebhook(context.Background(), webhook.ID); err != nil || got.URL != webhook.URL || got.Secret != webhook.Secret {
+		t.Fatal("Expected the webhook to be restored, got", got, err)
+	}
+	due, err := rs.DueDeliveries(context.Background(), now, 10)
+	if err != nil {
+		t.Fatal("Expected nil err, got", err)
+	}
+	if len(due) != 1 || due[0].ID != delivery.ID || string(due[0].Payload) != "{}" {
+		t.Fatal("Expected the pending delivery to be restored, got", due)
+	}
+}
+
+func TestFileWebhookStoreLog(t *testing.T) {
+	dir := t.TempDir()
+	s := newFileWebhookStore(t, dir)
+	s.state.snapshotEvery = 3
+	ctx := context.Background()
+	now := time.Now().UTC()
+	webhook := addWebhook(t, s, now)
+
+	delivery := func() WebhookDelivery {
+		return WebhookDelivery{ID: uuid.New(), WebhookID: webhook.ID, EventType: "device.created", Payload: []byte(`{}`), NextAttemptAt: now, CreatedAt: now}
+	}
+	first, second := delivery(), delivery()
+	if err := s.EnqueueDeliveries(ctx, []WebhookDelivery{first}); err != nil {
+		t.Fatal("Expected nil ENQUEUE err, got", err)
+	}
+	// A change leaving the state as it is is not logged.
+	if err := s.DeleteDelivery(ctx, uuid.New()); err != nil {
+		t.Fatal("Expected nil DELETE err, got", err)
+	}
+	if s.state.log.entries != 2 {
+		t.Fatal("Expected a log entry per change, got entries", s.state.log.entries)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "webhooks.json")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatal("Expected no snapshot yet, got", err)
+	}
+
+	if err := s.EnqueueDeliveries(ctx, []WebhookDelivery{second}); err != nil {
+		t.Fatal("Expected nil ENQUEUE err, got", err)
+	}
+	if s.state.log.entries != 0 {
+		t.Fatal("Expected the log to be truncated, got entries", s.state.log.entries)
+	}
+	if err := s.DeleteDelivery(ctx, first.ID); err != nil {
+		t.Fatal("Expected nil DELETE err, got", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal("Expected nil CLOSE err, got", err)
+	}
+
+	// Restored from the snapshot, then the log.
+	due, err := newFileWebhookStore(t, dir).DueDeliveries(ctx, now, 10)
+	if err != nil {
+		t.Fatal("Expected nil err, got", err)
+	}
+	if len(due) != 1 || due[0].ID != second.ID {
+		t.Fatal("Expected the delivery left, got", due)
+	}
+}
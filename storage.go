@@ -0,0 +1,148 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/casell/signing-service-challenge/domain"
+	"github.com/casell/signing-service-challenge/persistence"
+
+	// The SQLite driver is linked in, other database/sql drivers can be added the same way.
+	_ "modernc.org/sqlite"
+)
+
+const (
+	SQLDriverEnvName      = "SQL_DRIVER"
+	SQLDriverDefault      = "sqlite"
+	SQLDSNEnvName         = "SQL_DSN"
+	SQLPlaceholderEnvName = "SQL_PLACEHOLDER"
+)
+
+// storage is what the service stores its state in.
+type storage struct {
+	store    persistence.Storage
+	journal  persistence.SignatureJournal
+	webhooks persistence.WebhookStorage
+	apikeys  persistence.APIKeyStorage
+	// close releases the storage.
+	close func() error
+}
+
+func getSQLPlaceholderFromEnv() (persistence.SQLPlaceholder, error) {
+	placeholder, set := os.LookupEnv(SQLPlaceholderEnvName)
+	if !set {
+		return persistence.QUESTION_PLACEHOLDER, nil
+	}
+	switch placeholder {
+	case "question":
+		return persistence.QUESTION_PLACEHOLDER, nil
+	case "dollar":
+		return persistence.DOLLAR_PLACEHOLDER, nil
+	default:
+		return 0, fmt.Errorf("%q is neither question nor dollar", placeholder)
+	}
+}
+
+// openSQLStore opens the database of SQL_DSN with the driver of SQL_DRIVER and brings its schema up to date.
+// The database is returned as well, for the caller to close.
+func openSQLStore(dsn string, factory domain.SigningDeviceFactory) (*persistence.SQLStore, *sql.DB, error) {
+	driver, set := os.LookupEnv(SQLDriverEnvName)
+	if !set {
+		driver = SQLDriverDefault
+	}
+	placeholder, err := getSQLPlaceholderFromEnv()
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid %s: %w", SQLPlaceholderEnvName, err)
+	}
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, nil, err
+	}
+	if driver == SQLDriverDefault {
+		// SQLite allows a single writer: with more connections concurrent commits fail as busy instead of waiting.
+		db.SetMaxOpenConns(1)
+	}
+	store, err := persistence.NewSQLStore(db, placeholder, factory)
+	if err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+	return store, db, nil
+}
+
+// openStorage opens the storage configured by the environment: the database of SQL_DSN, the directory of
+// STORAGE_DIR or, when neither is set, memory.
+func openStorage(factory domain.SigningDeviceFactory) (*storage, error) {
+	dsn, sqlSet := os.LookupEnv(SQLDSNEnvName)
+	dir, dirSet := os.LookupEnv(StorageDirEnvName)
+	switch {
+	case sqlSet && dirSet:
+		return nil, fmt.Errorf("only one of %s and %s can be set", SQLDSNEnvName, StorageDirEnvName)
+	case sqlSet:
+		sqlStore, db, err := openSQLStore(dsn, factory)
+		if err != nil {
+			return nil, fmt.Errorf("SQL database: %w", err)
+		}
+		return &storage{
+			store:    sqlStore,
+			journal:  sqlStore.Journal(),
+			webhooks: sqlStore.Webhooks(),
+			apikeys:  sqlStore.APIKeys(),
+			close:    db.Close,
+		}, nil
+	case dirSet:
+		fileStore, err := persistence.NewFileStore(dir, factory, persistence.DEFAULT_SNAPSHOT_EVERY)
+		if err != nil {
+			return nil, fmt.Errorf("devices in %s: %w", dir, err)
+		}
+		webhooks, err := persistence.NewFileWebhookStore(dir)
+		if err != nil {
+			return nil, fmt.Errorf("webhooks in %s: %w", dir, err)
+		}
+		apikeys, err := persistence.NewFileAPIKeyStore(dir)
+		if err != nil {
+			return nil, fmt.Errorf("API keys in %s: %w", dir, err)
+		}
+		return &storage{
+			store:    fileStore,
+			journal:  fileStore.Journal(),
+			webhooks: webhooks,
+			apikeys:  apikeys,
+			close: func() error {
+				return errors.Join(fileStore.Close(), webhooks.Close(), apikeys.Close())
+			},
+		}, nil
+	default:
+		memoryStore := persistence.NewMemoryStore()
+		return &storage{
+			store:    memoryStore,
+			journal:  memoryStore.Journal(),
+			webhooks: persistence.NewMemoryWebhookStore(),
+			apikeys:  persistence.NewMemoryAPIKeyStore(),
+			close:    func() error { return nil },
+		}, nil
+	}
+}
+
+// openAPIKeyStorage opens the API keys of the storage configured by the environment, which must be persistent.
+// The returned function releases the storage.
+func openAPIKeyStorage() (persistence.APIKeyStorage, func() error, error) {
+	if dsn, set := os.LookupEnv(SQLDSNEnvName); set {
+		sqlStore, db, err := openSQLStore(dsn, domain.NewDefaultDeviceFactory())
+		if err != nil {
+			return nil, nil, fmt.Errorf("SQL database: %w", err)
+		}
+		return sqlStore.APIKeys(), db.Close, nil
+	}
+	dir, set := os.LookupEnv(StorageDirEnvName)
+	if !set {
+		return nil, nil, fmt.Errorf("%s or %s must be set to manage API keys", StorageDirEnvName, SQLDSNEnvName)
+	}
+	keys, err := persistence.NewFileAPIKeyStore(dir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("API keys in %s: %w", dir, err)
+	}
+	return keys, keys.Close, nil
+}